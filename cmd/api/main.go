@@ -4,6 +4,16 @@ import (
 	"context"
 	"fmt"
 	"invoice-api/internal/database"
+	apikey_command "invoice-api/internal/features/apikey/command"
+	auth_command "invoice-api/internal/features/auth/command"
+	customer_command "invoice-api/internal/features/customer/command"
+	customfield_command "invoice-api/internal/features/customfield/command"
+	org_command "invoice-api/internal/features/org/command"
+	portal_command "invoice-api/internal/features/portal/command"
+	reportsubscription_command "invoice-api/internal/features/reportsubscription/command"
+	"invoice-api/internal/features/reportsubscription/scheduler"
+	revenue_command "invoice-api/internal/features/revenue/command"
+	user_command "invoice-api/internal/features/user/command"
 	"invoice-api/internal/server"
 	"log"
 	"net/http"
@@ -43,6 +53,27 @@ func main() {
 	server := server.New()
 	server.RegisterFiberRoutes()
 	database.InitDB()
+	err := database.EnsureIndexes(
+		org_command.EnsureOrganizations,
+		org_command.EnsureIndexes,
+		user_command.EnsureEmailVerification,
+		user_command.EnsureIndexes,
+		auth_command.EnsureIndexes,
+		apikey_command.EnsureIndexes,
+		customer_command.EnsureIndexes,
+		customfield_command.EnsureIndexes,
+		portal_command.EnsureIndexes,
+		revenue_command.EnsureIndexes,
+		reportsubscription_command.EnsureIndexes,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Send scheduled reports until shutdown
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	defer stopScheduler()
+	go scheduler.New().Start(schedulerCtx)
 
 	// Create a done channel to signal when the shutdown is complete
 	done := make(chan bool, 1)
@@ -64,5 +95,6 @@ func main() {
 
 	// Wait for the graceful shutdown to complete
 	<-done
+	stopScheduler()
 	log.Println("Graceful shutdown complete.")
 }
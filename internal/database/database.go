@@ -104,3 +104,25 @@ func GetDatabase() *mongo.Database {
 	}
 	return db.Database(dbName)
 }
+
+// IndexSetup creates the indexes a feature relies on.
+type IndexSetup func(ctx context.Context, db *mongo.Database) error
+
+// IndexTimeout bounds each IndexSetup.
+const IndexTimeout = 30 * time.Second
+
+// EnsureIndexes runs each setup against the application database, each
+// within IndexTimeout, and stops at the first that fails. Setups deal with
+// existing data that violates their indexes themselves, so an error means
+// the database cannot enforce what the API relies on.
+func EnsureIndexes(setups ...IndexSetup) error {
+	for _, setup := range setups {
+		ctx, cancel := context.WithTimeout(context.Background(), IndexTimeout)
+		err := setup(ctx, GetDatabase())
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to ensure indexes: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+// Package dbtest runs MongoDB for tests that need a real database.
+package dbtest
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Start runs MongoDB as a single node replica set, so that transactions
+// work, and points database.GetDatabase at it. The test is skipped when
+// Docker is not available.
+func Start(t *testing.T) *mongo.Database {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx := context.Background()
+	container, err := mongodb.Run(ctx, "mongo:latest", mongodb.WithReplicaSet("rs0"))
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("could not start mongodb container: %v", err)
+	}
+
+	uri, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("could not get mongodb connection string: %v", err)
+	}
+
+	// the replica set knows the node by its name inside the container
+	t.Setenv("DB_CONNECTION", uri+"&directConnection=true")
+	t.Setenv("DB_NAME", "invoice_test")
+	database.InitDB()
+
+	return database.GetDatabase()
+}
@@ -0,0 +1,155 @@
+package tenant_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"invoice-api/internal/database/dbtest"
+	analytics_query "invoice-api/internal/features/analytics/query"
+	customer_model "invoice-api/internal/features/customer/model"
+	customer_query "invoice-api/internal/features/customer/query"
+	customfield_model "invoice-api/internal/features/customfield/model"
+	customfield_query "invoice-api/internal/features/customfield/query"
+	dashboard_query "invoice-api/internal/features/dashboard/query"
+	invoice_query "invoice-api/internal/features/invoice/query"
+	report_query "invoice-api/internal/features/report/query"
+	reportsubscription_query "invoice-api/internal/features/reportsubscription/query"
+	revenue_model "invoice-api/internal/features/revenue/model"
+	revenue_query "invoice-api/internal/features/revenue/query"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TestReadsStayInOrganization seeds two organizations and reads everything
+// through org A. Org B's invoice points at org A's customer and one of org A's
+// invoices at org B's customer, so that joins leak unless they are scoped too.
+func TestReadsStayInOrganization(t *testing.T) {
+	db := dbtest.Start(t)
+	ctx := context.Background()
+
+	orgA, orgB := primitive.NewObjectID(), primitive.NewObjectID()
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+	invoiceB := primitive.NewObjectID()
+	paidAt := time.Date(2025, time.January, 20, 0, 0, 0, 0, time.UTC)
+
+	seed := func(collection string, docs ...bson.M) {
+		items := make([]interface{}, len(docs))
+		for i, doc := range docs {
+			items[i] = doc
+		}
+		_, err := db.Collection(collection).InsertMany(ctx, items)
+		require.NoError(t, err)
+	}
+	seed("customers",
+		bson.M{"_id": alice, "orgId": orgA, "name": "Alice", "email": "alice@example.com", "normalizedEmail": "alice@example.com"},
+		bson.M{"_id": bob, "orgId": orgB, "name": "Bob", "email": "bob@example.com", "normalizedEmail": "bob@example.com"},
+	)
+	seed("invoices",
+		bson.M{"orgId": orgA, "customerId": alice, "customer": bson.M{"_id": alice, "name": "Alice"}, "amount": 100.0, "date": "2025-01-15", "status": "pending"},
+		bson.M{"orgId": orgA, "customerId": bob, "customer": bson.M{"_id": bob}, "amount": 10.0, "date": "2025-01-10", "status": "paid", "paidAt": paidAt},
+		bson.M{"_id": invoiceB, "orgId": orgB, "customerId": alice, "customer": bson.M{"_id": alice, "name": "Bob"}, "amount": 1000.0, "date": "2025-01-05", "status": "pending"},
+	)
+	seed("revenues",
+		bson.M{"orgId": orgB, "period": "2025-01", "year": 2025, "month": 1, "revenue": 5000.0, "kind": revenue_model.KindAdjustment},
+	)
+	seed("custom_fields",
+		bson.M{"orgId": orgA, "name": "region", "type": customfield_model.TypeString, "appliesTo": customfield_model.AppliesToCustomer},
+		bson.M{"orgId": orgB, "name": "secret", "type": customfield_model.TypeString, "appliesTo": customfield_model.AppliesToCustomer},
+	)
+	seed("report_subscriptions",
+		bson.M{"orgId": orgA, "name": "A report"},
+		bson.M{"orgId": orgB, "name": "B report"},
+	)
+
+	t.Run("customer", func(t *testing.T) {
+		q := &customer_query.DefaultQuery{OrgID: orgA}
+		page, err := q.GetItemsByQuery("", customfield_model.Filter{}, 10, 1)
+		require.NoError(t, err)
+		require.Len(t, page.Data, 1)
+		require.Equal(t, "Alice", page.Data[0].Name)
+
+		_, err = q.GetItemByID(bob.Hex())
+		require.ErrorIs(t, err, mongo.ErrNoDocuments)
+
+		totals, err := q.GetItemsWithTotalByQuery("", customer_model.CustomerSort{Field: "name"}, 10, 1)
+		require.NoError(t, err)
+		require.Len(t, totals.Data, 1)
+		require.Equal(t, int64(1), totals.Data[0].TotalInvoices)
+		require.Equal(t, 100.0, totals.Data[0].TotalAmount)
+	})
+
+	t.Run("invoice", func(t *testing.T) {
+		q := &invoice_query.DefaultInvoiceQuery{OrgID: orgA}
+		page, err := q.GetItemsByQuery("", "", customfield_model.Filter{}, 10, 1)
+		require.NoError(t, err)
+		require.Len(t, page.Data, 2)
+
+		_, err = q.GetItemByID(invoiceB.Hex())
+		require.ErrorIs(t, err, mongo.ErrNoDocuments)
+
+		latest, err := q.GetLatestInvoices()
+		require.NoError(t, err)
+		require.Len(t, latest, 2)
+		for _, item := range latest {
+			require.NotEqual(t, "Bob", item.Name)
+		}
+	})
+
+	t.Run("revenue", func(t *testing.T) {
+		items, err := (&revenue_query.DefaultRevenueQuery{OrgID: orgA}).GetItemsByQuery(revenue_model.BasisAccrual)
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		require.Equal(t, 110.0, items[0].Revenue)
+	})
+
+	t.Run("report", func(t *testing.T) {
+		report, err := (&report_query.DefaultReportQuery{OrgID: orgA}).GetARAging(time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC), "", false)
+		require.NoError(t, err)
+		require.Len(t, report.Customers, 1)
+		require.Equal(t, 100.0, report.Totals.Total)
+	})
+
+	t.Run("dashboard", func(t *testing.T) {
+		q := &dashboard_query.DefaultDashboardQuery{OrgID: orgA}
+		summary, err := q.GetInvoiceSummary(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), summary.Total)
+		require.Equal(t, 100.0, summary.Pending)
+
+		count, err := q.GetCustomerCount(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), count)
+
+		top, err := q.GetTopCustomers(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, top, 2)
+		require.Equal(t, 100.0, top[0].Billed)
+	})
+
+	t.Run("analytics", func(t *testing.T) {
+		period := revenue_model.Period{Year: 2025, Month: time.January}
+		metrics, err := (&analytics_query.DefaultAnalyticsQuery{OrgID: orgA}).GetSubscriptionMetrics(period, period)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), metrics.TotalCustomers)
+		require.Len(t, metrics.Months, 1)
+		require.Equal(t, 110.0, metrics.Months[0].MRR)
+	})
+
+	t.Run("customfield", func(t *testing.T) {
+		items, err := (&customfield_query.DefaultQuery{OrgID: orgA}).GetItemsByQuery(customfield_model.AppliesToCustomer)
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		require.Equal(t, "region", items[0].Name)
+	})
+
+	t.Run("reportsubscription", func(t *testing.T) {
+		items, err := (&reportsubscription_query.DefaultQuery{OrgID: orgA}).GetItemsByQuery()
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		require.Equal(t, "A report", items[0].Name)
+	})
+}
@@ -0,0 +1,172 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"invoice-api/internal/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OrgField is the field that ties a document to the organization owning it.
+const OrgField = "orgId"
+
+// Database hands out collections scoped to a single organization. Every
+// filter, pipeline and inserted document passing through them is restricted to
+// that organization, so a query cannot read or change another tenant's data.
+// A zero organization ID matches nothing.
+type Database struct {
+	db    *mongo.Database
+	orgID primitive.ObjectID
+}
+
+// ForOrg returns the application database scoped to the given organization.
+func ForOrg(orgID primitive.ObjectID) *Database {
+	return &Database{db: database.GetDatabase(), orgID: orgID}
+}
+
+func (t *Database) OrgID() primitive.ObjectID {
+	return t.orgID
+}
+
+// Client is exposed for starting sessions. Transactions are not scoped by
+// themselves; the collections used inside them are.
+func (t *Database) Client() *mongo.Client {
+	return t.db.Client()
+}
+
+func (t *Database) Collection(name string) *Collection {
+	return &Collection{coll: t.db.Collection(name), orgID: t.orgID}
+}
+
+// Collection mirrors the subset of *mongo.Collection the features use,
+// adding the organization to every operation.
+type Collection struct {
+	coll  *mongo.Collection
+	orgID primitive.ObjectID
+}
+
+// NewCollection scopes an existing collection to an organization.
+func NewCollection(coll *mongo.Collection, orgID primitive.ObjectID) *Collection {
+	return &Collection{coll: coll, orgID: orgID}
+}
+
+func (c *Collection) Name() string {
+	return c.coll.Name()
+}
+
+// Filter returns filter restricted to the collection's organization. Plain
+// bson.M filters are copied and get the orgId key set, overriding any orgId the
+// caller supplied; anything else is combined with $and.
+func (c *Collection) Filter(filter interface{}) interface{} {
+	switch f := filter.(type) {
+	case nil:
+		return bson.M{OrgField: c.orgID}
+	case bson.M:
+		scoped := make(bson.M, len(f)+1)
+		for key, value := range f {
+			scoped[key] = value
+		}
+		scoped[OrgField] = c.orgID
+		return scoped
+	default:
+		return bson.M{OrgField: c.orgID, "$and": bson.A{filter}}
+	}
+}
+
+// Pipeline prepends a $match on the collection's organization to pipeline.
+func (c *Collection) Pipeline(pipeline interface{}) (bson.A, error) {
+	scoped := bson.A{bson.D{{Key: "$match", Value: bson.M{OrgField: c.orgID}}}}
+	switch p := pipeline.(type) {
+	case mongo.Pipeline:
+		for _, stage := range p {
+			scoped = append(scoped, stage)
+		}
+	case []bson.D:
+		for _, stage := range p {
+			scoped = append(scoped, stage)
+		}
+	case []bson.M:
+		for _, stage := range p {
+			scoped = append(scoped, stage)
+		}
+	case bson.A:
+		scoped = append(scoped, p...)
+	case []interface{}:
+		scoped = append(scoped, p...)
+	default:
+		return nil, fmt.Errorf("unsupported pipeline type %T", pipeline)
+	}
+	return scoped, nil
+}
+
+// Document converts doc into a bson.D carrying the collection's organization.
+func (c *Collection) Document(doc interface{}) (bson.D, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.D
+	if err := bson.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	scoped := make(bson.D, 0, len(fields)+1)
+	for _, field := range fields {
+		if field.Key != OrgField {
+			scoped = append(scoped, field)
+		}
+	}
+	return append(scoped, bson.E{Key: OrgField, Value: c.orgID}), nil
+}
+
+func (c *Collection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	scoped, err := c.Pipeline(pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return c.coll.Aggregate(ctx, scoped, opts...)
+}
+
+func (c *Collection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return c.coll.CountDocuments(ctx, c.Filter(filter), opts...)
+}
+
+func (c *Collection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	return c.coll.Find(ctx, c.Filter(filter), opts...)
+}
+
+func (c *Collection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	return c.coll.FindOne(ctx, c.Filter(filter), opts...)
+}
+
+func (c *Collection) FindOneAndUpdate(ctx context.Context, filter interface{}, update interface{}, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	return c.coll.FindOneAndUpdate(ctx, c.Filter(filter), update, opts...)
+}
+
+func (c *Collection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	scoped, err := c.Document(document)
+	if err != nil {
+		return nil, err
+	}
+	return c.coll.InsertOne(ctx, scoped, opts...)
+}
+
+func (c *Collection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateOne(ctx, c.Filter(filter), update, opts...)
+}
+
+func (c *Collection) UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateMany(ctx, c.Filter(filter), update, opts...)
+}
+
+func (c *Collection) DeleteOne(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.coll.DeleteOne(ctx, c.Filter(filter), opts...)
+}
+
+func (c *Collection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.coll.DeleteMany(ctx, c.Filter(filter), opts...)
+}
@@ -0,0 +1,94 @@
+package tenant
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestFilterAddsOrg(t *testing.T) {
+	orgA := primitive.NewObjectID()
+	coll := NewCollection(nil, orgA)
+
+	id := primitive.NewObjectID()
+	filter := bson.M{"_id": id}
+	scoped := coll.Filter(filter)
+
+	require.Equal(t, bson.M{"_id": id, OrgField: orgA}, scoped)
+	require.NotContains(t, filter, OrgField, "caller's filter must not be modified")
+}
+
+func TestFilterCannotBeWidenedToAnotherOrg(t *testing.T) {
+	orgA := primitive.NewObjectID()
+	orgB := primitive.NewObjectID()
+	coll := NewCollection(nil, orgA)
+
+	scoped := coll.Filter(bson.M{OrgField: orgB})
+	require.Equal(t, bson.M{OrgField: orgA}, scoped)
+
+	scoped = coll.Filter(bson.M{"$or": bson.A{bson.M{OrgField: orgB}, bson.M{}}})
+	require.Equal(t, orgA, scoped.(bson.M)[OrgField])
+}
+
+func TestFilterWrapsOtherTypes(t *testing.T) {
+	orgA := primitive.NewObjectID()
+	coll := NewCollection(nil, orgA)
+
+	require.Equal(t, bson.M{OrgField: orgA}, coll.Filter(nil))
+
+	filter := bson.D{{Key: "status", Value: "paid"}}
+	require.Equal(t, bson.M{OrgField: orgA, "$and": bson.A{filter}}, coll.Filter(filter))
+}
+
+func TestZeroOrgMatchesNothing(t *testing.T) {
+	coll := NewCollection(nil, primitive.NilObjectID)
+
+	scoped := coll.Filter(bson.M{})
+	require.Equal(t, bson.M{OrgField: primitive.NilObjectID}, scoped)
+}
+
+func TestPipelineStartsWithOrgMatch(t *testing.T) {
+	orgA := primitive.NewObjectID()
+	coll := NewCollection(nil, orgA)
+	match := bson.D{{Key: "$match", Value: bson.M{OrgField: orgA}}}
+
+	tests := []struct {
+		name     string
+		pipeline interface{}
+	}{
+		{"mongo.Pipeline", mongo.Pipeline{{{Key: "$sort", Value: bson.M{"createdAt": -1}}}}},
+		{"[]bson.M", []bson.M{{"$sort": bson.M{"createdAt": -1}}}},
+		{"bson.A", bson.A{bson.M{"$sort": bson.M{"createdAt": -1}}}},
+		{"empty", mongo.Pipeline{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scoped, err := coll.Pipeline(tt.pipeline)
+			require.NoError(t, err)
+			require.Equal(t, match, scoped[0])
+		})
+	}
+
+	_, err := coll.Pipeline("not a pipeline")
+	require.Error(t, err)
+}
+
+func TestDocumentIsStampedWithOrg(t *testing.T) {
+	orgA := primitive.NewObjectID()
+	orgB := primitive.NewObjectID()
+	coll := NewCollection(nil, orgA)
+
+	doc, err := coll.Document(struct {
+		Name  string             `bson:"name"`
+		OrgID primitive.ObjectID `bson:"orgId"`
+	}{Name: "Acme", OrgID: orgB})
+	require.NoError(t, err)
+
+	require.Equal(t, bson.D{
+		{Key: "name", Value: "Acme"},
+		{Key: OrgField, Value: orgA},
+	}, doc)
+}
@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"invoice-api/internal/features/analytics/query"
+	revenue_model "invoice-api/internal/features/revenue/model"
+	"invoice-api/middleware"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type AnalyticsController struct {
+	Query query.AnalyticsQuery
+}
+
+// query returns the injected query, or one scoped to the organization the
+// request was authorized for.
+func (s *AnalyticsController) query(c *fiber.Ctx) query.AnalyticsQuery {
+	if s.Query != nil {
+		return s.Query
+	}
+	return &query.DefaultAnalyticsQuery{OrgID: middleware.OrgID(c)}
+}
+
+func (s *AnalyticsController) GetSubscriptionMetrics(c *fiber.Ctx) error {
+	from, to, err := revenue_model.ParsePeriodRange(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	metrics, err := s.query(c).GetSubscriptionMetrics(from, to)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(metrics)
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"invoice-api/internal/features/analytics/model"
+	revenue_model "invoice-api/internal/features/revenue/model"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type mockQuery struct {
+	from, to revenue_model.Period
+}
+
+func (m *mockQuery) GetSubscriptionMetrics(from revenue_model.Period, to revenue_model.Period) (*model.SubscriptionMetrics, error) {
+	m.from, m.to = from, to
+	return &model.SubscriptionMetrics{}, nil
+}
+
+func TestGetSubscriptionMetrics_Range(t *testing.T) {
+	app := fiber.New()
+	q := &mockQuery{}
+	ctrl := &AnalyticsController{Query: q}
+	app.Get("/analytics/subscriptions", ctrl.GetSubscriptionMetrics)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/analytics/subscriptions?from=2025-01&to=2025-06", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, revenue_model.Period{Year: 2025, Month: 1}, q.from)
+	require.Equal(t, revenue_model.Period{Year: 2025, Month: 6}, q.to)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/analytics/subscriptions?from=2025-07&to=2025-06", nil))
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode)
+}
@@ -0,0 +1,61 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// CustomerMonth is the recurring revenue of one customer in one month
+// (YYYY-MM). Invoices with a service period contribute their recognition
+// schedule; other invoices count in the month of their date.
+type CustomerMonth struct {
+	CustomerID primitive.ObjectID `bson:"customerId"`
+	Period     string             `bson:"period"`
+	Amount     float64            `bson:"amount"`
+}
+
+// MRRMonth is monthly recurring revenue at the end of a month and how it moved
+// since the previous month. Reactivation is revenue from customers who paid
+// before, stopped, and came back. Rates are percentages of the previous
+// month's customers or MRR and are nil when that month had none.
+type MRRMonth struct {
+	Period              string   `json:"period"`
+	MRR                 float64  `json:"mrr"`
+	ARR                 float64  `json:"arr"`
+	New                 float64  `json:"new"`
+	Expansion           float64  `json:"expansion"`
+	Contraction         float64  `json:"contraction"`
+	Churned             float64  `json:"churned"`
+	Reactivation        float64  `json:"reactivation"`
+	NetNew              float64  `json:"netNew"`
+	Customers           int      `json:"customers"`
+	NewCustomers        int      `json:"newCustomers"`
+	ChurnedCustomers    int      `json:"churnedCustomers"`
+	LogoChurnRate       *float64 `json:"logoChurnRate"`
+	RevenueChurnRate    *float64 `json:"revenueChurnRate"`
+	NetRevenueRetention *float64 `json:"netRevenueRetention"`
+}
+
+// CohortMonth is how much of a cohort was still paying a number of months
+// after its first invoice month.
+type CohortMonth struct {
+	Offset            int      `json:"offset"`
+	Period            string   `json:"period"`
+	Customers         int      `json:"customers"`
+	CustomerRetention float64  `json:"customerRetention"`
+	MRR               float64  `json:"mrr"`
+	RevenueRetention  *float64 `json:"revenueRetention"`
+}
+
+// Cohort groups the customers whose first invoice fell in the same month.
+type Cohort struct {
+	Cohort    string        `json:"cohort"`
+	Customers int           `json:"customers"`
+	Months    []CohortMonth `json:"months"`
+}
+
+type SubscriptionMetrics struct {
+	From            string     `json:"from"`
+	To              string     `json:"to"`
+	TotalCustomers  int64      `json:"totalCustomers"`
+	PayingCustomers int        `json:"payingCustomers"`
+	Months          []MRRMonth `json:"months"`
+	Cohorts         []Cohort   `json:"cohorts"`
+}
@@ -0,0 +1,119 @@
+package query
+
+import (
+	"math"
+	"sort"
+
+	"invoice-api/internal/features/analytics/model"
+	revenue_model "invoice-api/internal/features/revenue/model"
+)
+
+// pct returns part as a percentage of whole rounded to two decimals, or nil
+// when whole is zero.
+func pct(part, whole float64) *float64 {
+	if whole == 0 {
+		return nil
+	}
+	v := math.Round(part/whole*10000) / 100
+	return &v
+}
+
+// buildMetrics derives MRR movements for every month from from to to and the
+// retention of each cohort that started in that range. rows must hold every
+// month of history so that new and reactivated customers can be told apart.
+func buildMetrics(rows []model.CustomerMonth, from revenue_model.Period, to revenue_model.Period) *model.SubscriptionMetrics {
+	mrr := make(map[string]map[string]float64)
+	first := make(map[string]string)
+	for _, row := range rows {
+		if row.Amount <= 0 {
+			continue
+		}
+		customer := row.CustomerID.Hex()
+		if mrr[customer] == nil {
+			mrr[customer] = make(map[string]float64)
+		}
+		mrr[customer][row.Period] += row.Amount
+		if f, ok := first[customer]; !ok || row.Period < f {
+			first[customer] = row.Period
+		}
+	}
+
+	metrics := &model.SubscriptionMetrics{
+		From:            from.String(),
+		To:              to.String(),
+		PayingCustomers: len(mrr),
+		Months:          make([]model.MRRMonth, 0),
+		Cohorts:         make([]model.Cohort, 0),
+	}
+
+	for p := from; p.String() <= to.String(); p = p.AddMonths(1) {
+		period, previous := p.String(), p.AddMonths(-1).String()
+		month := model.MRRMonth{Period: period}
+		startMRR, startCustomers := 0.0, 0
+
+		for customer, months := range mrr {
+			cur, prev := months[period], months[previous]
+			month.MRR += cur
+			startMRR += prev
+			if cur > 0 {
+				month.Customers++
+			}
+			if prev > 0 {
+				startCustomers++
+			}
+
+			switch {
+			case prev == 0 && cur > 0 && first[customer] == period:
+				month.New += cur
+				month.NewCustomers++
+			case prev == 0 && cur > 0:
+				month.Reactivation += cur
+			case prev > 0 && cur == 0:
+				month.Churned += prev
+				month.ChurnedCustomers++
+			case cur > prev:
+				month.Expansion += cur - prev
+			case cur < prev:
+				month.Contraction += prev - cur
+			}
+		}
+
+		month.ARR = month.MRR * 12
+		month.NetNew = month.New + month.Reactivation + month.Expansion - month.Contraction - month.Churned
+		month.LogoChurnRate = pct(float64(month.ChurnedCustomers), float64(startCustomers))
+		month.RevenueChurnRate = pct(month.Churned+month.Contraction, startMRR)
+		month.NetRevenueRetention = pct(startMRR+month.Expansion-month.Contraction-month.Churned, startMRR)
+		metrics.Months = append(metrics.Months, month)
+	}
+
+	cohorts := make(map[string][]string)
+	for customer, period := range first {
+		if period >= from.String() && period <= to.String() {
+			cohorts[period] = append(cohorts[period], customer)
+		}
+	}
+	for period, customers := range cohorts {
+		start, _ := revenue_model.ParsePeriod(period)
+		cohort := model.Cohort{Cohort: period, Customers: len(customers), Months: make([]model.CohortMonth, 0)}
+		initialMRR := 0.0
+		for offset, p := 0, start; p.String() <= to.String(); offset, p = offset+1, p.AddMonths(1) {
+			cell := model.CohortMonth{Offset: offset, Period: p.String()}
+			for _, customer := range customers {
+				if amount := mrr[customer][p.String()]; amount > 0 {
+					cell.Customers++
+					cell.MRR += amount
+				}
+			}
+			if offset == 0 {
+				initialMRR = cell.MRR
+			}
+			cell.CustomerRetention = *pct(float64(cell.Customers), float64(len(customers)))
+			cell.RevenueRetention = pct(cell.MRR, initialMRR)
+			cohort.Months = append(cohort.Months, cell)
+		}
+		metrics.Cohorts = append(metrics.Cohorts, cohort)
+	}
+	sort.Slice(metrics.Cohorts, func(i, j int) bool { return metrics.Cohorts[i].Cohort < metrics.Cohorts[j].Cohort })
+
+	return metrics
+}
@@ -0,0 +1,56 @@
+package query
+
+import (
+	"testing"
+
+	"invoice-api/internal/features/analytics/model"
+	revenue_model "invoice-api/internal/features/revenue/model"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBuildMetrics_MovementsAndCohorts(t *testing.T) {
+	a, b, c := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	rows := []model.CustomerMonth{
+		{CustomerID: a, Period: "2025-01", Amount: 100},
+		{CustomerID: a, Period: "2025-02", Amount: 150},
+		{CustomerID: a, Period: "2025-03", Amount: 150},
+		{CustomerID: b, Period: "2025-01", Amount: 200},
+		{CustomerID: b, Period: "2025-02", Amount: 50},
+		{CustomerID: b, Period: "2025-04", Amount: 50},
+		{CustomerID: c, Period: "2025-02", Amount: 300},
+	}
+	from := revenue_model.Period{Year: 2025, Month: 2}
+	to := revenue_model.Period{Year: 2025, Month: 4}
+
+	metrics := buildMetrics(rows, from, to)
+	require.Len(t, metrics.Months, 3)
+	require.Equal(t, 3, metrics.PayingCustomers)
+
+	feb := metrics.Months[0]
+	require.Equal(t, 500.0, feb.MRR)
+	require.Equal(t, 6000.0, feb.ARR)
+	require.Equal(t, 300.0, feb.New)
+	require.Equal(t, 50.0, feb.Expansion)
+	require.Equal(t, 150.0, feb.Contraction)
+	require.Equal(t, 50.0, *feb.RevenueChurnRate)
+	require.Equal(t, 0.0, *feb.LogoChurnRate)
+
+	mar := metrics.Months[1]
+	require.Equal(t, 350.0, mar.Churned)
+	require.Equal(t, 2, mar.ChurnedCustomers)
+	require.Equal(t, 66.67, *mar.LogoChurnRate)
+
+	apr := metrics.Months[2]
+	require.Equal(t, 50.0, apr.Reactivation)
+	require.Equal(t, 150.0, apr.Churned)
+
+	require.Len(t, metrics.Cohorts, 1)
+	cohort := metrics.Cohorts[0]
+	require.Equal(t, "2025-02", cohort.Cohort)
+	require.Equal(t, 1, cohort.Customers)
+	require.Equal(t, 100.0, cohort.Months[0].CustomerRetention)
+	require.Equal(t, 0.0, cohort.Months[1].CustomerRetention)
+	require.Equal(t, 0.0, *cohort.Months[2].RevenueRetention)
+}
@@ -0,0 +1,83 @@
+package query
+
+import (
+	"context"
+	"invoice-api/internal/database/tenant"
+	"invoice-api/internal/features/analytics/model"
+	revenue_model "invoice-api/internal/features/revenue/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type DefaultAnalyticsQuery struct {
+	OrgID primitive.ObjectID
+}
+
+func (c *DefaultAnalyticsQuery) CollectionName() string {
+	return "invoices"
+}
+
+type AnalyticsQuery interface {
+	GetSubscriptionMetrics(from revenue_model.Period, to revenue_model.Period) (*model.SubscriptionMetrics, error)
+}
+
+// GetSubscriptionMetrics returns MRR, ARR, churn and cohort retention for the
+// months from from to to.
+func (c *DefaultAnalyticsQuery) GetSubscriptionMetrics(from revenue_model.Period, to revenue_model.Period) (*model.SubscriptionMetrics, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	invoiceMonth := bson.M{"$dateToString": bson.M{
+		"format": "%Y-%m",
+		"date":   bson.M{"$dateFromString": bson.M{"dateString": "$date", "onError": nil, "onNull": nil}},
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": bson.M{"$nin": bson.A{"cancelled", "void"}}}}},
+		{{Key: "$project", Value: bson.M{
+			"customerId": 1,
+			"entries": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$recognitionSchedule", bson.A{}}}}, 0}},
+				"$recognitionSchedule",
+				bson.A{bson.M{"period": invoiceMonth, "amount": "$amount"}},
+			}},
+		}}},
+		{{Key: "$unwind", Value: "$entries"}},
+		{{Key: "$match", Value: bson.M{"entries.period": bson.M{"$type": "string"}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"customerId": "$customerId", "period": "$entries.period"},
+			"amount": bson.M{"$sum": "$entries.amount"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":        0,
+			"customerId": "$_id.customerId",
+			"period":     "$_id.period",
+			"amount":     1,
+		}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rows := make([]model.CustomerMonth, 0)
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	totalCustomers, err := db.Collection("customers").CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := buildMetrics(rows, from, to)
+	metrics.TotalCustomers = totalCustomers
+	return metrics, nil
+}
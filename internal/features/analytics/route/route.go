@@ -0,0 +1,18 @@
+package route
+
+import (
+	"invoice-api/internal/features/analytics/controller"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type AnalyticsRoute struct{}
+
+func (s *AnalyticsRoute) Init(router *fiber.App) {
+	controller := new(controller.AnalyticsController)
+	analytics := router.Group("/analytics")
+
+	analytics.Get("/subscriptions", middleware.RequirePermission(user_model.PermReportRead), controller.GetSubscriptionMetrics)
+}
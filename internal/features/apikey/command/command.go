@@ -0,0 +1,125 @@
+package command
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
+	"invoice-api/internal/features/apikey/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// lastUsedInterval is how often lastUsedAt is written for a key in use, so
+// that a busy integration does not update its key on every request.
+const lastUsedInterval = time.Minute
+
+type DefaultCommand struct {
+	OrgID primitive.ObjectID
+}
+
+func (c *DefaultCommand) CollectionName() string {
+	return "api_keys"
+}
+
+type Command interface {
+	CreateItem(createdBy primitive.ObjectID, _val *model.CreateAPIKey) (*model.CreatedAPIKey, error)
+	RevokeItem(id string) (*mongo.UpdateResult, error)
+}
+
+// EnsureIndexes looks keys up by prefix and lists them per organization.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("api_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "prefix", Value: 1}},
+			Options: options.Index().SetName("prefix_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "createdAt", Value: -1}},
+			Options: options.Index().SetName("orgId_createdAt"),
+		},
+	})
+	return err
+}
+
+// CreateItem stores a new key and returns it with its full value, which
+// cannot be recovered afterwards.
+func (c *DefaultCommand) CreateItem(createdBy primitive.ObjectID, _val *model.CreateAPIKey) (*model.CreatedAPIKey, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	prefix, secret, err := model.NewKey()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := model.APIKey{
+		ID:         primitive.NewObjectID(),
+		OrgID:      c.OrgID,
+		Name:       _val.Name,
+		Prefix:     prefix,
+		SecretHash: model.HashSecret(secret),
+		Scopes:     _val.Scopes,
+		CreatedBy:  createdBy,
+		ExpiresAt:  _val.ExpiresAt,
+		CreatedAt:  time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := collection.InsertOne(ctx, doc); err != nil {
+		return nil, err
+	}
+
+	return &model.CreatedAPIKey{APIKey: doc, Key: prefix + "." + secret}, nil
+}
+
+// RevokeItem stops a key from authenticating. Revoked keys are kept so that
+// they still show in the listing.
+func (c *DefaultCommand) RevokeItem(id string) (*mongo.UpdateResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objId, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return result, nil
+}
+
+// RecordUse sets the key's lastUsedAt, at most once per lastUsedInterval.
+func (c *DefaultCommand) RecordUse(id primitive.ObjectID) error {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := collection.UpdateOne(ctx,
+		bson.M{"_id": id, "$or": bson.A{
+			bson.M{"lastUsedAt": bson.M{"$exists": false}},
+			bson.M{"lastUsedAt": bson.M{"$lt": now.Add(-lastUsedInterval)}},
+		}},
+		bson.M{"$set": bson.M{"lastUsedAt": now}})
+	return err
+}
@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"invoice-api/internal/features/apikey/command"
+	"invoice-api/internal/features/apikey/model"
+	"invoice-api/internal/features/apikey/query"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type APIKeyController struct {
+	Command command.Command
+	Query   query.Query
+}
+
+// query returns the injected query, or one scoped to the organization the
+// request was authorized for.
+func (s *APIKeyController) query(c *fiber.Ctx) query.Query {
+	if s.Query != nil {
+		return s.Query
+	}
+	return &query.DefaultQuery{OrgID: middleware.OrgID(c)}
+}
+
+// command returns the injected command, or one scoped to the organization
+// the request was authorized for.
+func (s *APIKeyController) command(c *fiber.Ctx) command.Command {
+	if s.Command != nil {
+		return s.Command
+	}
+	return &command.DefaultCommand{OrgID: middleware.OrgID(c)}
+}
+
+// CreateAPIKey issues a key with a subset of the creator's permissions. The
+// key is only shown in this response.
+func (s *APIKeyController) CreateAPIKey(c *fiber.Ctx) error {
+	if _, ok := middleware.CurrentAPIKey(c); ok {
+		return c.Status(403).JSON(fiber.Map{"status": "fail", "message": "API keys cannot be managed with an API key"})
+	}
+
+	payload := new(model.CreateAPIKey)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(err.Error())
+	}
+
+	validationErrors := model.ValidateStruct(payload)
+	if validationErrors != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
+	}
+
+	for _, scope := range payload.Scopes {
+		if !slices.Contains(user_model.AllPermissions, scope) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unknown scope " + scope})
+		}
+		if !middleware.HasPermission(c, scope) {
+			return middleware.Forbidden(c, scope)
+		}
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		return c.Status(400).JSON(fiber.Map{"error": "expiresAt must be in the future"})
+	}
+
+	user, _ := middleware.CurrentUser(c)
+	resp, err := s.command(c).CreateItem(user.ID, payload)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to create API key",
+		})
+	}
+
+	return c.Status(201).JSON(resp)
+}
+
+func (s *APIKeyController) GetAllAPIKeys(c *fiber.Ctx) error {
+	items, err := s.query(c).GetItems()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(items)
+}
+
+func (s *APIKeyController) GetAPIKeyByID(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	item, err := s.query(c).GetItemByID(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "API key not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch API key",
+		})
+	}
+
+	return c.JSON(item)
+}
+
+// RevokeAPIKey stops a key from working. Revoking a key that is already
+// revoked responds with 404.
+func (s *APIKeyController) RevokeAPIKey(c *fiber.Ctx) error {
+	if _, ok := middleware.CurrentAPIKey(c); ok {
+		return c.Status(403).JSON(fiber.Map{"status": "fail", "message": "API keys cannot be managed with an API key"})
+	}
+
+	id := c.Params("id")
+
+	_, err := s.command(c).RevokeItem(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "API key not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to revoke API key",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "API key revoked successfully",
+	})
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	modelpkg "invoice-api/internal/features/apikey/model"
+	user_model "invoice-api/internal/features/user/model"
+)
+
+type mockCommand struct {
+	created   *modelpkg.CreateAPIKey
+	createdBy primitive.ObjectID
+	revokeErr error
+}
+
+func (m *mockCommand) CreateItem(createdBy primitive.ObjectID, _val *modelpkg.CreateAPIKey) (*modelpkg.CreatedAPIKey, error) {
+	m.created, m.createdBy = _val, createdBy
+	return &modelpkg.CreatedAPIKey{
+		APIKey: modelpkg.APIKey{ID: primitive.NewObjectID(), Name: _val.Name, Prefix: "ik_0123456789ab", SecretHash: "hash", Scopes: _val.Scopes},
+		Key:    "ik_0123456789ab.secret",
+	}, nil
+}
+
+func (m *mockCommand) RevokeItem(id string) (*mongo.UpdateResult, error) {
+	if m.revokeErr != nil {
+		return nil, m.revokeErr
+	}
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+type mockQuery struct {
+	items []modelpkg.APIKey
+}
+
+func (m *mockQuery) GetItems() ([]modelpkg.APIKey, error) {
+	return m.items, nil
+}
+
+func (m *mockQuery) GetItemByID(id string) (*modelpkg.APIKey, error) {
+	return nil, mongo.ErrNoDocuments
+}
+
+// appAs serves handler to a user with the role, through an API key when key
+// is set.
+func appAs(role string, key *modelpkg.APIKey, method string, handler fiber.Handler) *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", user_model.UserDTO{ID: primitive.NewObjectID(), Role: role})
+		if key != nil {
+			c.Locals("apiKey", key)
+		}
+		return c.Next()
+	})
+	app.Add(method, "/", handler)
+	app.Add(method, "/:id", handler)
+	return app
+}
+
+func TestCreateAPIKey_Scopes(t *testing.T) {
+	cases := []struct {
+		role    string
+		payload map[string]interface{}
+		status  int
+	}{
+		{user_model.RoleAdmin, map[string]interface{}{"name": "ERP sync", "scopes": []string{"invoice:read", "invoice:write"}}, 201},
+		{user_model.RoleAdmin, map[string]interface{}{"name": "ERP sync", "scopes": []string{}}, 400},
+		{user_model.RoleAdmin, map[string]interface{}{"scopes": []string{"invoice:read"}}, 400},
+		{user_model.RoleAdmin, map[string]interface{}{"name": "ERP sync", "scopes": []string{"invoice:everything"}}, 400},
+		{user_model.RoleAdmin, map[string]interface{}{"name": "ERP sync", "scopes": []string{"invoice:read"}, "expiresAt": time.Now().Add(-time.Hour)}, 400},
+		// a key cannot be granted more than its creator can do
+		{user_model.RoleSales, map[string]interface{}{"name": "ERP sync", "scopes": []string{"revenue:write"}}, 403},
+	}
+
+	for _, tc := range cases {
+		mock := &mockCommand{}
+		app := appAs(tc.role, nil, "POST", (&APIKeyController{Command: mock}).CreateAPIKey)
+		b, _ := json.Marshal(tc.payload)
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		require.Equal(t, tc.status, resp.StatusCode, tc.payload)
+
+		if tc.status == 201 {
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			require.Equal(t, "ik_0123456789ab.secret", body["key"])
+			require.Equal(t, "ik_0123456789ab", body["prefix"])
+			require.NotContains(t, body, "secretHash")
+			require.False(t, mock.createdBy.IsZero())
+		}
+	}
+}
+
+func TestAPIKeysCannotManageKeys(t *testing.T) {
+	key := &modelpkg.APIKey{Scopes: []string{user_model.PermAPIKeyManage}}
+	ctrl := &APIKeyController{Command: &mockCommand{}}
+
+	b, _ := json.Marshal(map[string]interface{}{"name": "another", "scopes": []string{"invoice:read"}})
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := appAs(user_model.RoleAdmin, key, "POST", ctrl.CreateAPIKey).Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 403, resp.StatusCode)
+
+	resp, err = appAs(user_model.RoleAdmin, key, "DELETE", ctrl.RevokeAPIKey).Test(httptest.NewRequest("DELETE", "/someid", nil))
+	require.NoError(t, err)
+	require.Equal(t, 403, resp.StatusCode)
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	ctrl := &APIKeyController{Command: &mockCommand{}}
+	resp, err := appAs(user_model.RoleAdmin, nil, "DELETE", ctrl.RevokeAPIKey).Test(httptest.NewRequest("DELETE", "/someid", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	ctrl = &APIKeyController{Command: &mockCommand{revokeErr: mongo.ErrNoDocuments}}
+	resp, err = appAs(user_model.RoleAdmin, nil, "DELETE", ctrl.RevokeAPIKey).Test(httptest.NewRequest("DELETE", "/someid", nil))
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode)
+}
+
+func TestGetAllAPIKeys_HidesSecretHash(t *testing.T) {
+	ctrl := &APIKeyController{Query: &mockQuery{items: []modelpkg.APIKey{{Name: "ERP sync", Prefix: "ik_0123456789ab", SecretHash: "hash"}}}}
+	resp, err := appAs(user_model.RoleAdmin, nil, "GET", ctrl.GetAllAPIKeys).Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var body []map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Len(t, body, 1)
+	require.Equal(t, "ik_0123456789ab", body[0]["prefix"])
+	require.NotContains(t, body[0], "secretHash")
+}
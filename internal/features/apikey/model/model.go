@@ -0,0 +1,112 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var validate = validator.New()
+
+// KeyPrefix starts every API key so that keys are easy to recognise in
+// configuration and secret scanners.
+const KeyPrefix = "ik_"
+
+// APIKey lets an integration call the API on behalf of the member who
+// created it, limited to its scopes. Only a hash of the secret is stored; the
+// prefix identifies the key and is safe to show.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID      primitive.ObjectID `bson:"orgId" json:"orgId"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	SecretHash string             `bson:"secretHash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	CreatedBy  primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	ExpiresAt  *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// Expired reports whether the key's expiry has passed at now.
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// HasScope reports whether the key was granted permission.
+func (k *APIKey) HasScope(permission string) bool {
+	return slices.Contains(k.Scopes, permission)
+}
+
+type CreateAPIKey struct {
+	Name string `json:"name" validate:"required"`
+	// Scopes are the permissions the key is granted, out of those of its
+	// creator.
+	Scopes    []string   `json:"scopes" validate:"required,min=1"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// CreatedAPIKey is the response to creating a key, the only time its full
+// value is shown.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// NewKey returns a random key, split into the prefix it is looked up by and
+// the secret that is hashed. The full key is `<prefix>.<secret>`.
+func NewKey() (prefix string, secret string, err error) {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	return KeyPrefix + hex.EncodeToString(id), base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ParseKey splits a key into its prefix and secret.
+func ParseKey(key string) (prefix string, secret string, ok bool) {
+	prefix, secret, ok = strings.Cut(key, ".")
+	if !ok || !strings.HasPrefix(prefix, KeyPrefix) || len(prefix) == len(KeyPrefix) || secret == "" {
+		return "", "", false
+	}
+	return prefix, secret, true
+}
+
+// HashSecret hashes a key's secret the way it is stored.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type ErrorResponse struct {
+	Field string `json:"field"`
+	Tag   string `json:"tag"`
+	Value string `json:"value,omitempty"`
+}
+
+func ValidateStruct[T any](payload T) []ErrorResponse {
+	var errors []ErrorResponse
+	err := validate.Struct(payload)
+	if err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			var element ErrorResponse
+			element.Field = err.StructNamespace()
+			element.Tag = err.Tag()
+			element.Value = err.Param()
+			errors = append(errors, element)
+		}
+	}
+	return errors
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeyRoundTrip(t *testing.T) {
+	prefix, secret, err := NewKey()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(prefix, KeyPrefix))
+
+	parsedPrefix, parsedSecret, ok := ParseKey(prefix + "." + secret)
+	require.True(t, ok)
+	require.Equal(t, prefix, parsedPrefix)
+	require.Equal(t, secret, parsedSecret)
+
+	other, _, err := NewKey()
+	require.NoError(t, err)
+	require.NotEqual(t, prefix, other)
+}
+
+func TestParseKey_RejectsMalformedKeys(t *testing.T) {
+	for _, key := range []string{"", "ik_abc", "ik_.secret", "ik_abc.", "xx_abc.secret", "secret"} {
+		_, _, ok := ParseKey(key)
+		require.False(t, ok, key)
+	}
+}
+
+func TestHashSecret(t *testing.T) {
+	require.Equal(t, HashSecret("secret"), HashSecret("secret"))
+	require.NotEqual(t, HashSecret("secret"), HashSecret("Secret"))
+	require.NotContains(t, HashSecret("secret"), "secret")
+}
+
+func TestExpiredAndScopes(t *testing.T) {
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+	key := APIKey{Scopes: []string{"invoice:read"}, ExpiresAt: &expiresAt}
+
+	require.False(t, key.Expired(now))
+	require.True(t, key.Expired(expiresAt))
+	require.False(t, (&APIKey{}).Expired(now))
+
+	require.True(t, key.HasScope("invoice:read"))
+	require.False(t, key.HasScope("invoice:write"))
+}
@@ -0,0 +1,87 @@
+package query
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
+	"invoice-api/internal/features/apikey/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DefaultQuery struct {
+	OrgID primitive.ObjectID
+}
+
+func (c *DefaultQuery) CollectionName() string {
+	return "api_keys"
+}
+
+type Query interface {
+	GetItems() ([]model.APIKey, error)
+	GetItemByID(id string) (*model.APIKey, error)
+}
+
+// GetItems returns the organization's keys, newest first, including revoked
+// and expired ones.
+func (c *DefaultQuery) GetItems() ([]model.APIKey, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]model.APIKey, 0, 20)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (c *DefaultQuery) GetItemByID(id string) (*model.APIKey, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var item model.APIKey
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&item); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// GetItemByPrefix looks a key up by its prefix across organizations, to
+// authenticate a request before its organization is known.
+func (c *DefaultQuery) GetItemByPrefix(prefix string) (*model.APIKey, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var item model.APIKey
+	if err := collection.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&item); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
@@ -0,0 +1,21 @@
+package route
+
+import (
+	"invoice-api/internal/features/apikey/controller"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type APIKeyRoute struct{}
+
+func (c *APIKeyRoute) Init(router *fiber.App) {
+	controller := new(controller.APIKeyController)
+	apiKeys := router.Group("/api-keys")
+
+	apiKeys.Post("/", middleware.RequirePermission(user_model.PermAPIKeyManage), controller.CreateAPIKey)
+	apiKeys.Get("/", middleware.RequirePermission(user_model.PermAPIKeyManage), controller.GetAllAPIKeys)
+	apiKeys.Get("/:id", middleware.RequirePermission(user_model.PermAPIKeyManage), controller.GetAPIKeyByID)
+	apiKeys.Delete("/:id", middleware.RequirePermission(user_model.PermAPIKeyManage), controller.RevokeAPIKey)
+}
@@ -0,0 +1,672 @@
+package command
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	"invoice-api/internal/features/auth/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DefaultCommand struct{}
+
+func (c *DefaultCommand) CollectionName() string {
+	return "refresh_tokens"
+}
+
+type Command interface {
+	CreateRefreshToken(userID primitive.ObjectID, orgID primitive.ObjectID, familyID primitive.ObjectID, twoFactor bool) (string, error)
+	RotateRefreshToken(token string) (*model.RefreshToken, string, error)
+	RevokeFamily(familyID primitive.ObjectID) error
+	RevokeAccessToken(tokenID string, expiresAt time.Time) error
+	RevokeUser(userID primitive.ObjectID) error
+	CreateUserToken(item model.UserToken, ttl time.Duration) (string, error)
+	FindUserToken(token string, purpose string) (*model.UserToken, error)
+	UseUserToken(token string, purpose string) (*model.UserToken, error)
+	FailUserToken(id primitive.ObjectID, maxAttempts int) error
+	EnrollTwoFactor(userID primitive.ObjectID) (string, error)
+	ConfirmTwoFactor(userID primitive.ObjectID, code string) ([]string, error)
+	VerifyTwoFactor(userID primitive.ObjectID, code string) error
+	DisableTwoFactor(userID primitive.ObjectID) error
+	CreateOIDCLogin(verifier string, nonce string) (string, error)
+	UseOIDCLogin(state string) (*model.OIDCLogin, error)
+	RecordLoginFailure(key string, policy model.AttemptPolicy) (*model.LoginAttempts, error)
+	ResetLoginAttempts(key string) error
+}
+
+// EnsureIndexes looks refresh tokens, user tokens and single sign-on states
+// up by hash and lets MongoDB remove them, revocations and failed sign-in
+// counters once they expire. Users have at most one two-factor enrollment.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("refresh_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetName("tokenHash_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "familyId", Value: 1}},
+			Options: options.Index().SetName("familyId"),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("revoked_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "jti", Value: 1}},
+			Options: options.Index().SetName("jti").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "familyId", Value: 1}},
+			Options: options.Index().SetName("familyId").SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("user_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetName("tokenHash_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "purpose", Value: 1}},
+			Options: options.Index().SetName("userId_purpose"),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("two_factor").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userId", Value: 1}},
+		Options: options.Index().SetName("userId_unique").SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("oidc_logins").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "stateHash", Value: 1}},
+			Options: options.Index().SetName("stateHash_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("login_attempts").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetName("key_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// CreateRefreshToken stores a new refresh token in the family and returns
+// it. The token itself is only ever returned here; the database keeps its
+// hash.
+func (c *DefaultCommand) CreateRefreshToken(userID primitive.ObjectID, orgID primitive.ObjectID, familyID primitive.ObjectID, twoFactor bool) (string, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := model.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = collection.InsertOne(ctx, model.RefreshToken{
+		UserID:    userID,
+		OrgID:     orgID,
+		FamilyID:  familyID,
+		TwoFactor: twoFactor,
+		TokenHash: model.HashToken(token),
+		ExpiresAt: now.Add(model.RefreshTokenTTL()),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for its successor, returning
+// the exchanged token and the new one. A token can be exchanged once; a
+// second attempt revokes its family and returns ErrRefreshTokenReused.
+func (c *DefaultCommand) RotateRefreshToken(token string) (*model.RefreshToken, string, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var current model.RefreshToken
+	err := collection.FindOne(ctx, bson.M{"tokenHash": model.HashToken(token)}).Decode(&current)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, "", model.ErrInvalidRefreshToken
+		}
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if current.UsedAt != nil || current.RevokedAt != nil {
+		if err := c.RevokeFamily(current.FamilyID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", model.ErrRefreshTokenReused
+	}
+	if !current.ExpiresAt.After(now) {
+		return nil, "", model.ErrInvalidRefreshToken
+	}
+
+	// Only one of several concurrent exchanges can mark the token used; the
+	// others are treated as reuse.
+	res, err := collection.UpdateOne(ctx,
+		bson.M{"_id": current.ID, "usedAt": bson.M{"$exists": false}, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"usedAt": now}})
+	if err != nil {
+		return nil, "", err
+	}
+	if res.ModifiedCount == 0 {
+		if err := c.RevokeFamily(current.FamilyID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", model.ErrRefreshTokenReused
+	}
+
+	next, err := c.CreateRefreshToken(current.UserID, current.OrgID, current.FamilyID, current.TwoFactor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &current, next, nil
+}
+
+// RevokeFamily revokes every refresh token of the family and blocks the
+// access tokens issued with them.
+func (c *DefaultCommand) RevokeFamily(familyID primitive.ObjectID) error {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"familyId": familyID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": now}})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("revoked_tokens").InsertOne(ctx, model.RevokedToken{
+		FamilyID:  familyID.Hex(),
+		ExpiresAt: now.Add(model.AccessTokenTTL()),
+	})
+	return err
+}
+
+// RevokeAccessToken blocks a single access token until it expires.
+func (c *DefaultCommand) RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+	db := database.GetDatabase()
+	collection := db.Collection("revoked_tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, model.RevokedToken{
+		TokenID:   tokenID,
+		ExpiresAt: expiresAt,
+	})
+	return err
+}
+
+// RevokeUser revokes every refresh token family of the user, signing them
+// out everywhere.
+func (c *DefaultCommand) RevokeUser(userID primitive.ObjectID) error {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	families, err := collection.Distinct(ctx, "familyId", bson.M{"userId": userID, "revokedAt": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+
+	for _, family := range families {
+		familyID, ok := family.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		if err := c.RevokeFamily(familyID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateUserToken issues a single-use token for the user and purpose of item
+// and returns it. Earlier unused tokens of the user for the same purpose stop
+// working.
+func (c *DefaultCommand) CreateUserToken(item model.UserToken, ttl time.Duration) (string, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("user_tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := model.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = collection.DeleteMany(ctx, bson.M{"userId": item.UserID, "purpose": item.Purpose, "usedAt": bson.M{"$exists": false}})
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = collection.InsertOne(ctx, model.UserToken{
+		UserID:    item.UserID,
+		Purpose:   item.Purpose,
+		OrgID:     item.OrgID,
+		Email:     item.Email,
+		TokenHash: model.HashToken(token),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// UseUserToken redeems a token issued for the purpose. It returns
+// ErrInvalidUserToken when the token is unknown, expired or already used.
+func (c *DefaultCommand) UseUserToken(token string, purpose string) (*model.UserToken, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("user_tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{
+		"tokenHash": model.HashToken(token),
+		"purpose":   purpose,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": now},
+	}
+
+	var item model.UserToken
+	err := collection.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"usedAt": now}}).Decode(&item)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, model.ErrInvalidUserToken
+		}
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// FindUserToken returns a token issued for the purpose without using it up.
+// It returns ErrInvalidUserToken when the token is unknown, expired or
+// already used.
+func (c *DefaultCommand) FindUserToken(token string, purpose string) (*model.UserToken, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("user_tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"tokenHash": model.HashToken(token),
+		"purpose":   purpose,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+
+	var item model.UserToken
+	if err := collection.FindOne(ctx, filter).Decode(&item); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, model.ErrInvalidUserToken
+		}
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// FailUserToken counts a failed attempt at the token and uses it up once
+// maxAttempts have failed.
+func (c *DefaultCommand) FailUserToken(id primitive.ObjectID, maxAttempts int) error {
+	db := database.GetDatabase()
+	collection := db.Collection("user_tokens")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var item model.UserToken
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&item)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+
+	if item.Attempts >= maxAttempts {
+		_, err = collection.UpdateOne(ctx,
+			bson.M{"_id": id, "usedAt": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"usedAt": time.Now()}})
+	}
+	return err
+}
+
+// EnrollTwoFactor starts, or restarts, the user's TOTP enrollment and returns
+// the new secret. The enrollment stays pending until ConfirmTwoFactor.
+func (c *DefaultCommand) EnrollTwoFactor(userID primitive.ObjectID) (string, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("two_factor")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	secret, err := model.NewTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := model.EncryptSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	// An enabled enrollment does not match, so the upsert collides with it
+	// on the unique userId index instead of replacing it.
+	_, err = collection.UpdateOne(ctx,
+		bson.M{"userId": userID, "enabled": false},
+		bson.M{"$set": bson.M{
+			"secret":        encrypted,
+			"enabled":       false,
+			"recoveryCodes": []string{},
+			"lastStep":      0,
+			"createdAt":     time.Now(),
+		}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return "", model.ErrTwoFactorEnabled
+		}
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// ConfirmTwoFactor enables the pending enrollment if code is valid for its
+// secret, and returns new recovery codes. They are only ever returned here.
+func (c *DefaultCommand) ConfirmTwoFactor(userID primitive.ObjectID, code string) ([]string, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("two_factor")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var item model.TwoFactor
+	if err := collection.FindOne(ctx, bson.M{"userId": userID}).Decode(&item); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, model.ErrTwoFactorNotEnrolled
+		}
+		return nil, err
+	}
+	if item.Enabled {
+		return nil, model.ErrTwoFactorEnabled
+	}
+
+	secret, err := model.DecryptSecret(item.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := model.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, model.ErrInvalidTwoFactorCode
+	}
+
+	codes, err := model.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = model.HashRecoveryCode(code)
+	}
+
+	now := time.Now()
+	res, err := collection.UpdateOne(ctx,
+		bson.M{"_id": item.ID, "enabled": false, "secret": item.Secret},
+		bson.M{"$set": bson.M{
+			"enabled":       true,
+			"recoveryCodes": hashes,
+			"lastStep":      step,
+			"confirmedAt":   now,
+		}})
+	if err != nil {
+		return nil, err
+	}
+	if res.ModifiedCount == 0 {
+		return nil, model.ErrInvalidTwoFactorCode
+	}
+
+	return codes, nil
+}
+
+// VerifyTwoFactor accepts a TOTP code or a recovery code for the user's
+// enabled enrollment. Each TOTP code and each recovery code is accepted once.
+func (c *DefaultCommand) VerifyTwoFactor(userID primitive.ObjectID, code string) error {
+	db := database.GetDatabase()
+	collection := db.Collection("two_factor")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var item model.TwoFactor
+	if err := collection.FindOne(ctx, bson.M{"userId": userID, "enabled": true}).Decode(&item); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return model.ErrTwoFactorNotEnrolled
+		}
+		return err
+	}
+
+	secret, err := model.DecryptSecret(item.Secret)
+	if err != nil {
+		return err
+	}
+
+	var res *mongo.UpdateResult
+	if step, ok := model.ValidateTOTP(secret, code, time.Now()); ok {
+		res, err = collection.UpdateOne(ctx,
+			bson.M{"_id": item.ID, "lastStep": bson.M{"$lt": step}},
+			bson.M{"$set": bson.M{"lastStep": step}})
+	} else {
+		hash := model.HashRecoveryCode(code)
+		res, err = collection.UpdateOne(ctx,
+			bson.M{"_id": item.ID, "recoveryCodes": hash},
+			bson.M{"$pull": bson.M{"recoveryCodes": hash}})
+	}
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return model.ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// DisableTwoFactor removes the user's enrollment.
+func (c *DefaultCommand) DisableTwoFactor(userID primitive.ObjectID) error {
+	db := database.GetDatabase()
+	collection := db.Collection("two_factor")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := collection.DeleteOne(ctx, bson.M{"userId": userID})
+	return err
+}
+
+// CreateOIDCLogin starts a single sign-on and returns its state, which only
+// the user's browser and the identity provider see; the database keeps its
+// hash.
+func (c *DefaultCommand) CreateOIDCLogin(verifier string, nonce string) (string, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("oidc_logins")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	state, err := model.NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = collection.InsertOne(ctx, model.OIDCLogin{
+		StateHash: model.HashToken(state),
+		Verifier:  verifier,
+		Nonce:     nonce,
+		ExpiresAt: now.Add(model.OIDCLoginTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return state, nil
+}
+
+// UseOIDCLogin ends the single sign-on with the state, so that each state is
+// accepted once. It returns ErrInvalidOIDCState when the state is unknown,
+// expired or already used.
+func (c *DefaultCommand) UseOIDCLogin(state string) (*model.OIDCLogin, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("oidc_logins")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"stateHash": model.HashToken(state),
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+
+	var item model.OIDCLogin
+	if err := collection.FindOneAndDelete(ctx, filter).Decode(&item); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, model.ErrInvalidOIDCState
+		}
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// RecordLoginFailure counts a failed sign-in against the key and locks it
+// once the policy's MaxAttempts is reached. A lockout that has ended starts
+// the count over. The counters live in the database so that every instance
+// of the API sees the same ones, and a single update both counts and locks,
+// so that concurrent failures are neither lost nor let past the lockout.
+func (c *DefaultCommand) RecordLoginFailure(key string, policy model.AttemptPolicy) (*model.LoginAttempts, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("login_attempts")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	lockedUntil := now.Add(policy.Lockout)
+	ended := bson.M{"$and": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": "$lockedUntil"}, "date"}},
+		bson.M{"$lte": bson.A{"$lockedUntil", now}},
+	}}
+	reached := bson.M{"$gte": bson.A{"$failures", policy.MaxAttempts}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"failures":    bson.M{"$cond": bson.A{ended, 0, bson.M{"$ifNull": bson.A{"$failures", 0}}}},
+			"lockedUntil": bson.M{"$cond": bson.A{ended, "$$REMOVE", "$lockedUntil"}},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"failures":      bson.M{"$add": bson.A{"$failures", 1}},
+			"lastFailureAt": now,
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"failures":    bson.M{"$cond": bson.A{reached, 0, "$failures"}},
+			"lockedUntil": bson.M{"$cond": bson.A{reached, lockedUntil, "$lockedUntil"}},
+			"expiresAt":   bson.M{"$cond": bson.A{reached, lockedUntil.Add(policy.Window), now.Add(policy.Window)}},
+		}}},
+	}
+
+	var item model.LoginAttempts
+	err := collection.FindOneAndUpdate(ctx, bson.M{"key": key}, update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&item)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// ResetLoginAttempts forgets the failed sign-ins of the key, lifting any
+// lockout.
+func (c *DefaultCommand) ResetLoginAttempts(key string) error {
+	db := database.GetDatabase()
+	collection := db.Collection("login_attempts")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := collection.DeleteOne(ctx, bson.M{"key": key})
+	return err
+}
@@ -0,0 +1,45 @@
+package command
+
+import (
+	"context"
+	"invoice-api/internal/database/dbtest"
+	"invoice-api/internal/features/auth/model"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordLoginFailure_Concurrent(t *testing.T) {
+	db := dbtest.Start(t)
+	require.NoError(t, EnsureIndexes(context.Background(), db))
+
+	policy := model.AttemptPolicy{FreeAttempts: 1, MaxAttempts: 5, Lockout: time.Minute, Window: time.Hour}
+	cmd := &DefaultCommand{}
+
+	// 12 failures at once lock the key twice and leave 2 counted
+	const failures = 12
+	var wg sync.WaitGroup
+	items := make([]*model.LoginAttempts, failures)
+	errs := make([]error, failures)
+	for i := 0; i < failures; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			items[i], errs[i] = cmd.RecordLoginFailure("account:a@b.com", policy)
+		}(i)
+	}
+	wg.Wait()
+
+	counts, locked := map[int]int{}, 0
+	for i, item := range items {
+		require.NoError(t, errs[i])
+		counts[item.Failures]++
+		if item.LockedUntil != nil {
+			locked++
+		}
+	}
+	require.Equal(t, map[int]int{0: 2, 1: 3, 2: 3, 3: 2, 4: 2}, counts)
+	require.Equal(t, failures-policy.MaxAttempts+1, locked)
+}
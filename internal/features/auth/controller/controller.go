@@ -1,16 +1,32 @@
 package controller
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	auth_command "invoice-api/internal/features/auth/command"
+	auth_model "invoice-api/internal/features/auth/model"
+	auth_query "invoice-api/internal/features/auth/query"
+	org_command "invoice-api/internal/features/org/command"
+	org_model "invoice-api/internal/features/org/model"
+	org_query "invoice-api/internal/features/org/query"
 	"invoice-api/internal/features/user/command"
 	"invoice-api/internal/features/user/model"
 	"invoice-api/internal/features/user/query"
+	"invoice-api/internal/mailer"
+	"invoice-api/internal/oidc"
+	"invoice-api/middleware"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -18,6 +34,14 @@ import (
 type AuthController struct {
 	Command command.Command
 	Query   query.Query
+	Orgs    org_query.Query
+	Tokens  auth_command.Command
+	Auth    auth_query.Query
+	Mailer  mailer.Mailer
+	// OIDC, SSO and Members back single sign-on.
+	OIDC    *oidc.Provider
+	SSO     *auth_model.SSOSettings
+	Members org_command.Command
 }
 
 func (s *AuthController) SignUpUser(c *fiber.Ctx) error {
@@ -25,9 +49,6 @@ func (s *AuthController) SignUpUser(c *fiber.Ctx) error {
 	if s.Query == nil {
 		s.Query = &query.DefaultQuery{}
 	}
-	if s.Command == nil {
-		s.Command = &command.DefaultCommand{}
-	}
 
 	if err := c.BodyParser(&payload); err != nil {
 		fmt.Printf("error: %+v\n", err)
@@ -38,22 +59,30 @@ func (s *AuthController) SignUpUser(c *fiber.Ctx) error {
 	if len(errors) > 0 {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "Some fields are required. Please fill in the required fields", "errors": errors})
 	}
+	// Roles are assigned by user managers, never chosen at sign-up.
+	payload.Role = ""
 
 	user, err := s.Query.GetItemByEmail(payload.Email)
 	if user != (model.User{}) {
 		return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "The email address already taken. Please select another email address"})
 	}
 
-	if err != nil {
+	if err != nil && err != mongo.ErrNoDocuments {
 		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
 	}
 
-	_, err = s.Command.CreateUser(payload)
+	res, err := s.users(primitive.NilObjectID).CreateUser(payload)
 	if err != nil {
 		return c.Status(400).SendString(err.Error())
 	}
 
-	return c.Status(201).JSON(fiber.Map{"status": "success", "message": "User created successfully"})
+	user = model.User{FirstName: payload.FirstName, Email: model.NormalizeEmail(payload.Email)}
+	user.ID, _ = res.InsertedID.(primitive.ObjectID)
+	if err := s.sendVerification(user); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "User created, but the verification email could not be sent"})
+	}
+
+	return c.Status(201).JSON(fiber.Map{"status": "success", "message": "User created successfully. Check your email to verify your email address"})
 }
 
 func (s *AuthController) SignInUser(c *fiber.Ctx) error {
@@ -72,28 +101,406 @@ func (s *AuthController) SignInUser(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "message": "Some fields are required. Please fill in the required fields", "errors": errors})
 	}
 
+	accountKey, ipKey := auth_model.AccountKey(payload.Email), auth_model.IPKey(c.IP())
+	blockedUntil, err := s.blockedUntil(accountKey, ipKey)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to sign in"})
+	}
+	if time.Until(blockedUntil) > 0 {
+		return tooManyAttempts(c, blockedUntil)
+	}
+
 	user, err := s.Query.GetItemByEmail(payload.Email)
 	if err != nil {
-		return c.Status(404).JSON(fiber.Map{"status": "fail", "message": "User not found"})
+		if err != mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to sign in"})
+		}
+		// compare anyway so that unknown emails take as long as wrong passwords
+		bcrypt.CompareHashAndPassword(dummyPassword(), []byte(payload.Password))
+		return s.signInFailed(c, accountKey, ipKey)
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password))
 	if err != nil {
-		fmt.Printf("err: %+v\n", err)
-		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": "Invalid email or Password"})
+		return s.signInFailed(c, accountKey, ipKey)
+	}
+
+	if !user.EmailVerified {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "fail", "message": "Please verify your email address before signing in"})
+	}
+
+	var orgID primitive.ObjectID
+	if payload.OrgID != "" {
+		if orgID, err = primitive.ObjectIDFromHex(payload.OrgID); err != nil {
+			return c.Status(400).JSON(fiber.Map{"status": "fail", "message": "Invalid organization ID"})
+		}
+	}
+
+	enabled, err := s.auth().TwoFactorEnabled(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to check two-factor authentication"})
+	}
+	if enabled {
+		// the failures are forgotten once the second factor is right too
+		return s.challenge(c, user.ID, user.Email, orgID)
+	}
+
+	if err := s.tokens().ResetLoginAttempts(accountKey); err != nil {
+		fmt.Printf("Error: %+v\n", err)
+	}
+
+	return s.issueToken(c, user.ID, orgID, false)
+}
+
+// blockedUntil is when the account or client address may try to sign in
+// again after too many failures, or the zero time when it may now.
+func (s *AuthController) blockedUntil(accountKey string, ipKey string) (time.Time, error) {
+	attempts, err := s.auth().GetLoginAttempts([]string{accountKey, ipKey})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var until time.Time
+	for _, item := range attempts {
+		policy := auth_model.IPPolicy
+		if item.Key == accountKey {
+			policy = auth_model.AccountPolicy
+		}
+		if blocked := policy.BlockedUntil(item); blocked.After(until) {
+			until = blocked
+		}
+	}
+	return until, nil
+}
+
+// tooManyAttempts refuses a sign-in until the time blockedUntil returned.
+func tooManyAttempts(c *fiber.Ctx, until time.Time) error {
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"status": "fail", "message": "Too many failed sign-in attempts. Please try again later", "retryAfter": retryAfter})
+}
+
+// signInFailed counts a failed sign-in against the account and the client
+// address. Unknown emails and wrong passwords get the same response, so that
+// it does not tell which accounts exist.
+func (s *AuthController) signInFailed(c *fiber.Ctx, accountKey string, ipKey string) error {
+	if lockedUntil := s.recordFailure(accountKey, ipKey); time.Until(lockedUntil) > 0 {
+		return tooManyAttempts(c, lockedUntil)
 	}
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "Invalid email or password"})
+}
+
+// recordFailure counts a wrong password or second factor against the
+// account and the client address. It returns until when the failure locked
+// either of them, as counted by the database, or the zero time.
+func (s *AuthController) recordFailure(accountKey string, ipKey string) time.Time {
+	var lockedUntil time.Time
+	for _, key := range []string{accountKey, ipKey} {
+		policy := auth_model.IPPolicy
+		if key == accountKey {
+			policy = auth_model.AccountPolicy
+		}
+		item, err := s.tokens().RecordLoginFailure(key, policy)
+		if err != nil {
+			fmt.Printf("Error: %+v\n", err)
+			continue
+		}
+		if item.LockedUntil != nil && item.LockedUntil.After(lockedUntil) {
+			lockedUntil = *item.LockedUntil
+		}
+	}
+	return lockedUntil
+}
 
-	tokenByte := jwt.New(jwt.SigningMethodHS256)
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// dummyPassword is a hash no password matches, compared against when the
+// email is unknown.
+func dummyPassword() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte(primitive.NewObjectID().Hex()), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+// challenge responds to a valid password of a user with two-factor
+// authentication with a challenge token, which VerifyTwoFactor exchanges for
+// a session together with a code. Wrong codes count against the email's
+// account like wrong passwords.
+func (s *AuthController) challenge(c *fiber.Ctx, userID primitive.ObjectID, email string, orgID primitive.ObjectID) error {
+	token, err := s.tokens().CreateUserToken(auth_model.UserToken{
+		UserID:  userID,
+		OrgID:   orgID,
+		Purpose: auth_model.PurposeTwoFactorChallenge,
+		Email:   email,
+	}, auth_model.ChallengeTTL)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to create sign-in challenge"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":         "two_factor_required",
+		"challengeToken": token,
+		"expiresIn":      int(auth_model.ChallengeTTL.Seconds()),
+	})
+}
+
+// VerifyTwoFactor completes a sign-in with the challenge token from
+// SignInUser and a code from the user's authenticator app or one of their
+// recovery codes. A challenge is used up after too many wrong codes, and
+// wrong codes lock the account and the client address like wrong passwords.
+func (s *AuthController) VerifyTwoFactor(c *fiber.Ctx) error {
+	payload := new(auth_model.TwoFactorChallenge)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if len(errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": errors})
+	}
+
+	challenge, err := s.tokens().FindUserToken(payload.ChallengeToken, auth_model.PurposeTwoFactorChallenge)
+	if err != nil {
+		if err == auth_model.ErrInvalidUserToken {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "The sign-in challenge is invalid or has expired. Please sign in again"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to verify code"})
+	}
+
+	accountKey, ipKey := auth_model.AccountKey(challenge.Email), auth_model.IPKey(c.IP())
+	blockedUntil, err := s.blockedUntil(accountKey, ipKey)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to verify code"})
+	}
+	if time.Until(blockedUntil) > 0 {
+		return tooManyAttempts(c, blockedUntil)
+	}
+
+	if err := s.tokens().VerifyTwoFactor(challenge.UserID, payload.Code); err != nil {
+		if err != auth_model.ErrInvalidTwoFactorCode && err != auth_model.ErrTwoFactorNotEnrolled {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to verify code"})
+		}
+		if err := s.tokens().FailUserToken(challenge.ID, auth_model.MaxChallengeAttempts); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to verify code"})
+		}
+		if lockedUntil := s.recordFailure(accountKey, ipKey); time.Until(lockedUntil) > 0 {
+			return tooManyAttempts(c, lockedUntil)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "Invalid authentication code"})
+	}
+
+	// Using the challenge up fails if the code was raced with another one.
+	if _, err := s.tokens().UseUserToken(payload.ChallengeToken, auth_model.PurposeTwoFactorChallenge); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "The sign-in challenge is invalid or has expired. Please sign in again"})
+	}
+
+	if err := s.tokens().ResetLoginAttempts(accountKey); err != nil {
+		fmt.Printf("Error: %+v\n", err)
+	}
+
+	return s.issueToken(c, challenge.UserID, challenge.OrgID, true)
+}
+
+// EnrollTwoFactor starts two-factor enrollment for the authenticated user.
+// The secret is returned once, also as an otpauth URI to show as a QR code;
+// it takes effect when ConfirmTwoFactor receives a first code.
+func (s *AuthController) EnrollTwoFactor(c *fiber.Ctx) error {
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "You are not logged in"})
+	}
+
+	secret, err := s.tokens().EnrollTwoFactor(user.ID)
+	if err != nil {
+		if err == auth_model.ErrTwoFactorEnabled {
+			return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "Two-factor authentication is already enabled"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to enroll in two-factor authentication"})
+	}
 
+	return c.Status(201).JSON(fiber.Map{
+		"secret":     secret,
+		"otpauthUri": auth_model.OTPAuthURI(auth_model.TwoFactorIssuer(), user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor enables two-factor authentication with a first code from
+// the authenticator app and returns the recovery codes, which are not shown
+// again.
+func (s *AuthController) ConfirmTwoFactor(c *fiber.Ctx) error {
+	payload := new(auth_model.TwoFactorCode)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if len(errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": errors})
+	}
+
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "You are not logged in"})
+	}
+
+	codes, err := s.tokens().ConfirmTwoFactor(user.ID, payload.Code)
+	if err != nil {
+		switch err {
+		case auth_model.ErrTwoFactorEnabled:
+			return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "Two-factor authentication is already enabled"})
+		case auth_model.ErrTwoFactorNotEnrolled, auth_model.ErrInvalidTwoFactorCode:
+			return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to confirm two-factor authentication"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "recoveryCodes": codes})
+}
+
+// DisableTwoFactor turns two-factor authentication off after checking a
+// code. It is refused while the current organization requires it.
+func (s *AuthController) DisableTwoFactor(c *fiber.Ctx) error {
+	if s.Orgs == nil {
+		s.Orgs = &org_query.DefaultQuery{}
+	}
+
+	payload := new(auth_model.TwoFactorCode)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if len(errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": errors})
+	}
+
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "You are not logged in"})
+	}
+
+	org, err := s.Orgs.GetOrganization(middleware.OrgID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to load organization"})
+	}
+	if org.RequireTwoFactor {
+		return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "This organization requires two-factor authentication"})
+	}
+
+	if err := s.tokens().VerifyTwoFactor(user.ID, payload.Code); err != nil {
+		if err == auth_model.ErrTwoFactorNotEnrolled || err == auth_model.ErrInvalidTwoFactorCode {
+			return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to verify code"})
+	}
+
+	if err := s.tokens().DisableTwoFactor(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to disable two-factor authentication"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success"})
+}
+
+// SwitchOrganization issues the authenticated user a token for another of
+// their organizations.
+func (s *AuthController) SwitchOrganization(c *fiber.Ctx) error {
+	payload := new(org_model.SwitchOrganization)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+	}
+
+	errors := org_model.ValidateStruct(payload)
+	if len(errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": errors})
+	}
+
+	orgID, err := primitive.ObjectIDFromHex(payload.OrgID)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": "Invalid organization ID"})
+	}
+
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "You are not logged in"})
+	}
+
+	token, _ := middleware.CurrentToken(c)
+	return s.issueToken(c, user.ID, orgID, token.TwoFactor)
+}
+
+// users returns the injected user command, or one that adds the users it
+// creates to orgID. Without an organization, CreateUser gives the user a
+// workspace of their own.
+func (s *AuthController) users(orgID primitive.ObjectID) command.Command {
+	if s.Command != nil {
+		return s.Command
+	}
+	return &command.DefaultCommand{OrgID: orgID}
+}
+
+func (s *AuthController) tokens() auth_command.Command {
+	if s.Tokens == nil {
+		s.Tokens = &auth_command.DefaultCommand{}
+	}
+	return s.Tokens
+}
+
+func (s *AuthController) auth() auth_query.Query {
+	if s.Auth == nil {
+		s.Auth = &auth_query.DefaultQuery{}
+	}
+	return s.Auth
+}
+
+// issueToken signs the user in to the organization, or to their first one
+// when orgID is zero, starting a new refresh token family. twoFactor records
+// that the user passed two-factor authentication.
+func (s *AuthController) issueToken(c *fiber.Ctx, userID primitive.ObjectID, orgID primitive.ObjectID, twoFactor bool) error {
+	if s.Orgs == nil {
+		s.Orgs = &org_query.DefaultQuery{}
+	}
+
+	membership, err := s.Orgs.GetMembership(userID, orgID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "fail", "message": "You are not a member of this organization"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to load organization"})
+	}
+
+	familyID := primitive.NewObjectID()
+	refreshToken, err := s.tokens().CreateRefreshToken(userID, membership.OrgID, familyID, twoFactor)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to create refresh token"})
+	}
+
+	return sendTokens(c, userID, membership.OrgID, familyID, twoFactor, refreshToken)
+}
+
+// sendTokens responds with a new access token and the refresh token, and
+// sets both as cookies.
+func sendTokens(c *fiber.Ctx, userID primitive.ObjectID, orgID primitive.ObjectID, familyID primitive.ObjectID, twoFactor bool, refreshToken string) error {
 	now := time.Now().UTC()
-	claims := tokenByte.Claims.(jwt.MapClaims)
+	ttl := auth_model.AccessTokenTTL()
+	claims := jwt.MapClaims{}
 
-	claims["id"] = user.ID
-	claims["exp"] = now.Add(time.Hour * 24).Unix()
+	claims["sub"] = userID.Hex()
+	claims["org"] = orgID.Hex()
+	claims["jti"] = primitive.NewObjectID().Hex()
+	claims["fam"] = familyID.Hex()
+	claims["amr"] = []string{"pwd"}
+	if twoFactor {
+		claims["amr"] = []string{"pwd", "otp"}
+	}
+	claims["exp"] = now.Add(ttl).Unix()
 	claims["iat"] = now.Unix()
 	claims["nbf"] = now.Unix()
 
-	tokenString, err := tokenByte.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	tokenString, err := middleware.SignToken(claims)
 
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "fail", "message": fmt.Sprintf("generating JWT Token failed: %v", err)})
@@ -103,25 +510,261 @@ func (s *AuthController) SignInUser(c *fiber.Ctx) error {
 		Name:     "token",
 		Value:    tokenString,
 		Path:     "/",
-		MaxAge:   60 * 60,
+		MaxAge:   int(ttl.Seconds()),
 		Secure:   false,
 		HTTPOnly: true,
 		Domain:   "localhost",
 	})
+	c.Cookie(&fiber.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		Path:     "/",
+		MaxAge:   int(auth_model.RefreshTokenTTL().Seconds()),
+		Secure:   false,
+		HTTPOnly: true,
+		Domain:   "localhost",
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":       "success",
+		"token":        tokenString,
+		"expiresIn":    int(ttl.Seconds()),
+		"refreshToken": refreshToken,
+		"orgId":        orgID,
+	})
+}
+
+// RefreshToken exchanges a refresh token, from the body or the
+// `refresh_token` cookie, for a new access token and refresh token. Reusing
+// an exchanged refresh token revokes every token of its sign-in.
+func (s *AuthController) RefreshToken(c *fiber.Ctx) error {
+	payload := new(auth_model.Refresh)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(payload); err != nil {
+			return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+		}
+	}
+	if payload.RefreshToken == "" {
+		payload.RefreshToken = c.Cookies("refresh_token")
+	}
+	if payload.RefreshToken == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "Refresh token is required"})
+	}
+
+	previous, refreshToken, err := s.tokens().RotateRefreshToken(payload.RefreshToken)
+	if err != nil {
+		switch err {
+		case auth_model.ErrRefreshTokenReused:
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "Refresh token was already used. Please sign in again"})
+		case auth_model.ErrInvalidRefreshToken:
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to refresh token"})
+	}
+
+	// The user may have left the organization since signing in.
+	if s.Orgs == nil {
+		s.Orgs = &org_query.DefaultQuery{}
+	}
+	if _, err := s.Orgs.GetMembership(previous.UserID, previous.OrgID); err != nil {
+		if err := s.tokens().RevokeFamily(previous.FamilyID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to revoke refresh token"})
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "fail", "message": "You are not a member of this organization"})
+	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "token": tokenString})
+	return sendTokens(c, previous.UserID, previous.OrgID, previous.FamilyID, previous.TwoFactor, refreshToken)
 }
 
+// LogoutUser revokes the access token and its refresh token family, so
+// neither can be used again, and clears the cookies.
 func (s *AuthController) LogoutUser(c *fiber.Ctx) error {
+	if token, ok := middleware.CurrentToken(c); ok {
+		if token.ID != "" {
+			if err := s.tokens().RevokeAccessToken(token.ID, token.ExpiresAt); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to revoke token"})
+			}
+		}
+		if familyID, err := primitive.ObjectIDFromHex(token.FamilyID); err == nil {
+			if err := s.tokens().RevokeFamily(familyID); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to revoke token"})
+			}
+		}
+	}
+
 	expired := time.Now().Add(-time.Hour * 24)
 	c.Cookie(&fiber.Cookie{
 		Name:    "token",
 		Value:   "",
 		Expires: expired,
 	})
+	c.Cookie(&fiber.Cookie{
+		Name:    "refresh_token",
+		Value:   "",
+		Expires: expired,
+	})
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success"})
 }
 
+// ForgotPassword emails the user a link to reset their password. It responds
+// the same whether or not the email address belongs to a user.
+func (s *AuthController) ForgotPassword(c *fiber.Ctx) error {
+	if s.Query == nil {
+		s.Query = &query.DefaultQuery{}
+	}
+
+	payload := new(auth_model.ForgotPassword)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if len(errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": errors})
+	}
+
+	user, err := s.Query.GetItemByEmail(payload.Email)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to send password reset email"})
+	}
+
+	if user.ID != primitive.NilObjectID {
+		err := s.sendLink(user, auth_model.PurposePasswordReset, auth_model.PasswordResetTTL, "/reset-password",
+			"Reset your password",
+			"Use the link below to choose a new password. It expires in 1 hour and can only be used once.\n\n%s\n\nIf you did not ask to reset your password, you can ignore this email.")
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to send password reset email"})
+		}
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "If the email address belongs to an account, a password reset link has been sent to it"})
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword and
+// signs the user out everywhere.
+func (s *AuthController) ResetPassword(c *fiber.Ctx) error {
+	payload := new(auth_model.ResetPassword)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if len(errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": errors})
+	}
+
+	token, err := s.tokens().UseUserToken(payload.Token, auth_model.PurposePasswordReset)
+	if err != nil {
+		if err == auth_model.ErrInvalidUserToken {
+			return c.Status(400).JSON(fiber.Map{"status": "fail", "message": "The password reset link is invalid or has expired"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to reset password"})
+	}
+
+	if _, err := s.users(primitive.NilObjectID).SetPassword(token.UserID, payload.Password); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to reset password"})
+	}
+
+	if err := s.tokens().RevokeUser(token.UserID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to revoke sessions"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Your password has been reset. Please sign in"})
+}
+
+// VerifyEmail confirms the email address of a user who signed up.
+func (s *AuthController) VerifyEmail(c *fiber.Ctx) error {
+	payload := new(auth_model.VerifyEmail)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if len(errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": errors})
+	}
+
+	token, err := s.tokens().UseUserToken(payload.Token, auth_model.PurposeEmailVerification)
+	if err != nil {
+		if err == auth_model.ErrInvalidUserToken {
+			return c.Status(400).JSON(fiber.Map{"status": "fail", "message": "The verification link is invalid or has expired"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to verify email address"})
+	}
+
+	if _, err := s.users(primitive.NilObjectID).VerifyEmail(token.UserID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to verify email address"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Your email address has been verified. Please sign in"})
+}
+
+// ResendVerification sends a new verification link to a user who has not
+// verified their email address yet. Like ForgotPassword, it responds the same
+// whatever the email address.
+func (s *AuthController) ResendVerification(c *fiber.Ctx) error {
+	if s.Query == nil {
+		s.Query = &query.DefaultQuery{}
+	}
+
+	payload := new(auth_model.ResendVerification)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if len(errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": errors})
+	}
+
+	user, err := s.Query.GetItemByEmail(payload.Email)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to send verification email"})
+	}
+
+	if user.ID != primitive.NilObjectID && !user.EmailVerified {
+		if err := s.sendVerification(user); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to send verification email"})
+		}
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "If the email address belongs to an unverified account, a verification link has been sent to it"})
+}
+
+func (s *AuthController) sendVerification(user model.User) error {
+	return s.sendLink(user, auth_model.PurposeEmailVerification, auth_model.EmailVerificationTTL, "/verify-email",
+		"Verify your email address",
+		"Confirm your email address to finish signing up:\n\n%s\n\nThe link expires in 48 hours.")
+}
+
+// sendLink emails the user a link to path on APP_URL carrying a new token for
+// the purpose. text is the body of the email with %s in place of the link.
+func (s *AuthController) sendLink(user model.User, purpose string, ttl time.Duration, path string, subject string, text string) error {
+	if s.Mailer == nil {
+		s.Mailer = mailer.New()
+	}
+
+	token, err := s.tokens().CreateUserToken(auth_model.UserToken{UserID: user.ID, Purpose: purpose, Email: user.Email}, ttl)
+	if err != nil {
+		return err
+	}
+
+	baseURL := os.Getenv("APP_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:3001"
+	}
+	link := strings.TrimSuffix(baseURL, "/") + path + "?token=" + url.QueryEscape(token)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return s.Mailer.Send(ctx, mailer.Message{
+		To:      []string{user.Email},
+		Subject: subject,
+		Text:    fmt.Sprintf("Hi %s,\n\n", user.FirstName) + fmt.Sprintf(text, link) + "\n",
+	})
+}
+
 func (s *AuthController) GetUser(c *fiber.Ctx) error {
 	if s.Query == nil {
 		s.Query = &query.DefaultQuery{}
@@ -144,3 +787,138 @@ func (s *AuthController) GetUser(c *fiber.Ctx) error {
 
 	return c.JSON(user)
 }
+
+// UpdateProfile changes the names of the authenticated user.
+func (s *AuthController) UpdateProfile(c *fiber.Ctx) error {
+	if s.Command == nil {
+		s.Command = &command.DefaultCommand{}
+	}
+
+	payload := new(model.UpdateProfile)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if len(errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": errors})
+	}
+
+	user, ok := middleware.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "You are not logged in"})
+	}
+
+	if _, err := s.Command.UpdateProfile(user.ID, payload); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to update profile"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Your profile has been updated"})
+}
+
+// ChangeEmail sends a link to confirm a new email address to that address,
+// once the authenticated user has given their password. The address only
+// changes when the link is followed, so that nobody can take over an
+// address they cannot read, and the current address is told about it.
+func (s *AuthController) ChangeEmail(c *fiber.Ctx) error {
+	if s.Query == nil {
+		s.Query = &query.DefaultQuery{}
+	}
+
+	payload := new(auth_model.ChangeEmail)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if len(errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": errors})
+	}
+
+	current, ok := middleware.CurrentUser(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "You are not logged in"})
+	}
+
+	user, err := s.Query.GetItemByEmail(current.Email)
+	if err != nil || user.ID != current.ID {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to change email address"})
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password)); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "Invalid password"})
+	}
+
+	email := model.NormalizeEmail(payload.Email)
+	if email == user.Email {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": "This is already your email address"})
+	}
+	taken, err := s.Query.GetItemByEmail(email)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to change email address"})
+	}
+	if taken.ID != primitive.NilObjectID {
+		return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "The email address already taken. Please select another email address"})
+	}
+
+	err = s.sendLink(model.User{ID: user.ID, FirstName: user.FirstName, Email: email}, auth_model.PurposeEmailChange, auth_model.EmailChangeTTL, "/confirm-email",
+		"Confirm your new email address",
+		"Follow the link below to make this the email address of your account. It expires in 24 hours and can only be used once.\n\n%s\n\nIf you did not ask for this, you can ignore this email.")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to send confirmation email"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = s.Mailer.Send(ctx, mailer.Message{
+		To:      []string{user.Email},
+		Subject: "Your email address is being changed",
+		Text:    fmt.Sprintf("Hi %s,\n\nSomeone asked to change the email address of your account to %s. If this was not you, reset your password.\n", user.FirstName, email),
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to send confirmation email"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "A confirmation link has been sent to the new email address"})
+}
+
+// ConfirmEmail changes the user's email address to the one a link from
+// ChangeEmail was sent to, and signs them out everywhere.
+func (s *AuthController) ConfirmEmail(c *fiber.Ctx) error {
+	payload := new(auth_model.VerifyEmail)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if len(errors) > 0 {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": errors})
+	}
+
+	if s.Command == nil {
+		s.Command = &command.DefaultCommand{}
+	}
+
+	token, err := s.tokens().UseUserToken(payload.Token, auth_model.PurposeEmailChange)
+	if err != nil {
+		if err == auth_model.ErrInvalidUserToken {
+			return c.Status(400).JSON(fiber.Map{"status": "fail", "message": "The confirmation link is invalid or has expired"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to change email address"})
+	}
+
+	if _, err := s.Command.SetEmail(token.UserID, token.Email); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "The email address already taken. Please select another email address"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to change email address"})
+	}
+
+	if err := s.tokens().RevokeUser(token.UserID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to revoke sessions"})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "message": "Your email address has been changed. Please sign in"})
+}
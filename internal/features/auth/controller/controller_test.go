@@ -3,13 +3,25 @@ package controller
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	authmodel "invoice-api/internal/features/auth/model"
+	orgmodel "invoice-api/internal/features/org/model"
 	usermodel "invoice-api/internal/features/user/model"
+	"invoice-api/internal/mailer"
+	"invoice-api/internal/oidc"
+	"invoice-api/internal/oidc/oidctest"
+	"invoice-api/middleware"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
@@ -38,8 +50,268 @@ func (m *mockAuthQuery) GetItemsByQuery() ([]usermodel.UserDTO, error) {
     return []usermodel.UserDTO{}, nil
 }
 
+// mockOrgQuery makes every user a member of orgs, the first being their
+// default organization.
+type mockOrgQuery struct{
+    orgs []primitive.ObjectID
+    requireTwoFactor bool
+}
+
+func (m *mockOrgQuery) GetOrganization(orgID primitive.ObjectID) (*orgmodel.Organization, error) {
+    return &orgmodel.Organization{ID: orgID, RequireTwoFactor: m.requireTwoFactor}, nil
+}
+
+func (m *mockOrgQuery) GetOrganizations(userID primitive.ObjectID) ([]orgmodel.OrganizationDTO, error) {
+    return []orgmodel.OrganizationDTO{}, nil
+}
+
+func (m *mockOrgQuery) GetInvitations(email string) ([]orgmodel.InvitationDTO, error) {
+    return []orgmodel.InvitationDTO{}, nil
+}
+
+func (m *mockOrgQuery) GetMembership(userID primitive.ObjectID, orgID primitive.ObjectID) (*orgmodel.Membership, error) {
+    if len(m.orgs) == 0 {
+        return &orgmodel.Membership{OrgID: primitive.NewObjectID(), UserID: userID, Role: usermodel.RoleOwner}, nil
+    }
+    if orgID.IsZero() {
+        orgID = m.orgs[0]
+    }
+    for _, org := range m.orgs {
+        if org == orgID {
+            return &orgmodel.Membership{OrgID: org, UserID: userID, Role: usermodel.RoleViewer}, nil
+        }
+    }
+    return nil, mongo.ErrNoDocuments
+}
+
+// mockTokens keeps refresh tokens, user tokens, two-factor enrollments and
+// failed sign-ins in memory and follows the same rules as the database
+// command and query.
+type mockTokens struct{
+    tokens map[string]*authmodel.RefreshToken
+    revokedFamilies []primitive.ObjectID
+    revokedTokens []string
+    userTokens map[string]*authmodel.UserToken
+    revokedUsers []primitive.ObjectID
+    twoFactor map[primitive.ObjectID]*authmodel.TwoFactor
+    logins map[string]*authmodel.OIDCLogin
+    attempts map[string]*authmodel.LoginAttempts
+}
+
+func newMockTokens() *mockTokens {
+    return &mockTokens{
+        tokens: map[string]*authmodel.RefreshToken{},
+        userTokens: map[string]*authmodel.UserToken{},
+        twoFactor: map[primitive.ObjectID]*authmodel.TwoFactor{},
+        logins: map[string]*authmodel.OIDCLogin{},
+        attempts: map[string]*authmodel.LoginAttempts{},
+    }
+}
+
+func (m *mockTokens) CreateRefreshToken(userID primitive.ObjectID, orgID primitive.ObjectID, familyID primitive.ObjectID, twoFactor bool) (string, error) {
+    token := primitive.NewObjectID().Hex()
+    m.tokens[token] = &authmodel.RefreshToken{UserID: userID, OrgID: orgID, FamilyID: familyID, TwoFactor: twoFactor}
+    return token, nil
+}
+
+func (m *mockTokens) RotateRefreshToken(token string) (*authmodel.RefreshToken, string, error) {
+    current, ok := m.tokens[token]
+    if !ok {
+        return nil, "", authmodel.ErrInvalidRefreshToken
+    }
+    if current.UsedAt != nil || current.RevokedAt != nil {
+        m.RevokeFamily(current.FamilyID)
+        return nil, "", authmodel.ErrRefreshTokenReused
+    }
+    now := time.Now()
+    current.UsedAt = &now
+    next, _ := m.CreateRefreshToken(current.UserID, current.OrgID, current.FamilyID, current.TwoFactor)
+    return current, next, nil
+}
+
+func (m *mockTokens) RevokeFamily(familyID primitive.ObjectID) error {
+    now := time.Now()
+    for _, token := range m.tokens {
+        if token.FamilyID == familyID {
+            token.RevokedAt = &now
+        }
+    }
+    m.revokedFamilies = append(m.revokedFamilies, familyID)
+    return nil
+}
+
+func (m *mockTokens) RevokeAccessToken(tokenID string, expiresAt time.Time) error {
+    m.revokedTokens = append(m.revokedTokens, tokenID)
+    return nil
+}
+
+func (m *mockTokens) RevokeUser(userID primitive.ObjectID) error {
+    m.revokedUsers = append(m.revokedUsers, userID)
+    return nil
+}
+
+func (m *mockTokens) CreateUserToken(item authmodel.UserToken, ttl time.Duration) (string, error) {
+    for token, existing := range m.userTokens {
+        if existing.UserID == item.UserID && existing.Purpose == item.Purpose && existing.UsedAt == nil {
+            delete(m.userTokens, token)
+        }
+    }
+    token := primitive.NewObjectID().Hex()
+    item.ID = primitive.NewObjectID()
+    item.ExpiresAt = time.Now().Add(ttl)
+    m.userTokens[token] = &item
+    return token, nil
+}
+
+func (m *mockTokens) FindUserToken(token string, purpose string) (*authmodel.UserToken, error) {
+    item, ok := m.userTokens[token]
+    if !ok || item.Purpose != purpose || item.UsedAt != nil || !item.ExpiresAt.After(time.Now()) {
+        return nil, authmodel.ErrInvalidUserToken
+    }
+    return item, nil
+}
+
+func (m *mockTokens) UseUserToken(token string, purpose string) (*authmodel.UserToken, error) {
+    item, err := m.FindUserToken(token, purpose)
+    if err != nil {
+        return nil, err
+    }
+    now := time.Now()
+    item.UsedAt = &now
+    return item, nil
+}
+
+func (m *mockTokens) FailUserToken(id primitive.ObjectID, maxAttempts int) error {
+    for _, item := range m.userTokens {
+        if item.ID == id {
+            item.Attempts++
+            if item.Attempts >= maxAttempts {
+                now := time.Now()
+                item.UsedAt = &now
+            }
+        }
+    }
+    return nil
+}
+
+// The mock keeps TOTP secrets unencrypted.
+func (m *mockTokens) EnrollTwoFactor(userID primitive.ObjectID) (string, error) {
+    if item, ok := m.twoFactor[userID]; ok && item.Enabled {
+        return "", authmodel.ErrTwoFactorEnabled
+    }
+    secret, _ := authmodel.NewTOTPSecret()
+    m.twoFactor[userID] = &authmodel.TwoFactor{UserID: userID, Secret: secret}
+    return secret, nil
+}
+
+func (m *mockTokens) ConfirmTwoFactor(userID primitive.ObjectID, code string) ([]string, error) {
+    item, ok := m.twoFactor[userID]
+    if !ok {
+        return nil, authmodel.ErrTwoFactorNotEnrolled
+    }
+    if item.Enabled {
+        return nil, authmodel.ErrTwoFactorEnabled
+    }
+    step, ok := authmodel.ValidateTOTP(item.Secret, code, time.Now())
+    if !ok {
+        return nil, authmodel.ErrInvalidTwoFactorCode
+    }
+    codes, _ := authmodel.NewRecoveryCodes()
+    for _, code := range codes {
+        item.RecoveryCodes = append(item.RecoveryCodes, authmodel.HashRecoveryCode(code))
+    }
+    item.Enabled, item.LastStep = true, step
+    return codes, nil
+}
+
+func (m *mockTokens) VerifyTwoFactor(userID primitive.ObjectID, code string) error {
+    item, ok := m.twoFactor[userID]
+    if !ok || !item.Enabled {
+        return authmodel.ErrTwoFactorNotEnrolled
+    }
+    if step, ok := authmodel.ValidateTOTP(item.Secret, code, time.Now()); ok && step > item.LastStep {
+        item.LastStep = step
+        return nil
+    }
+    hash := authmodel.HashRecoveryCode(code)
+    for i, recovery := range item.RecoveryCodes {
+        if recovery == hash {
+            item.RecoveryCodes = append(item.RecoveryCodes[:i], item.RecoveryCodes[i+1:]...)
+            return nil
+        }
+    }
+    return authmodel.ErrInvalidTwoFactorCode
+}
+
+func (m *mockTokens) DisableTwoFactor(userID primitive.ObjectID) error {
+    delete(m.twoFactor, userID)
+    return nil
+}
+
+func (m *mockTokens) CreateOIDCLogin(verifier string, nonce string) (string, error) {
+    state := primitive.NewObjectID().Hex()
+    m.logins[state] = &authmodel.OIDCLogin{Verifier: verifier, Nonce: nonce, ExpiresAt: time.Now().Add(authmodel.OIDCLoginTTL)}
+    return state, nil
+}
+
+func (m *mockTokens) UseOIDCLogin(state string) (*authmodel.OIDCLogin, error) {
+    login, ok := m.logins[state]
+    if !ok {
+        return nil, authmodel.ErrInvalidOIDCState
+    }
+    delete(m.logins, state)
+    return login, nil
+}
+
+func (m *mockTokens) RecordLoginFailure(key string, policy authmodel.AttemptPolicy) (*authmodel.LoginAttempts, error) {
+    now := time.Now()
+    item, ok := m.attempts[key]
+    if !ok {
+        item = &authmodel.LoginAttempts{Key: key}
+        m.attempts[key] = item
+    }
+    if item.LockedUntil != nil && !item.LockedUntil.After(now) {
+        item.Failures, item.LockedUntil = 0, nil
+    }
+    item.Failures++
+    item.LastFailureAt = now
+    if item.Failures >= policy.MaxAttempts {
+        lockedUntil := now.Add(policy.Lockout)
+        item.Failures, item.LockedUntil = 0, &lockedUntil
+    }
+    return item, nil
+}
+
+func (m *mockTokens) ResetLoginAttempts(key string) error {
+    delete(m.attempts, key)
+    return nil
+}
+
+func (m *mockTokens) GetLoginAttempts(keys []string) ([]authmodel.LoginAttempts, error) {
+    items := []authmodel.LoginAttempts{}
+    for _, key := range keys {
+        if item, ok := m.attempts[key]; ok {
+            items = append(items, *item)
+        }
+    }
+    return items, nil
+}
+
+func (m *mockTokens) IsRevoked(tokenID string, familyID string) (bool, error) {
+    return false, nil
+}
+
+func (m *mockTokens) TwoFactorEnabled(userID primitive.ObjectID) (bool, error) {
+    item, ok := m.twoFactor[userID]
+    return ok && item.Enabled, nil
+}
+
 type mockAuthCommand struct{
     create func(u *usermodel.CreateUser) (*mongo.InsertOneResult, error)
+    passwords map[primitive.ObjectID]string
+    verified []primitive.ObjectID
+    emails map[primitive.ObjectID]string
+    profiles map[primitive.ObjectID]usermodel.UpdateProfile
 }
 
 func (m *mockAuthCommand) CreateUser(u *usermodel.CreateUser) (*mongo.InsertOneResult, error) {
@@ -53,10 +325,39 @@ func (m *mockAuthCommand) UpdateUser(id string, val *usermodel.UpdateUser) (*mon
     return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
 }
 
+func (m *mockAuthCommand) UpdateProfile(userID primitive.ObjectID, val *usermodel.UpdateProfile) (*mongo.UpdateResult, error) {
+    if m.profiles == nil {
+        m.profiles = map[primitive.ObjectID]usermodel.UpdateProfile{}
+    }
+    m.profiles[userID] = *val
+    return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (m *mockAuthCommand) SetEmail(userID primitive.ObjectID, email string) (*mongo.UpdateResult, error) {
+    if m.emails == nil {
+        m.emails = map[primitive.ObjectID]string{}
+    }
+    m.emails[userID] = email
+    return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
 func (m *mockAuthCommand) DeleteUser(id string) (*mongo.DeleteResult, error) {
     return &mongo.DeleteResult{DeletedCount: 1}, nil
 }
 
+func (m *mockAuthCommand) SetPassword(userID primitive.ObjectID, password string) (*mongo.UpdateResult, error) {
+    if m.passwords == nil {
+        m.passwords = map[primitive.ObjectID]string{}
+    }
+    m.passwords[userID] = password
+    return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (m *mockAuthCommand) VerifyEmail(userID primitive.ObjectID) (*mongo.UpdateResult, error) {
+    m.verified = append(m.verified, userID)
+    return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
 func TestGetUser_NotFoundAndSuccess(t *testing.T) {
     app := fiber.New()
 
@@ -100,7 +401,7 @@ func TestSignInUser_InvalidPayloadAndAuth(t *testing.T) {
     pw, _ := bcrypt.GenerateFromPassword([]byte("correct.pass321"), bcrypt.DefaultCost)
     ctrl2 := &AuthController{Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
         return usermodel.User{Email: email, Password: string(pw)}, nil
-    }}}
+    }}, Tokens: newMockTokens(), Auth: newMockTokens()}
     app2 := fiber.New()
     app2.Post("/auth/signin", ctrl2.SignInUser)
     body := bytes.NewReader([]byte(`{"email":"a@b.com","password":"wrong.pass123"}`))
@@ -108,12 +409,12 @@ func TestSignInUser_InvalidPayloadAndAuth(t *testing.T) {
     r2.Header.Set("Content-Type", "application/json")
     resp2, err := app2.Test(r2)
     if err != nil { t.Fatalf("request failed: %v", err) }
-    if resp2.StatusCode != 400 { t.Fatalf("expected 400 got %d", resp2.StatusCode) }
+    if resp2.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp2.StatusCode) }
 
     // Success: correct username & password
     ctrl3 := &AuthController{Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
-        return usermodel.User{ID: primitive.NewObjectID(), Email: email, Password: string(pw)}, nil
-    }}}
+        return usermodel.User{ID: primitive.NewObjectID(), Email: email, Password: string(pw), EmailVerified: true}, nil
+    }}, Orgs: &mockOrgQuery{}, Tokens: newMockTokens(), Auth: newMockTokens()}
     os.Setenv("JWT_SECRET", "testsecret")
     app3 := fiber.New()
     app3.Post("/auth/signin", ctrl3.SignInUser)
@@ -130,6 +431,86 @@ func TestSignInUser_InvalidPayloadAndAuth(t *testing.T) {
     }
 }
 
+func TestSignInUser_DoesNotRevealAccounts(t *testing.T) {
+    pw, _ := bcrypt.GenerateFromPassword([]byte("correct.pass321"), bcrypt.DefaultCost)
+    ctrl := &AuthController{Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
+        if email != "a@b.com" {
+            return usermodel.User{}, mongo.ErrNoDocuments
+        }
+        return usermodel.User{Email: email, Password: string(pw)}, nil
+    }}, Tokens: newMockTokens(), Auth: newMockTokens()}
+    app := fiber.New()
+    app.Post("/auth/signin", ctrl.SignInUser)
+    signIn := func(body string) (int, string) {
+        r, _ := http.NewRequest("POST", "/auth/signin", bytes.NewReader([]byte(body)))
+        r.Header.Set("Content-Type", "application/json")
+        resp, err := app.Test(r)
+        if err != nil { t.Fatalf("request failed: %v", err) }
+        got, _ := io.ReadAll(resp.Body)
+        return resp.StatusCode, string(got)
+    }
+
+    unknownStatus, unknown := signIn(`{"email":"nobody@b.com","password":"wrong.pass123"}`)
+    wrongStatus, wrong := signIn(`{"email":"a@b.com","password":"wrong.pass123"}`)
+    if unknownStatus != 401 || wrongStatus != 401 { t.Fatalf("expected 401 got %d and %d", unknownStatus, wrongStatus) }
+    if unknown != wrong { t.Fatalf("expected the same response, got %s and %s", unknown, wrong) }
+}
+
+func TestSignInUser_LimitsFailedAttempts(t *testing.T) {
+    os.Setenv("JWT_SECRET", "testsecret")
+    pw, _ := bcrypt.GenerateFromPassword([]byte("correct.pass321"), bcrypt.DefaultCost)
+    tokens := newMockTokens()
+    ctrl := &AuthController{
+        Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
+            return usermodel.User{ID: primitive.NewObjectID(), Email: email, Password: string(pw), EmailVerified: true}, nil
+        }},
+        Orgs: &mockOrgQuery{},
+        Tokens: tokens,
+        Auth: tokens,
+    }
+    app := fiber.New()
+    app.Post("/auth/signin", ctrl.SignInUser)
+    signIn := func(password string) *http.Response {
+        r, _ := http.NewRequest("POST", "/auth/signin", bytes.NewReader([]byte(`{"email":"a@b.com","password":"` + password + `"}`)))
+        r.Header.Set("Content-Type", "application/json")
+        resp, err := app.Test(r)
+        if err != nil { t.Fatalf("request failed: %v", err) }
+        return resp
+    }
+    account := authmodel.AccountKey("A@b.com ")
+
+    // the first failures are free, and a success forgets them
+    for i := 0; i < authmodel.AccountPolicy.FreeAttempts; i++ {
+        if resp := signIn("wrong.pass123"); resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+    }
+    if resp := signIn("correct.pass321"); resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if _, ok := tokens.attempts[account]; ok { t.Fatalf("expected the failures to be reset") }
+    if _, ok := tokens.attempts[authmodel.IPKey("0.0.0.0")]; !ok { t.Fatalf("expected the client address failures to be kept") }
+
+    // after that the account has to wait, even with the right password
+    for i := 0; i <= authmodel.AccountPolicy.FreeAttempts; i++ {
+        if resp := signIn("wrong.pass123"); resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+    }
+    resp := signIn("correct.pass321")
+    if resp.StatusCode != 429 { t.Fatalf("expected 429 got %d", resp.StatusCode) }
+    if resp.Header.Get("Retry-After") != "1" { t.Fatalf("expected Retry-After 1 got %q", resp.Header.Get("Retry-After")) }
+
+    // too many failures lock the account
+    tokens.attempts[account].Failures = authmodel.AccountPolicy.MaxAttempts - 1
+    tokens.attempts[account].LastFailureAt = time.Now().Add(-time.Hour)
+    if resp := signIn("wrong.pass123"); resp.StatusCode != 429 { t.Fatalf("expected the locking attempt to get 429, got %d", resp.StatusCode) }
+    if tokens.attempts[account].LockedUntil == nil { t.Fatalf("expected the account to be locked") }
+    resp = signIn("correct.pass321")
+    if resp.StatusCode != 429 { t.Fatalf("expected 429 got %d", resp.StatusCode) }
+    var got map[string]interface{}
+    json.NewDecoder(resp.Body).Decode(&got)
+    if got["retryAfter"].(float64) < authmodel.AccountPolicy.Lockout.Seconds()-1 { t.Fatalf("expected to wait out the lockout, got %v", got["retryAfter"]) }
+
+    // until an admin unlocks it
+    tokens.ResetLoginAttempts(account)
+    if resp := signIn("correct.pass321"); resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+}
+
 func TestSignUpUser_ValidationAndCreate(t *testing.T) {
     app := fiber.New()
 
@@ -161,3 +542,710 @@ func TestLogoutUser(t *testing.T) {
     if err != nil { t.Fatalf("request failed: %v", err) }
     if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
 }
+
+// tokenOrg returns the "org" claim of the token in a sign-in response.
+func tokenOrg(t *testing.T, resp *http.Response) string {
+    var got map[string]interface{}
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil { t.Fatalf("decode failed: %v", err) }
+    claims := jwt.MapClaims{}
+    if _, err := jwt.ParseWithClaims(got["token"].(string), claims, func(*jwt.Token) (interface{}, error) {
+        return []byte("testsecret"), nil
+    }); err != nil {
+        t.Fatalf("invalid token: %v", err)
+    }
+    return claims["org"].(string)
+}
+
+func TestSignInUser_CarriesOrganization(t *testing.T) {
+    os.Setenv("JWT_SECRET", "testsecret")
+    pw, _ := bcrypt.GenerateFromPassword([]byte("correct.pass321"), bcrypt.DefaultCost)
+    orgA, orgB := primitive.NewObjectID(), primitive.NewObjectID()
+    ctrl := &AuthController{
+        Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
+            return usermodel.User{ID: primitive.NewObjectID(), Email: email, Password: string(pw), EmailVerified: true}, nil
+        }},
+        Orgs: &mockOrgQuery{orgs: []primitive.ObjectID{orgA, orgB}},
+        Tokens: newMockTokens(),
+        Auth: newMockTokens(),
+    }
+    app := fiber.New()
+    app.Post("/auth/signin", ctrl.SignInUser)
+    signIn := func(body string) *http.Response {
+        r, _ := http.NewRequest("POST", "/auth/signin", bytes.NewReader([]byte(body)))
+        r.Header.Set("Content-Type", "application/json")
+        resp, err := app.Test(r)
+        if err != nil { t.Fatalf("request failed: %v", err) }
+        return resp
+    }
+
+    // defaults to the first organization
+    resp := signIn(`{"email":"a@b.com","password":"correct.pass321"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if org := tokenOrg(t, resp); org != orgA.Hex() { t.Fatalf("expected org %s got %s", orgA.Hex(), org) }
+
+    // an organization the user belongs to can be chosen
+    resp = signIn(`{"email":"a@b.com","password":"correct.pass321","orgId":"` + orgB.Hex() + `"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if org := tokenOrg(t, resp); org != orgB.Hex() { t.Fatalf("expected org %s got %s", orgB.Hex(), org) }
+
+    // any other organization is refused
+    resp = signIn(`{"email":"a@b.com","password":"correct.pass321","orgId":"` + primitive.NewObjectID().Hex() + `"}`)
+    if resp.StatusCode != 403 { t.Fatalf("expected 403 got %d", resp.StatusCode) }
+}
+
+func TestSwitchOrganization(t *testing.T) {
+    os.Setenv("JWT_SECRET", "testsecret")
+    orgA, orgB := primitive.NewObjectID(), primitive.NewObjectID()
+    ctrl := &AuthController{Orgs: &mockOrgQuery{orgs: []primitive.ObjectID{orgA, orgB}}, Tokens: newMockTokens()}
+    app := fiber.New()
+    app.Use(func(c *fiber.Ctx) error {
+        c.Locals("user", usermodel.UserDTO{ID: primitive.NewObjectID(), Role: usermodel.RoleViewer})
+        c.Locals("orgId", orgA)
+        return c.Next()
+    })
+    app.Post("/auth/switch-org", ctrl.SwitchOrganization)
+    switchTo := func(body string) *http.Response {
+        r, _ := http.NewRequest("POST", "/auth/switch-org", bytes.NewReader([]byte(body)))
+        r.Header.Set("Content-Type", "application/json")
+        resp, err := app.Test(r)
+        if err != nil { t.Fatalf("request failed: %v", err) }
+        return resp
+    }
+
+    resp := switchTo(`{"orgId":"` + orgB.Hex() + `"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if org := tokenOrg(t, resp); org != orgB.Hex() { t.Fatalf("expected org %s got %s", orgB.Hex(), org) }
+
+    resp = switchTo(`{"orgId":"` + primitive.NewObjectID().Hex() + `"}`)
+    if resp.StatusCode != 403 { t.Fatalf("expected 403 got %d", resp.StatusCode) }
+
+    resp = switchTo(`{}`)
+    if resp.StatusCode != 400 { t.Fatalf("expected 400 got %d", resp.StatusCode) }
+}
+
+// tokenClaims decodes the access token of a sign-in or refresh response and
+// returns its claims along with the refresh token.
+func tokenClaims(t *testing.T, resp *http.Response) (jwt.MapClaims, string) {
+    var got map[string]interface{}
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil { t.Fatalf("decode failed: %v", err) }
+    claims := jwt.MapClaims{}
+    if _, err := jwt.ParseWithClaims(got["token"].(string), claims, func(*jwt.Token) (interface{}, error) {
+        return []byte("testsecret"), nil
+    }); err != nil {
+        t.Fatalf("invalid token: %v", err)
+    }
+    return claims, got["refreshToken"].(string)
+}
+
+func TestRefreshToken_RotatesAndDetectsReuse(t *testing.T) {
+    os.Setenv("JWT_SECRET", "testsecret")
+    pw, _ := bcrypt.GenerateFromPassword([]byte("correct.pass321"), bcrypt.DefaultCost)
+    tokens := newMockTokens()
+    ctrl := &AuthController{
+        Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
+            return usermodel.User{ID: primitive.NewObjectID(), Email: email, Password: string(pw), EmailVerified: true}, nil
+        }},
+        Orgs: &mockOrgQuery{orgs: []primitive.ObjectID{primitive.NewObjectID()}},
+        Tokens: tokens,
+        Auth: tokens,
+    }
+    app := fiber.New()
+    app.Post("/auth/signin", ctrl.SignInUser)
+    app.Post("/auth/refresh", ctrl.RefreshToken)
+    post := func(path string, body string) *http.Response {
+        r, _ := http.NewRequest("POST", path, bytes.NewReader([]byte(body)))
+        r.Header.Set("Content-Type", "application/json")
+        resp, err := app.Test(r)
+        if err != nil { t.Fatalf("request failed: %v", err) }
+        return resp
+    }
+
+    resp := post("/auth/signin", `{"email":"a@b.com","password":"correct.pass321"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    signIn, first := tokenClaims(t, resp)
+    if exp := int64(signIn["exp"].(float64)) - int64(signIn["iat"].(float64)); exp != int64(authmodel.DefaultAccessTokenTTL.Seconds()) {
+        t.Fatalf("expected a short-lived access token, got %ds", exp)
+    }
+
+    // the refresh token is exchanged for a new pair in the same family
+    resp = post("/auth/refresh", `{"refreshToken":"`+first+`"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    refreshed, second := tokenClaims(t, resp)
+    if second == first { t.Fatalf("expected the refresh token to rotate") }
+    if refreshed["fam"] != signIn["fam"] { t.Fatalf("expected the same token family") }
+    if refreshed["jti"] == signIn["jti"] { t.Fatalf("expected a new token ID") }
+
+    // presenting the exchanged token again revokes the whole family
+    resp = post("/auth/refresh", `{"refreshToken":"`+first+`"}`)
+    if resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+    if len(tokens.revokedFamilies) != 1 || tokens.revokedFamilies[0].Hex() != signIn["fam"] {
+        t.Fatalf("expected the token family to be revoked, got %v", tokens.revokedFamilies)
+    }
+    resp = post("/auth/refresh", `{"refreshToken":"`+second+`"}`)
+    if resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+
+    resp = post("/auth/refresh", `{"refreshToken":"unknown"}`)
+    if resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+    resp = post("/auth/refresh", `{}`)
+    if resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+}
+
+func TestRefreshToken_RevokedWhenMembershipEnds(t *testing.T) {
+    os.Setenv("JWT_SECRET", "testsecret")
+    tokens := newMockTokens()
+    family := primitive.NewObjectID()
+    token, _ := tokens.CreateRefreshToken(primitive.NewObjectID(), primitive.NewObjectID(), family, false)
+    ctrl := &AuthController{Orgs: &mockOrgQuery{orgs: []primitive.ObjectID{primitive.NewObjectID()}}, Tokens: tokens}
+    app := fiber.New()
+    app.Post("/auth/refresh", ctrl.RefreshToken)
+
+    r, _ := http.NewRequest("POST", "/auth/refresh", bytes.NewReader([]byte(`{"refreshToken":"`+token+`"}`)))
+    r.Header.Set("Content-Type", "application/json")
+    resp, err := app.Test(r)
+    if err != nil { t.Fatalf("request failed: %v", err) }
+    if resp.StatusCode != 403 { t.Fatalf("expected 403 got %d", resp.StatusCode) }
+    if len(tokens.revokedFamilies) != 1 || tokens.revokedFamilies[0] != family {
+        t.Fatalf("expected the token family to be revoked, got %v", tokens.revokedFamilies)
+    }
+}
+
+func TestLogoutUser_RevokesTokens(t *testing.T) {
+    tokens := newMockTokens()
+    family := primitive.NewObjectID()
+    ctrl := &AuthController{Tokens: tokens}
+    app := fiber.New()
+    app.Use(func(c *fiber.Ctx) error {
+        c.Locals("token", middleware.Token{ID: "token-id", FamilyID: family.Hex(), ExpiresAt: time.Now().Add(time.Minute)})
+        return c.Next()
+    })
+    app.Get("/auth/signout", ctrl.LogoutUser)
+
+    resp, err := app.Test(httptest.NewRequest("GET", "/auth/signout", nil))
+    if err != nil { t.Fatalf("request failed: %v", err) }
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if len(tokens.revokedTokens) != 1 || tokens.revokedTokens[0] != "token-id" {
+        t.Fatalf("expected the access token to be revoked, got %v", tokens.revokedTokens)
+    }
+    if len(tokens.revokedFamilies) != 1 || tokens.revokedFamilies[0] != family {
+        t.Fatalf("expected the token family to be revoked, got %v", tokens.revokedFamilies)
+    }
+}
+
+// linkToken returns the token of the link in an email sent by the controller.
+func linkToken(t *testing.T, msg mailer.Message) string {
+    _, after, ok := strings.Cut(msg.Text, "?token=")
+    if !ok { t.Fatalf("expected a link in %q", msg.Text) }
+    token, _, _ := strings.Cut(after, "\n")
+    return token
+}
+
+func postJSON(t *testing.T, app *fiber.App, path string, body string) *http.Response {
+    r, _ := http.NewRequest("POST", path, bytes.NewReader([]byte(body)))
+    r.Header.Set("Content-Type", "application/json")
+    resp, err := app.Test(r)
+    if err != nil { t.Fatalf("request failed: %v", err) }
+    return resp
+}
+
+func TestSignUpUser_RequiresEmailVerification(t *testing.T) {
+    os.Setenv("JWT_SECRET", "testsecret")
+    userID := primitive.NewObjectID()
+    pw, _ := bcrypt.GenerateFromPassword([]byte("correct.pass321"), bcrypt.DefaultCost)
+    verified := false
+    signedUp := false
+    mail := &mailer.MemoryMailer{}
+    command := &mockAuthCommand{create: func(u *usermodel.CreateUser) (*mongo.InsertOneResult, error) {
+        if u.EmailVerified { t.Fatalf("expected users who sign up to start unverified") }
+        signedUp = true
+        return &mongo.InsertOneResult{InsertedID: userID}, nil
+    }}
+    ctrl := &AuthController{
+        Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
+            if !signedUp {
+                return usermodel.User{}, mongo.ErrNoDocuments
+            }
+            return usermodel.User{ID: userID, Email: email, Password: string(pw), EmailVerified: verified}, nil
+        }},
+        Command: command,
+        Orgs: &mockOrgQuery{orgs: []primitive.ObjectID{primitive.NewObjectID()}},
+        Tokens: newMockTokens(),
+        Auth: newMockTokens(),
+        Mailer: mail,
+    }
+    app := fiber.New()
+    app.Post("/auth/signup", ctrl.SignUpUser)
+    app.Post("/auth/signin", ctrl.SignInUser)
+    app.Post("/auth/verify-email", ctrl.VerifyEmail)
+    app.Post("/auth/resend-verification", ctrl.ResendVerification)
+
+    resp := postJSON(t, app, "/auth/signup", `{"firstName":"Ada","lastName":"Lovelace","email":"ada@example.com","password":"correct.pass321"}`)
+    if resp.StatusCode != 201 { t.Fatalf("expected 201 got %d", resp.StatusCode) }
+    messages := mail.Messages()
+    if len(messages) != 1 || messages[0].To[0] != "ada@example.com" {
+        t.Fatalf("expected a verification email, got %+v", messages)
+    }
+    first := linkToken(t, messages[0])
+
+    // unverified users cannot sign in
+    resp = postJSON(t, app, "/auth/signin", `{"email":"ada@example.com","password":"correct.pass321"}`)
+    if resp.StatusCode != 403 { t.Fatalf("expected 403 got %d", resp.StatusCode) }
+
+    // a resent link replaces the first one
+    resp = postJSON(t, app, "/auth/resend-verification", `{"email":"ada@example.com"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    messages = mail.Messages()
+    if len(messages) != 2 { t.Fatalf("expected a second verification email, got %d", len(messages)) }
+    second := linkToken(t, messages[1])
+    resp = postJSON(t, app, "/auth/verify-email", `{"token":"`+first+`"}`)
+    if resp.StatusCode != 400 { t.Fatalf("expected 400 got %d", resp.StatusCode) }
+
+    resp = postJSON(t, app, "/auth/verify-email", `{"token":"`+second+`"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if len(command.verified) != 1 || command.verified[0] != userID {
+        t.Fatalf("expected the user to be verified, got %v", command.verified)
+    }
+    verified = true
+
+    // links are single-use
+    resp = postJSON(t, app, "/auth/verify-email", `{"token":"`+second+`"}`)
+    if resp.StatusCode != 400 { t.Fatalf("expected 400 got %d", resp.StatusCode) }
+
+    resp = postJSON(t, app, "/auth/signin", `{"email":"ada@example.com","password":"correct.pass321"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+
+    // verified users are not sent another link
+    resp = postJSON(t, app, "/auth/resend-verification", `{"email":"ada@example.com"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if len(mail.Messages()) != 2 { t.Fatalf("expected no email for a verified user") }
+}
+
+func TestForgotAndResetPassword(t *testing.T) {
+    userID := primitive.NewObjectID()
+    mail := &mailer.MemoryMailer{}
+    tokens := newMockTokens()
+    command := &mockAuthCommand{}
+    ctrl := &AuthController{
+        Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
+            if email != "ada@example.com" {
+                return usermodel.User{}, mongo.ErrNoDocuments
+            }
+            return usermodel.User{ID: userID, FirstName: "Ada", Email: email, EmailVerified: true}, nil
+        }},
+        Command: command,
+        Tokens: tokens,
+        Mailer: mail,
+    }
+    app := fiber.New()
+    app.Post("/auth/forgot-password", ctrl.ForgotPassword)
+    app.Post("/auth/reset-password", ctrl.ResetPassword)
+
+    // unknown addresses get the same response and no email
+    unknown := postJSON(t, app, "/auth/forgot-password", `{"email":"nobody@example.com"}`)
+    known := postJSON(t, app, "/auth/forgot-password", `{"email":"ada@example.com"}`)
+    if unknown.StatusCode != 200 || known.StatusCode != 200 {
+        t.Fatalf("expected 200 for both, got %d and %d", unknown.StatusCode, known.StatusCode)
+    }
+    unknownBody, _ := io.ReadAll(unknown.Body)
+    knownBody, _ := io.ReadAll(known.Body)
+    if string(unknownBody) != string(knownBody) { t.Fatalf("expected the same response, got %s and %s", unknownBody, knownBody) }
+    messages := mail.Messages()
+    if len(messages) != 1 || messages[0].To[0] != "ada@example.com" || !strings.Contains(messages[0].Text, "/reset-password?token=") {
+        t.Fatalf("expected one reset email, got %+v", messages)
+    }
+    token := linkToken(t, messages[0])
+
+    // the token is stored for the reset purpose only
+    if _, err := tokens.UseUserToken(token, authmodel.PurposeEmailVerification); err == nil {
+        t.Fatalf("expected a reset token not to verify email addresses")
+    }
+
+    resp := postJSON(t, app, "/auth/reset-password", `{"token":"`+token+`","password":"new.pass4321","passwordConfirm":"other.pass4321"}`)
+    if resp.StatusCode != 400 { t.Fatalf("expected 400 got %d", resp.StatusCode) }
+
+    resp = postJSON(t, app, "/auth/reset-password", `{"token":"`+token+`","password":"new.pass4321","passwordConfirm":"new.pass4321"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if command.passwords[userID] != "new.pass4321" { t.Fatalf("expected the password to be set") }
+    if len(tokens.revokedUsers) != 1 || tokens.revokedUsers[0] != userID {
+        t.Fatalf("expected the user's sessions to be revoked, got %v", tokens.revokedUsers)
+    }
+
+    resp = postJSON(t, app, "/auth/reset-password", `{"token":"`+token+`","password":"again.pass4321","passwordConfirm":"again.pass4321"}`)
+    if resp.StatusCode != 400 { t.Fatalf("expected 400 got %d", resp.StatusCode) }
+}
+
+func TestChangeEmail_RequiresConfirmation(t *testing.T) {
+    userID := primitive.NewObjectID()
+    pw, _ := bcrypt.GenerateFromPassword([]byte("correct.pass321"), bcrypt.DefaultCost)
+    mail := &mailer.MemoryMailer{}
+    tokens := newMockTokens()
+    command := &mockAuthCommand{}
+    ctrl := &AuthController{
+        Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
+            switch email {
+            case "ada@example.com":
+                return usermodel.User{ID: userID, FirstName: "Ada", Email: email, Password: string(pw), EmailVerified: true}, nil
+            case "taken@example.com":
+                return usermodel.User{ID: primitive.NewObjectID(), Email: email}, nil
+            }
+            return usermodel.User{}, mongo.ErrNoDocuments
+        }},
+        Command: command,
+        Tokens: tokens,
+        Mailer: mail,
+    }
+    app := fiber.New()
+    app.Use(func(c *fiber.Ctx) error {
+        c.Locals("user", usermodel.UserDTO{ID: userID, Email: "ada@example.com", Role: usermodel.RoleViewer})
+        return c.Next()
+    })
+    app.Post("/auth/me/email", ctrl.ChangeEmail)
+    app.Post("/auth/confirm-email", ctrl.ConfirmEmail)
+
+    resp := postJSON(t, app, "/auth/me/email", `{"email":"new@example.com","password":"wrong.pass123"}`)
+    if resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+    resp = postJSON(t, app, "/auth/me/email", `{"email":"Taken@Example.com","password":"correct.pass321"}`)
+    if resp.StatusCode != 409 { t.Fatalf("expected 409 got %d", resp.StatusCode) }
+    if len(mail.Messages()) != 0 { t.Fatalf("expected no email") }
+
+    // the address only changes once the link sent to it is followed
+    resp = postJSON(t, app, "/auth/me/email", `{"email":"New@Example.com","password":"correct.pass321"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if len(command.emails) != 0 { t.Fatalf("expected the email address not to change yet") }
+    messages := mail.Messages()
+    if len(messages) != 2 || messages[0].To[0] != "new@example.com" || !strings.Contains(messages[0].Text, "/confirm-email?token=") {
+        t.Fatalf("expected a confirmation email to the new address, got %+v", messages)
+    }
+    if messages[1].To[0] != "ada@example.com" { t.Fatalf("expected the current address to be told, got %+v", messages[1]) }
+    token := linkToken(t, messages[0])
+
+    if _, err := tokens.UseUserToken(token, authmodel.PurposeEmailVerification); err == nil {
+        t.Fatalf("expected an email change token not to verify email addresses")
+    }
+
+    resp = postJSON(t, app, "/auth/confirm-email", `{"token":"`+token+`"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if command.emails[userID] != "new@example.com" { t.Fatalf("expected the email address to change, got %v", command.emails) }
+    if len(tokens.revokedUsers) != 1 || tokens.revokedUsers[0] != userID {
+        t.Fatalf("expected the user's sessions to be revoked, got %v", tokens.revokedUsers)
+    }
+
+    resp = postJSON(t, app, "/auth/confirm-email", `{"token":"`+token+`"}`)
+    if resp.StatusCode != 400 { t.Fatalf("expected 400 got %d", resp.StatusCode) }
+}
+
+func TestTwoFactor_EnrollAndSignIn(t *testing.T) {
+    os.Setenv("JWT_SECRET", "testsecret")
+    userID := primitive.NewObjectID()
+    pw, _ := bcrypt.GenerateFromPassword([]byte("correct.pass321"), bcrypt.DefaultCost)
+    tokens := newMockTokens()
+    ctrl := &AuthController{
+        Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
+            return usermodel.User{ID: userID, Email: email, Password: string(pw), EmailVerified: true}, nil
+        }},
+        Orgs: &mockOrgQuery{orgs: []primitive.ObjectID{primitive.NewObjectID()}},
+        Tokens: tokens,
+        Auth: tokens,
+    }
+    app := fiber.New()
+    signedIn := app.Group("/me", func(c *fiber.Ctx) error {
+        c.Locals("user", usermodel.UserDTO{ID: userID, Email: "ada@example.com"})
+        return c.Next()
+    })
+    signedIn.Post("/2fa/enroll", ctrl.EnrollTwoFactor)
+    signedIn.Post("/2fa/confirm", ctrl.ConfirmTwoFactor)
+    app.Post("/auth/signin", ctrl.SignInUser)
+    app.Post("/auth/2fa/verify", ctrl.VerifyTwoFactor)
+    decode := func(resp *http.Response) map[string]interface{} {
+        var got map[string]interface{}
+        if err := json.NewDecoder(resp.Body).Decode(&got); err != nil { t.Fatalf("decode failed: %v", err) }
+        return got
+    }
+    signIn := func() string {
+        resp := postJSON(t, app, "/auth/signin", `{"email":"ada@example.com","password":"correct.pass321"}`)
+        if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+        got := decode(resp)
+        if got["status"] != "two_factor_required" || got["token"] != nil {
+            t.Fatalf("expected a challenge instead of a session, got %v", got)
+        }
+        return got["challengeToken"].(string)
+    }
+
+    resp := postJSON(t, app, "/me/2fa/enroll", ``)
+    if resp.StatusCode != 201 { t.Fatalf("expected 201 got %d", resp.StatusCode) }
+    enrollment := decode(resp)
+    secret := enrollment["secret"].(string)
+    if !strings.HasPrefix(enrollment["otpauthUri"].(string), "otpauth://totp/") { t.Fatalf("expected an otpauth URI, got %v", enrollment) }
+
+    // enrollment takes effect once confirmed with a valid code
+    resp = postJSON(t, app, "/auth/signin", `{"email":"ada@example.com","password":"correct.pass321"}`)
+    if got := decode(resp); got["token"] == nil { t.Fatalf("expected a session before confirmation, got %v", got) }
+    resp = postJSON(t, app, "/me/2fa/confirm", `{"code":"000000"}`)
+    if resp.StatusCode != 400 { t.Fatalf("expected 400 got %d", resp.StatusCode) }
+    step := authmodel.TOTPStep(time.Now())
+    code, _ := authmodel.TOTP(secret, step)
+    resp = postJSON(t, app, "/me/2fa/confirm", `{"code":"`+code+`"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    recoveryCodes := decode(resp)["recoveryCodes"].([]interface{})
+    if len(recoveryCodes) != authmodel.RecoveryCodeCount { t.Fatalf("expected %d recovery codes, got %d", authmodel.RecoveryCodeCount, len(recoveryCodes)) }
+    if stored := tokens.twoFactor[userID].RecoveryCodes[0]; stored == recoveryCodes[0] { t.Fatalf("expected recovery codes to be stored hashed") }
+    resp = postJSON(t, app, "/me/2fa/enroll", ``)
+    if resp.StatusCode != 409 { t.Fatalf("expected 409 got %d", resp.StatusCode) }
+
+    // the code used to confirm cannot be replayed, a later one signs in
+    challenge := signIn()
+    resp = postJSON(t, app, "/auth/2fa/verify", `{"challengeToken":"`+challenge+`","code":"`+code+`"}`)
+    if resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+    next, _ := authmodel.TOTP(secret, step+1)
+    resp = postJSON(t, app, "/auth/2fa/verify", `{"challengeToken":"`+challenge+`","code":"`+next+`"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    claims, _ := tokenClaims(t, resp)
+    if amr := fmt.Sprint(claims["amr"]); amr != "[pwd otp]" { t.Fatalf("expected the token to claim two-factor authentication, got %s", amr) }
+
+    // a challenge can only be exchanged once
+    resp = postJSON(t, app, "/auth/2fa/verify", `{"challengeToken":"`+challenge+`","code":"`+recoveryCodes[0].(string)+`"}`)
+    if resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+
+    // recovery codes work once each
+    resp = postJSON(t, app, "/auth/2fa/verify", `{"challengeToken":"`+signIn()+`","code":"`+recoveryCodes[0].(string)+`"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    resp = postJSON(t, app, "/auth/2fa/verify", `{"challengeToken":"`+signIn()+`","code":"`+recoveryCodes[0].(string)+`"}`)
+    if resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+
+    // too many wrong codes use the challenge up, even before they lock the account
+    challenge = signIn()
+    for i := 0; i < authmodel.MaxChallengeAttempts; i++ {
+        delete(tokens.attempts, authmodel.AccountKey("ada@example.com"))
+        resp = postJSON(t, app, "/auth/2fa/verify", `{"challengeToken":"`+challenge+`","code":"000000"}`)
+        if resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+    }
+    resp = postJSON(t, app, "/auth/2fa/verify", `{"challengeToken":"`+challenge+`","code":"`+recoveryCodes[1].(string)+`"}`)
+    if resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+}
+
+func TestTwoFactor_WrongCodesLockTheAccount(t *testing.T) {
+    os.Setenv("JWT_SECRET", "testsecret")
+    userID := primitive.NewObjectID()
+    pw, _ := bcrypt.GenerateFromPassword([]byte("correct.pass321"), bcrypt.DefaultCost)
+    tokens := newMockTokens()
+    tokens.twoFactor[userID] = &authmodel.TwoFactor{UserID: userID, Secret: "JBSWY3DPEHPK3PXP", Enabled: true}
+    ctrl := &AuthController{
+        Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
+            return usermodel.User{ID: userID, Email: email, Password: string(pw), EmailVerified: true}, nil
+        }},
+        Orgs: &mockOrgQuery{},
+        Tokens: tokens,
+        Auth: tokens,
+    }
+    app := fiber.New()
+    app.Post("/auth/signin", ctrl.SignInUser)
+    app.Post("/auth/2fa/verify", ctrl.VerifyTwoFactor)
+    signIn := func(password string) *http.Response {
+        return postJSON(t, app, "/auth/signin", `{"email":"ada@example.com","password":"`+password+`"}`)
+    }
+    challenge := func() string {
+        resp := signIn("correct.pass321")
+        if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+        var got map[string]interface{}
+        json.NewDecoder(resp.Body).Decode(&got)
+        return got["challengeToken"].(string)
+    }
+    verify := func(challenge string, code string) *http.Response {
+        return postJSON(t, app, "/auth/2fa/verify", `{"challengeToken":"`+challenge+`","code":"`+code+`"}`)
+    }
+    code := func() string {
+        code, _ := authmodel.TOTP("JBSWY3DPEHPK3PXP", authmodel.TOTPStep(time.Now()))
+        return code
+    }
+    account := authmodel.AccountKey("ada@example.com")
+
+    // the right password alone does not forget earlier failures
+    if resp := signIn("wrong.pass123"); resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+    next := challenge()
+    if _, ok := tokens.attempts[account]; !ok { t.Fatalf("expected the failures to be kept until the code is checked") }
+    if resp := verify(next, code()); resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if _, ok := tokens.attempts[account]; ok { t.Fatalf("expected the failures to be reset") }
+
+    // wrong codes count like wrong passwords
+    next = challenge()
+    if resp := verify(next, "000000"); resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+    if tokens.attempts[account] == nil || tokens.attempts[account].Failures != 1 { t.Fatalf("expected the wrong code to count against the account") }
+    if _, ok := tokens.attempts[authmodel.IPKey("0.0.0.0")]; !ok { t.Fatalf("expected the wrong code to count against the client address") }
+
+    // and lock the account, so that the right code has to wait as well
+    tokens.attempts[account].Failures = authmodel.AccountPolicy.MaxAttempts - 1
+    tokens.attempts[account].LastFailureAt = time.Now().Add(-time.Hour)
+    if resp := verify(next, "000000"); resp.StatusCode != 429 { t.Fatalf("expected the locking attempt to get 429, got %d", resp.StatusCode) }
+    if tokens.attempts[account].LockedUntil == nil { t.Fatalf("expected the account to be locked") }
+    resp := verify(next, code())
+    if resp.StatusCode != 429 { t.Fatalf("expected 429 got %d", resp.StatusCode) }
+    if resp.Header.Get("Retry-After") == "" { t.Fatalf("expected a Retry-After header") }
+    if resp := signIn("correct.pass321"); resp.StatusCode != 429 { t.Fatalf("expected 429 got %d", resp.StatusCode) }
+}
+
+func TestDisableTwoFactor_RefusedWhenRequired(t *testing.T) {
+    userID := primitive.NewObjectID()
+    tokens := newMockTokens()
+    secret, _ := tokens.EnrollTwoFactor(userID)
+    code, _ := authmodel.TOTP(secret, authmodel.TOTPStep(time.Now())-1)
+    if _, err := tokens.ConfirmTwoFactor(userID, code); err != nil { t.Fatalf("confirm failed: %v", err) }
+    orgs := &mockOrgQuery{requireTwoFactor: true}
+    ctrl := &AuthController{Orgs: orgs, Tokens: tokens}
+    app := fiber.New()
+    app.Use(func(c *fiber.Ctx) error {
+        c.Locals("user", usermodel.UserDTO{ID: userID})
+        return c.Next()
+    })
+    app.Post("/auth/2fa/disable", ctrl.DisableTwoFactor)
+
+    current, _ := authmodel.TOTP(secret, authmodel.TOTPStep(time.Now()))
+    resp := postJSON(t, app, "/auth/2fa/disable", `{"code":"`+current+`"}`)
+    if resp.StatusCode != 409 { t.Fatalf("expected 409 got %d", resp.StatusCode) }
+
+    orgs.requireTwoFactor = false
+    resp = postJSON(t, app, "/auth/2fa/disable", `{"code":"000000"}`)
+    if resp.StatusCode != 400 { t.Fatalf("expected 400 got %d", resp.StatusCode) }
+    resp = postJSON(t, app, "/auth/2fa/disable", `{"code":"`+current+`"}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if enabled, _ := tokens.TwoFactorEnabled(userID); enabled { t.Fatalf("expected two-factor authentication to be disabled") }
+}
+
+// mockMembers records role changes made through the org command.
+type mockMembers struct{
+    added map[primitive.ObjectID]string
+    updated map[primitive.ObjectID]string
+}
+
+func (m *mockMembers) CreateOrganization(name string, ownerID primitive.ObjectID) (*orgmodel.Organization, error) {
+    return &orgmodel.Organization{ID: primitive.NewObjectID(), Name: name}, nil
+}
+
+func (m *mockMembers) AddMember(userID primitive.ObjectID, role string) (*mongo.InsertOneResult, error) {
+    m.added[userID] = role
+    return &mongo.InsertOneResult{InsertedID: primitive.NewObjectID()}, nil
+}
+
+func (m *mockMembers) UpdateMemberRole(userID primitive.ObjectID, role string) (*mongo.UpdateResult, error) {
+    m.updated[userID] = role
+    return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (m *mockMembers) RemoveMember(userID primitive.ObjectID) (*mongo.DeleteResult, error) {
+    return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+
+func (m *mockMembers) SetTwoFactorRequired(required bool) (*mongo.UpdateResult, error) {
+    return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (m *mockMembers) InviteMember(email string, role string, invitedBy primitive.ObjectID) (*orgmodel.Invitation, error) {
+    return &orgmodel.Invitation{ID: primitive.NewObjectID(), Email: email, Role: role, InvitedBy: invitedBy}, nil
+}
+
+func (m *mockMembers) AcceptInvitation(id primitive.ObjectID, userID primitive.ObjectID, email string) (*orgmodel.Membership, error) {
+    return nil, mongo.ErrNoDocuments
+}
+
+func (m *mockMembers) DeclineInvitation(id primitive.ObjectID, email string) (*mongo.DeleteResult, error) {
+    return nil, mongo.ErrNoDocuments
+}
+
+// ssoSignIn starts single sign-on, signs in at the mock provider and returns
+// the response to the provider's redirect back.
+func ssoSignIn(t *testing.T, app *fiber.App, provider *oidctest.Server) *http.Response {
+    resp, err := app.Test(httptest.NewRequest("GET", "/auth/oidc/login", nil))
+    if err != nil { t.Fatalf("request failed: %v", err) }
+    if resp.StatusCode != 302 { t.Fatalf("expected 302 got %d", resp.StatusCode) }
+
+    callback, err := provider.SignIn(resp.Header.Get("Location"))
+    if err != nil { t.Fatalf("provider sign-in failed: %v", err) }
+    resp, err = app.Test(httptest.NewRequest("GET", callback.RequestURI(), nil))
+    if err != nil { t.Fatalf("request failed: %v", err) }
+    return resp
+}
+
+func TestOIDC_ProvisionsAndMapsRoles(t *testing.T) {
+    os.Setenv("JWT_SECRET", "testsecret")
+    provider := oidctest.NewServer("invoice-api", "secret")
+    defer provider.Close()
+
+    orgID := primitive.NewObjectID()
+    existingID := primitive.NewObjectID()
+    createdID := primitive.NewObjectID()
+    var created *usermodel.CreateUser
+    members := &mockMembers{added: map[primitive.ObjectID]string{}, updated: map[primitive.ObjectID]string{}}
+    ctrl := &AuthController{
+        Query: &mockAuthQuery{byEmail: func(email string) (usermodel.User, error) {
+            if email == "existing@example.com" {
+                return usermodel.User{ID: existingID, Email: email, EmailVerified: true}, nil
+            }
+            return usermodel.User{}, mongo.ErrNoDocuments
+        }},
+        Command: &mockAuthCommand{create: func(u *usermodel.CreateUser) (*mongo.InsertOneResult, error) {
+            created = u
+            return &mongo.InsertOneResult{InsertedID: createdID}, nil
+        }},
+        Orgs: &mockOrgQuery{orgs: []primitive.ObjectID{orgID}},
+        Members: members,
+        Tokens: newMockTokens(),
+        Auth: newMockTokens(),
+        OIDC: oidc.NewProvider(provider.Config("http://localhost:3000/auth/oidc/callback")),
+        SSO: &authmodel.SSOSettings{
+            OrgID: orgID,
+            GroupsClaim: "groups",
+            RoleMapping: map[string]string{"finance": usermodel.RoleAccountant, "it": usermodel.RoleAdmin},
+            DefaultRole: usermodel.RoleViewer,
+        },
+    }
+    app := fiber.New()
+    app.Get("/auth/oidc/login", ctrl.OIDCLogin)
+    app.Get("/auth/oidc/callback", ctrl.OIDCCallback)
+
+    // new users are created in the organization with the role of their groups
+    provider.SetClaims(map[string]interface{}{
+        "sub": "new", "email": "new@example.com", "email_verified": true,
+        "name": "Grace Hopper", "groups": []string{"staff", "finance"},
+    })
+    resp := ssoSignIn(t, app, provider)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    claims, _ := tokenClaims(t, resp)
+    if claims["sub"] != createdID.Hex() || claims["org"] != orgID.Hex() { t.Fatalf("unexpected claims %v", claims) }
+    if created == nil || created.Role != usermodel.RoleAccountant || !created.EmailVerified { t.Fatalf("unexpected user %+v", created) }
+    if created.FirstName != "Grace" || created.LastName != "Hopper" || created.Password == "" { t.Fatalf("unexpected user %+v", created) }
+
+    // existing members get the role of their groups
+    provider.SetClaims(map[string]interface{}{
+        "sub": "existing", "email": "existing@example.com", "email_verified": true,
+        "groups": []string{"it", "finance"},
+    })
+    resp = ssoSignIn(t, app, provider)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+    if members.updated[existingID] != usermodel.RoleAdmin { t.Fatalf("expected role admin, got %q", members.updated[existingID]) }
+
+    // an email address the provider has not verified is not trusted
+    provider.SetClaims(map[string]interface{}{"sub": "unverified", "email": "existing@example.com", "email_verified": false})
+    resp = ssoSignIn(t, app, provider)
+    if resp.StatusCode != 403 { t.Fatalf("expected 403 got %d", resp.StatusCode) }
+
+    // a state is accepted once
+    resp, _ = app.Test(httptest.NewRequest("GET", "/auth/oidc/login", nil))
+    callback, err := provider.SignIn(resp.Header.Get("Location"))
+    if err != nil { t.Fatalf("provider sign-in failed: %v", err) }
+    resp, _ = app.Test(httptest.NewRequest("GET", callback.RequestURI(), nil))
+    if resp.StatusCode != 403 { t.Fatalf("expected 403 got %d", resp.StatusCode) }
+    resp, _ = app.Test(httptest.NewRequest("GET", callback.RequestURI(), nil))
+    if resp.StatusCode != 400 { t.Fatalf("expected 400 got %d", resp.StatusCode) }
+
+    resp, _ = app.Test(httptest.NewRequest("GET", "/auth/oidc/callback?error=access_denied", nil))
+    if resp.StatusCode != 401 { t.Fatalf("expected 401 got %d", resp.StatusCode) }
+}
+
+func TestOIDC_NotConfigured(t *testing.T) {
+    os.Unsetenv("OIDC_ISSUER")
+    ctrl := &AuthController{}
+    app := fiber.New()
+    app.Get("/auth/oidc/login", ctrl.OIDCLogin)
+
+    resp, err := app.Test(httptest.NewRequest("GET", "/auth/oidc/login", nil))
+    if err != nil { t.Fatalf("request failed: %v", err) }
+    if resp.StatusCode != 404 { t.Fatalf("expected 404 got %d", resp.StatusCode) }
+}
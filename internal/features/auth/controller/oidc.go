@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"slices"
+	"strings"
+
+	auth_model "invoice-api/internal/features/auth/model"
+	org_command "invoice-api/internal/features/org/command"
+	org_query "invoice-api/internal/features/org/query"
+	"invoice-api/internal/features/user/model"
+	"invoice-api/internal/features/user/query"
+	"invoice-api/internal/oidc"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// oidc returns the injected provider, or the one configured by OIDC_ISSUER.
+// It returns nil when single sign-on is not configured.
+func (s *AuthController) oidc() *oidc.Provider {
+	if s.OIDC == nil {
+		if config, ok := oidc.ConfigFromEnv(); ok {
+			s.OIDC = oidc.NewProvider(config)
+		}
+	}
+	return s.OIDC
+}
+
+func (s *AuthController) sso() auth_model.SSOSettings {
+	if s.SSO == nil {
+		settings := auth_model.SSOSettingsFromEnv()
+		s.SSO = &settings
+	}
+	return *s.SSO
+}
+
+// members returns the injected membership command, or one for orgID.
+func (s *AuthController) members(orgID primitive.ObjectID) org_command.Command {
+	if s.Members != nil {
+		return s.Members
+	}
+	return &org_command.DefaultCommand{OrgID: orgID}
+}
+
+// OIDCLogin starts single sign-on by sending the user to the identity
+// provider.
+func (s *AuthController) OIDCLogin(c *fiber.Ctx) error {
+	provider := s.oidc()
+	if provider == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "fail", "message": "Single sign-on is not configured"})
+	}
+
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to start single sign-on"})
+	}
+	nonce, err := oidc.NewVerifier()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to start single sign-on"})
+	}
+
+	state, err := s.tokens().CreateOIDCLogin(verifier, nonce)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to start single sign-on"})
+	}
+
+	authURL, err := provider.AuthCodeURL(c.UserContext(), state, nonce, verifier)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"status": "fail", "message": "Failed to reach the identity provider"})
+	}
+
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// OIDCCallback completes single sign-on when the identity provider redirects
+// back. The user is found by their verified email address, or created in the
+// single sign-on organization, and their role there follows their groups.
+func (s *AuthController) OIDCCallback(c *fiber.Ctx) error {
+	provider := s.oidc()
+	if provider == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "fail", "message": "Single sign-on is not configured"})
+	}
+
+	if reason := c.Query("error"); reason != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "Single sign-on failed: " + reason})
+	}
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": "Missing state or code"})
+	}
+
+	login, err := s.tokens().UseOIDCLogin(state)
+	if err != nil {
+		if err == auth_model.ErrInvalidOIDCState {
+			return c.Status(400).JSON(fiber.Map{"status": "fail", "message": "Single sign-on has expired. Please try again"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to complete single sign-on"})
+	}
+
+	idToken, err := provider.Exchange(c.UserContext(), code, login.Verifier)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "Single sign-on failed"})
+	}
+	if idToken.Nonce != login.Nonce {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "Single sign-on failed"})
+	}
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "fail", "message": "Your identity provider has not verified your email address"})
+	}
+
+	userID, status, message := s.ssoMember(idToken)
+	if status != 0 {
+		return c.Status(status).JSON(fiber.Map{"status": "fail", "message": message})
+	}
+
+	// A provider that asked for a second factor satisfies two-factor
+	// authentication; otherwise users who enabled it here are asked for it.
+	orgID := s.sso().OrgID
+	twoFactor := slices.Contains(idToken.AMR, "mfa") || slices.Contains(idToken.AMR, "otp")
+	if !twoFactor {
+		enabled, err := s.auth().TwoFactorEnabled(userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to check two-factor authentication"})
+		}
+		if enabled {
+			return s.challenge(c, userID, idToken.Email, orgID)
+		}
+	}
+
+	return s.issueToken(c, userID, orgID, twoFactor)
+}
+
+// ssoMember returns the user the ID token identifies, creating them when
+// they are new and keeping their membership of the single sign-on
+// organization in line with their groups. Owners keep their role. On failure
+// it returns the status and message to respond with.
+func (s *AuthController) ssoMember(idToken *oidc.IDToken) (primitive.ObjectID, int, string) {
+	if s.Query == nil {
+		s.Query = &query.DefaultQuery{}
+	}
+	if s.Orgs == nil {
+		s.Orgs = &org_query.DefaultQuery{}
+	}
+	settings := s.sso()
+	role := settings.RoleFor(idToken.Strings(settings.GroupsClaim))
+
+	user, err := s.Query.GetItemByEmail(idToken.Email)
+	if err == mongo.ErrNoDocuments {
+		if settings.OrgID.IsZero() {
+			return primitive.NilObjectID, fiber.StatusForbidden, "There is no account for this email address"
+		}
+		if role == "" {
+			role = settings.DefaultRole
+		}
+
+		// Users created here sign in with single sign-on; they can set a
+		// password with a password reset.
+		password, err := auth_model.NewToken()
+		if err != nil {
+			return primitive.NilObjectID, fiber.StatusInternalServerError, "Failed to create user"
+		}
+		firstName, lastName := ssoName(idToken)
+		res, err := s.users(settings.OrgID).CreateUser(&model.CreateUser{
+			FirstName:     firstName,
+			LastName:      lastName,
+			Email:         idToken.Email,
+			Password:      password,
+			Role:          role,
+			EmailVerified: true,
+		})
+		if err != nil {
+			return primitive.NilObjectID, fiber.StatusInternalServerError, "Failed to create user"
+		}
+		userID, _ := res.InsertedID.(primitive.ObjectID)
+		return userID, 0, ""
+	}
+	if err != nil {
+		return primitive.NilObjectID, fiber.StatusInternalServerError, "Failed to load user"
+	}
+
+	if !user.EmailVerified {
+		if _, err := s.users(primitive.NilObjectID).VerifyEmail(user.ID); err != nil {
+			return primitive.NilObjectID, fiber.StatusInternalServerError, "Failed to load user"
+		}
+	}
+	if settings.OrgID.IsZero() {
+		return user.ID, 0, ""
+	}
+
+	membership, err := s.Orgs.GetMembership(user.ID, settings.OrgID)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		if role == "" {
+			role = settings.DefaultRole
+		}
+		_, err = s.members(settings.OrgID).AddMember(user.ID, role)
+	case err != nil:
+	case role != "" && membership.Role != role && membership.Role != model.RoleOwner:
+		_, err = s.members(settings.OrgID).UpdateMemberRole(user.ID, role)
+	}
+	if err != nil {
+		return primitive.NilObjectID, fiber.StatusInternalServerError, "Failed to update membership"
+	}
+
+	return user.ID, 0, ""
+}
+
+// ssoName splits the name from an ID token into a first and last name,
+// falling back to the email address.
+func ssoName(idToken *oidc.IDToken) (string, string) {
+	if idToken.GivenName != "" {
+		return idToken.GivenName, idToken.FamilyName
+	}
+	if first, last, _ := strings.Cut(strings.TrimSpace(idToken.Name), " "); first != "" {
+		return first, strings.TrimSpace(last)
+	}
+	local, _, _ := strings.Cut(idToken.Email, "@")
+	return local, ""
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AttemptPolicy limits failed sign-ins. After FreeAttempts failures each
+// further attempt has to wait BaseDelay, doubling with every failure up to
+// MaxDelay. Reaching MaxAttempts locks the sign-in for Lockout, after which
+// counting starts over. Failures are forgotten Window after the last one.
+type AttemptPolicy struct {
+	FreeAttempts int
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Lockout      time.Duration
+	Window       time.Duration
+}
+
+var (
+	// AccountPolicy applies to each email address, whether or not it belongs
+	// to a user, so that the responses do not tell them apart.
+	AccountPolicy = AttemptPolicy{
+		FreeAttempts: 3,
+		MaxAttempts:  10,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		Lockout:      15 * time.Minute,
+		Window:       24 * time.Hour,
+	}
+	// IPPolicy applies to each client address and is looser, as offices
+	// share one address.
+	IPPolicy = AttemptPolicy{
+		FreeAttempts: 20,
+		MaxAttempts:  100,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		Lockout:      time.Hour,
+		Window:       time.Hour,
+	}
+)
+
+// LoginAttempts counts the failed sign-ins of an account or client address,
+// identified by Key.
+type LoginAttempts struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	Key           string             `bson:"key" json:"key"`
+	Failures      int                `bson:"failures" json:"failures"`
+	LastFailureAt time.Time          `bson:"lastFailureAt" json:"lastFailureAt"`
+	LockedUntil   *time.Time         `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+	ExpiresAt     time.Time          `bson:"expiresAt" json:"-"`
+}
+
+// AccountKey identifies the attempts on an email address.
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPKey identifies the attempts from a client address.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// BlockedUntil is when the next attempt is allowed under the policy. It is
+// the zero time when an attempt is allowed right away.
+func (p AttemptPolicy) BlockedUntil(attempts LoginAttempts) time.Time {
+	if attempts.LockedUntil != nil {
+		return *attempts.LockedUntil
+	}
+	if attempts.Failures <= p.FreeAttempts {
+		return time.Time{}
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < attempts.Failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return attempts.LastFailureAt.Add(delay)
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockedUntil_BacksOffExponentially(t *testing.T) {
+	last := time.Now()
+	policy := AttemptPolicy{FreeAttempts: 3, MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	blockedUntil := func(failures int) time.Time {
+		return policy.BlockedUntil(LoginAttempts{Failures: failures, LastFailureAt: last})
+	}
+
+	require.True(t, blockedUntil(0).IsZero())
+	require.True(t, blockedUntil(3).IsZero())
+	require.Equal(t, last.Add(time.Second), blockedUntil(4))
+	require.Equal(t, last.Add(2*time.Second), blockedUntil(5))
+	require.Equal(t, last.Add(4*time.Second), blockedUntil(6))
+	require.Equal(t, last.Add(5*time.Second), blockedUntil(7))
+	require.Equal(t, last.Add(5*time.Second), blockedUntil(9))
+
+	lockedUntil := last.Add(time.Hour)
+	require.Equal(t, lockedUntil, policy.BlockedUntil(LoginAttempts{LastFailureAt: last, LockedUntil: &lockedUntil}))
+}
+
+func TestAccountKey_IgnoresCaseAndSpaces(t *testing.T) {
+	require.Equal(t, "account:jane@example.com", AccountKey(" Jane@Example.com"))
+	require.Equal(t, "ip:10.0.0.1", IPKey("10.0.0.1"))
+}
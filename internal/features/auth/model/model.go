@@ -0,0 +1,150 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	PasswordResetTTL     = time.Hour
+	EmailVerificationTTL = 48 * time.Hour
+	EmailChangeTTL       = 24 * time.Hour
+)
+
+// Purposes of UserToken.
+const (
+	PurposePasswordReset     = "password-reset"
+	PurposeEmailVerification = "email-verification"
+	PurposeEmailChange       = "email-change"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused means a refresh token was presented after it had
+	// already been exchanged, which suggests it was stolen. Its whole family
+	// is revoked when this happens.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	ErrInvalidUserToken   = errors.New("invalid or expired token")
+)
+
+// AccessTokenTTL is how long an access token is valid, from
+// ACCESS_TOKEN_TTL (a Go duration such as "15m").
+func AccessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", DefaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL is how long a refresh token is valid, from
+// REFRESH_TOKEN_TTL.
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", DefaultRefreshTokenTTL)
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// RefreshToken is stored by hash only. Every refresh token issued from one
+// sign-in shares a FamilyID; using a token marks it used and issues its
+// successor in the same family.
+type RefreshToken struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID   primitive.ObjectID `bson:"userId" json:"userId"`
+	OrgID    primitive.ObjectID `bson:"orgId" json:"orgId"`
+	FamilyID primitive.ObjectID `bson:"familyId" json:"familyId"`
+	// TwoFactor records that the sign-in passed two-factor authentication,
+	// which the access tokens of the family then claim.
+	TwoFactor bool       `bson:"twoFactor,omitempty" json:"twoFactor"`
+	TokenHash string     `bson:"tokenHash" json:"-"`
+	ExpiresAt time.Time  `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+	UsedAt    *time.Time `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	RevokedAt *time.Time `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// RevokedToken blocks an access token by its ID (jti), or every access token
+// of a refresh token family, until ExpiresAt. Entries outlive the tokens
+// they block and are then removed by a TTL index.
+type RevokedToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	TokenID   string             `bson:"jti,omitempty"`
+	FamilyID  string             `bson:"familyId,omitempty"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+}
+
+// UserToken is a single-use token given to a user, such as a password reset
+// link or a sign-in challenge. Like refresh tokens it is stored by hash only.
+// Issuing a new token discards the user's unused tokens of the same purpose.
+type UserToken struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID  primitive.ObjectID `bson:"userId" json:"userId"`
+	Purpose string             `bson:"purpose" json:"purpose"`
+	// OrgID is the organization a sign-in challenge signs in to.
+	OrgID primitive.ObjectID `bson:"orgId,omitempty" json:"orgId,omitempty"`
+	// Email is the address the token was sent to, which an email change
+	// makes the user's, or the account a sign-in challenge counts wrong
+	// codes against.
+	Email     string `bson:"email,omitempty" json:"-"`
+	TokenHash string `bson:"tokenHash" json:"-"`
+	// Attempts counts wrong codes entered for a sign-in challenge.
+	Attempts  int        `bson:"attempts,omitempty" json:"-"`
+	ExpiresAt time.Time  `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+	UsedAt    *time.Time `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+}
+
+type ForgotPassword struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPassword struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password" validate:"required,min=8"`
+	PasswordConfirm string `json:"passwordConfirm" validate:"required,eqfield=Password"`
+}
+
+type VerifyEmail struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResendVerification struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ChangeEmail asks for a link to confirm a new email address with. The
+// password is checked so that a stolen session cannot take the account over.
+type ChangeEmail struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type Refresh struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// NewToken returns a random opaque token.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken is how opaque tokens are stored and looked up.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
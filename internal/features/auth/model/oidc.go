@@ -0,0 +1,95 @@
+package model
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	user_model "invoice-api/internal/features/user/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OIDCLoginTTL is how long a user has to sign in with the identity provider
+// after starting single sign-on.
+const OIDCLoginTTL = 10 * time.Minute
+
+var ErrInvalidOIDCState = errors.New("invalid or expired single sign-on state")
+
+// OIDCLogin is a single sign-on in progress, looked up by the hash of its
+// state when the identity provider redirects back. It keeps the PKCE
+// verifier and the nonce the ID token must carry.
+type OIDCLogin struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	StateHash string             `bson:"stateHash"`
+	Verifier  string             `bson:"verifier"`
+	Nonce     string             `bson:"nonce"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+// SSOSettings decide which organization users who sign in with single
+// sign-on join, and with which role.
+type SSOSettings struct {
+	OrgID primitive.ObjectID
+	// GroupsClaim is the ID token claim listing the user's groups.
+	GroupsClaim string
+	// RoleMapping maps identity provider groups to roles.
+	RoleMapping map[string]string
+	// DefaultRole is given to new members none of whose groups are mapped.
+	DefaultRole string
+}
+
+// SSOSettingsFromEnv reads OIDC_ORG_ID, OIDC_GROUPS_CLAIM (default
+// "groups"), OIDC_ROLE_MAPPING as comma separated group=role pairs, and
+// OIDC_DEFAULT_ROLE (default viewer). Pairs with an unknown role are ignored,
+// and so is the owner role, which only an owner can grant.
+func SSOSettingsFromEnv() SSOSettings {
+	settings := SSOSettings{
+		GroupsClaim: os.Getenv("OIDC_GROUPS_CLAIM"),
+		RoleMapping: map[string]string{},
+		DefaultRole: os.Getenv("OIDC_DEFAULT_ROLE"),
+	}
+	settings.OrgID, _ = primitive.ObjectIDFromHex(os.Getenv("OIDC_ORG_ID"))
+	if settings.GroupsClaim == "" {
+		settings.GroupsClaim = "groups"
+	}
+	if !grantable(settings.DefaultRole) {
+		settings.DefaultRole = user_model.RoleViewer
+	}
+
+	for _, pair := range strings.Split(os.Getenv("OIDC_ROLE_MAPPING"), ",") {
+		group, role, ok := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if ok && group != "" && grantable(role) {
+			settings.RoleMapping[group] = role
+		}
+	}
+
+	return settings
+}
+
+func grantable(role string) bool {
+	return role != user_model.RoleOwner && slices.Contains(user_model.Roles, role)
+}
+
+// RoleFor returns the most privileged role the groups map to, or "" when
+// none of them is mapped.
+func (s SSOSettings) RoleFor(groups []string) string {
+	best := -1
+	for _, group := range groups {
+		role, ok := s.RoleMapping[group]
+		if !ok {
+			continue
+		}
+		if i := slices.Index(user_model.Roles, role); best == -1 || i < best {
+			best = i
+		}
+	}
+	if best == -1 {
+		return ""
+	}
+	return user_model.Roles[best]
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSSOSettingsFromEnv(t *testing.T) {
+	orgID := primitive.NewObjectID()
+	t.Setenv("OIDC_ORG_ID", orgID.Hex())
+	t.Setenv("OIDC_GROUPS_CLAIM", "")
+	t.Setenv("OIDC_ROLE_MAPPING", "finance=accountant, it = admin,founders=owner,typo=superuser,broken")
+	t.Setenv("OIDC_DEFAULT_ROLE", "owner")
+
+	settings := SSOSettingsFromEnv()
+	require.Equal(t, orgID, settings.OrgID)
+	require.Equal(t, "groups", settings.GroupsClaim)
+	require.Equal(t, map[string]string{"finance": "accountant", "it": "admin"}, settings.RoleMapping)
+	// the owner role cannot be granted through single sign-on
+	require.Equal(t, "viewer", settings.DefaultRole)
+}
+
+func TestRoleFor_PicksTheMostPrivilegedRole(t *testing.T) {
+	settings := SSOSettings{RoleMapping: map[string]string{
+		"sales":   "sales",
+		"finance": "accountant",
+		"it":      "admin",
+	}}
+
+	require.Equal(t, "accountant", settings.RoleFor([]string{"sales", "staff", "finance"}))
+	require.Equal(t, "admin", settings.RoleFor([]string{"it", "finance"}))
+	require.Equal(t, "", settings.RoleFor([]string{"staff"}))
+	require.Equal(t, "", settings.RoleFor(nil))
+}
@@ -0,0 +1,228 @@
+package model
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// TOTPPeriod and TOTPDigits are the RFC 6238 defaults every
+	// authenticator app supports.
+	TOTPPeriod = 30 * time.Second
+	TOTPDigits = 6
+
+	RecoveryCodeCount = 10
+
+	// ChallengeTTL is how long a user has to enter their code after their
+	// password was accepted, and MaxChallengeAttempts how many wrong codes
+	// they can enter before they have to start over.
+	ChallengeTTL         = 5 * time.Minute
+	MaxChallengeAttempts = 5
+
+	PurposeTwoFactorChallenge = "two-factor-challenge"
+)
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication is not enrolled")
+	ErrInvalidTwoFactorCode = errors.New("invalid authentication code")
+)
+
+// TwoFactor is a user's TOTP enrollment. It is pending until the user
+// confirms it with a first code. The secret is stored encrypted and the
+// recovery codes hashed; each recovery code can be used once.
+type TwoFactor struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID `bson:"userId" json:"userId"`
+	Secret        string             `bson:"secret" json:"-"`
+	Enabled       bool               `bson:"enabled" json:"enabled"`
+	RecoveryCodes []string           `bson:"recoveryCodes" json:"-"`
+	// LastStep is the time step of the last accepted code, so that a code
+	// cannot be used twice.
+	LastStep    int64      `bson:"lastStep" json:"-"`
+	CreatedAt   time.Time  `bson:"createdAt" json:"createdAt"`
+	ConfirmedAt *time.Time `bson:"confirmedAt,omitempty" json:"confirmedAt,omitempty"`
+}
+
+type TwoFactorCode struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type TwoFactorChallenge struct {
+	ChallengeToken string `json:"challengeToken" validate:"required"`
+	// Code is a code from the authenticator app or a recovery code.
+	Code string `json:"code" validate:"required"`
+}
+
+// NewTOTPSecret returns a random 160-bit secret, base32 encoded as
+// authenticator apps expect.
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// TOTPStep is the RFC 6238 time step at t.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod/time.Second)
+}
+
+// TOTP returns the code for the secret at the time step.
+func TOTP(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulus := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, code%modulus), nil
+}
+
+// ValidateTOTP checks code against the steps around now, allowing for one
+// step of clock drift either way, and returns the step it matched.
+func ValidateTOTP(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(now)
+	for _, step := range []int64{current - 1, current, current + 1} {
+		expected, err := TOTP(secret, step)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// OTPAuthURI is the enrollment URI authenticator apps read from a QR code.
+func OTPAuthURI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TwoFactorIssuer names this service in authenticator apps, from
+// TWO_FACTOR_ISSUER.
+func TwoFactorIssuer() string {
+	if issuer := os.Getenv("TWO_FACTOR_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Invoice API"
+}
+
+// NewRecoveryCodes returns RecoveryCodeCount random codes formatted as
+// xxxxx-xxxxx.
+func NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode hashes a recovery code the way it is stored, ignoring
+// case, spaces and dashes.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashToken(code)
+}
+
+// twoFactorKey is the key TOTP secrets are encrypted with: TWO_FACTOR_KEY
+// when set, otherwise a key derived from JWT_SECRET. Changing it makes
+// existing enrollments unusable.
+func twoFactorKey() []byte {
+	secret := os.Getenv("TWO_FACTOR_KEY")
+	if secret == "" {
+		mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+		mac.Write([]byte("two-factor"))
+		return mac.Sum(nil)
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// EncryptSecret encrypts a TOTP secret for storage with AES-GCM.
+func EncryptSecret(secret string) (string, error) {
+	gcm, err := twoFactorCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret reverses EncryptSecret.
+func DecryptSecret(encrypted string) (string, error) {
+	gcm, err := twoFactorCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted secret is too short")
+	}
+	secret, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+func twoFactorCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(twoFactorKey())
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the RFC 6238 SHA1 test key "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTP_RFC6238Vectors(t *testing.T) {
+	cases := []struct {
+		at   int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tc := range cases {
+		code, err := TOTP(rfcSecret, TOTPStep(time.Unix(tc.at, 0)))
+		require.NoError(t, err)
+		require.Equal(t, tc.want, code, tc.at)
+	}
+}
+
+func TestValidateTOTP_AllowsOneStepOfDrift(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := TOTPStep(now)
+
+	for _, offset := range []int64{-1, 0, 1} {
+		code, err := TOTP(rfcSecret, step+offset)
+		require.NoError(t, err)
+		matched, ok := ValidateTOTP(rfcSecret, code, now)
+		require.True(t, ok, offset)
+		require.Equal(t, step+offset, matched)
+	}
+
+	code, err := TOTP(rfcSecret, step+2)
+	require.NoError(t, err)
+	_, ok := ValidateTOTP(rfcSecret, code, now)
+	require.False(t, ok)
+	_, ok = ValidateTOTP(rfcSecret, "12345", now)
+	require.False(t, ok)
+}
+
+func TestOTPAuthURI(t *testing.T) {
+	uri, err := url.Parse(OTPAuthURI("Invoice API", "ada@example.com", rfcSecret))
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", uri.Scheme)
+	require.Equal(t, "totp", uri.Host)
+	require.Equal(t, "/Invoice API:ada@example.com", uri.Path)
+	require.Equal(t, rfcSecret, uri.Query().Get("secret"))
+	require.Equal(t, "Invoice API", uri.Query().Get("issuer"))
+	require.Equal(t, "6", uri.Query().Get("digits"))
+	require.Equal(t, "30", uri.Query().Get("period"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, RecoveryCodeCount)
+	require.Regexp(t, `^[0-9a-f]{5}-[0-9a-f]{5}$`, codes[0])
+	require.Equal(t, HashRecoveryCode(codes[0]), HashRecoveryCode(" "+codes[0][:5]+codes[0][6:]+" "))
+	require.NotEqual(t, HashRecoveryCode(codes[0]), HashRecoveryCode(codes[1]))
+}
+
+func TestEncryptSecret(t *testing.T) {
+	t.Setenv("TWO_FACTOR_KEY", "test-key")
+
+	encrypted, err := EncryptSecret(rfcSecret)
+	require.NoError(t, err)
+	require.NotContains(t, encrypted, rfcSecret)
+	again, err := EncryptSecret(rfcSecret)
+	require.NoError(t, err)
+	require.NotEqual(t, encrypted, again)
+
+	secret, err := DecryptSecret(encrypted)
+	require.NoError(t, err)
+	require.Equal(t, rfcSecret, secret)
+
+	t.Setenv("TWO_FACTOR_KEY", "other-key")
+	_, err = DecryptSecret(encrypted)
+	require.Error(t, err)
+}
@@ -0,0 +1,91 @@
+package query
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	"invoice-api/internal/features/auth/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DefaultQuery struct{}
+
+func (c *DefaultQuery) CollectionName() string {
+	return "revoked_tokens"
+}
+
+type Query interface {
+	IsRevoked(tokenID string, familyID string) (bool, error)
+	TwoFactorEnabled(userID primitive.ObjectID) (bool, error)
+	GetLoginAttempts(keys []string) ([]model.LoginAttempts, error)
+}
+
+// IsRevoked reports whether the access token, or the refresh token family it
+// was issued with, has been revoked.
+func (c *DefaultQuery) IsRevoked(tokenID string, familyID string) (bool, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	or := bson.A{}
+	if tokenID != "" {
+		or = append(or, bson.M{"jti": tokenID})
+	}
+	if familyID != "" {
+		or = append(or, bson.M{"familyId": familyID})
+	}
+	if len(or) == 0 {
+		return false, nil
+	}
+
+	count, err := collection.CountDocuments(ctx, bson.M{"$or": or, "expiresAt": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// TwoFactorEnabled reports whether the user has confirmed a two-factor
+// enrollment.
+func (c *DefaultQuery) TwoFactorEnabled(userID primitive.ObjectID) (bool, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("two_factor")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := collection.CountDocuments(ctx, bson.M{"userId": userID, "enabled": true})
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// GetLoginAttempts returns the failed sign-in counters of the keys that have
+// any.
+func (c *DefaultQuery) GetLoginAttempts(keys []string) ([]model.LoginAttempts, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("login_attempts")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{"key": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]model.LoginAttempts, 0, len(keys))
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
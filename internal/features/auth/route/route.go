@@ -11,9 +11,25 @@ type AuthRoute struct{}
 
 func (s *AuthRoute) Init(router *fiber.App) {
 	authController := new(controller.AuthController)
+	router.Get("/.well-known/jwks.json", middleware.JWKSHandler)
 	auth := router.Group("/auth")
 
 	auth.Post("/signup", authController.SignUpUser)
 	auth.Post("/signin", authController.SignInUser)
-	auth.Get("/signout", middleware.Authorize, authController.LogoutUser)
+	auth.Post("/refresh", authController.RefreshToken)
+	auth.Post("/forgot-password", authController.ForgotPassword)
+	auth.Post("/reset-password", authController.ResetPassword)
+	auth.Post("/verify-email", authController.VerifyEmail)
+	auth.Post("/resend-verification", authController.ResendVerification)
+	auth.Post("/confirm-email", authController.ConfirmEmail)
+	auth.Patch("/me", middleware.AuthorizeSession, authController.UpdateProfile)
+	auth.Post("/me/email", middleware.AuthorizeSession, authController.ChangeEmail)
+	auth.Get("/signout", middleware.AuthorizeTwoFactorSetup, authController.LogoutUser)
+	auth.Post("/switch-org", middleware.AuthorizeTwoFactorSetup, authController.SwitchOrganization)
+	auth.Post("/2fa/verify", authController.VerifyTwoFactor)
+	auth.Post("/2fa/enroll", middleware.AuthorizeTwoFactorSetup, authController.EnrollTwoFactor)
+	auth.Post("/2fa/confirm", middleware.AuthorizeTwoFactorSetup, authController.ConfirmTwoFactor)
+	auth.Get("/oidc/login", authController.OIDCLogin)
+	auth.Get("/oidc/callback", authController.OIDCCallback)
+	auth.Post("/2fa/disable", middleware.AuthorizeSession, authController.DisableTwoFactor)
 }
@@ -2,16 +2,29 @@ package command
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
 	"invoice-api/internal/features/customer/model"
+	customfield_model "invoice-api/internal/features/customfield/model"
+	customfield_query "invoice-api/internal/features/customfield/query"
+	"log"
+	"slices"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type DefaultCommand struct{}
+var ErrMergeSameCustomer = errors.New("cannot merge a customer into itself")
+
+type DefaultCommand struct {
+	OrgID primitive.ObjectID
+}
 
 func (c *DefaultCommand) CollectionName() string {
 	return "customers"
@@ -21,10 +34,88 @@ type Command interface {
 	CreateCustomer(_val *model.CreateCustomer) (*mongo.InsertOneResult, error)
 	UpdateCustomer(id string, _val *model.UpdateCustomer) (*mongo.UpdateResult, error)
 	DeleteCustomer(id string) (*mongo.DeleteResult, error)
+	MergeCustomer(targetID string, sourceID string) (*model.CustomerMerge, error)
+	AnonymizeCustomer(id string) (*model.CustomerDTOMin, error)
+	RecordDataRequest(id string, requestType string) error
+}
+
+// EnsureIndexes backfills normalizedEmail on older documents and enforces that
+// no two customers of an organization share the same normalized email.
+// Customers that already share one are left to be merged by hand: the oldest
+// keeps its normalizedEmail and the others are logged and left out of the
+// index until then.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection("customers")
+
+	backfill := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"normalizedEmail": bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}},
+		}}},
+	}
+	if _, err := collection.UpdateMany(ctx, bson.M{"normalizedEmail": bson.M{"$exists": false}}, backfill); err != nil {
+		return err
+	}
+
+	if err := excludeDuplicateEmails(ctx, collection); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"normalizedEmail_unique", "orgId_normalizedEmail_unique"} {
+		if err := database.DropIndex(ctx, collection, name); err != nil {
+			return err
+		}
+	}
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "orgId", Value: 1}, {Key: "normalizedEmail", Value: 1}},
+		Options: options.Index().SetName("orgId_normalizedEmail_partial_unique").SetUnique(true).
+			SetPartialFilterExpression(bson.M{"normalizedEmail": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+// excludeDuplicateEmails finds customers of an organization that share a
+// normalized email and removes it from all but the oldest, which the unique
+// index would otherwise refuse. They show up as duplicates with a score of 1
+// and are logged on every start until they are merged.
+func excludeDuplicateEmails(ctx context.Context, collection *mongo.Collection) error {
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"normalizedEmail": bson.M{"$exists": true}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"orgId": "$orgId", "normalizedEmail": "$normalizedEmail"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		Key struct {
+			OrgID primitive.ObjectID `bson:"orgId"`
+		} `bson:"_id"`
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		log.Printf("customers %v of organization %v share the email of customer %v and are not checked for unique emails until they are merged",
+			group.IDs[1:], group.Key.OrgID.Hex(), group.IDs[0])
+		if _, err := collection.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": group.IDs[1:]}},
+			bson.M{"$unset": bson.M{"normalizedEmail": ""}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (c *DefaultCommand) CreateCustomer(_val *model.CreateCustomer) (*mongo.InsertOneResult, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	imageURL := _val.ImageURL
@@ -32,12 +123,26 @@ func (c *DefaultCommand) CreateCustomer(_val *model.CreateCustomer) (*mongo.Inse
 		imageURL = "https://placehold.co/250/93C5fd/fff/png?text=" + _val.Name[:1]
 	}
 
+	defs, err := (&customfield_query.DefaultQuery{OrgID: c.OrgID}).GetItemsByQuery(customfield_model.AppliesToCustomer)
+	if err != nil {
+		return nil, err
+	}
+	customFields, err := customfield_model.ValidateValues(defs, _val.CustomFields, true)
+	if err != nil {
+		return nil, err
+	}
+
 	customer := &model.Customer{
-		Name:  _val.Name,
-		Email:      _val.Email,
-		ImageURL:   imageURL,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		Name:            _val.Name,
+		Email:           _val.Email,
+		NormalizedEmail: model.NormalizeEmail(_val.Email),
+		ImageURL:        imageURL,
+		CreditLimit:     _val.CreditLimit,
+		OnHold:          _val.OnHold,
+		CustomFields:    customfield_model.CreateFields(customFields),
+		Tags:            customfield_model.NormalizeTags(_val.Tags),
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -52,17 +157,42 @@ func (c *DefaultCommand) CreateCustomer(_val *model.CreateCustomer) (*mongo.Inse
 }
 
 func (c *DefaultCommand) UpdateCustomer(id string, _val *model.UpdateCustomer) (*mongo.UpdateResult, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	update := bson.M{
-		"$set": bson.M{
-			"name":  _val.Name,
-			"imageUrl":   _val.ImageURL,
-			"updatedAt":  time.Now(),
-		},
+	set := bson.M{
+		"name":      _val.Name,
+		"imageUrl":  _val.ImageURL,
+		"updatedAt": time.Now(),
+	}
+	if _val.CreditLimit != nil {
+		set["creditLimit"] = *_val.CreditLimit
+	}
+	if _val.OnHold != nil {
+		set["onHold"] = *_val.OnHold
+	}
+	if _val.Tags != nil {
+		set["tags"] = customfield_model.NormalizeTags(_val.Tags)
+	}
+	update := bson.M{"$set": set}
+	if len(_val.CustomFields) > 0 {
+		defs, err := (&customfield_query.DefaultQuery{OrgID: c.OrgID}).GetItemsByQuery(customfield_model.AppliesToCustomer)
+		if err != nil {
+			return nil, err
+		}
+		values, err := customfield_model.ValidateValues(defs, _val.CustomFields, false)
+		if err != nil {
+			return nil, err
+		}
+		fieldSet, fieldUnset := customfield_model.UpdateFields(values)
+		for key, value := range fieldSet {
+			set[key] = value
+		}
+		if len(fieldUnset) > 0 {
+			update["$unset"] = fieldUnset
+		}
 	}
 
 	objId, err := primitive.ObjectIDFromHex(id)
@@ -82,7 +212,7 @@ func (c *DefaultCommand) UpdateCustomer(id string, _val *model.UpdateCustomer) (
 }
 
 func (c *DefaultCommand) DeleteCustomer(id string) (*mongo.DeleteResult, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -103,4 +233,284 @@ func (c *DefaultCommand) DeleteCustomer(id string) (*mongo.DeleteResult, error)
 	}
 
 	return res, nil
-}
\ No newline at end of file
+}
+
+// MergeCustomer moves every invoice, data request and earlier merge from the
+// source customer to the target, adds the source's tags, custom fields and
+// billing contact the target lacks, deletes the source and records the merge
+// in customer_merges. All writes happen in a single transaction, which needs
+// MongoDB to run as a replica set.
+func (c *DefaultCommand) MergeCustomer(targetID string, sourceID string) (*model.CustomerMerge, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	targetObjID, err := primitive.ObjectIDFromHex(targetID)
+	if err != nil {
+		return nil, err
+	}
+	sourceObjID, err := primitive.ObjectIDFromHex(sourceID)
+	if err != nil {
+		return nil, err
+	}
+	if targetObjID == sourceObjID {
+		return nil, ErrMergeSameCustomer
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	res, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var target, source model.Customer
+		if err := collection.FindOne(sc, bson.M{"_id": targetObjID}).Decode(&target); err != nil {
+			return nil, err
+		}
+		if err := collection.FindOne(sc, bson.M{"_id": sourceObjID}).Decode(&source); err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		if _, err := collection.UpdateOne(sc, bson.M{"_id": targetObjID}, mergeCustomerUpdate(target, source, now)); err != nil {
+			return nil, err
+		}
+
+		snapshot := model.CustomerDTOMin{
+			ID:       target.ID,
+			Name:     target.Name,
+			Email:    target.Email,
+			ImageURL: target.ImageURL,
+		}
+		moved, err := db.Collection("invoices").UpdateMany(sc, bson.M{"customerId": sourceObjID}, bson.M{
+			"$set": bson.M{
+				"customerId": targetObjID,
+				"customer":   snapshot,
+				"updatedAt":  now,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// The target answers for the source's data requests and for the
+		// customers merged into the source before.
+		if _, err := db.Collection("data_requests").UpdateMany(sc, bson.M{"customerId": sourceObjID}, bson.M{"$set": bson.M{"customerId": targetObjID}}); err != nil {
+			return nil, err
+		}
+		if _, err := db.Collection("customer_merges").UpdateMany(sc, bson.M{"targetId": sourceObjID}, bson.M{"$set": bson.M{"targetId": targetObjID}}); err != nil {
+			return nil, err
+		}
+
+		if _, err := collection.DeleteOne(sc, bson.M{"_id": sourceObjID}); err != nil {
+			return nil, err
+		}
+
+		merge := &model.CustomerMerge{
+			TargetID:      targetObjID,
+			SourceID:      sourceObjID,
+			Source:        source,
+			InvoicesMoved: moved.ModifiedCount,
+			MergedAt:      now,
+		}
+		inserted, err := db.Collection("customer_merges").InsertOne(sc, merge)
+		if err != nil {
+			return nil, err
+		}
+		merge.ID = inserted.InsertedID.(primitive.ObjectID)
+
+		return merge, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*model.CustomerMerge), nil
+}
+
+// mergeCustomerUpdate adds the source's tags and the custom fields and
+// billing contact the target does not have to the target. Where both have a
+// value the target's is kept.
+func mergeCustomerUpdate(target model.Customer, source model.Customer, now time.Time) bson.M {
+	tags := append([]string{}, target.Tags...)
+	for _, tag := range source.Tags {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	customFields := map[string]interface{}{}
+	for key, value := range source.CustomFields {
+		customFields[key] = value
+	}
+	for key, value := range target.CustomFields {
+		customFields[key] = value
+	}
+
+	billingContact := target.BillingContact
+	if billingContact == nil {
+		billingContact = source.BillingContact
+	}
+
+	set := bson.M{"updatedAt": now}
+	if len(tags) > 0 {
+		set["tags"] = tags
+	}
+	if len(customFields) > 0 {
+		set["customFields"] = customFields
+	}
+	if billingContact != nil {
+		set["billingContact"] = billingContact
+	}
+	return bson.M{"$set": set}
+}
+
+// AnonymizeCustomer irreversibly replaces the customer's personal data with a
+// random pseudonym, both on the customer and on the customer snapshot stored in
+// each invoice. Tags and custom fields are free-form and may hold personal
+// data, so they are removed from the customer and its invoices. Amounts, dates
+// and statuses are kept for bookkeeping. The customer's portal links are
+// revoked. Like MergeCustomer it writes in a transaction, which needs MongoDB
+// to run as a replica set.
+func (c *DefaultCommand) AnonymizeCustomer(id string) (*model.CustomerDTOMin, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	pseudonym := hex.EncodeToString(suffix)
+
+	now := time.Now()
+	snapshot := model.CustomerDTOMin{
+		ID:       objID,
+		Name:     "Anonymized Customer " + pseudonym,
+		Email:    "anonymized+" + pseudonym + "@invalid",
+		ImageURL: "https://placehold.co/250/93C5fd/fff/png?text=A",
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		res, err := collection.UpdateOne(sc, bson.M{"_id": objID}, anonymizeCustomerUpdate(snapshot, now))
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, mongo.ErrNoDocuments
+		}
+
+		if _, err := db.Collection("invoices").UpdateMany(sc, bson.M{"customerId": objID}, anonymizeInvoicesUpdate(snapshot)); err != nil {
+			return nil, err
+		}
+
+		// Merge history keeps a full copy of merged customers, so scrub those
+		// copies as well.
+		if _, err := db.Collection("customer_merges").UpdateMany(sc, bson.M{"targetId": objID}, anonymizeMergesUpdate(snapshot)); err != nil {
+			return nil, err
+		}
+
+		if _, err := db.Collection("portal_links").UpdateMany(sc, bson.M{"customerId": objID, "revokedAt": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"revokedAt": now}}); err != nil {
+			return nil, err
+		}
+
+		if _, err := db.Collection("data_requests").InsertOne(sc, model.DataRequest{
+			CustomerID:  objID,
+			Type:        model.DataRequestAnonymize,
+			RequestedAt: now,
+		}); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// anonymizeCustomerUpdate replaces the customer's personal data with the
+// snapshot's pseudonym and removes its free-form fields.
+func anonymizeCustomerUpdate(snapshot model.CustomerDTOMin, now time.Time) bson.M {
+	return bson.M{
+		"$set": bson.M{
+			"name":            snapshot.Name,
+			"email":           snapshot.Email,
+			"normalizedEmail": model.NormalizeEmail(snapshot.Email),
+			"imageUrl":        snapshot.ImageURL,
+			"anonymizedAt":    now,
+			"updatedAt":       now,
+		},
+		"$unset": bson.M{
+			"billingContact": "",
+			"customFields":   "",
+			"tags":           "",
+		},
+	}
+}
+
+// anonymizeInvoicesUpdate replaces the customer snapshot of the customer's
+// invoices and removes their custom fields.
+func anonymizeInvoicesUpdate(snapshot model.CustomerDTOMin) bson.M {
+	return bson.M{
+		"$set":   bson.M{"customer": snapshot},
+		"$unset": bson.M{"customFields": ""},
+	}
+}
+
+// anonymizeMergesUpdate scrubs the copies of customers merged into the
+// customer like anonymizeCustomerUpdate scrubs the customer.
+func anonymizeMergesUpdate(snapshot model.CustomerDTOMin) bson.M {
+	return bson.M{
+		"$set": bson.M{
+			"source.name":            snapshot.Name,
+			"source.email":           snapshot.Email,
+			"source.normalizedEmail": model.NormalizeEmail(snapshot.Email),
+			"source.imageUrl":        snapshot.ImageURL,
+		},
+		"$unset": bson.M{
+			"source.billingContact": "",
+			"source.customFields":   "",
+			"source.tags":           "",
+		},
+	}
+}
+
+// RecordDataRequest logs that a data request was fulfilled for the customer.
+func (c *DefaultCommand) RecordDataRequest(id string, requestType string) error {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection("data_requests")
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = collection.InsertOne(ctx, model.DataRequest{
+		CustomerID:  objID,
+		Type:        requestType,
+		RequestedAt: time.Now(),
+	})
+	return err
+}
@@ -0,0 +1,113 @@
+package command
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"invoice-api/internal/features/customer/model"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// apply runs the $set and $unset of an update on a document, including
+// dotted paths one level deep.
+func apply(doc bson.M, update bson.M) {
+	field := func(path string) (bson.M, string) {
+		if parent, key, ok := strings.Cut(path, "."); ok {
+			return doc[parent].(bson.M), key
+		}
+		return doc, path
+	}
+	for path, value := range update["$set"].(bson.M) {
+		target, key := field(path)
+		target[key] = value
+	}
+	for path := range update["$unset"].(bson.M) {
+		target, key := field(path)
+		delete(target, key)
+	}
+}
+
+func personalCustomer() bson.M {
+	return bson.M{
+		"name":            "Jane Doe",
+		"email":           "jane@example.com",
+		"normalizedEmail": "jane@example.com",
+		"imageUrl":        "https://example.com/jane.png",
+		"creditLimit":     1000.0,
+		"billingContact":  bson.M{"name": "John Doe", "email": "john@example.com"},
+		"customFields":    bson.M{"accountManager": "Mary Major", "poContact": "john@example.com"},
+		"tags":            bson.A{"friend of Mary Major"},
+	}
+}
+
+// requireScrubbed fails when any of the personal values is left in doc.
+func requireScrubbed(t *testing.T, doc bson.M) {
+	b, err := bson.MarshalExtJSON(doc, false, false)
+	require.NoError(t, err)
+	for _, value := range []string{"Jane", "jane@example.com", "jane.png", "John", "john@example.com", "Mary Major"} {
+		require.NotContains(t, string(b), value)
+	}
+}
+
+func TestAnonymizeCustomer_ScrubsPersonalData(t *testing.T) {
+	now := time.Now()
+	snapshot := model.CustomerDTOMin{
+		ID:       primitive.NewObjectID(),
+		Name:     "Anonymized Customer abc",
+		Email:    "anonymized+abc@invalid",
+		ImageURL: "https://placehold.co/250/93C5fd/fff/png?text=A",
+	}
+
+	customer := personalCustomer()
+	apply(customer, anonymizeCustomerUpdate(snapshot, now))
+	requireScrubbed(t, customer)
+	require.Equal(t, "Anonymized Customer abc", customer["name"])
+	require.Equal(t, 1000.0, customer["creditLimit"])
+	require.Equal(t, now, customer["anonymizedAt"])
+
+	invoice := bson.M{
+		"amount":       250.0,
+		"status":       "paid",
+		"customer":     bson.M{"name": "Jane Doe", "email": "jane@example.com", "imageUrl": "https://example.com/jane.png"},
+		"customFields": bson.M{"poContact": "john@example.com"},
+	}
+	apply(invoice, anonymizeInvoicesUpdate(snapshot))
+	requireScrubbed(t, invoice)
+	require.Equal(t, 250.0, invoice["amount"])
+	require.Equal(t, snapshot, invoice["customer"])
+
+	merge := bson.M{"invoicesMoved": 3, "source": personalCustomer()}
+	apply(merge, anonymizeMergesUpdate(snapshot))
+	requireScrubbed(t, merge)
+	require.Equal(t, 3, merge["invoicesMoved"])
+}
+
+func TestMergeCustomerUpdate_AddsWhatTheTargetLacks(t *testing.T) {
+	now := time.Now()
+	target := model.Customer{
+		Tags:         []string{"vip", "emea"},
+		CustomFields: map[string]interface{}{"accountManager": "Mary Major"},
+	}
+	source := model.Customer{
+		Tags:           []string{"emea", "reseller"},
+		CustomFields:   map[string]interface{}{"accountManager": "Richard Roe", "poNumber": "PO-1"},
+		BillingContact: &model.BillingContact{Name: "John Doe", Email: "john@example.com"},
+	}
+
+	set := mergeCustomerUpdate(target, source, now)["$set"].(bson.M)
+	require.Equal(t, []string{"vip", "emea", "reseller"}, set["tags"])
+	require.Equal(t, map[string]interface{}{"accountManager": "Mary Major", "poNumber": "PO-1"}, set["customFields"])
+	require.Equal(t, source.BillingContact, set["billingContact"])
+	require.Equal(t, now, set["updatedAt"])
+
+	// the target's billing contact is kept, and nothing is set that neither has
+	target.BillingContact = &model.BillingContact{Name: "Jane Doe"}
+	set = mergeCustomerUpdate(target, source, now)["$set"].(bson.M)
+	require.Equal(t, target.BillingContact, set["billingContact"])
+	set = mergeCustomerUpdate(model.Customer{}, model.Customer{}, now)["$set"].(bson.M)
+	require.Equal(t, bson.M{"updatedAt": now}, set)
+}
@@ -1,12 +1,19 @@
 package controller
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"invoice-api/internal/features/customer/command"
 	"invoice-api/internal/features/customer/model"
 	"invoice-api/internal/features/customer/query"
+	customfield_model "invoice-api/internal/features/customfield/model"
+	"invoice-api/middleware"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -15,11 +22,25 @@ type CustomerController struct {
 	Query   query.Query
 }
 
-func (s *CustomerController) CreateCustomer(c *fiber.Ctx) error {
-	if s.Command == nil {
-		s.Command = &command.DefaultCommand{}
+// query returns the injected query, or one scoped to the organization the
+// request was authorized for.
+func (s *CustomerController) query(c *fiber.Ctx) query.Query {
+	if s.Query != nil {
+		return s.Query
 	}
+	return &query.DefaultQuery{OrgID: middleware.OrgID(c)}
+}
 
+// command returns the injected command, or one scoped to the organization
+// the request was authorized for.
+func (s *CustomerController) command(c *fiber.Ctx) command.Command {
+	if s.Command != nil {
+		return s.Command
+	}
+	return &command.DefaultCommand{OrgID: middleware.OrgID(c)}
+}
+
+func (s *CustomerController) CreateCustomer(c *fiber.Ctx) error {
 	payload := new(model.CreateCustomer)
 	if err := c.BodyParser(payload); err != nil {
 		return c.Status(400).JSON(err.Error())
@@ -30,8 +51,20 @@ func (s *CustomerController) CreateCustomer(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
 	}
 
-	resp, err := s.Command.CreateCustomer(payload)
+	item, _ := s.query(c).GetByEmail(payload.Email)
+	if item.ID != primitive.NilObjectID {
+		return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "A customer with this email address already exists", "id": item.ID})
+	}
+
+	resp, err := s.command(c).CreateCustomer(payload)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "A customer with this email address already exists"})
+		}
+		var fieldErr *customfield_model.ValidationError
+		if errors.As(err, &fieldErr) {
+			return c.Status(400).JSON(fiber.Map{"status": "error", "errors": fieldErr.Errors})
+		}
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Failed to create customer",
 		})
@@ -41,9 +74,6 @@ func (s *CustomerController) CreateCustomer(c *fiber.Ctx) error {
 }
 
 func (s *CustomerController) GetAllCustomers(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultQuery{}
-	}
 	keyword := c.Query("keyword")
 	sizeStr := c.Query("size")
 	pageStr := c.Query("page")
@@ -55,7 +85,8 @@ func (s *CustomerController) GetAllCustomers(c *fiber.Ctx) error {
 	if err != nil {
 		page = 1
 	}
-	items, err := s.Query.GetItemsByQuery(keyword, size, page)
+	fieldFilter := customfield_model.ParseFilter(c.Queries())
+	items, err := s.query(c).GetItemsByQuery(keyword, fieldFilter, size, page)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": err.Error(),
@@ -66,12 +97,9 @@ func (s *CustomerController) GetAllCustomers(c *fiber.Ctx) error {
 }
 
 func (s *CustomerController) GetCustomerByID(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultQuery{}
-	}
 	id := c.Params("id")
 
-	item, err := s.Query.GetItemByID(id)
+	item, err := s.query(c).GetItemByID(id)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
@@ -87,9 +115,6 @@ func (s *CustomerController) GetCustomerByID(c *fiber.Ctx) error {
 }
 
 func (s *CustomerController) UpdateCustomer(c *fiber.Ctx) error {
-	if s.Command == nil {
-		s.Command = &command.DefaultCommand{}
-	}
 	id := c.Params("id")
 
 	payload := new(model.UpdateCustomer)
@@ -101,13 +126,17 @@ func (s *CustomerController) UpdateCustomer(c *fiber.Ctx) error {
 	if validationErrors != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
 	}
-	res, err := s.Command.UpdateCustomer(id, payload)
+	res, err := s.command(c).UpdateCustomer(id, payload)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
 				"error": "Customer not found",
 			})
 		}
+		var fieldErr *customfield_model.ValidationError
+		if errors.As(err, &fieldErr) {
+			return c.Status(400).JSON(fiber.Map{"status": "error", "errors": fieldErr.Errors})
+		}
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Failed to update customer",
 		})
@@ -127,7 +156,7 @@ func (s *CustomerController) UpdateCustomer(c *fiber.Ctx) error {
 func (s *CustomerController) DeleteCustomer(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	res, err := s.Command.DeleteCustomer(id)
+	res, err := s.command(c).DeleteCustomer(id)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
@@ -151,11 +180,8 @@ func (s *CustomerController) DeleteCustomer(c *fiber.Ctx) error {
 }
 
 func (s *CustomerController) GetCustomersCount(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultQuery{}
-	}
 	keyword := c.Query("keyword")
-	total_items, err := s.Query.GetTotalItemsByQuery(keyword)
+	total_items, err := s.query(c).GetTotalItemsByQuery(keyword)
 	if err != nil {
 		return c.JSON(0)
 	}
@@ -164,9 +190,6 @@ func (s *CustomerController) GetCustomersCount(c *fiber.Ctx) error {
 }
 
 func (s *CustomerController) GetCustomersWithTotalByQuery(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultQuery{}
-	}
 	keyword := c.Query("keyword")
 	sizeStr := c.Query("size")
 	pageStr := c.Query("page")
@@ -179,7 +202,14 @@ func (s *CustomerController) GetCustomersWithTotalByQuery(c *fiber.Ctx) error {
 		page = 1
 	}
 
-	res, err := s.Query.GetItemsWithTotalByQuery(keyword, size, page)
+	sort, err := model.ParseCustomerSort(c.Query("sort"), c.Query("order"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	res, err := s.query(c).GetItemsWithTotalByQuery(keyword, sort, size, page)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": err.Error(),
@@ -188,3 +218,149 @@ func (s *CustomerController) GetCustomersWithTotalByQuery(c *fiber.Ctx) error {
 
 	return c.JSON(res)
 }
+
+func (s *CustomerController) GetDuplicateCustomers(c *fiber.Ctx) error {
+	threshold, err := strconv.ParseFloat(c.Query("threshold"), 64)
+	if err != nil || threshold <= 0 || threshold > 1 {
+		threshold = 0.85
+	}
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+
+	items, err := s.query(c).GetDuplicates(threshold, limit)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(items)
+}
+
+func (s *CustomerController) MergeCustomer(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	payload := new(model.MergeCustomer)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(err.Error())
+	}
+
+	validationErrors := model.ValidateStruct(payload)
+	if validationErrors != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
+	}
+
+	res, err := s.command(c).MergeCustomer(id, payload.SourceID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Customer not found",
+			})
+		}
+		if errors.Is(err, command.ErrMergeSameCustomer) {
+			return c.Status(400).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to merge customer",
+		})
+	}
+
+	return c.JSON(res)
+}
+
+func (s *CustomerController) GetCustomerTags(c *fiber.Ctx) error {
+	items, err := s.query(c).GetTagCounts()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(items)
+}
+
+// ExportCustomer returns a ZIP archive with all personal data held about the
+// customer, for subject access requests.
+func (s *CustomerController) ExportCustomer(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	export, err := s.query(c).GetExport(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Customer not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to export customer",
+		})
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"customer.json", export.Customer},
+		{"invoices.json", export.Invoices},
+		{"merges.json", export.Merges},
+		{"data_requests.json", export.DataRequests},
+	}
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err == nil {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(file.data)
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Failed to build export",
+			})
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to build export",
+		})
+	}
+
+	if err := s.command(c).RecordDataRequest(id, model.DataRequestExport); err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to record export",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Attachment("customer-" + id + "-export.zip")
+	return c.Send(buf.Bytes())
+}
+
+func (s *CustomerController) AnonymizeCustomer(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	res, err := s.command(c).AnonymizeCustomer(id)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Customer not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to anonymize customer",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":  "Customer anonymized successfully",
+		"customer": res,
+	})
+}
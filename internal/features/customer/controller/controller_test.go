@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
 	"io"
@@ -12,7 +13,9 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
+	"invoice-api/internal/features/customer/command"
 	modelpkg "invoice-api/internal/features/customer/model"
+	customfield_model "invoice-api/internal/features/customfield/model"
 )
 
 type mockCommand struct {
@@ -22,6 +25,11 @@ type mockCommand struct {
 	updateErr error
 	deleteRes *mongo.DeleteResult
 	deleteErr error
+	mergeRes  *modelpkg.CustomerMerge
+	mergeErr  error
+	anonRes   *modelpkg.CustomerDTOMin
+	anonErr   error
+	requests  []string
 }
 
 func (m *mockCommand) CreateCustomer(_val *modelpkg.CreateCustomer) (*mongo.InsertOneResult, error) {
@@ -36,12 +44,71 @@ func (m *mockCommand) DeleteCustomer(id string) (*mongo.DeleteResult, error) {
 	return m.deleteRes, m.deleteErr
 }
 
+func (m *mockCommand) MergeCustomer(targetID string, sourceID string) (*modelpkg.CustomerMerge, error) {
+	return m.mergeRes, m.mergeErr
+}
+
+func (m *mockCommand) AnonymizeCustomer(id string) (*modelpkg.CustomerDTOMin, error) {
+	return m.anonRes, m.anonErr
+}
+
+func (m *mockCommand) RecordDataRequest(id string, requestType string) error {
+	m.requests = append(m.requests, requestType)
+	return nil
+}
+
+type mockQuery struct {
+	byEmail    modelpkg.CustomerDTO
+	byEmailErr error
+	duplicates []modelpkg.DuplicateCandidate
+	limit      int
+	filter     customfield_model.Filter
+	sort       modelpkg.CustomerSort
+	export     *modelpkg.CustomerExport
+	exportErr  error
+}
+
+func (m *mockQuery) GetItemsByQuery(keyword string, fieldFilter customfield_model.Filter, size int64, page int64) (*modelpkg.CustomerPage, error) {
+	m.filter = fieldFilter
+	return &modelpkg.CustomerPage{}, nil
+}
+
+func (m *mockQuery) GetItemByID(id string) (*modelpkg.CustomerDTO, error) {
+	return &modelpkg.CustomerDTO{}, nil
+}
+
+func (m *mockQuery) GetByEmail(email string) (modelpkg.CustomerDTO, error) {
+	return m.byEmail, m.byEmailErr
+}
+
+func (m *mockQuery) GetTotalItemsByQuery(keyword string) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockQuery) GetItemsWithTotalByQuery(keyword string, sort modelpkg.CustomerSort, size int64, page int64) (*modelpkg.CustomerWithTotalPage, error) {
+	m.sort = sort
+	return &modelpkg.CustomerWithTotalPage{}, nil
+}
+
+func (m *mockQuery) GetDuplicates(threshold float64, limit int) ([]modelpkg.DuplicateCandidate, error) {
+	m.limit = limit
+	return m.duplicates, nil
+}
+
+func (m *mockQuery) GetExport(id string) (*modelpkg.CustomerExport, error) {
+	return m.export, m.exportErr
+}
+
+func (m *mockQuery) GetTagCounts() ([]customfield_model.TagCount, error) {
+	return []customfield_model.TagCount{{Tag: "vip", Count: 2}}, nil
+}
+
 func TestCreateCustomer_SuccessAndBadBody(t *testing.T) {
 	app := fiber.New()
 
 	// success case
 	mock := &mockCommand{createRes: &mongo.InsertOneResult{InsertedID: primitive.NewObjectID()}, createErr: nil}
-	ctrl := &CustomerController{Command: mock}
+	ctrl := &CustomerController{Command: mock, Query: &mockQuery{byEmailErr: mongo.ErrNoDocuments}}
 	app.Post("/", ctrl.CreateCustomer)
 
 	payload := map[string]string{"name": "John", "email": "john@example.com"}
@@ -132,3 +199,166 @@ func TestUpdateCustomer_NotFoundAndSuccess(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "Customer updated successfully", body["message"])
 }
+
+func TestCreateCustomer_EmailTaken(t *testing.T) {
+	app := fiber.New()
+	taken := &mockQuery{byEmail: modelpkg.CustomerDTO{ID: primitive.NewObjectID()}}
+	ctrl := &CustomerController{Command: &mockCommand{}, Query: taken}
+	app.Post("/", ctrl.CreateCustomer)
+
+	payload := map[string]string{"name": "John", "email": "John@Example.com "}
+	b, _ := json.Marshal(payload)
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 409, resp.StatusCode)
+}
+
+func TestMergeCustomer_NotFoundSameAndSuccess(t *testing.T) {
+	payload := map[string]string{"sourceId": "507f1f77bcf86cd799439012"}
+	b, _ := json.Marshal(payload)
+
+	cases := []struct {
+		mock   *mockCommand
+		status int
+	}{
+		{&mockCommand{mergeErr: mongo.ErrNoDocuments}, 404},
+		{&mockCommand{mergeErr: command.ErrMergeSameCustomer}, 400},
+		{&mockCommand{mergeRes: &modelpkg.CustomerMerge{InvoicesMoved: 3}}, 200},
+	}
+
+	for _, tc := range cases {
+		app := fiber.New()
+		ctrl := &CustomerController{Command: tc.mock}
+		app.Post("/:id/merge", ctrl.MergeCustomer)
+
+		req := httptest.NewRequest("POST", "/507f1f77bcf86cd799439011/merge", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		require.Equal(t, tc.status, resp.StatusCode)
+	}
+
+	// missing sourceId
+	app := fiber.New()
+	ctrl := &CustomerController{Command: &mockCommand{}}
+	app.Post("/:id/merge", ctrl.MergeCustomer)
+	req := httptest.NewRequest("POST", "/507f1f77bcf86cd799439011/merge", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode)
+}
+
+func TestGetAllCustomers_ParsesTagAndCustomFieldFilters(t *testing.T) {
+	app := fiber.New()
+	q := &mockQuery{}
+	ctrl := &CustomerController{Query: q}
+	app.Get("/", ctrl.GetAllCustomers)
+
+	req := httptest.NewRequest("GET", "/?tags=VIP,%20north&cf.costCenter=CC-1", nil)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, []string{"vip", "north"}, q.filter.Tags)
+	require.Equal(t, map[string]string{"costCenter": "CC-1"}, q.filter.Fields)
+}
+
+func TestCreateCustomer_InvalidCustomFields(t *testing.T) {
+	app := fiber.New()
+	fieldErr := &customfield_model.ValidationError{Errors: []customfield_model.ValueError{{Field: "poNumber", Message: "is required"}}}
+	ctrl := &CustomerController{Command: &mockCommand{createErr: fieldErr}, Query: &mockQuery{byEmailErr: mongo.ErrNoDocuments}}
+	app.Post("/", ctrl.CreateCustomer)
+
+	payload := map[string]string{"name": "John", "email": "john@example.com"}
+	b, _ := json.Marshal(payload)
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode)
+}
+
+func TestExportCustomer_NotFoundAndZip(t *testing.T) {
+	app := fiber.New()
+	ctrl := &CustomerController{Command: &mockCommand{}, Query: &mockQuery{exportErr: mongo.ErrNoDocuments}}
+	app.Get("/:id/export", ctrl.ExportCustomer)
+	resp, err := app.Test(httptest.NewRequest("GET", "/507f1f77bcf86cd799439011/export", nil))
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode)
+
+	app = fiber.New()
+	cmd := &mockCommand{}
+	export := &modelpkg.CustomerExport{Customer: modelpkg.Customer{Name: "John", Email: "john@example.com"}}
+	ctrl = &CustomerController{Command: cmd, Query: &mockQuery{export: export}}
+	app.Get("/:id/export", ctrl.ExportCustomer)
+	resp, err = app.Test(httptest.NewRequest("GET", "/507f1f77bcf86cd799439011/export", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	require.Equal(t, []string{modelpkg.DataRequestExport}, cmd.requests)
+
+	body, _ := io.ReadAll(resp.Body)
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	names := make([]string, 0)
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	require.Equal(t, []string{"customer.json", "invoices.json", "merges.json", "data_requests.json"}, names)
+}
+
+func TestAnonymizeCustomer_NotFoundAndSuccess(t *testing.T) {
+	cases := []struct {
+		mock   *mockCommand
+		status int
+	}{
+		{&mockCommand{anonErr: mongo.ErrNoDocuments}, 404},
+		{&mockCommand{anonRes: &modelpkg.CustomerDTOMin{Name: "Anonymized Customer abc"}}, 200},
+	}
+
+	for _, tc := range cases {
+		app := fiber.New()
+		ctrl := &CustomerController{Command: tc.mock}
+		app.Post("/:id/anonymize", ctrl.AnonymizeCustomer)
+		resp, err := app.Test(httptest.NewRequest("POST", "/507f1f77bcf86cd799439011/anonymize", nil))
+		require.NoError(t, err)
+		require.Equal(t, tc.status, resp.StatusCode)
+	}
+}
+
+func TestGetCustomersWithTotal_Sort(t *testing.T) {
+	app := fiber.New()
+	q := &mockQuery{}
+	ctrl := &CustomerController{Query: q}
+	app.Get("/", ctrl.GetCustomersWithTotalByQuery)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/?sort=outstanding&order=desc", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, modelpkg.CustomerSort{Field: "outstanding", Desc: true}, q.sort)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, modelpkg.CustomerSort{Field: "_id", Desc: true}, q.sort)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/?sort=password", nil))
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode)
+}
+
+func TestGetDuplicateCustomers_Limit(t *testing.T) {
+	app := fiber.New()
+	q := &mockQuery{}
+	ctrl := &CustomerController{Query: q}
+	app.Get("/duplicates", ctrl.GetDuplicateCustomers)
+
+	for query, limit := range map[string]int{"": 100, "?limit=20": 20, "?limit=-1": 100, "?limit=50000": 1000} {
+		resp, err := app.Test(httptest.NewRequest("GET", "/duplicates"+query, nil))
+		require.NoError(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+		require.Equal(t, limit, q.limit, query)
+	}
+}
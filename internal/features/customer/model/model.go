@@ -1,30 +1,56 @@
 package model
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var validate = validator.New()
 
+// Customer is a billed party. A CreditLimit of 0 means the customer has no
+// limit on their outstanding balance.
 type Customer struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name      string             `bson:"name" json:"name"`
-	Email     string             `bson:"email" json:"email"`
-	ImageURL  string             `bson:"imageUrl" json:"imageUrl"`
-	CreatedAt time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
-	UpdatedAt time.Time          `bson:"updatedAt,omitempty" json:"updatedAt"`
+	ID              primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Name            string                 `bson:"name" json:"name"`
+	Email           string                 `bson:"email" json:"email"`
+	NormalizedEmail string                 `bson:"normalizedEmail" json:"-"`
+	ImageURL        string                 `bson:"imageUrl" json:"imageUrl"`
+	CreditLimit     float64                `bson:"creditLimit" json:"creditLimit"`
+	OnHold          bool                   `bson:"onHold" json:"onHold"`
+	CustomFields    map[string]interface{} `bson:"customFields,omitempty" json:"customFields,omitempty"`
+	Tags            []string               `bson:"tags,omitempty" json:"tags,omitempty"`
+	BillingContact  *BillingContact        `bson:"billingContact,omitempty" json:"billingContact,omitempty"`
+	AnonymizedAt    *time.Time             `bson:"anonymizedAt,omitempty" json:"anonymizedAt,omitempty"`
+	CreatedAt       time.Time              `bson:"createdAt,omitempty" json:"createdAt"`
+	UpdatedAt       time.Time              `bson:"updatedAt,omitempty" json:"updatedAt"`
 }
 
 type CustomerDTO struct {
-	ID        primitive.ObjectID `bson:"_id" json:"id"`
-	Name      string             `json:"name"`
-	Email     string             `json:"email"`
-	ImageURL  string             `json:"imageUrl"`
-	CreatedAt time.Time          `json:"createdAt"`
-	UpdatedAt time.Time          `json:"updatedAt,omitzero"`
+	ID             primitive.ObjectID     `bson:"_id" json:"id"`
+	Name           string                 `json:"name"`
+	Email          string                 `json:"email"`
+	ImageURL       string                 `json:"imageUrl"`
+	CreditLimit    float64                `bson:"creditLimit" json:"creditLimit"`
+	OnHold         bool                   `bson:"onHold" json:"onHold"`
+	CustomFields   map[string]interface{} `bson:"customFields" json:"customFields,omitempty"`
+	Tags           []string               `bson:"tags" json:"tags,omitempty"`
+	BillingContact *BillingContact        `bson:"billingContact" json:"billingContact,omitempty"`
+	CreatedAt      time.Time              `json:"createdAt"`
+	UpdatedAt      time.Time              `json:"updatedAt,omitzero"`
+}
+
+// BillingContact is who receives invoices on the customer's side. Customers
+// can maintain it themselves through the portal.
+type BillingContact struct {
+	Name    string `bson:"name" json:"name"`
+	Email   string `bson:"email" json:"email" validate:"omitempty,email"`
+	Phone   string `bson:"phone" json:"phone"`
+	Address string `bson:"address" json:"address"`
 }
 
 type CustomerDTOMin struct {
@@ -35,15 +61,74 @@ type CustomerDTOMin struct {
 }
 
 type CreateCustomer struct {
-	Name     string `json:"name" validate:"required"`
-	Email    string `json:"email" validate:"required"`
-	ImageURL string `json:"imageUrl"`
+	Name         string                 `json:"name" validate:"required"`
+	Email        string                 `json:"email" validate:"required"`
+	ImageURL     string                 `json:"imageUrl"`
+	CreditLimit  float64                `json:"creditLimit" validate:"gte=0"`
+	OnHold       bool                   `json:"onHold"`
+	CustomFields map[string]interface{} `json:"customFields"`
+	Tags         []string               `json:"tags"`
 }
 
 type UpdateCustomer struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	ImageURL string `json:"imageUrl"`
+	Name         string                 `json:"name"`
+	Email        string                 `json:"email"`
+	ImageURL     string                 `json:"imageUrl"`
+	CreditLimit  *float64               `json:"creditLimit" validate:"omitempty,gte=0"`
+	OnHold       *bool                  `json:"onHold"`
+	CustomFields map[string]interface{} `json:"customFields"`
+	Tags         []string               `json:"tags"`
+}
+
+// NormalizeEmail returns the form of an email address used to detect
+// duplicate customers.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+type MergeCustomer struct {
+	SourceID string `json:"sourceId" validate:"required"`
+}
+
+// CustomerMerge is the audit record written when one customer is merged into
+// another.
+type CustomerMerge struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TargetID      primitive.ObjectID `bson:"targetId" json:"targetId"`
+	SourceID      primitive.ObjectID `bson:"sourceId" json:"sourceId"`
+	Source        Customer           `bson:"source" json:"source"`
+	InvoicesMoved int64              `bson:"invoicesMoved" json:"invoicesMoved"`
+	MergedAt      time.Time          `bson:"mergedAt" json:"mergedAt"`
+}
+
+// DataRequest logs a subject access or erasure request handled for a
+// customer.
+type DataRequest struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CustomerID  primitive.ObjectID `bson:"customerId" json:"customerId"`
+	Type        string             `bson:"type" json:"type"`
+	RequestedAt time.Time          `bson:"requestedAt" json:"requestedAt"`
+}
+
+const (
+	DataRequestExport    = "export"
+	DataRequestAnonymize = "anonymize"
+)
+
+// CustomerExport holds every record that carries the customer's personal
+// data. Invoices and audit entries are exported as stored.
+type CustomerExport struct {
+	Customer     Customer        `json:"customer"`
+	Invoices     []bson.M        `json:"invoices"`
+	Merges       []CustomerMerge `json:"merges"`
+	DataRequests []DataRequest   `json:"dataRequests"`
+}
+
+type DuplicateCandidate struct {
+	Customer  CustomerDTOMin `json:"customer"`
+	Duplicate CustomerDTOMin `json:"duplicate"`
+	Score     float64        `json:"score"`
+	Reasons   []string       `json:"reasons"`
 }
 
 type CustomerPage struct {
@@ -62,14 +147,74 @@ type CustomerWithTotalPage struct {
 	Data       []*CustomerWithTotalDTO `json:"data"`
 }
 
+// SlowPayerDaysLate is how many days past due, on average, a customer's paid
+// invoices must have been settled for them to be flagged as a slow payer.
+const SlowPayerDaysLate = 15
+
+// CustomerWithTotalDTO is a customer with a summary of their invoices.
+// TotalPending counts open invoices only; cancelled and void invoices are
+// counted in TotalInvoices and Statuses but are neither paid nor pending.
 type CustomerWithTotalDTO struct {
-	ID            primitive.ObjectID `bson:"_id" json:"id"`
-	Name          string             `json:"name"`
-	Email         string             `json:"email"`
-	ImageURL      string             `json:"imageUrl"`
-	TotalInvoices int64              `json:"totalInvoices"`
-	TotalPending  int64              `json:"totalPending"`
-	TotalPaid     int64              `json:"totalPaid"`
+	ID              primitive.ObjectID     `bson:"_id" json:"id"`
+	Name            string                 `json:"name"`
+	Email           string                 `json:"email"`
+	ImageURL        string                 `json:"imageUrl"`
+	TotalInvoices   int64                  `bson:"totalInvoices" json:"totalInvoices"`
+	TotalPending    int64                  `bson:"totalPending" json:"totalPending"`
+	TotalPaid       int64                  `bson:"totalPaid" json:"totalPaid"`
+	TotalAmount     float64                `bson:"totalAmount" json:"totalAmount"`
+	PaidAmount      float64                `bson:"paidAmount" json:"paidAmount"`
+	Outstanding     float64                `bson:"outstanding" json:"outstanding"`
+	OverdueAmount   float64                `bson:"overdueAmount" json:"overdueAmount"`
+	LastInvoiceDate *time.Time             `bson:"lastInvoiceDate" json:"lastInvoiceDate"`
+	AvgDaysToPay    *float64               `bson:"avgDaysToPay" json:"avgDaysToPay"`
+	AvgDaysLate     *float64               `bson:"avgDaysLate" json:"avgDaysLate"`
+	SlowPayer       bool                   `bson:"slowPayer" json:"slowPayer"`
+	Statuses        []InvoiceStatusSummary `bson:"statuses" json:"statuses"`
+}
+
+type InvoiceStatusSummary struct {
+	Status string  `bson:"_id" json:"status"`
+	Count  int64   `bson:"count" json:"count"`
+	Amount float64 `bson:"amount" json:"amount"`
+}
+
+// CustomerWithTotalSortFields maps the accepted `sort` values of
+// /customers-with-total to document fields.
+var CustomerWithTotalSortFields = map[string]string{
+	"name":            "name",
+	"email":           "email",
+	"totalInvoices":   "totalInvoices",
+	"totalPending":    "totalPending",
+	"totalPaid":       "totalPaid",
+	"totalAmount":     "totalAmount",
+	"paidAmount":      "paidAmount",
+	"outstanding":     "outstanding",
+	"overdueAmount":   "overdueAmount",
+	"lastInvoiceDate": "lastInvoiceDate",
+	"avgDaysToPay":    "avgDaysToPay",
+	"avgDaysLate":     "avgDaysLate",
+}
+
+type CustomerSort struct {
+	Field string
+	Desc  bool
+}
+
+// ParseCustomerSort validates the `sort` and `order` query parameters. An
+// empty field sorts newest customers first.
+func ParseCustomerSort(field string, order string) (CustomerSort, error) {
+	if order != "" && order != "asc" && order != "desc" {
+		return CustomerSort{}, fmt.Errorf("invalid order %q, expected asc or desc", order)
+	}
+	if field == "" {
+		return CustomerSort{Field: "_id", Desc: order != "asc"}, nil
+	}
+	mapped, ok := CustomerWithTotalSortFields[field]
+	if !ok {
+		return CustomerSort{}, fmt.Errorf("invalid sort field %q", field)
+	}
+	return CustomerSort{Field: mapped, Desc: order == "desc"}, nil
 }
 
 type ErrorResponse struct {
@@ -2,9 +2,13 @@ package query
 
 import (
 	"context"
-	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
 	"invoice-api/internal/features/customer/model"
+	customfield_model "invoice-api/internal/features/customfield/model"
+	customfield_query "invoice-api/internal/features/customfield/query"
+	invoice_model "invoice-api/internal/features/invoice/model"
 	"math"
+	"sort"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,22 +17,27 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type DefaultQuery struct{}
+type DefaultQuery struct {
+	OrgID primitive.ObjectID
+}
 
 func (c *DefaultQuery) CollectionName() string {
 	return "customers"
 }
 
 type Query interface {
-	GetItemsByQuery(keyword string, size int64, page int64) (*model.CustomerPage, error)
+	GetItemsByQuery(keyword string, fieldFilter customfield_model.Filter, size int64, page int64) (*model.CustomerPage, error)
 	GetItemByID(id string) (*model.CustomerDTO, error)
 	GetByEmail(email string) (model.CustomerDTO, error)
 	GetTotalItemsByQuery(keyword string) (int64, error)
-	GetItemsWithTotalByQuery(keyword string, size int64, page int64) (*model.CustomerWithTotalPage, error)
+	GetItemsWithTotalByQuery(keyword string, sortBy model.CustomerSort, size int64, page int64) (*model.CustomerWithTotalPage, error)
+	GetDuplicates(threshold float64, limit int) ([]model.DuplicateCandidate, error)
+	GetTagCounts() ([]customfield_model.TagCount, error)
+	GetExport(id string) (*model.CustomerExport, error)
 }
 
-func (c *DefaultQuery) GetItemsByQuery(keyword string, size int64, page int64) (*model.CustomerPage, error) {
-	db := database.GetDatabase()
+func (c *DefaultQuery) GetItemsByQuery(keyword string, fieldFilter customfield_model.Filter, size int64, page int64) (*model.CustomerPage, error) {
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	var filter = bson.M{}
@@ -40,6 +49,18 @@ func (c *DefaultQuery) GetItemsByQuery(keyword string, size int64, page int64) (
 		}
 	}
 
+	var defs []customfield_model.CustomFieldDTO
+	if len(fieldFilter.Fields) > 0 {
+		var err error
+		defs, err = (&customfield_query.DefaultQuery{OrgID: c.OrgID}).GetItemsByQuery(customfield_model.AppliesToCustomer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := customfield_model.ApplyFilter(filter, defs, fieldFilter); err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -82,7 +103,7 @@ func (c *DefaultQuery) GetItemsByQuery(keyword string, size int64, page int64) (
 }
 
 func (c *DefaultQuery) GetItemByID(id string) (*model.CustomerDTO, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -106,11 +127,11 @@ func (c *DefaultQuery) GetItemByID(id string) (*model.CustomerDTO, error) {
 }
 
 func (c *DefaultQuery) GetByEmail(email string) (model.CustomerDTO, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	var user model.CustomerDTO
-	err := collection.FindOne(context.TODO(), bson.M{"email": email}).Decode(&user)
+	err := collection.FindOne(context.TODO(), bson.M{"normalizedEmail": model.NormalizeEmail(email)}).Decode(&user)
 
 	if err != nil {
 		return user, err
@@ -120,7 +141,7 @@ func (c *DefaultQuery) GetByEmail(email string) (model.CustomerDTO, error) {
 }
 
 func (c *DefaultQuery) GetTotalItemsByQuery(keyword string) (int64, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	var filter = bson.M{}
@@ -144,8 +165,11 @@ func (c *DefaultQuery) GetTotalItemsByQuery(keyword string) (int64, error) {
 	return totalItems, nil
 }
 
-func (c *DefaultQuery) GetItemsWithTotalByQuery(keyword string, size int64, page int64) (*model.CustomerWithTotalPage, error) {
-	db := database.GetDatabase()
+// GetItemsWithTotalByQuery returns customers with a summary of their invoices,
+// computed in a single aggregation so that sorting by any summary field and
+// pagination happen in the database.
+func (c *DefaultQuery) GetItemsWithTotalByQuery(keyword string, sortBy model.CustomerSort, size int64, page int64) (*model.CustomerWithTotalPage, error) {
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	var filter = bson.M{}
@@ -156,66 +180,306 @@ func (c *DefaultQuery) GetItemsWithTotalByQuery(keyword string, size int64, page
 			bson.M{"email": bson.M{"$regex": keyword, "$options": "i"}},
 		}
 	}
+	if page < 1 {
+		page = 1
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	totalItems, err := collection.CountDocuments(ctx, filter)
+	cursor, err := collection.Aggregate(ctx, customersWithTotalPipeline(filter, sortBy, size, page, time.Now()))
 	if err != nil {
 		return nil, err
 	}
-	totalPages := int64(math.Ceil(float64(totalItems) / float64(page)))
+	defer cursor.Close(ctx)
 
-	items := make([]*model.CustomerWithTotalDTO, 0, 100)
-	opts := new(options.FindOptions)
-	if size != 0 {
-		if page == 0 {
-			page = 1
+	var result []struct {
+		Metadata []struct {
+			Total int64 `bson:"total"`
+		} `bson:"metadata"`
+		Data []*model.CustomerWithTotalDTO `bson:"data"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	resp := &model.CustomerWithTotalPage{
+		PageSize:   size,
+		PageNumber: page,
+		Data:       make([]*model.CustomerWithTotalDTO, 0),
+	}
+	if len(result) > 0 {
+		if len(result[0].Metadata) > 0 {
+			resp.TotalRows = result[0].Metadata[0].Total
 		}
-		opts.SetSkip(int64((page - 1) * size))
-		opts.SetLimit(int64(size))
-		opts.SetSort(bson.D{{Key: "_id", Value: -1}})
+		if result[0].Data != nil {
+			resp.Data = result[0].Data
+		}
+	}
+	if size > 0 {
+		resp.TotalPages = int64(math.Ceil(float64(resp.TotalRows) / float64(size)))
+	} else if resp.TotalRows > 0 {
+		resp.TotalPages = 1
 	}
 
-	cursor, err := collection.Find(ctx, filter, opts)
+	return resp, nil
+}
+
+// customersWithTotalPipeline joins each customer's invoices, groups them by
+// status and derives the summary fields. Invoices without a due date fall due
+// DefaultPaymentTermsDays after their date; paid invoices without a paidAt use
+// their last update as the payment date. Customers whose paid invoices were
+// settled more than SlowPayerDaysLate days after their due date on average
+// are flagged as slow payers.
+func customersWithTotalPipeline(filter bson.M, sortBy model.CustomerSort, size int64, page int64, now time.Time) mongo.Pipeline {
+	const dayMillis = 24 * 60 * 60 * 1000
+
+	issued := bson.M{"$dateFromString": bson.M{"dateString": "$date", "onError": nil, "onNull": nil}}
+	due := bson.M{"$ifNull": bson.A{
+		bson.M{"$dateFromString": bson.M{"dateString": "$dueDate", "onError": nil, "onNull": nil}},
+		bson.M{"$add": bson.A{"$issued", invoice_model.DefaultPaymentTermsDays * dayMillis}},
+	}}
+	open := bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$status", invoice_model.ClosedStatuses}}}}
+	paid := bson.M{"$eq": bson.A{"$status", "paid"}}
+	daysToPay := bson.M{"$max": bson.A{0, bson.M{"$divide": bson.A{
+		bson.M{"$subtract": bson.A{bson.M{"$ifNull": bson.A{"$paidAt", "$updatedAt"}}, "$issued"}},
+		dayMillis,
+	}}}}
+	daysLate := bson.M{"$max": bson.A{0, bson.M{"$divide": bson.A{
+		bson.M{"$subtract": bson.A{bson.M{"$ifNull": bson.A{"$paidAt", "$updatedAt"}}, bson.M{"$add": bson.A{due, dayMillis}}}},
+		dayMillis,
+	}}}}
+	paidWithDates := bson.M{"$and": bson.A{paid, bson.M{"$ne": bson.A{"$issued", nil}}}}
+	isPaid := bson.M{"$eq": bson.A{"$$s._id", "paid"}}
+	isOpen := bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$$s._id", invoice_model.ClosedStatuses}}}}
+	sumStatuses := func(field string, cond bson.M) bson.M {
+		return bson.M{"$sum": bson.M{"$map": bson.M{
+			"input": bson.M{"$filter": bson.M{"input": "$statuses", "as": "s", "cond": cond}},
+			"as":    "s",
+			"in":    "$$s." + field,
+		}}}
+	}
+
+	sortDir := 1
+	if sortBy.Desc {
+		sortDir = -1
+	}
+	data := bson.A{bson.M{"$sort": bson.D{{Key: sortBy.Field, Value: sortDir}, {Key: "_id", Value: 1}}}}
+	if size > 0 {
+		data = append(data, bson.M{"$skip": (page - 1) * size}, bson.M{"$limit": size})
+	}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": "invoices",
+			"let":  bson.M{"customerId": "$_id", "orgId": "$orgId"},
+			"pipeline": bson.A{
+				// the join is not scoped by the tenant collection, so it
+				// matches the customer's organization itself
+				bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$customerId", "$$customerId"}},
+					bson.M{"$eq": bson.A{"$orgId", "$$orgId"}},
+				}}}},
+				bson.M{"$addFields": bson.M{"issued": issued}},
+				bson.M{"$group": bson.M{
+					"_id":      "$status",
+					"count":    bson.M{"$sum": 1},
+					"amount":   bson.M{"$sum": "$amount"},
+					"lastDate": bson.M{"$max": "$issued"},
+					"overdue": bson.M{"$sum": bson.M{"$cond": bson.A{
+						bson.M{"$and": bson.A{open, bson.M{"$lt": bson.A{due, now}}}}, "$amount", 0,
+					}}},
+					"daysToPay": bson.M{"$sum": bson.M{"$cond": bson.A{paidWithDates, daysToPay, 0}}},
+					"daysLate":  bson.M{"$sum": bson.M{"$cond": bson.A{paidWithDates, daysLate, 0}}},
+					"paidDated": bson.M{"$sum": bson.M{"$cond": bson.A{paidWithDates, 1, 0}}},
+				}},
+				bson.M{"$sort": bson.M{"_id": 1}},
+			},
+			"as": "statuses",
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"totalInvoices":   bson.M{"$sum": "$statuses.count"},
+			"totalAmount":     bson.M{"$sum": "$statuses.amount"},
+			"totalPaid":       sumStatuses("count", isPaid),
+			"paidAmount":      sumStatuses("amount", isPaid),
+			"totalPending":    sumStatuses("count", isOpen),
+			"outstanding":     sumStatuses("amount", isOpen),
+			"overdueAmount":   bson.M{"$sum": "$statuses.overdue"},
+			"lastInvoiceDate": bson.M{"$max": "$statuses.lastDate"},
+			"avgDaysToPay": bson.M{"$let": bson.M{
+				"vars": bson.M{
+					"days": bson.M{"$sum": "$statuses.daysToPay"},
+					"paid": bson.M{"$sum": "$statuses.paidDated"},
+				},
+				"in": bson.M{"$cond": bson.A{
+					bson.M{"$gt": bson.A{"$$paid", 0}},
+					bson.M{"$round": bson.A{bson.M{"$divide": bson.A{"$$days", "$$paid"}}, 1}},
+					nil,
+				}},
+			}},
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"avgDaysLate": bson.M{"$let": bson.M{
+				"vars": bson.M{
+					"days": bson.M{"$sum": "$statuses.daysLate"},
+					"paid": bson.M{"$sum": "$statuses.paidDated"},
+				},
+				"in": bson.M{"$cond": bson.A{
+					bson.M{"$gt": bson.A{"$$paid", 0}},
+					bson.M{"$round": bson.A{bson.M{"$divide": bson.A{"$$days", "$$paid"}}, 1}},
+					nil,
+				}},
+			}},
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"slowPayer": bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{"$avgDaysLate", 0}}, model.SlowPayerDaysLate}},
+		}}},
+		{{Key: "$facet", Value: bson.M{
+			"metadata": bson.A{bson.M{"$count": "total"}},
+			"data":     data,
+		}}},
+	}
+}
+
+// GetDuplicates returns up to limit pairs of customers whose name or email
+// similarity is at least threshold, most similar first. Only the pairs that
+// candidatePairs picks are compared, so that the work grows with the number
+// of customers rather than the number of pairs.
+func (c *DefaultQuery) GetDuplicates(threshold float64, limit int) ([]model.DuplicateCandidate, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"name": 1, "email": 1, "imageUrl": 1})
+	cursor, err := collection.Find(ctx, bson.M{"anonymizedAt": bson.M{"$exists": false}}, opts)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	if err := cursor.All(ctx, &items); err != nil {
+	customers := make([]model.CustomerDTOMin, 0, 100)
+	if err := cursor.All(ctx, &customers); err != nil {
 		return nil, err
 	}
 
-	for i, item := range items {
-		if !item.ID.IsZero() {
-			invoiceCollection := db.Collection("invoices")
-			totalInvoices, err := invoiceCollection.CountDocuments(ctx, bson.M{"customer._id": item.ID})
-			if err != nil {
-				totalInvoices = 0
-			}
-			totalPaid, err := invoiceCollection.CountDocuments(ctx, bson.M{"customer._id": item.ID, "status": "paid"})
-			if err != nil {
-				totalPaid = 0
-			}
-			totalPending, err := invoiceCollection.CountDocuments(ctx, bson.M{"customer._id": item.ID, "status": bson.M{"$ne": "paid"}})
-			if err != nil {
-				totalPaid = 0
-			}
-
-			items[i].TotalInvoices = totalInvoices
-			items[i].TotalPaid = totalPaid
-			items[i].TotalPending = totalPending
+	names := make([]string, len(customers))
+	emails := make([]string, len(customers))
+	for i, customer := range customers {
+		names[i] = normalizeName(customer.Name)
+		emails[i] = model.NormalizeEmail(customer.Email)
+	}
+
+	duplicates := make([]model.DuplicateCandidate, 0)
+	for _, pair := range candidatePairs(names, emails) {
+		i, j := pair[0], pair[1]
+		nameScore := similarity(names[i], names[j])
+		emailScore := similarity(emails[i], emails[j])
+
+		reasons := make([]string, 0, 2)
+		if nameScore >= threshold {
+			reasons = append(reasons, "name")
+		}
+		if emailScore >= threshold {
+			reasons = append(reasons, "email")
 		}
+		if len(reasons) == 0 {
+			continue
+		}
+
+		duplicates = append(duplicates, model.DuplicateCandidate{
+			Customer:  customers[i],
+			Duplicate: customers[j],
+			Score:     math.Max(nameScore, emailScore),
+			Reasons:   reasons,
+		})
 	}
 
-	resp := &model.CustomerWithTotalPage{
-		TotalRows:  int64(len(items)),
-		TotalPages: totalPages,
-		PageNumber: page,
-		PageSize:   page,
-		Data:       items,
+	sort.SliceStable(duplicates, func(a, b int) bool {
+		return duplicates[a].Score > duplicates[b].Score
+	})
+	if len(duplicates) > limit {
+		duplicates = duplicates[:limit]
 	}
 
-	return resp, nil
+	return duplicates, nil
+}
+
+// GetTagCounts returns every tag used on customers with the number of
+// customers carrying it.
+func (c *DefaultQuery) GetTagCounts() ([]customfield_model.TagCount, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$unwind": "$tags"},
+		{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]customfield_model.TagCount, 0, 20)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// GetExport collects the customer together with every invoice and audit record
+// that refers to them.
+func (c *DefaultQuery) GetExport(id string) (*model.CustomerExport, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	export := &model.CustomerExport{
+		Invoices:     make([]bson.M, 0),
+		Merges:       make([]model.CustomerMerge, 0),
+		DataRequests: make([]model.DataRequest, 0),
+	}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&export.Customer); err != nil {
+		return nil, err
+	}
+
+	sortByDate := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	sources := []struct {
+		collection string
+		filter     bson.M
+		into       interface{}
+	}{
+		{"invoices", bson.M{"customerId": objID}, &export.Invoices},
+		{"customer_merges", bson.M{"$or": bson.A{bson.M{"targetId": objID}, bson.M{"sourceId": objID}}}, &export.Merges},
+		{"data_requests", bson.M{"customerId": objID}, &export.DataRequests},
+	}
+	for _, source := range sources {
+		cursor, err := db.Collection(source.collection).Find(ctx, source.filter, sortByDate)
+		if err != nil {
+			return nil, err
+		}
+		err = cursor.All(ctx, source.into)
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return export, nil
 }
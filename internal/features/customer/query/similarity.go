@@ -0,0 +1,173 @@
+package query
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// maxBlockSize is the largest group of candidates compared pair by pair.
+	// Larger groups are sorted and each customer is only compared with the
+	// blockWindow customers after it.
+	maxBlockSize = 200
+	blockWindow  = 20
+)
+
+// companySuffixes are dropped from names before comparing them so that
+// "Acme Inc." and "Acme Corporation" are treated as the same company.
+var companySuffixes = map[string]bool{
+	"inc": true, "incorporated": true, "corp": true, "corporation": true,
+	"co": true, "company": true, "llc": true, "ltd": true, "limited": true,
+	"plc": true, "gmbh": true, "pte": true,
+}
+
+// normalizeName lowercases the name, strips punctuation and company suffixes
+// and collapses whitespace.
+func normalizeName(name string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, name)
+
+	words := make([]string, 0)
+	for _, word := range strings.Fields(cleaned) {
+		if !companySuffixes[word] {
+			words = append(words, word)
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// similarity returns a score between 0 and 1 based on the Levenshtein distance
+// between a and b, where 1 means the strings are identical.
+func similarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// candidatePairs returns the pairs of customers, as indexes into names and
+// emails, that are worth scoring. Rather than comparing every pair, customers
+// are grouped by the sound and the start of their name and by their email
+// domain, and only customers sharing a group are compared.
+func candidatePairs(names []string, emails []string) [][2]int {
+	nameBlocks := map[string][]int{}
+	emailBlocks := map[string][]int{}
+	for i := range names {
+		if words := strings.Fields(names[i]); len(words) > 0 {
+			if key := soundex(words[0]); key != "" {
+				nameBlocks["sound:"+key] = append(nameBlocks["sound:"+key], i)
+			}
+			prefix := []rune(strings.Join(words, ""))
+			if len(prefix) > 3 {
+				prefix = prefix[:3]
+			}
+			nameBlocks["prefix:"+string(prefix)] = append(nameBlocks["prefix:"+string(prefix)], i)
+		}
+		if local, domain, ok := strings.Cut(emails[i], "@"); ok && local != "" && domain != "" {
+			key := domain + "/" + string([]rune(local)[0])
+			emailBlocks[key] = append(emailBlocks[key], i)
+		}
+	}
+
+	seen := map[[2]int]bool{}
+	pairs := make([][2]int, 0)
+	add := func(blocks map[string][]int, values []string) {
+		for _, members := range blocks {
+			window := len(members)
+			if window > maxBlockSize {
+				sort.SliceStable(members, func(a, b int) bool { return values[members[a]] < values[members[b]] })
+				window = blockWindow
+			}
+			for a := 0; a < len(members); a++ {
+				for b := a + 1; b < len(members) && b <= a+window; b++ {
+					pair := [2]int{min(members[a], members[b]), max(members[a], members[b])}
+					if !seen[pair] {
+						seen[pair] = true
+						pairs = append(pairs, pair)
+					}
+				}
+			}
+		}
+	}
+	add(nameBlocks, names)
+	add(emailBlocks, emails)
+
+	sort.Slice(pairs, func(a, b int) bool {
+		if pairs[a][0] != pairs[b][0] {
+			return pairs[a][0] < pairs[b][0]
+		}
+		return pairs[a][1] < pairs[b][1]
+	})
+	return pairs
+}
+
+// soundexCodes holds the Soundex digit of each letter from a to z.
+const soundexCodes = "01230120022455012623010202"
+
+// soundex returns the Soundex code of a lowercase word, under which words that
+// sound alike, like "acme" and "akme", share a key. Words that do not start
+// with a letter from a to z have no code.
+func soundex(word string) string {
+	if word == "" || word[0] < 'a' || word[0] > 'z' {
+		return ""
+	}
+
+	key := []byte{word[0] - 'a' + 'A'}
+	last := soundexCodes[word[0]-'a']
+	for i := 1; i < len(word) && len(key) < 4; i++ {
+		r := word[i]
+		if r < 'a' || r > 'z' {
+			continue
+		}
+		code := soundexCodes[r-'a']
+		if code != '0' && code != last {
+			key = append(key, code)
+		}
+		// h and w do not separate letters with the same code
+		if r != 'h' && r != 'w' {
+			last = code
+		}
+	}
+	for len(key) < 4 {
+		key = append(key, '0')
+	}
+	return string(key)
+}
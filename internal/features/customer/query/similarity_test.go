@@ -0,0 +1,54 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeName(t *testing.T) {
+	require.Equal(t, "acme", normalizeName("ACME, Inc."))
+	require.Equal(t, "simple", normalizeName("Simple Corporation"))
+	require.Equal(t, "john wick", normalizeName("  John   Wick "))
+}
+
+func TestSimilarity(t *testing.T) {
+	require.Equal(t, 1.0, similarity("acme", "acme"))
+	require.Equal(t, 0.0, similarity("", "acme"))
+	require.InDelta(t, 0.75, similarity("acme", "acne"), 0.001)
+	require.Less(t, similarity("acme", "globex"), 0.5)
+}
+
+func TestSoundex(t *testing.T) {
+	require.Equal(t, "A250", soundex("acme"))
+	require.Equal(t, soundex("acme"), soundex("akme"))
+	require.Equal(t, "R163", soundex("robert"))
+	require.Equal(t, soundex("robert"), soundex("rupert"))
+	require.Equal(t, "A261", soundex("ashcraft"))
+	require.Equal(t, "G412", soundex("globex"))
+	require.Equal(t, "", soundex("3m"))
+	require.Equal(t, "", soundex(""))
+}
+
+func TestCandidatePairs(t *testing.T) {
+	names := []string{"acme", "akme", "globex", "initech", "initrode"}
+	emails := []string{"billing@acme.com", "info@akme.com", "ap@globex.com", "ap@globex.com", "x@initrode.com"}
+
+	pairs := candidatePairs(names, emails)
+	// similar sounding names, shared email domains and shared name prefixes
+	require.Equal(t, [][2]int{{0, 1}, {2, 3}, {3, 4}}, pairs)
+}
+
+func TestCandidatePairs_BoundsLargeGroups(t *testing.T) {
+	n := 5000
+	names := make([]string, n)
+	emails := make([]string, n)
+	for i := range names {
+		names[i] = "acme"
+		emails[i] = "a@acme.com"
+	}
+
+	pairs := candidatePairs(names, emails)
+	require.LessOrEqual(t, len(pairs), n*blockWindow)
+	require.Contains(t, pairs, [2]int{0, 1})
+}
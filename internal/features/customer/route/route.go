@@ -2,6 +2,8 @@ package route
 
 import (
 	"invoice-api/internal/features/customer/controller"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -12,11 +14,16 @@ func (c *CustomerRoute) Init(router *fiber.App) {
 	controller := new(controller.CustomerController)
 	customers := router.Group("/customers")
 
-	customers.Post("/", controller.CreateCustomer)
-	customers.Get("/", controller.GetAllCustomers)
-	customers.Get("/:id", controller.GetCustomerByID)
-	customers.Patch("/:id", controller.UpdateCustomer)
-	customers.Delete("/:id", controller.DeleteCustomer)
-	router.Get("/customers-total", controller.GetCustomersCount)
-	router.Get("/customers-with-total", controller.GetCustomersWithTotalByQuery)
+	customers.Post("/", middleware.RequirePermission(user_model.PermCustomerWrite), controller.CreateCustomer)
+	customers.Get("/", middleware.RequirePermission(user_model.PermCustomerRead), controller.GetAllCustomers)
+	customers.Get("/duplicates", middleware.RequirePermission(user_model.PermCustomerRead), controller.GetDuplicateCustomers)
+	customers.Get("/tags", middleware.RequirePermission(user_model.PermCustomerRead), controller.GetCustomerTags)
+	customers.Get("/:id", middleware.RequirePermission(user_model.PermCustomerRead), controller.GetCustomerByID)
+	customers.Patch("/:id", middleware.RequirePermission(user_model.PermCustomerWrite), controller.UpdateCustomer)
+	customers.Delete("/:id", middleware.RequirePermission(user_model.PermCustomerDelete), controller.DeleteCustomer)
+	customers.Post("/:id/merge", middleware.RequirePermission(user_model.PermCustomerDelete), controller.MergeCustomer)
+	customers.Get("/:id/export", middleware.RequirePermission(user_model.PermCustomerPrivacy), controller.ExportCustomer)
+	customers.Post("/:id/anonymize", middleware.RequirePermission(user_model.PermCustomerPrivacy), controller.AnonymizeCustomer)
+	router.Get("/customers-total", middleware.RequirePermission(user_model.PermCustomerRead), controller.GetCustomersCount)
+	router.Get("/customers-with-total", middleware.RequirePermission(user_model.PermCustomerRead), controller.GetCustomersWithTotalByQuery)
 }
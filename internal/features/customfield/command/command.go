@@ -0,0 +1,124 @@
+package command
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
+	"invoice-api/internal/features/customfield/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DefaultCommand struct {
+	OrgID primitive.ObjectID
+}
+
+func (c *DefaultCommand) CollectionName() string {
+	return "custom_fields"
+}
+
+type Command interface {
+	CreateItem(_val *model.CreateCustomField) (*mongo.InsertOneResult, error)
+	UpdateItem(id string, _val *model.UpdateCustomField) (*mongo.UpdateResult, error)
+	DeleteItem(id string) (*mongo.DeleteResult, error)
+}
+
+// EnsureIndexes keeps custom field names unique per organization and entity
+// type.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection("custom_fields")
+
+	if err := database.DropIndex(ctx, collection, "appliesTo_name_unique"); err != nil {
+		return err
+	}
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "appliesTo", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetName("orgId_appliesTo_name_unique").SetUnique(true),
+	})
+	return err
+}
+
+func (c *DefaultCommand) CreateItem(_val *model.CreateCustomField) (*mongo.InsertOneResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	doc := &model.CustomField{
+		Name:      _val.Name,
+		Type:      _val.Type,
+		Options:   _val.Options,
+		Required:  _val.Required,
+		AppliesTo: _val.AppliesTo,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := collection.InsertOne(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *DefaultCommand) UpdateItem(id string, _val *model.UpdateCustomField) (*mongo.UpdateResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	set := bson.M{"updatedAt": time.Now()}
+	if _val.Options != nil {
+		set["options"] = _val.Options
+	}
+	if _val.Required != nil {
+		set["required"] = *_val.Required
+	}
+
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objId}, bson.M{"$set": set})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return result, nil
+}
+
+func (c *DefaultCommand) DeleteItem(id string) (*mongo.DeleteResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": objId})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.DeletedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return res, nil
+}
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"invoice-api/internal/features/customfield/command"
+	"invoice-api/internal/features/customfield/model"
+	"invoice-api/internal/features/customfield/query"
+	"invoice-api/middleware"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type CustomFieldController struct {
+	Command command.Command
+	Query   query.Query
+}
+
+// query returns the injected query, or one scoped to the organization the
+// request was authorized for.
+func (s *CustomFieldController) query(c *fiber.Ctx) query.Query {
+	if s.Query != nil {
+		return s.Query
+	}
+	return &query.DefaultQuery{OrgID: middleware.OrgID(c)}
+}
+
+// command returns the injected command, or one scoped to the organization
+// the request was authorized for.
+func (s *CustomFieldController) command(c *fiber.Ctx) command.Command {
+	if s.Command != nil {
+		return s.Command
+	}
+	return &command.DefaultCommand{OrgID: middleware.OrgID(c)}
+}
+
+func (s *CustomFieldController) CreateCustomField(c *fiber.Ctx) error {
+	payload := new(model.CreateCustomField)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(err.Error())
+	}
+
+	validationErrors := model.ValidateStruct(payload)
+	if validationErrors != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
+	}
+
+	if err := model.ValidateDefinition(payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	resp, err := s.command(c).CreateItem(payload)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "A custom field with this name already exists"})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to create custom field",
+		})
+	}
+
+	return c.Status(201).JSON(resp)
+}
+
+func (s *CustomFieldController) GetAllCustomFields(c *fiber.Ctx) error {
+	items, err := s.query(c).GetItemsByQuery(c.Query("appliesTo"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(items)
+}
+
+func (s *CustomFieldController) GetCustomFieldByID(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	item, err := s.query(c).GetItemByID(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Custom field not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch custom field",
+		})
+	}
+
+	return c.JSON(item)
+}
+
+func (s *CustomFieldController) UpdateCustomField(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	payload := new(model.UpdateCustomField)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(err.Error())
+	}
+
+	res, err := s.command(c).UpdateItem(id, payload)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Custom field not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to update custom field",
+		})
+	}
+
+	if res.ModifiedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Failed to update custom field",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Custom field updated successfully",
+	})
+}
+
+func (s *CustomFieldController) DeleteCustomField(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	res, err := s.command(c).DeleteItem(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Custom field not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to delete custom field",
+		})
+	}
+
+	if res.DeletedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{
+			"error": "Failed to delete custom field",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Custom field deleted successfully",
+	})
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	modelpkg "invoice-api/internal/features/customfield/model"
+)
+
+type mockCommand struct {
+	createRes *mongo.InsertOneResult
+	createErr error
+	updateRes *mongo.UpdateResult
+	updateErr error
+	deleteRes *mongo.DeleteResult
+	deleteErr error
+}
+
+func (m *mockCommand) CreateItem(_val *modelpkg.CreateCustomField) (*mongo.InsertOneResult, error) {
+	return m.createRes, m.createErr
+}
+
+func (m *mockCommand) UpdateItem(id string, _val *modelpkg.UpdateCustomField) (*mongo.UpdateResult, error) {
+	return m.updateRes, m.updateErr
+}
+
+func (m *mockCommand) DeleteItem(id string) (*mongo.DeleteResult, error) {
+	return m.deleteRes, m.deleteErr
+}
+
+type mockQuery struct {
+	items   []modelpkg.CustomFieldDTO
+	itemErr error
+}
+
+func (m *mockQuery) GetItemsByQuery(appliesTo string) ([]modelpkg.CustomFieldDTO, error) {
+	return m.items, nil
+}
+
+func (m *mockQuery) GetItemByID(id string) (*modelpkg.CustomFieldDTO, error) {
+	if m.itemErr != nil {
+		return nil, m.itemErr
+	}
+	return &modelpkg.CustomFieldDTO{}, nil
+}
+
+func TestCreateCustomField_SuccessAndValidation(t *testing.T) {
+	mock := &mockCommand{createRes: &mongo.InsertOneResult{InsertedID: primitive.NewObjectID()}}
+	ctrl := &CustomFieldController{Command: mock}
+
+	cases := []struct {
+		payload map[string]interface{}
+		status  int
+	}{
+		{map[string]interface{}{"name": "poNumber", "type": "string", "appliesTo": "invoice"}, 201},
+		{map[string]interface{}{"name": "tier", "type": "enum", "options": []string{"gold", "silver"}, "appliesTo": "customer"}, 201},
+		{map[string]interface{}{"name": "poNumber", "type": "bool", "appliesTo": "invoice"}, 400},
+		{map[string]interface{}{"name": "poNumber", "type": "string", "appliesTo": "user"}, 400},
+		{map[string]interface{}{"name": "po.number", "type": "string", "appliesTo": "invoice"}, 400},
+		{map[string]interface{}{"name": "tier", "type": "enum", "appliesTo": "customer"}, 400},
+	}
+
+	for _, tc := range cases {
+		app := fiber.New()
+		app.Post("/", ctrl.CreateCustomField)
+		b, _ := json.Marshal(tc.payload)
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		require.Equal(t, tc.status, resp.StatusCode, tc.payload)
+	}
+}
+
+func TestGetCustomFieldByID_NotFound(t *testing.T) {
+	app := fiber.New()
+	ctrl := &CustomFieldController{Query: &mockQuery{itemErr: mongo.ErrNoDocuments}}
+	app.Get("/:id", ctrl.GetCustomFieldByID)
+
+	req := httptest.NewRequest("GET", "/someid", nil)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode)
+}
+
+func TestDeleteCustomField_Success(t *testing.T) {
+	app := fiber.New()
+	ctrl := &CustomFieldController{Command: &mockCommand{deleteRes: &mongo.DeleteResult{DeletedCount: 1}}}
+	app.Delete("/:id", ctrl.DeleteCustomField)
+
+	req := httptest.NewRequest("DELETE", "/someid", nil)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+}
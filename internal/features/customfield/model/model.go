@@ -0,0 +1,320 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var validate = validator.New()
+
+const (
+	AppliesToCustomer = "customer"
+	AppliesToInvoice  = "invoice"
+
+	TypeString = "string"
+	TypeNumber = "number"
+	TypeDate   = "date"
+	TypeEnum   = "enum"
+
+	dateLayout = "2006-01-02"
+)
+
+// fieldName restricts custom field names to something that is safe to use as
+// a MongoDB key and as a query string parameter.
+var fieldName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+
+type CustomField struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	Type      string             `bson:"type" json:"type"`
+	Options   []string           `bson:"options,omitempty" json:"options,omitempty"`
+	Required  bool               `bson:"required" json:"required"`
+	AppliesTo string             `bson:"appliesTo" json:"appliesTo"`
+	CreatedAt time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt,omitempty" json:"updatedAt"`
+}
+
+type CustomFieldDTO struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	Type      string             `bson:"type" json:"type"`
+	Options   []string           `bson:"options" json:"options,omitempty"`
+	Required  bool               `bson:"required" json:"required"`
+	AppliesTo string             `bson:"appliesTo" json:"appliesTo"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt,omitzero"`
+}
+
+type CreateCustomField struct {
+	Name      string   `json:"name" validate:"required"`
+	Type      string   `json:"type" validate:"required,oneof=string number date enum"`
+	Options   []string `json:"options"`
+	Required  bool     `json:"required"`
+	AppliesTo string   `json:"appliesTo" validate:"required,oneof=customer invoice"`
+}
+
+// UpdateCustomField only allows changes that keep existing values valid, so
+// the name, type and target of a field are fixed once created.
+type UpdateCustomField struct {
+	Options  []string `json:"options"`
+	Required *bool    `json:"required"`
+}
+
+type TagCount struct {
+	Tag   string `bson:"_id" json:"tag"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// Filter narrows a customer or invoice listing by tags and custom field
+// values.
+type Filter struct {
+	Tags   []string
+	Fields map[string]string
+}
+
+// ParseFilter reads `tags=a,b` and `cf.<name>=<value>` parameters from a query
+// string.
+func ParseFilter(queries map[string]string) Filter {
+	filter := Filter{Fields: map[string]string{}}
+	for key, value := range queries {
+		if key == "tags" {
+			filter.Tags = NormalizeTags(strings.Split(value, ","))
+			continue
+		}
+		if name, ok := strings.CutPrefix(key, "cf."); ok && name != "" {
+			filter.Fields[name] = value
+		}
+	}
+	return filter
+}
+
+// NormalizeTags lowercases, trims and de-duplicates tags, dropping empty ones.
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// ValueError describes a single invalid custom field value.
+type ValueError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when custom field values do not match the
+// schema.
+type ValidationError struct {
+	Errors []ValueError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Field + ": " + err.Message
+	}
+	return "invalid custom fields: " + strings.Join(messages, "; ")
+}
+
+// ValidateDefinition checks the parts of a new field that the struct tags
+// cannot express.
+func ValidateDefinition(field *CreateCustomField) error {
+	if !fieldName.MatchString(field.Name) {
+		return fmt.Errorf("name must start with a letter and contain only letters, digits and underscores")
+	}
+	if field.Type == TypeEnum && len(field.Options) == 0 {
+		return fmt.Errorf("enum fields require at least one option")
+	}
+	if field.Type != TypeEnum && len(field.Options) > 0 {
+		return fmt.Errorf("options are only allowed on enum fields")
+	}
+	return nil
+}
+
+// ValidateValues checks values against the field definitions and returns them
+// converted to their stored form. A nil value clears an optional field. When
+// requireAll is set every required field must be present, which is the case on
+// create; updates only validate the fields they touch.
+func ValidateValues(defs []CustomFieldDTO, values map[string]interface{}, requireAll bool) (map[string]interface{}, error) {
+	byName := make(map[string]CustomFieldDTO, len(defs))
+	for _, def := range defs {
+		byName[def.Name] = def
+	}
+
+	var errs []ValueError
+	result := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		def, ok := byName[name]
+		if !ok {
+			errs = append(errs, ValueError{Field: name, Message: "unknown custom field"})
+			continue
+		}
+		if value == nil {
+			if def.Required {
+				errs = append(errs, ValueError{Field: name, Message: "is required"})
+				continue
+			}
+			result[name] = nil
+			continue
+		}
+
+		converted, err := convertValue(def, value)
+		if err != nil {
+			errs = append(errs, ValueError{Field: name, Message: err.Error()})
+			continue
+		}
+		result[name] = converted
+	}
+
+	if requireAll {
+		for _, def := range defs {
+			if _, ok := values[def.Name]; def.Required && !ok {
+				errs = append(errs, ValueError{Field: def.Name, Message: "is required"})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		slices.SortFunc(errs, func(a, b ValueError) int { return strings.Compare(a.Field, b.Field) })
+		return nil, &ValidationError{Errors: errs}
+	}
+
+	return result, nil
+}
+
+// UpdateFields turns validated values into the $set and $unset documents of an
+// update, so that fields not mentioned are left untouched.
+func UpdateFields(values map[string]interface{}) (bson.M, bson.M) {
+	set, unset := bson.M{}, bson.M{}
+	for name, value := range values {
+		if value == nil {
+			unset["customFields."+name] = ""
+		} else {
+			set["customFields."+name] = value
+		}
+	}
+	return set, unset
+}
+
+// CreateFields drops cleared values so that new documents only store fields
+// that have a value.
+func CreateFields(values map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		if value != nil {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// ApplyFilter adds tag and custom field conditions to a MongoDB filter. Values
+// from the query string are converted using the field definitions so that
+// number fields match numerically.
+func ApplyFilter(mongoFilter bson.M, defs []CustomFieldDTO, filter Filter) error {
+	if len(filter.Tags) > 0 {
+		mongoFilter["tags"] = bson.M{"$all": filter.Tags}
+	}
+
+	byName := make(map[string]CustomFieldDTO, len(defs))
+	for _, def := range defs {
+		byName[def.Name] = def
+	}
+
+	for name, raw := range filter.Fields {
+		def, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("unknown custom field %q", name)
+		}
+
+		var value interface{} = raw
+		if def.Type == TypeNumber {
+			number, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return fmt.Errorf("custom field %q must be a number", name)
+			}
+			value = number
+		} else {
+			converted, err := convertValue(def, raw)
+			if err != nil {
+				return fmt.Errorf("custom field %q %s", name, err.Error())
+			}
+			value = converted
+		}
+		mongoFilter["customFields."+name] = value
+	}
+
+	return nil
+}
+
+func convertValue(def CustomFieldDTO, value interface{}) (interface{}, error) {
+	switch def.Type {
+	case TypeNumber:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		}
+		return nil, fmt.Errorf("must be a number")
+	case TypeDate:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a date in YYYY-MM-DD format")
+		}
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			return t.Format(dateLayout), nil
+		}
+		if _, err := time.Parse(dateLayout, s); err != nil {
+			return nil, fmt.Errorf("must be a date in YYYY-MM-DD format")
+		}
+		return s, nil
+	case TypeEnum:
+		s, ok := value.(string)
+		if !ok || !slices.Contains(def.Options, s) {
+			return nil, fmt.Errorf("must be one of %s", strings.Join(def.Options, ", "))
+		}
+		return s, nil
+	default:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a string")
+		}
+		return s, nil
+	}
+}
+
+type ErrorResponse struct {
+	Field string `json:"field"`
+	Tag   string `json:"tag"`
+	Value string `json:"value,omitempty"`
+}
+
+func ValidateStruct[T any](payload T) []ErrorResponse {
+	var errors []ErrorResponse
+	err := validate.Struct(payload)
+	if err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			var element ErrorResponse
+			element.Field = err.StructNamespace()
+			element.Tag = err.Tag()
+			element.Value = err.Param()
+			errors = append(errors, element)
+		}
+	}
+	return errors
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var defs = []CustomFieldDTO{
+	{Name: "poNumber", Type: TypeString, Required: true},
+	{Name: "budget", Type: TypeNumber},
+	{Name: "renewal", Type: TypeDate},
+	{Name: "tier", Type: TypeEnum, Options: []string{"gold", "silver"}},
+}
+
+func TestValidateValues(t *testing.T) {
+	values, err := ValidateValues(defs, map[string]interface{}{
+		"poNumber": "PO-1",
+		"budget":   1500.0,
+		"renewal":  "2025-01-31T00:00:00Z",
+		"tier":     "gold",
+	}, true)
+	require.NoError(t, err)
+	require.Equal(t, "2025-01-31", values["renewal"])
+
+	_, err = ValidateValues(defs, map[string]interface{}{
+		"budget":  "lots",
+		"tier":    "bronze",
+		"unknown": 1,
+	}, true)
+	var verr *ValidationError
+	require.ErrorAs(t, err, &verr)
+	require.Equal(t, []string{"budget", "poNumber", "tier", "unknown"}, fieldsOf(verr))
+
+	// updates only validate the fields they touch, but cannot clear required ones
+	values, err = ValidateValues(defs, map[string]interface{}{"tier": nil}, false)
+	require.NoError(t, err)
+	set, unset := UpdateFields(values)
+	require.Empty(t, set)
+	require.Equal(t, bson.M{"customFields.tier": ""}, unset)
+
+	_, err = ValidateValues(defs, map[string]interface{}{"poNumber": nil}, false)
+	require.Error(t, err)
+}
+
+func TestApplyFilter(t *testing.T) {
+	filter := bson.M{}
+	err := ApplyFilter(filter, defs, ParseFilter(map[string]string{"tags": "A,b,a", "cf.budget": "10", "keyword": "x"}))
+	require.NoError(t, err)
+	require.Equal(t, bson.M{"tags": bson.M{"$all": []string{"a", "b"}}, "customFields.budget": 10.0}, filter)
+
+	require.Error(t, ApplyFilter(bson.M{}, defs, Filter{Fields: map[string]string{"budget": "ten"}}))
+	require.Error(t, ApplyFilter(bson.M{}, defs, Filter{Fields: map[string]string{"missing": "x"}}))
+}
+
+func fieldsOf(err *ValidationError) []string {
+	fields := make([]string, len(err.Errors))
+	for i, e := range err.Errors {
+		fields[i] = e.Field
+	}
+	return fields
+}
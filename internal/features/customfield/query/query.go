@@ -0,0 +1,74 @@
+package query
+
+import (
+	"context"
+	"invoice-api/internal/database/tenant"
+	"invoice-api/internal/features/customfield/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DefaultQuery struct {
+	OrgID primitive.ObjectID
+}
+
+func (c *DefaultQuery) CollectionName() string {
+	return "custom_fields"
+}
+
+type Query interface {
+	GetItemsByQuery(appliesTo string) ([]model.CustomFieldDTO, error)
+	GetItemByID(id string) (*model.CustomFieldDTO, error)
+}
+
+// GetItemsByQuery returns the field definitions, optionally limited to those
+// that apply to customers or invoices.
+func (c *DefaultQuery) GetItemsByQuery(appliesTo string) ([]model.CustomFieldDTO, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if appliesTo != "" {
+		filter["appliesTo"] = appliesTo
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "appliesTo", Value: 1}, {Key: "name", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]model.CustomFieldDTO, 0, 20)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (c *DefaultQuery) GetItemByID(id string) (*model.CustomFieldDTO, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var item model.CustomFieldDTO
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&item); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
@@ -0,0 +1,22 @@
+package route
+
+import (
+	"invoice-api/internal/features/customfield/controller"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CustomFieldRoute struct{}
+
+func (c *CustomFieldRoute) Init(router *fiber.App) {
+	controller := new(controller.CustomFieldController)
+	customFields := router.Group("/custom-fields")
+
+	customFields.Post("/", middleware.RequirePermission(user_model.PermCustomFieldManage), controller.CreateCustomField)
+	customFields.Get("/", middleware.RequirePermission(user_model.PermCustomFieldRead), controller.GetAllCustomFields)
+	customFields.Get("/:id", middleware.RequirePermission(user_model.PermCustomFieldRead), controller.GetCustomFieldByID)
+	customFields.Patch("/:id", middleware.RequirePermission(user_model.PermCustomFieldManage), controller.UpdateCustomField)
+	customFields.Delete("/:id", middleware.RequirePermission(user_model.PermCustomFieldManage), controller.DeleteCustomField)
+}
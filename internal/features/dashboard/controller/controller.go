@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"context"
+	"invoice-api/internal/features/dashboard/model"
+	"invoice-api/internal/features/dashboard/query"
+	"invoice-api/middleware"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultSectionTimeout bounds how long the dashboard waits for any one
+// section.
+const DefaultSectionTimeout = 3 * time.Second
+
+type DashboardController struct {
+	Query          query.DashboardQuery
+	SectionTimeout time.Duration
+}
+
+// query returns the injected query, or one scoped to the organization the
+// request was authorized for.
+func (s *DashboardController) query(c *fiber.Ctx) query.DashboardQuery {
+	if s.Query != nil {
+		return s.Query
+	}
+	return &query.DefaultDashboardQuery{OrgID: middleware.OrgID(c)}
+}
+
+// loadSection runs fetch with its own timeout. When the timeout passes first
+// the section is abandoned; fetch's context is cancelled so it can stop.
+func loadSection[T any](timeout time.Duration, fetch func(context.Context) (T, error)) (T, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := fetch(ctx)
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// GetDashboard loads every section concurrently and returns whatever
+// completed; failed sections are listed under `errors`. It only fails when no
+// section could be loaded.
+func (s *DashboardController) GetDashboard(c *fiber.Ctx) error {
+	// the controller is shared by concurrent requests, so the default is
+	// applied to a local rather than written back
+	timeout := s.SectionTimeout
+	if timeout == 0 {
+		timeout = DefaultSectionTimeout
+	}
+	q := s.query(c)
+
+	top := c.QueryInt("top", 5)
+	if top < 1 || top > 50 {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "top must be between 1 and 50",
+		})
+	}
+
+	dashboard := &model.Dashboard{}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		errors = make(map[string]string)
+	)
+	run := func(name string, load func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := load(); err != nil {
+				mu.Lock()
+				errors[name] = err.Error()
+				mu.Unlock()
+			}
+		}()
+	}
+
+	run(model.SectionInvoices, func() (err error) {
+		dashboard.Invoices, err = loadSection(timeout, q.GetInvoiceSummary)
+		return err
+	})
+	run(model.SectionCustomerCount, func() error {
+		count, err := loadSection(timeout, q.GetCustomerCount)
+		if err == nil {
+			dashboard.CustomerCount = &count
+		}
+		return err
+	})
+	run(model.SectionLatestInvoices, func() (err error) {
+		dashboard.LatestInvoices, err = loadSection(timeout, q.GetLatestInvoices)
+		return err
+	})
+	run(model.SectionRevenue, func() (err error) {
+		dashboard.Revenue, err = loadSection(timeout, q.GetRevenue)
+		return err
+	})
+	run(model.SectionTopCustomers, func() (err error) {
+		dashboard.TopCustomers, err = loadSection(timeout, func(ctx context.Context) ([]model.TopCustomer, error) {
+			return q.GetTopCustomers(ctx, top)
+		})
+		return err
+	})
+	wg.Wait()
+
+	if len(errors) > 0 {
+		dashboard.Errors = errors
+	}
+	if len(errors) == 5 {
+		return c.Status(503).JSON(dashboard)
+	}
+
+	return c.JSON(dashboard)
+}
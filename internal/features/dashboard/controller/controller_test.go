@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"invoice-api/internal/features/dashboard/model"
+	invoice_model "invoice-api/internal/features/invoice/model"
+	revenue_model "invoice-api/internal/features/revenue/model"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+)
+
+type mockQuery struct {
+	fail  map[string]bool
+	delay time.Duration
+	top   int
+}
+
+func (m *mockQuery) err(section string) error {
+	if m.fail[section] {
+		return errors.New(section + " failed")
+	}
+	return nil
+}
+
+func (m *mockQuery) GetInvoiceSummary(ctx context.Context) (*model.InvoiceSummary, error) {
+	return &model.InvoiceSummary{Collected: 100, Counts: map[string]int64{"paid": 1}}, m.err(model.SectionInvoices)
+}
+
+func (m *mockQuery) GetCustomerCount(ctx context.Context) (int64, error) {
+	return 3, m.err(model.SectionCustomerCount)
+}
+
+func (m *mockQuery) GetLatestInvoices(ctx context.Context) ([]invoice_model.LatestInvoice, error) {
+	return []invoice_model.LatestInvoice{{Name: "Acme"}}, m.err(model.SectionLatestInvoices)
+}
+
+func (m *mockQuery) GetRevenue(ctx context.Context) (*revenue_model.RevenueSeries, error) {
+	select {
+	case <-time.After(m.delay):
+		return &revenue_model.RevenueSeries{Total: 10}, m.err(model.SectionRevenue)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m *mockQuery) GetTopCustomers(ctx context.Context, limit int) ([]model.TopCustomer, error) {
+	m.top = limit
+	return []model.TopCustomer{{Name: "Acme", Billed: 100}}, m.err(model.SectionTopCustomers)
+}
+
+func TestGetDashboard_AllSections(t *testing.T) {
+	app := fiber.New()
+	q := &mockQuery{}
+	ctrl := &DashboardController{Query: q}
+	app.Get("/dashboard", ctrl.GetDashboard)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/dashboard?top=3", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, 3, q.top)
+
+	var body model.Dashboard
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, 100.0, body.Invoices.Collected)
+	require.Equal(t, int64(3), *body.CustomerCount)
+	require.Equal(t, 10.0, body.Revenue.Total)
+	require.Len(t, body.TopCustomers, 1)
+	require.Nil(t, body.Errors)
+}
+
+func TestGetDashboard_PartialResults(t *testing.T) {
+	app := fiber.New()
+	q := &mockQuery{fail: map[string]bool{model.SectionCustomerCount: true}, delay: time.Second}
+	ctrl := &DashboardController{Query: q, SectionTimeout: 50 * time.Millisecond}
+	app.Get("/dashboard", ctrl.GetDashboard)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/dashboard", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var body model.Dashboard
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.NotNil(t, body.Invoices)
+	require.Nil(t, body.CustomerCount)
+	require.Nil(t, body.Revenue)
+	require.Equal(t, "customerCount failed", body.Errors[model.SectionCustomerCount])
+	require.Equal(t, context.DeadlineExceeded.Error(), body.Errors[model.SectionRevenue])
+}
+
+func TestGetDashboard_AllSectionsFail(t *testing.T) {
+	app := fiber.New()
+	fail := map[string]bool{}
+	for _, s := range []string{model.SectionInvoices, model.SectionCustomerCount, model.SectionLatestInvoices, model.SectionRevenue, model.SectionTopCustomers} {
+		fail[s] = true
+	}
+	ctrl := &DashboardController{Query: &mockQuery{fail: fail}}
+	app.Get("/dashboard", ctrl.GetDashboard)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/dashboard", nil))
+	require.NoError(t, err)
+	require.Equal(t, 503, resp.StatusCode)
+}
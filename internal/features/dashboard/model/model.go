@@ -0,0 +1,46 @@
+package model
+
+import (
+	invoice_model "invoice-api/internal/features/invoice/model"
+	revenue_model "invoice-api/internal/features/revenue/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Dashboard sections, as named in Dashboard.Errors.
+const (
+	SectionInvoices       = "invoices"
+	SectionCustomerCount  = "customerCount"
+	SectionLatestInvoices = "latestInvoices"
+	SectionRevenue        = "revenue"
+	SectionTopCustomers   = "topCustomers"
+)
+
+// InvoiceSummary is what has been collected and what is still pending, with
+// invoice counts by status.
+type InvoiceSummary struct {
+	Collected float64          `json:"collected"`
+	Pending   float64          `json:"pending"`
+	Total     int64            `json:"total"`
+	Counts    map[string]int64 `json:"counts"`
+}
+
+type TopCustomer struct {
+	ID       primitive.ObjectID `bson:"_id" json:"id"`
+	Name     string             `bson:"name" json:"name"`
+	Email    string             `bson:"email" json:"email"`
+	ImageURL string             `bson:"imageUrl" json:"imageUrl"`
+	Billed   float64            `bson:"billed" json:"billed"`
+	Invoices int64              `bson:"invoices" json:"invoices"`
+}
+
+// Dashboard is built from independent sections. A section that failed or
+// timed out is left empty and its error is reported in Errors.
+type Dashboard struct {
+	Invoices       *InvoiceSummary               `json:"invoices"`
+	CustomerCount  *int64                        `json:"customerCount"`
+	LatestInvoices []invoice_model.LatestInvoice `json:"latestInvoices"`
+	Revenue        *revenue_model.RevenueSeries  `json:"revenue"`
+	TopCustomers   []TopCustomer                 `json:"topCustomers"`
+	Errors         map[string]string             `json:"errors,omitempty"`
+}
@@ -0,0 +1,131 @@
+package query
+
+import (
+	"context"
+	"invoice-api/internal/database/tenant"
+	"invoice-api/internal/features/dashboard/model"
+	invoice_model "invoice-api/internal/features/invoice/model"
+	invoice_query "invoice-api/internal/features/invoice/query"
+	revenue_model "invoice-api/internal/features/revenue/model"
+	revenue_query "invoice-api/internal/features/revenue/query"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type DefaultDashboardQuery struct {
+	OrgID primitive.ObjectID
+}
+
+func (c *DefaultDashboardQuery) CollectionName() string {
+	return "invoices"
+}
+
+// DashboardQuery loads each dashboard section on its own so that sections
+// can run concurrently and fail independently.
+type DashboardQuery interface {
+	GetInvoiceSummary(ctx context.Context) (*model.InvoiceSummary, error)
+	GetCustomerCount(ctx context.Context) (int64, error)
+	GetLatestInvoices(ctx context.Context) ([]invoice_model.LatestInvoice, error)
+	GetRevenue(ctx context.Context) (*revenue_model.RevenueSeries, error)
+	GetTopCustomers(ctx context.Context, limit int) ([]model.TopCustomer, error)
+}
+
+// GetInvoiceSummary counts and sums invoices by status. Paid invoices are
+// collected; invoices that are not closed are pending.
+func (c *DefaultDashboardQuery) GetInvoiceSummary(ctx context.Context) (*model.InvoiceSummary, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$status",
+			"count":  bson.M{"$sum": 1},
+			"amount": bson.M{"$sum": "$amount"},
+		}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Status string  `bson:"_id"`
+		Count  int64   `bson:"count"`
+		Amount float64 `bson:"amount"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	summary := &model.InvoiceSummary{Counts: make(map[string]int64)}
+	for _, row := range rows {
+		summary.Counts[row.Status] = row.Count
+		summary.Total += row.Count
+		switch {
+		case row.Status == "paid":
+			summary.Collected += row.Amount
+		case !slices.Contains(invoice_model.ClosedStatuses, row.Status):
+			summary.Pending += row.Amount
+		}
+	}
+
+	return summary, nil
+}
+
+func (c *DefaultDashboardQuery) GetCustomerCount(ctx context.Context) (int64, error) {
+	db := tenant.ForOrg(c.OrgID)
+	return db.Collection("customers").CountDocuments(ctx, bson.M{})
+}
+
+func (c *DefaultDashboardQuery) GetLatestInvoices(ctx context.Context) ([]invoice_model.LatestInvoice, error) {
+	return (&invoice_query.DefaultInvoiceQuery{OrgID: c.OrgID}).GetLatestInvoices()
+}
+
+// GetRevenue returns monthly cash revenue for the last 12 months.
+func (c *DefaultDashboardQuery) GetRevenue(ctx context.Context) (*revenue_model.RevenueSeries, error) {
+	q, err := revenue_model.ParseSeriesQuery("", "", revenue_model.GranularityMonth, "", revenue_model.BasisCash, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return (&revenue_query.DefaultRevenueQuery{OrgID: c.OrgID}).GetSeries(q)
+}
+
+// GetTopCustomers ranks customers by the amount billed to them, leaving out
+// cancelled and void invoices.
+func (c *DefaultDashboardQuery) GetTopCustomers(ctx context.Context, limit int) ([]model.TopCustomer, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": bson.M{"$nin": bson.A{"cancelled", "void"}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$customerId",
+			"name":     bson.M{"$first": "$customer.name"},
+			"email":    bson.M{"$first": "$customer.email"},
+			"imageUrl": bson.M{"$first": "$customer.imageUrl"},
+			"billed":   bson.M{"$sum": "$amount"},
+			"invoices": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "billed", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]model.TopCustomer, 0, limit)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
@@ -0,0 +1,17 @@
+package route
+
+import (
+	"invoice-api/internal/features/dashboard/controller"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type DashboardRoute struct{}
+
+func (s *DashboardRoute) Init(router *fiber.App) {
+	controller := new(controller.DashboardController)
+
+	router.Get("/dashboard", middleware.RequirePermission(user_model.PermReportRead), controller.GetDashboard)
+}
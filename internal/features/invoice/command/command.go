@@ -3,17 +3,22 @@ package command
 import (
 	"context"
 	"errors"
-	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
 	customer_model "invoice-api/internal/features/customer/model"
+	customfield_model "invoice-api/internal/features/customfield/model"
+	customfield_query "invoice-api/internal/features/customfield/query"
 	"invoice-api/internal/features/invoice/model"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type DefaultInvoiceCommand struct{}
+type DefaultInvoiceCommand struct {
+	OrgID primitive.ObjectID
+}
 
 func (c *DefaultInvoiceCommand) CollectionName() string {
 	return "invoices"
@@ -26,7 +31,7 @@ type InvoiceCommand interface {
 }
 
 func (c *DefaultInvoiceCommand) CreateItem(_val *model.CreateInvoice) (*mongo.InsertOneResult, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 	customerCollection := db.Collection("customers")
 
@@ -35,41 +40,198 @@ func (c *DefaultInvoiceCommand) CreateItem(_val *model.CreateInvoice) (*mongo.In
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	customer := customer_model.CustomerDTOMin{}
-	err = customerCollection.FindOne(ctx, bson.M{"_id": customerID}).Decode(&customer)
+	defs, err := (&customfield_query.DefaultQuery{OrgID: c.OrgID}).GetItemsByQuery(customfield_model.AppliesToInvoice)
+	if err != nil {
+		return nil, err
+	}
+	customFields, err := customfield_model.ValidateValues(defs, _val.CustomFields, true)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("customer not found")
-		}
 		return nil, err
 	}
 
+	var schedule []model.RecognitionEntry
+	if _val.ServiceStart != "" {
+		schedule, err = model.RecognitionSchedule(_val.Amount, _val.ServiceStart, _val.ServiceEnd, _val.RecognitionMethod)
+		if err != nil {
+			return nil, err
+		}
+		if _val.RecognitionMethod == "" {
+			_val.RecognitionMethod = model.RecognitionDaily
+		}
+	}
+
+	now := time.Now()
+	var paidAt *time.Time
+	if _val.Status == model.StatusPaid {
+		paidAt = &now
+	}
+
 	doc := &model.Invoice{
-		CustomerID: customerID,
-		Customer:   customer,
-		Status:     _val.Status,
-		Amount:     _val.Amount,
-		Date:       _val.Date,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		CustomerID:          customerID,
+		Status:              _val.Status,
+		Amount:              _val.Amount,
+		Date:                _val.Date,
+		DueDate:             _val.DueDate,
+		ServiceStart:        _val.ServiceStart,
+		ServiceEnd:          _val.ServiceEnd,
+		RecognitionMethod:   _val.RecognitionMethod,
+		RecognitionSchedule: schedule,
+		CustomFields:        customfield_model.CreateFields(customFields),
+		Tags:                customfield_model.NormalizeTags(_val.Tags),
+		PaidAt:              paidAt,
+		CreatedAt:           now,
+		UpdatedAt:           now,
 	}
 
-	res, err := collection.InsertOne(ctx, doc)
+	insert := func(ctx context.Context) (interface{}, error) {
+		var found customer_model.Customer
+		var err error
+		if _val.OverrideCredit {
+			err = customerCollection.FindOne(ctx, bson.M{"_id": customerID}).Decode(&found)
+		} else {
+			found, err = lockCustomer(ctx, customerCollection, customerID)
+		}
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, errors.New("customer not found")
+			}
+			return nil, err
+		}
+
+		if !_val.OverrideCredit {
+			if err := c.checkCredit(ctx, collection, &found, _val.Amount); err != nil {
+				return nil, err
+			}
+		}
+
+		doc.Customer = customer_model.CustomerDTOMin{
+			ID:       found.ID,
+			Name:     found.Name,
+			Email:    found.Email,
+			ImageURL: found.ImageURL,
+		}
+		return collection.InsertOne(ctx, doc)
+	}
+
+	var res interface{}
+	if _val.OverrideCredit {
+		res, err = insert(ctx)
+	} else {
+		res, err = withTransaction(ctx, db, insert)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return res, nil
+	return res.(*mongo.InsertOneResult), nil
+}
+
+// withTransaction runs fn in a transaction, which needs MongoDB to run as a
+// replica set. Invoices that are checked against the customer's credit are
+// written this way: the check locks the customer (see lockCustomer), so of two
+// concurrent invoices for the same customer one is retried after the other
+// commits and is checked against a balance that includes it.
+func withTransaction(ctx context.Context, db *tenant.Database, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	return session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return fn(sc)
+	})
+}
+
+// lockCustomer reads the customer inside a transaction and writes to it, so
+// that another transaction doing the same for the customer conflicts with
+// this one until it commits.
+func lockCustomer(ctx context.Context, customers *tenant.Collection, id primitive.ObjectID) (customer_model.Customer, error) {
+	var customer customer_model.Customer
+	err := customers.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"creditCheckedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&customer)
+	return customer, err
+}
+
+// checkCredit rejects the invoice when the customer is on hold or when the
+// amount would push their outstanding balance over the credit limit.
+func (c *DefaultInvoiceCommand) checkCredit(ctx context.Context, collection *tenant.Collection, customer *customer_model.Customer, amount float64) error {
+	if customer.OnHold {
+		return &model.CreditLimitError{
+			CustomerID:  customer.ID,
+			OnHold:      true,
+			CreditLimit: customer.CreditLimit,
+			Amount:      amount,
+		}
+	}
+	if customer.CreditLimit <= 0 {
+		return nil
+	}
+
+	outstanding, err := OutstandingBalance(ctx, collection, customer.ID)
+	if err != nil {
+		return err
+	}
+
+	available := customer.CreditLimit - outstanding
+	if amount > available {
+		return &model.CreditLimitError{
+			CustomerID:  customer.ID,
+			CreditLimit: customer.CreditLimit,
+			Outstanding: outstanding,
+			Amount:      amount,
+			Available:   available,
+			Shortfall:   amount - available,
+		}
+	}
+
+	return nil
+}
+
+// OutstandingBalance sums the amounts of the customer's invoices that are not
+// yet paid, cancelled or void.
+func OutstandingBalance(ctx context.Context, collection *tenant.Collection, customerID primitive.ObjectID) (float64, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"customerId": customerID,
+			"status":     bson.M{"$nin": model.ClosedStatuses},
+		}},
+		{"$group": bson.M{
+			"_id":   nil,
+			"total": bson.M{"$sum": "$amount"},
+		}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Total float64 `bson:"total"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+
+	return result[0].Total, nil
 }
 
 func (c *DefaultInvoiceCommand) UpdateItem(id string, _val *model.UpdateInvoice) (*mongo.UpdateResult, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	customerID, err := primitive.ObjectIDFromHex(_val.CustomerID)
@@ -77,21 +239,66 @@ func (c *DefaultInvoiceCommand) UpdateItem(id string, _val *model.UpdateInvoice)
 		return nil, err
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"customerID": customerID,
-			"status":     _val.Status,
-			"amount":     _val.Amount,
-			"date":       _val.Date,
-			"updatedAt":  time.Now(),
-		},
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
 	}
 
-	objId, err := primitive.ObjectIDFromHex(id)
+	if _val.OverrideCredit {
+		return c.update(ctx, collection, objId, customerID, id, _val)
+	}
+
+	res, err := withTransaction(ctx, db, func(ctx context.Context) (interface{}, error) {
+		if err := c.checkUpdateCredit(ctx, collection, objId, _val); err != nil {
+			return nil, err
+		}
+		return c.update(ctx, collection, objId, customerID, id, _val)
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	return res.(*mongo.UpdateResult), nil
+}
+
+// update writes the invoice changes of UpdateItem once the credit check, if
+// any, has passed.
+func (c *DefaultInvoiceCommand) update(ctx context.Context, collection *tenant.Collection, objId primitive.ObjectID, customerID primitive.ObjectID, id string, _val *model.UpdateInvoice) (*mongo.UpdateResult, error) {
+	set := bson.M{
+		"customerID": customerID,
+		"status":     _val.Status,
+		"amount":     _val.Amount,
+		"date":       _val.Date,
+		"updatedAt":  time.Now(),
+	}
+	if _val.DueDate != "" {
+		set["dueDate"] = _val.DueDate
+	}
+	if err := c.setRecognition(ctx, collection, id, _val, set); err != nil {
+		return nil, err
+	}
+	if _val.Tags != nil {
+		set["tags"] = customfield_model.NormalizeTags(_val.Tags)
+	}
+	update := bson.M{"$set": set}
+	if len(_val.CustomFields) > 0 {
+		defs, err := (&customfield_query.DefaultQuery{OrgID: c.OrgID}).GetItemsByQuery(customfield_model.AppliesToInvoice)
+		if err != nil {
+			return nil, err
+		}
+		values, err := customfield_model.ValidateValues(defs, _val.CustomFields, false)
+		if err != nil {
+			return nil, err
+		}
+		fieldSet, fieldUnset := customfield_model.UpdateFields(values)
+		for key, value := range fieldSet {
+			set[key] = value
+		}
+		if len(fieldUnset) > 0 {
+			update["$unset"] = fieldUnset
+		}
+	}
+
 	result, err := collection.UpdateOne(ctx, bson.M{"_id": objId}, update)
 	if err != nil {
 		return nil, err
@@ -101,12 +308,98 @@ func (c *DefaultInvoiceCommand) UpdateItem(id string, _val *model.UpdateInvoice)
 		return nil, mongo.ErrNoDocuments
 	}
 
+	if err := c.recordPayment(ctx, collection, objId, _val.Status); err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
 
+// checkUpdateCredit applies checkCredit to what the update adds to the
+// customer's outstanding balance, so that an invoice cannot be created small
+// and raised or reopened afterwards to get around the credit limit. Like
+// CreateItem it locks the customer when the update adds to the balance.
+func (c *DefaultInvoiceCommand) checkUpdateCredit(ctx context.Context, collection *tenant.Collection, id primitive.ObjectID, _val *model.UpdateInvoice) error {
+	var current model.Invoice
+	opts := options.FindOne().SetProjection(bson.M{"customerId": 1, "amount": 1, "status": 1})
+	if err := collection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&current); err != nil {
+		return err
+	}
+
+	increase := current.BalanceIncrease(_val.Amount, _val.Status)
+	if increase <= 0 {
+		return nil
+	}
+
+	customer, err := lockCustomer(ctx, tenant.ForOrg(c.OrgID).Collection("customers"), current.CustomerID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+
+	return c.checkCredit(ctx, collection, &customer, increase)
+}
+
+// recordPayment stamps paidAt the first time an invoice is marked paid and
+// clears it when the invoice is no longer paid, so paidAt survives later
+// edits of a paid invoice.
+func (c *DefaultInvoiceCommand) recordPayment(ctx context.Context, collection *tenant.Collection, id primitive.ObjectID, status string) error {
+	if status == model.StatusPaid {
+		_, err := collection.UpdateOne(ctx,
+			bson.M{"_id": id, "paidAt": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"paidAt": time.Now()}})
+		return err
+	}
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id, "paidAt": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"paidAt": ""}})
+	return err
+}
+
+// setRecognition adds the service period and a recognition schedule for the
+// new amount to set. Without a service period in the update, the invoice's
+// current one is kept and its schedule rebuilt.
+func (c *DefaultInvoiceCommand) setRecognition(ctx context.Context, collection *tenant.Collection, id string, _val *model.UpdateInvoice, set bson.M) error {
+	start, end, method := _val.ServiceStart, _val.ServiceEnd, _val.RecognitionMethod
+	if start == "" {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return err
+		}
+		var current model.Invoice
+		opts := options.FindOne().SetProjection(bson.M{"serviceStart": 1, "serviceEnd": 1, "recognitionMethod": 1})
+		if err := collection.FindOne(ctx, bson.M{"_id": objID}, opts).Decode(&current); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil
+			}
+			return err
+		}
+		if current.ServiceStart == "" {
+			return nil
+		}
+		start, end = current.ServiceStart, current.ServiceEnd
+		if method == "" {
+			method = current.RecognitionMethod
+		}
+	}
+	if method == "" {
+		method = model.RecognitionDaily
+	}
+
+	schedule, err := model.RecognitionSchedule(_val.Amount, start, end, method)
+	if err != nil {
+		return err
+	}
+	set["serviceStart"] = start
+	set["serviceEnd"] = end
+	set["recognitionMethod"] = method
+	set["recognitionSchedule"] = schedule
+	return nil
+}
+
 // DeleteInvoice executes the delete user command
 func (c *DefaultInvoiceCommand) DeleteItem(id string) (*mongo.DeleteResult, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
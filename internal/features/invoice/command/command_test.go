@@ -0,0 +1,59 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"invoice-api/internal/database/dbtest"
+	"invoice-api/internal/database/tenant"
+	customer_model "invoice-api/internal/features/customer/model"
+	"invoice-api/internal/features/invoice/model"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCreateItem_ConcurrentCreditCheck(t *testing.T) {
+	dbtest.Start(t)
+
+	orgID := primitive.NewObjectID()
+	customer := customer_model.Customer{ID: primitive.NewObjectID(), Name: "Acme", CreditLimit: 100}
+	_, err := tenant.ForOrg(orgID).Collection("customers").InsertOne(context.Background(), customer)
+	require.NoError(t, err)
+
+	cmd := &DefaultInvoiceCommand{OrgID: orgID}
+
+	// 5 invoices of 40 at once: only 2 fit into a limit of 100
+	const invoices = 5
+	var wg sync.WaitGroup
+	errs := make([]error, invoices)
+	for i := 0; i < invoices; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = cmd.CreateItem(&model.CreateInvoice{
+				CustomerID: customer.ID.Hex(),
+				Amount:     40,
+				Date:       "2025-03-01",
+				Status:     "pending",
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	created, refused := 0, 0
+	for _, err := range errs {
+		var creditErr *model.CreditLimitError
+		switch {
+		case err == nil:
+			created++
+		case errors.As(err, &creditErr):
+			refused++
+		default:
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, 2, created)
+	require.Equal(t, 3, refused)
+}
@@ -1,9 +1,13 @@
 package controller
 
 import (
+	"errors"
+	customfield_model "invoice-api/internal/features/customfield/model"
 	"invoice-api/internal/features/invoice/command"
 	"invoice-api/internal/features/invoice/model"
 	"invoice-api/internal/features/invoice/query"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
 	"strconv"
 	"strings"
 
@@ -16,11 +20,25 @@ type InvoiceController struct {
 	Query   query.InvoiceQuery
 }
 
-func (s *InvoiceController) CreateInvoice(c *fiber.Ctx) error {
-	if s.Command == nil {
-		s.Command = &command.DefaultInvoiceCommand{}
+// query returns the injected query, or one scoped to the organization the
+// request was authorized for.
+func (s *InvoiceController) query(c *fiber.Ctx) query.InvoiceQuery {
+	if s.Query != nil {
+		return s.Query
 	}
+	return &query.DefaultInvoiceQuery{OrgID: middleware.OrgID(c)}
+}
+
+// command returns the injected command, or one scoped to the organization
+// the request was authorized for.
+func (s *InvoiceController) command(c *fiber.Ctx) command.InvoiceCommand {
+	if s.Command != nil {
+		return s.Command
+	}
+	return &command.DefaultInvoiceCommand{OrgID: middleware.OrgID(c)}
+}
 
+func (s *InvoiceController) CreateInvoice(c *fiber.Ctx) error {
 	payload := new(model.CreateInvoice)
 	if err := c.BodyParser(payload); err != nil {
 		return c.Status(400).JSON(err.Error())
@@ -31,8 +49,23 @@ func (s *InvoiceController) CreateInvoice(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
 	}
 
-	resp, err := s.Command.CreateItem(payload)
+	if payload.OverrideCredit && !middleware.HasPermission(c, user_model.PermInvoiceOverride) {
+		return middleware.Forbidden(c, user_model.PermInvoiceOverride)
+	}
+
+	resp, err := s.command(c).CreateItem(payload)
 	if err != nil {
+		var creditErr *model.CreditLimitError
+		if errors.As(err, &creditErr) {
+			return c.Status(422).JSON(fiber.Map{
+				"error":   "Failed to create invoice. " + creditErr.Error(),
+				"details": creditErr,
+			})
+		}
+		var fieldErr *customfield_model.ValidationError
+		if errors.As(err, &fieldErr) {
+			return c.Status(400).JSON(fiber.Map{"status": "error", "errors": fieldErr.Errors})
+		}
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Failed to create invoice. " + err.Error(),
 		})
@@ -42,9 +75,6 @@ func (s *InvoiceController) CreateInvoice(c *fiber.Ctx) error {
 }
 
 func (s *InvoiceController) GetAllInvoices(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultInvoiceQuery{}
-	}
 	keyword := c.Query("keyword")
 	status := c.Query("status")
 	sizeStr := c.Query("size")
@@ -57,7 +87,8 @@ func (s *InvoiceController) GetAllInvoices(c *fiber.Ctx) error {
 	if err != nil {
 		page = 1
 	}
-	items, err := s.Query.GetItemsByQuery(keyword, status, size, page)
+	fieldFilter := customfield_model.ParseFilter(c.Queries())
+	items, err := s.query(c).GetItemsByQuery(keyword, status, fieldFilter, size, page)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": err.Error(),
@@ -68,12 +99,9 @@ func (s *InvoiceController) GetAllInvoices(c *fiber.Ctx) error {
 }
 
 func (s *InvoiceController) GetInvoiceByID(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultInvoiceQuery{}
-	}
 	id := c.Params("id")
 
-	item, err := s.Query.GetItemByID(id)
+	item, err := s.query(c).GetItemByID(id)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
@@ -89,9 +117,6 @@ func (s *InvoiceController) GetInvoiceByID(c *fiber.Ctx) error {
 }
 
 func (s *InvoiceController) UpdateInvoice(c *fiber.Ctx) error {
-	if s.Command == nil {
-		s.Command = &command.DefaultInvoiceCommand{}
-	}
 	id := c.Params("id")
 
 	payload := new(model.UpdateInvoice)
@@ -103,13 +128,36 @@ func (s *InvoiceController) UpdateInvoice(c *fiber.Ctx) error {
 	if validationErrors != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
 	}
-	res, err := s.Command.UpdateItem(id, payload)
+
+	if (payload.Status == "void" || payload.Status == "cancelled") && !middleware.HasPermission(c, user_model.PermInvoiceVoid) {
+		return middleware.Forbidden(c, user_model.PermInvoiceVoid)
+	}
+	if payload.OverrideCredit && !middleware.HasPermission(c, user_model.PermInvoiceOverride) {
+		return middleware.Forbidden(c, user_model.PermInvoiceOverride)
+	}
+
+	res, err := s.command(c).UpdateItem(id, payload)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
 				"error": "Invoice not found",
 			})
 		}
+		var creditErr *model.CreditLimitError
+		if errors.As(err, &creditErr) {
+			return c.Status(422).JSON(fiber.Map{
+				"error":   "Failed to update invoice. " + creditErr.Error(),
+				"details": creditErr,
+			})
+		}
+		var fieldErr *customfield_model.ValidationError
+		if errors.As(err, &fieldErr) {
+			return c.Status(400).JSON(fiber.Map{"status": "error", "errors": fieldErr.Errors})
+		}
+		var periodErr *model.ServicePeriodError
+		if errors.As(err, &periodErr) {
+			return c.Status(400).JSON(fiber.Map{"status": "error", "message": periodErr.Error()})
+		}
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Failed to update invoice",
 		})
@@ -127,12 +175,9 @@ func (s *InvoiceController) UpdateInvoice(c *fiber.Ctx) error {
 }
 
 func (s *InvoiceController) DeleteInvoice(c *fiber.Ctx) error {
-	if s.Command == nil {
-		s.Command = &command.DefaultInvoiceCommand{}
-	}
 	id := c.Params("id")
 
-	res, err := s.Command.DeleteItem(id)
+	res, err := s.command(c).DeleteItem(id)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
@@ -156,10 +201,7 @@ func (s *InvoiceController) DeleteInvoice(c *fiber.Ctx) error {
 }
 
 func (s *InvoiceController) GetLatestInvoices(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultInvoiceQuery{}
-	}
-	res, err := s.Query.GetLatestInvoices()
+	res, err := s.query(c).GetLatestInvoices()
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Failed to fetch latest invoices",
@@ -170,9 +212,6 @@ func (s *InvoiceController) GetLatestInvoices(c *fiber.Ctx) error {
 }
 
 func (s *InvoiceController) GetTotalInvoices(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultInvoiceQuery{}
-	}
 	keyword := c.Query("keyword")
 	status := c.Query("status")
 	validStatus := map[string]bool{"pending": true, "paid": true, "cancelled": true, "void": true}
@@ -184,7 +223,7 @@ func (s *InvoiceController) GetTotalInvoices(c *fiber.Ctx) error {
 			})
 		}
 	}
-	items, err := s.Query.GetTotalItemsByQuery(keyword, status)
+	items, err := s.query(c).GetTotalItemsByQuery(keyword, status)
 	if err != nil {
 		return c.JSON(0)
 	}
@@ -192,6 +231,17 @@ func (s *InvoiceController) GetTotalInvoices(c *fiber.Ctx) error {
 	return c.JSON(items)
 }
 
+func (s *InvoiceController) GetInvoiceTags(c *fiber.Ctx) error {
+	items, err := s.query(c).GetTagCounts()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(items)
+}
+
 // func (s *InvoiceController) GetCustomerInvoices(c *fiber.Ctx) error {
 // 	s.Query = &query.DefaultInvoiceQuery{}
 // 	keyword := c.Query("keyword")
@@ -7,7 +7,9 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	customfield_model "invoice-api/internal/features/customfield/model"
 	"invoice-api/internal/features/invoice/model"
+	user_model "invoice-api/internal/features/user/model"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/require"
@@ -20,7 +22,7 @@ type mockQuery struct{
     getTotal func(keyword string, status string) (int64, error)
 }
 
-func (m *mockQuery) GetItemsByQuery(keyword string, status string, size int64, page int64) (*model.InvoicePage, error) {
+func (m *mockQuery) GetItemsByQuery(keyword string, status string, fieldFilter customfield_model.Filter, size int64, page int64) (*model.InvoicePage, error) {
     return &model.InvoicePage{}, nil
 }
 func (m *mockQuery) GetItemByID(id string) (*model.InvoiceDTO, error) {
@@ -35,10 +37,14 @@ func (m *mockQuery) GetTotalItemsByQuery(keyword string, status string) (int64,
 func (m *mockQuery) GetCustomersInvoices(keyword string) ([]model.InvoiceCustomers, error) {
     return nil, nil
 }
+func (m *mockQuery) GetTagCounts() ([]customfield_model.TagCount, error) {
+    return []customfield_model.TagCount{}, nil
+}
 
 type mockCommand struct{
 	createRes *mongo.InsertOneResult
 	createErr error
+    create func(val *model.CreateInvoice) (*mongo.InsertOneResult, error)
     update func(id string, val *model.UpdateInvoice) (*mongo.UpdateResult, error)
     del func(id string) (*mongo.DeleteResult, error)
 }
@@ -48,6 +54,9 @@ func (m *mockCommand) CreateCustomer(_val *model.CreateInvoice) (*mongo.InsertOn
 }
 
 func (m *mockCommand) CreateItem(_val *model.CreateInvoice) (*mongo.InsertOneResult, error) {
+    if m.create != nil {
+        return m.create(_val)
+    }
     return &mongo.InsertOneResult{InsertedID: primitive.NewObjectID()}, nil
 }
 func (m *mockCommand) UpdateItem(id string, _val *model.UpdateInvoice) (*mongo.UpdateResult, error) {
@@ -190,3 +199,91 @@ func TestGetTotalInvoices_InvalidStatus(t *testing.T) {
     if err != nil { t.Fatalf("request failed: %v", err) }
     if resp.StatusCode != 400 { t.Fatalf("expected 400 got %d", resp.StatusCode) }
 }
+
+func TestCreateInvoice_CreditLimitExceeded(t *testing.T) {
+    app := fiber.New()
+    ctrl := &InvoiceController{Command: &mockCommand{create: func(val *model.CreateInvoice) (*mongo.InsertOneResult, error) {
+        return nil, &model.CreditLimitError{CreditLimit: 1000, Outstanding: 900, Amount: val.Amount, Available: 100, Shortfall: val.Amount - 100}
+    }}}
+    app.Post("/invoices", ctrl.CreateInvoice)
+
+    body := bytes.NewReader([]byte(`{"customerId":"507f1f77bcf86cd799439011","amount":250,"date":"2024-06-01"}`))
+    r, _ := http.NewRequest("POST", "/invoices", body)
+    r.Header.Set("Content-Type", "application/json")
+    resp, err := app.Test(r)
+    if err != nil { t.Fatalf("request failed: %v", err) }
+    if resp.StatusCode != 422 { t.Fatalf("expected 422 got %d", resp.StatusCode) }
+
+    var got struct {
+        Details model.CreditLimitError `json:"details"`
+    }
+    json.NewDecoder(resp.Body).Decode(&got)
+    if got.Details.Shortfall != 150 { t.Fatalf("expected shortfall 150 got %v", got.Details.Shortfall) }
+}
+
+func TestUpdateInvoice_CreditLimitExceeded(t *testing.T) {
+    app := fiber.New()
+    ctrl := &InvoiceController{Command: &mockCommand{update: func(id string, val *model.UpdateInvoice) (*mongo.UpdateResult, error) {
+        if val.OverrideCredit {
+            return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+        }
+        return nil, &model.CreditLimitError{OnHold: true, Amount: 249}
+    }}}
+    app.Put("/invoices/:id", withRole(user_model.RoleAccountant), ctrl.UpdateInvoice)
+    send := func(body string) *http.Response {
+        r, _ := http.NewRequest("PUT", "/invoices/507f1f77bcf86cd799439011", bytes.NewReader([]byte(body)))
+        r.Header.Set("Content-Type", "application/json")
+        resp, err := app.Test(r)
+        if err != nil { t.Fatalf("request failed: %v", err) }
+        return resp
+    }
+
+    resp := send(`{"customerId":"507f1f77bcf86cd799439011","amount":250,"date":"2024-06-01"}`)
+    if resp.StatusCode != 422 { t.Fatalf("expected 422 got %d", resp.StatusCode) }
+    var got struct {
+        Details model.CreditLimitError `json:"details"`
+    }
+    json.NewDecoder(resp.Body).Decode(&got)
+    if !got.Details.OnHold { t.Fatalf("expected the customer to be on hold") }
+
+    resp = send(`{"customerId":"507f1f77bcf86cd799439011","amount":250,"date":"2024-06-01","overrideCredit":true}`)
+    if resp.StatusCode != 200 { t.Fatalf("expected 200 got %d", resp.StatusCode) }
+}
+
+func withRole(role string) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        c.Locals("user", user_model.UserDTO{Role: role})
+        return c.Next()
+    }
+}
+
+func TestInvoicePermissions_OverrideCreditAndVoid(t *testing.T) {
+    ctrl := &InvoiceController{Command: &mockCommand{update: func(id string, val *model.UpdateInvoice) (*mongo.UpdateResult, error) {
+        return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+    }}}
+    send := func(role string, method string, body string) int {
+        app := fiber.New()
+        app.Post("/invoices", withRole(role), ctrl.CreateInvoice)
+        app.Patch("/invoices/:id", withRole(role), ctrl.UpdateInvoice)
+        path := "/invoices"
+        if method == "PATCH" {
+            path = "/invoices/507f1f77bcf86cd799439011"
+        }
+        req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+        req.Header.Set("Content-Type", "application/json")
+        resp, err := app.Test(req)
+        require.NoError(t, err)
+        return resp.StatusCode
+    }
+
+    override := `{"customerId":"507f1f77bcf86cd799439011","amount":100,"date":"2024-06-01","overrideCredit":true}`
+    require.Equal(t, 403, send(user_model.RoleSales, "POST", override))
+    require.Equal(t, 201, send(user_model.RoleAccountant, "POST", override))
+    require.Equal(t, 403, send(user_model.RoleSales, "PATCH", override))
+    require.Equal(t, 200, send(user_model.RoleAccountant, "PATCH", override))
+
+    void := `{"customerId":"507f1f77bcf86cd799439011","amount":100,"date":"2024-06-01","status":"void"}`
+    require.Equal(t, 403, send(user_model.RoleSales, "PATCH", void))
+    require.Equal(t, 200, send(user_model.RoleAccountant, "PATCH", void))
+    require.Equal(t, 200, send(user_model.RoleSales, "PATCH", `{"customerId":"507f1f77bcf86cd799439011","amount":100,"date":"2024-06-01","status":"paid"}`))
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"fmt"
+	"slices"
 	"time"
 
 	customer_model "invoice-api/internal/features/customer/model"
@@ -11,26 +13,57 @@ import (
 
 var validate = validator.New()
 
+// ClosedStatuses are the invoice statuses that no longer count towards a
+// customer's outstanding balance.
+var ClosedStatuses = []string{"paid", "cancelled", "void"}
+
+// StatusPaid is the status of an invoice that has been paid. The time it
+// became paid is recorded in PaidAt.
+const StatusPaid = "paid"
+
+// DefaultPaymentTermsDays is used to work out when an invoice without a
+// DueDate falls due.
+const DefaultPaymentTermsDays = 30
+
+// Invoice is a bill issued to a customer. When ServiceStart and ServiceEnd
+// are set the invoice pays for that period and RecognitionSchedule spreads its
+// amount over it as revenue.
 type Invoice struct {
-	ID         primitive.ObjectID            `json:"id" bson:"_id,omitempty"`
-	CustomerID primitive.ObjectID            `json:"customerId" bson:"customerId"`
-	Customer   customer_model.CustomerDTOMin `json:"customer" bson:"customer"`
-	Amount     float64                       `json:"amount" bson:"amount"`
-	Date       string                        `json:"date" bson:"date"`
-	Status     string                        `json:"status" bson:"status"`
-	CreatedAt  time.Time                     `bson:"createdAt,omitempty" json:"createdAt"`
-	UpdatedAt  time.Time                     `bson:"updatedAt,omitempty" json:"updatedAt"`
+	ID                  primitive.ObjectID            `json:"id" bson:"_id,omitempty"`
+	CustomerID          primitive.ObjectID            `json:"customerId" bson:"customerId"`
+	Customer            customer_model.CustomerDTOMin `json:"customer" bson:"customer"`
+	Amount              float64                       `json:"amount" bson:"amount"`
+	Date                string                        `json:"date" bson:"date"`
+	DueDate             string                        `json:"dueDate,omitempty" bson:"dueDate,omitempty"`
+	Status              string                        `json:"status" bson:"status"`
+	ServiceStart        string                        `json:"serviceStart,omitempty" bson:"serviceStart,omitempty"`
+	ServiceEnd          string                        `json:"serviceEnd,omitempty" bson:"serviceEnd,omitempty"`
+	RecognitionMethod   string                        `json:"recognitionMethod,omitempty" bson:"recognitionMethod,omitempty"`
+	RecognitionSchedule []RecognitionEntry            `json:"recognitionSchedule,omitempty" bson:"recognitionSchedule,omitempty"`
+	CustomFields        map[string]interface{}        `json:"customFields,omitempty" bson:"customFields,omitempty"`
+	Tags                []string                      `json:"tags,omitempty" bson:"tags,omitempty"`
+	PaidAt              *time.Time                    `json:"paidAt,omitempty" bson:"paidAt,omitempty"`
+	CreatedAt           time.Time                     `bson:"createdAt,omitempty" json:"createdAt"`
+	UpdatedAt           time.Time                     `bson:"updatedAt,omitempty" json:"updatedAt"`
 }
 
 type InvoiceDTO struct {
-	ID         primitive.ObjectID            `bson:"_id" json:"id"`
-	CustomerID primitive.ObjectID            `json:"customerId,omitzero" bson:"customerId"`
-	Customer   customer_model.CustomerDTOMin `json:"customer"`
-	Amount     float64                       `json:"amount"`
-	Date       string                        `json:"date"`
-	Status     string                        `json:"status"`
-	CreatedAt  time.Time                     `json:"createdAt" bson:"createdAt"`
-	UpdatedAt  time.Time                     `json:"updatedAt,omitzero" bson:"updatedAt"`
+	ID                  primitive.ObjectID            `bson:"_id" json:"id"`
+	CustomerID          primitive.ObjectID            `json:"customerId,omitzero" bson:"customerId"`
+	Customer            customer_model.CustomerDTOMin `json:"customer"`
+	Amount              float64                       `json:"amount"`
+	Date                string                        `json:"date"`
+	DueDate             string                        `json:"dueDate,omitempty" bson:"dueDate"`
+	Status              string                        `json:"status"`
+	ServiceStart        string                        `json:"serviceStart,omitempty" bson:"serviceStart"`
+	ServiceEnd          string                        `json:"serviceEnd,omitempty" bson:"serviceEnd"`
+	RecognitionMethod   string                        `json:"recognitionMethod,omitempty" bson:"recognitionMethod"`
+	RecognitionSchedule []RecognitionEntry            `json:"recognitionSchedule,omitempty" bson:"recognitionSchedule"`
+	CustomFields        map[string]interface{}        `json:"customFields,omitempty" bson:"customFields"`
+	Tags                []string                      `json:"tags,omitempty" bson:"tags"`
+	PaidAt              *time.Time                    `json:"paidAt,omitempty" bson:"paidAt"`
+	CreatedAt           time.Time                     `json:"createdAt" bson:"createdAt"`
+	UpdatedAt           time.Time                     `json:"updatedAt,omitzero" bson:"updatedAt"`
 }
 
 type LatestInvoice struct {
@@ -42,17 +75,74 @@ type LatestInvoice struct {
 }
 
 type CreateInvoice struct {
-	CustomerID string  `json:"customerId" validate:"required"`
-	Amount     float64 `json:"amount" validate:"required"`
-	Date       string  `json:"date" validate:"required"`
-	Status     string  `json:"status"`
+	CustomerID        string                 `json:"customerId" validate:"required"`
+	Amount            float64                `json:"amount" validate:"required"`
+	Date              string                 `json:"date" validate:"required"`
+	DueDate           string                 `json:"dueDate"`
+	Status            string                 `json:"status"`
+	ServiceStart      string                 `json:"serviceStart" validate:"required_with=ServiceEnd"`
+	ServiceEnd        string                 `json:"serviceEnd" validate:"required_with=ServiceStart"`
+	RecognitionMethod string                 `json:"recognitionMethod" validate:"omitempty,oneof=daily monthly"`
+	CustomFields      map[string]interface{} `json:"customFields"`
+	Tags              []string               `json:"tags"`
+	// OverrideCredit bills the customer even when they are on hold or the
+	// invoice would take them over their credit limit.
+	OverrideCredit bool `json:"overrideCredit"`
+}
+
+// CreditLimitError is returned by CreateItem and UpdateItem when the customer
+// cannot be billed without an override.
+type CreditLimitError struct {
+	CustomerID  primitive.ObjectID `json:"customerId"`
+	OnHold      bool               `json:"onHold"`
+	CreditLimit float64            `json:"creditLimit"`
+	Outstanding float64            `json:"outstanding"`
+	Amount      float64            `json:"amount"`
+	Available   float64            `json:"available"`
+	Shortfall   float64            `json:"shortfall"`
+}
+
+func (e *CreditLimitError) Error() string {
+	if e.OnHold {
+		return "customer is on hold"
+	}
+	return fmt.Sprintf("invoice of %.2f exceeds available credit of %.2f by %.2f", e.Amount, e.Available, e.Shortfall)
 }
 
 type UpdateInvoice struct {
-	CustomerID string  `json:"customerId"`
-	Amount     float64 `json:"amount"`
-	Date       string  `json:"date"`
-	Status     string  `json:"status"`
+	CustomerID        string                 `json:"customerId"`
+	Amount            float64                `json:"amount"`
+	Date              string                 `json:"date"`
+	DueDate           string                 `json:"dueDate"`
+	Status            string                 `json:"status"`
+	ServiceStart      string                 `json:"serviceStart" validate:"required_with=ServiceEnd"`
+	ServiceEnd        string                 `json:"serviceEnd" validate:"required_with=ServiceStart"`
+	RecognitionMethod string                 `json:"recognitionMethod" validate:"omitempty,oneof=daily monthly"`
+	CustomFields      map[string]interface{} `json:"customFields"`
+	Tags              []string               `json:"tags"`
+	// OverrideCredit raises or reopens the invoice even when the customer is
+	// on hold or it would take them over their credit limit.
+	OverrideCredit bool `json:"overrideCredit"`
+}
+
+// IsClosed reports whether an invoice with the status no longer counts
+// towards its customer's outstanding balance.
+func IsClosed(status string) bool {
+	return slices.Contains(ClosedStatuses, status)
+}
+
+// BalanceIncrease is how much updating the invoice to the amount and status
+// adds to its customer's outstanding balance. Raising the amount of an open
+// invoice adds the difference and reopening a closed one adds the whole
+// amount.
+func (i Invoice) BalanceIncrease(amount float64, status string) float64 {
+	if IsClosed(status) {
+		return 0
+	}
+	if IsClosed(i.Status) {
+		return amount
+	}
+	return amount - i.Amount
 }
 
 type InvoicePage struct {
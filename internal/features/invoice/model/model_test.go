@@ -0,0 +1,23 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBalanceIncrease(t *testing.T) {
+	pending := Invoice{Amount: 1, Status: "pending"}
+	paid := Invoice{Amount: 100, Status: StatusPaid}
+
+	// raising an open invoice adds the difference
+	require.Equal(t, 99.0, pending.BalanceIncrease(100, "pending"))
+	require.Equal(t, 99.0, pending.BalanceIncrease(100, ""))
+	require.Equal(t, -0.5, pending.BalanceIncrease(0.5, "pending"))
+	// reopening a closed invoice adds the whole amount
+	require.Equal(t, 100.0, paid.BalanceIncrease(100, "pending"))
+	require.Equal(t, 500.0, Invoice{Amount: 100, Status: "void"}.BalanceIncrease(500, "pending"))
+	// closing an invoice or keeping it closed adds nothing
+	require.Equal(t, 0.0, pending.BalanceIncrease(1000, "cancelled"))
+	require.Equal(t, 0.0, paid.BalanceIncrease(1000, StatusPaid))
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// An invoice with a service period is recognized as revenue over that period
+// instead of on its date, either in proportion to the days of each month or in
+// equal monthly parts.
+const (
+	RecognitionDaily   = "daily"
+	RecognitionMonthly = "monthly"
+)
+
+// RecognitionEntry is the part of an invoice's amount recognized as revenue in
+// one month (YYYY-MM).
+type RecognitionEntry struct {
+	Period string  `bson:"period" json:"period"`
+	Amount float64 `bson:"amount" json:"amount"`
+}
+
+// ServicePeriodError is returned when an invoice's service period or
+// recognition method is invalid.
+type ServicePeriodError struct {
+	Message string `json:"message"`
+}
+
+func (e *ServicePeriodError) Error() string {
+	return "invalid service period: " + e.Message
+}
+
+// RecognitionSchedule spreads amount straight-line over the service period
+// from start to end (YYYY-MM-DD, both inclusive). Entries are rounded to cents
+// and the last one absorbs the rounding difference so they add up to amount.
+func RecognitionSchedule(amount float64, start string, end string, method string) ([]RecognitionEntry, error) {
+	from, err := time.Parse(time.DateOnly, start)
+	if err != nil {
+		return nil, &ServicePeriodError{Message: fmt.Sprintf("serviceStart %q is not a YYYY-MM-DD date", start)}
+	}
+	to, err := time.Parse(time.DateOnly, end)
+	if err != nil {
+		return nil, &ServicePeriodError{Message: fmt.Sprintf("serviceEnd %q is not a YYYY-MM-DD date", end)}
+	}
+	if to.Before(from) {
+		return nil, &ServicePeriodError{Message: "serviceEnd is before serviceStart"}
+	}
+	if method == "" {
+		method = RecognitionDaily
+	}
+	if method != RecognitionDaily && method != RecognitionMonthly {
+		return nil, &ServicePeriodError{Message: fmt.Sprintf("recognitionMethod %q must be daily or monthly", method)}
+	}
+
+	totalDays := to.Sub(from).Hours()/24 + 1
+	entries := make([]RecognitionEntry, 0)
+	weights := make([]float64, 0)
+	for month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(to); month = month.AddDate(0, 1, 0) {
+		entries = append(entries, RecognitionEntry{Period: month.Format("2006-01")})
+		if method == RecognitionMonthly {
+			weights = append(weights, 1)
+			continue
+		}
+		first, last := month, month.AddDate(0, 1, -1)
+		if first.Before(from) {
+			first = from
+		}
+		if last.After(to) {
+			last = to
+		}
+		weights = append(weights, (last.Sub(first).Hours()/24+1)/totalDays)
+	}
+	if method == RecognitionMonthly {
+		for i := range weights {
+			weights[i] = 1 / float64(len(weights))
+		}
+	}
+
+	remaining := amount
+	for i := range entries {
+		if i == len(entries)-1 {
+			entries[i].Amount = math.Round(remaining*100) / 100
+			break
+		}
+		entries[i].Amount = math.Round(amount*weights[i]*100) / 100
+		remaining -= entries[i].Amount
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecognitionSchedule_Daily(t *testing.T) {
+	entries, err := RecognitionSchedule(1200, "2025-01-16", "2025-03-15", RecognitionDaily)
+	require.NoError(t, err)
+	require.Equal(t, []RecognitionEntry{
+		{Period: "2025-01", Amount: 325.42},
+		{Period: "2025-02", Amount: 569.49},
+		{Period: "2025-03", Amount: 305.09},
+	}, entries)
+}
+
+func TestRecognitionSchedule_MonthlyAddsUp(t *testing.T) {
+	entries, err := RecognitionSchedule(1000, "2025-01-01", "2025-12-31", RecognitionMonthly)
+	require.NoError(t, err)
+	require.Len(t, entries, 12)
+	require.Equal(t, 83.33, entries[0].Amount)
+	require.Equal(t, 83.37, entries[11].Amount)
+}
+
+func TestRecognitionSchedule_Invalid(t *testing.T) {
+	for _, tc := range [][3]string{
+		{"2025-02-01", "2025-01-01", ""},
+		{"01/01/2025", "2025-01-31", ""},
+		{"2025-01-01", "2025-01-31", "weekly"},
+	} {
+		_, err := RecognitionSchedule(100, tc[0], tc[1], tc[2])
+		var periodErr *ServicePeriodError
+		require.ErrorAs(t, err, &periodErr)
+	}
+}
@@ -2,7 +2,9 @@ package query
 
 import (
 	"context"
-	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
+	customfield_model "invoice-api/internal/features/customfield/model"
+	customfield_query "invoice-api/internal/features/customfield/query"
 	"invoice-api/internal/features/invoice/model"
 	"log"
 	"math"
@@ -14,7 +16,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type DefaultInvoiceQuery struct{}
+type DefaultInvoiceQuery struct {
+	OrgID primitive.ObjectID
+}
 
 func (c *DefaultInvoiceQuery) CollectionName() string {
 	return "invoices"
@@ -22,15 +26,16 @@ func (c *DefaultInvoiceQuery) CollectionName() string {
 
 //go:generate mockgen -destination=../mocks/query/mock_invoice_query.go -package=query invoice-api/internal/features/invoice/query InvoiceQuery
 type InvoiceQuery interface {
-	GetItemsByQuery(keyword string, status string, size int64, page int64) (*model.InvoicePage, error)
+	GetItemsByQuery(keyword string, status string, fieldFilter customfield_model.Filter, size int64, page int64) (*model.InvoicePage, error)
 	GetItemByID(id string) (*model.InvoiceDTO, error)
 	GetLatestInvoices() ([]model.LatestInvoice, error)
 	GetTotalItemsByQuery(keyword string, status string) (int64, error)
 	GetCustomersInvoices(keyword string) ([]model.InvoiceCustomers, error)
+	GetTagCounts() ([]customfield_model.TagCount, error)
 }
 
-func (c *DefaultInvoiceQuery) GetItemsByQuery(keyword string, status string, size int64, page int64) (*model.InvoicePage, error) {
-	db := database.GetDatabase()
+func (c *DefaultInvoiceQuery) GetItemsByQuery(keyword string, status string, fieldFilter customfield_model.Filter, size int64, page int64) (*model.InvoicePage, error) {
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 	// customerCollection := db.Collection("customers")
 
@@ -46,6 +51,18 @@ func (c *DefaultInvoiceQuery) GetItemsByQuery(keyword string, status string, siz
 		filter["status"] = status
 	}
 
+	var defs []customfield_model.CustomFieldDTO
+	if len(fieldFilter.Fields) > 0 {
+		var err error
+		defs, err = (&customfield_query.DefaultQuery{OrgID: c.OrgID}).GetItemsByQuery(customfield_model.AppliesToInvoice)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := customfield_model.ApplyFilter(filter, defs, fieldFilter); err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -122,7 +139,7 @@ func (c *DefaultInvoiceQuery) GetItemsByQuery(keyword string, status string, siz
 }
 
 func (c *DefaultInvoiceQuery) GetItemByID(id string) (*model.InvoiceDTO, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	objID, err := primitive.ObjectIDFromHex(id)
@@ -143,7 +160,7 @@ func (c *DefaultInvoiceQuery) GetItemByID(id string) (*model.InvoiceDTO, error)
 }
 
 func (c *DefaultInvoiceQuery) GetLatestInvoices() ([]model.LatestInvoice, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -157,11 +174,18 @@ func (c *DefaultInvoiceQuery) GetLatestInvoices() ([]model.LatestInvoice, error)
 			"$limit": 5,
 		},
 		{
+			// the join is not scoped by the tenant collection, so it
+			// matches the invoice's organization itself
 			"$lookup": bson.M{
-				"from":         "customers",
-				"localField":   "customerId",
-				"foreignField": "_id",
-				"as":           "customer",
+				"from": "customers",
+				"let":  bson.M{"customerId": "$customerId", "orgId": "$orgId"},
+				"pipeline": bson.A{
+					bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+						bson.M{"$eq": bson.A{"$_id", "$$customerId"}},
+						bson.M{"$eq": bson.A{"$orgId", "$$orgId"}},
+					}}}},
+				},
+				"as": "customer",
 			},
 		},
 		{
@@ -196,7 +220,7 @@ func (c *DefaultInvoiceQuery) GetLatestInvoices() ([]model.LatestInvoice, error)
 }
 
 func (c *DefaultInvoiceQuery) GetTotalItemsByQuery(keyword string, status string) (int64, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	var filter = bson.M{}
@@ -225,7 +249,7 @@ func (c *DefaultInvoiceQuery) GetTotalItemsByQuery(keyword string, status string
 }
 
 func (c *DefaultInvoiceQuery) GetCustomersInvoices(keyword string) ([]model.InvoiceCustomers, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -277,4 +301,31 @@ func (c *DefaultInvoiceQuery) GetCustomersInvoices(keyword string) ([]model.Invo
 	return totals, nil
 }
 
+// GetTagCounts returns every tag used on invoices with the number of invoices
+// carrying it.
+func (c *DefaultInvoiceQuery) GetTagCounts() ([]customfield_model.TagCount, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
+	pipeline := []bson.M{
+		{"$unwind": "$tags"},
+		{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]customfield_model.TagCount, 0, 20)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
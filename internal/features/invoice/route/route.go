@@ -2,6 +2,8 @@ package route
 
 import (
 	"invoice-api/internal/features/invoice/controller"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -12,11 +14,12 @@ func (c *InvoiceRoute) Init(router *fiber.App) {
 	controller := new(controller.InvoiceController)
 	invoices := router.Group("/invoices")
 
-	invoices.Post("/", controller.CreateInvoice)
-	invoices.Get("/", controller.GetAllInvoices)
-	invoices.Get("/latest", controller.GetLatestInvoices)
-	invoices.Get("/:id", controller.GetInvoiceByID)
-	invoices.Patch("/:id", controller.UpdateInvoice)
-	invoices.Delete("/:id", controller.DeleteInvoice)
-	router.Get("/invoices-total", controller.GetTotalInvoices)
+	invoices.Post("/", middleware.RequirePermission(user_model.PermInvoiceWrite), controller.CreateInvoice)
+	invoices.Get("/", middleware.RequirePermission(user_model.PermInvoiceRead), controller.GetAllInvoices)
+	invoices.Get("/latest", middleware.RequirePermission(user_model.PermInvoiceRead), controller.GetLatestInvoices)
+	invoices.Get("/tags", middleware.RequirePermission(user_model.PermInvoiceRead), controller.GetInvoiceTags)
+	invoices.Get("/:id", middleware.RequirePermission(user_model.PermInvoiceRead), controller.GetInvoiceByID)
+	invoices.Patch("/:id", middleware.RequirePermission(user_model.PermInvoiceWrite), controller.UpdateInvoice)
+	invoices.Delete("/:id", middleware.RequirePermission(user_model.PermInvoiceDelete), controller.DeleteInvoice)
+	router.Get("/invoices-total", middleware.RequirePermission(user_model.PermInvoiceRead), controller.GetTotalInvoices)
 }
\ No newline at end of file
@@ -0,0 +1,396 @@
+package command
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
+	"invoice-api/internal/features/org/model"
+	user_model "invoice-api/internal/features/user/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultCommand manages the members of OrgID. CreateOrganization and the
+// answers to invitations are the exception: they act for the user on an
+// organization they are not yet a member of.
+type DefaultCommand struct {
+	OrgID primitive.ObjectID
+}
+
+func (c *DefaultCommand) CollectionName() string {
+	return "memberships"
+}
+
+type Command interface {
+	CreateOrganization(name string, ownerID primitive.ObjectID) (*model.Organization, error)
+	AddMember(userID primitive.ObjectID, role string) (*mongo.InsertOneResult, error)
+	UpdateMemberRole(userID primitive.ObjectID, role string) (*mongo.UpdateResult, error)
+	RemoveMember(userID primitive.ObjectID) (*mongo.DeleteResult, error)
+	SetTwoFactorRequired(required bool) (*mongo.UpdateResult, error)
+	InviteMember(email string, role string, invitedBy primitive.ObjectID) (*model.Invitation, error)
+	AcceptInvitation(id primitive.ObjectID, userID primitive.ObjectID, email string) (*model.Membership, error)
+	DeclineInvitation(id primitive.ObjectID, email string) (*mongo.DeleteResult, error)
+}
+
+// EnsureIndexes allows one membership per user and organization and one
+// invitation per email address and organization, and lets MongoDB remove
+// invitations once they expire.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("memberships").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "userId", Value: 1}},
+			Options: options.Index().SetName("orgId_userId_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: 1}},
+			Options: options.Index().SetName("userId_createdAt"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("invitations").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "email", Value: 1}},
+			Options: options.Index().SetName("orgId_email_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("email"),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// EnsureOrganizations moves data from before organizations existed into the
+// Default organization. Users without a membership join it with the role
+// they had, and documents without an orgId are assigned to it. It must run
+// before the other index setups, whose unique indexes include orgId.
+func EnsureOrganizations(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection("users")
+	memberships := db.Collection("memberships")
+
+	cursor, err := users.Aggregate(ctx, []bson.M{
+		{"$lookup": bson.M{
+			"from":         "memberships",
+			"localField":   "_id",
+			"foreignField": "userId",
+			"as":           "memberships",
+		}},
+		{"$match": bson.M{"memberships": bson.M{"$size": 0}}},
+		{"$sort": bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}},
+		{"$project": bson.M{"_id": 1, "role": 1}},
+	})
+	if err != nil {
+		return err
+	}
+	var orphans []struct {
+		ID   primitive.ObjectID `bson:"_id"`
+		Role string             `bson:"role"`
+	}
+	if err := cursor.All(ctx, &orphans); err != nil {
+		return err
+	}
+
+	pending := len(orphans) > 0
+	for _, name := range model.TenantCollections {
+		if pending {
+			break
+		}
+		count, err := db.Collection(name).CountDocuments(ctx,
+			bson.M{tenant.OrgField: bson.M{"$exists": false}}, options.Count().SetLimit(1))
+		if err != nil {
+			return err
+		}
+		pending = count > 0
+	}
+	if !pending {
+		return nil
+	}
+
+	orgID, err := defaultOrganization(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	owners, err := memberships.CountDocuments(ctx, bson.M{"orgId": orgID, "role": user_model.RoleOwner})
+	if err != nil {
+		return err
+	}
+	for _, orphan := range orphans {
+		role := orphan.Role
+		if role == "" {
+			role = user_model.RoleViewer
+			if owners == 0 {
+				role = user_model.RoleOwner
+			}
+		}
+		if role == user_model.RoleOwner {
+			owners++
+		}
+		if _, err := memberships.InsertOne(ctx, model.Membership{
+			OrgID:     orgID,
+			UserID:    orphan.ID,
+			Role:      role,
+			CreatedAt: time.Now(),
+		}); err != nil {
+			return err
+		}
+	}
+	// Roles now live on memberships.
+	if _, err := users.UpdateMany(ctx, bson.M{"role": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"role": ""}}); err != nil {
+		return err
+	}
+
+	for _, name := range model.TenantCollections {
+		if _, err := db.Collection(name).UpdateMany(ctx,
+			bson.M{tenant.OrgField: bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{tenant.OrgField: orgID}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// defaultOrganization returns the Default organization, creating it the
+// first time.
+func defaultOrganization(ctx context.Context, db *mongo.Database) (primitive.ObjectID, error) {
+	collection := db.Collection("organizations")
+
+	var org model.Organization
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+	err := collection.FindOne(ctx, bson.M{"name": model.DefaultName}, opts).Decode(&org)
+	if err == nil {
+		return org.ID, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return primitive.NilObjectID, err
+	}
+
+	res, err := collection.InsertOne(ctx, model.Organization{
+		Name:      model.DefaultName,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return res.InsertedID.(primitive.ObjectID), nil
+}
+
+// CreateOrganization starts an organization with ownerID as its owner.
+func (c *DefaultCommand) CreateOrganization(name string, ownerID primitive.ObjectID) (*model.Organization, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("organizations")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	org := &model.Organization{
+		Name:      name,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	res, err := collection.InsertOne(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	org.ID = res.InsertedID.(primitive.ObjectID)
+
+	if _, err := (&DefaultCommand{OrgID: org.ID}).AddMember(ownerID, user_model.RoleOwner); err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+func (c *DefaultCommand) AddMember(userID primitive.ObjectID, role string) (*mongo.InsertOneResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if role == "" {
+		role = user_model.RoleViewer
+	}
+
+	return collection.InsertOne(ctx, model.Membership{
+		UserID:    userID,
+		Role:      role,
+		CreatedAt: time.Now(),
+	})
+}
+
+func (c *DefaultCommand) UpdateMemberRole(userID primitive.ObjectID, role string) (*mongo.UpdateResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := collection.UpdateOne(ctx, bson.M{"userId": userID}, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return res, nil
+}
+
+func (c *DefaultCommand) RemoveMember(userID primitive.ObjectID) (*mongo.DeleteResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := collection.DeleteOne(ctx, bson.M{"userId": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.DeletedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return res, nil
+}
+
+// SetTwoFactorRequired turns enforcement of two-factor authentication for
+// the organization's members on or off.
+func (c *DefaultCommand) SetTwoFactorRequired(required bool) (*mongo.UpdateResult, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("organizations")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"requireTwoFactor": required,
+			"updatedAt":        time.Now(),
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": c.OrgID}, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return result, nil
+}
+
+// InviteMember invites the user with the email address to the organization
+// with the role, which defaults to viewer. An earlier invitation of the
+// address is replaced and starts over.
+func (c *DefaultCommand) InviteMember(email string, role string, invitedBy primitive.ObjectID) (*model.Invitation, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection("invitations")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if role == "" {
+		role = user_model.RoleViewer
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"role":      role,
+			"invitedBy": invitedBy,
+			"expiresAt": now.Add(model.InvitationTTL),
+			"createdAt": now,
+		},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var item model.Invitation
+	err := collection.FindOneAndUpdate(ctx, bson.M{"email": user_model.NormalizeEmail(email)}, update, opts).Decode(&item)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// AcceptInvitation makes the user a member of the organization that invited
+// them, with the role of the invitation. Only the user signed in with the
+// invited email address can accept it, and only until it expires.
+func (c *DefaultCommand) AcceptInvitation(id primitive.ObjectID, userID primitive.ObjectID, email string) (*model.Membership, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("invitations")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":       id,
+		"email":     user_model.NormalizeEmail(email),
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+	var invitation model.Invitation
+	if err := collection.FindOne(ctx, filter).Decode(&invitation); err != nil {
+		return nil, err
+	}
+
+	res, addErr := (&DefaultCommand{OrgID: invitation.OrgID}).AddMember(userID, invitation.Role)
+	if addErr != nil && !mongo.IsDuplicateKeyError(addErr) {
+		return nil, addErr
+	}
+	// Users who are already members have no use for the invitation either.
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": invitation.ID}); err != nil {
+		return nil, err
+	}
+	if addErr != nil {
+		return nil, addErr
+	}
+
+	membership := &model.Membership{
+		OrgID:     invitation.OrgID,
+		UserID:    userID,
+		Role:      invitation.Role,
+		CreatedAt: time.Now(),
+	}
+	membership.ID, _ = res.InsertedID.(primitive.ObjectID)
+
+	return membership, nil
+}
+
+// DeclineInvitation discards an invitation sent to the email address.
+func (c *DefaultCommand) DeclineInvitation(id primitive.ObjectID, email string) (*mongo.DeleteResult, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("invitations")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": id, "email": user_model.NormalizeEmail(email)})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.DeletedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return res, nil
+}
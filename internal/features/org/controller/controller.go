@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"invoice-api/internal/features/org/command"
+	"invoice-api/internal/features/org/model"
+	"invoice-api/internal/features/org/query"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/internal/mailer"
+	"invoice-api/middleware"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type OrgController struct {
+	Command command.Command
+	Query   query.Query
+	Mailer  mailer.Mailer
+}
+
+func (s *OrgController) command(c *fiber.Ctx) command.Command {
+	if s.Command != nil {
+		return s.Command
+	}
+	return &command.DefaultCommand{OrgID: middleware.OrgID(c)}
+}
+
+func (s *OrgController) query() query.Query {
+	if s.Query == nil {
+		s.Query = &query.DefaultQuery{}
+	}
+	return s.Query
+}
+
+// GetOrganizations lists the organizations the authenticated user belongs to.
+func (s *OrgController) GetOrganizations(c *fiber.Ctx) error {
+	user, _ := middleware.CurrentUser(c)
+
+	orgs, err := s.query().GetOrganizations(user.ID)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch organizations",
+		})
+	}
+
+	return c.JSON(orgs)
+}
+
+// CreateOrganization starts an organization owned by the authenticated user.
+func (s *OrgController) CreateOrganization(c *fiber.Ctx) error {
+	payload := new(model.CreateOrganization)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(err.Error())
+	}
+
+	validationErrors := model.ValidateStruct(payload)
+	if validationErrors != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
+	}
+
+	user, _ := middleware.CurrentUser(c)
+	org, err := s.command(c).CreateOrganization(payload.Name, user.ID)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to create organization",
+		})
+	}
+
+	return c.Status(201).JSON(org)
+}
+
+// InviteMember invites a user to the current organization by email. Whoever
+// signs in with the address has to accept the invitation to become a member,
+// so the response is the same whether or not the address belongs to a user.
+func (s *OrgController) InviteMember(c *fiber.Ctx) error {
+	payload := new(model.AddMember)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(err.Error())
+	}
+
+	validationErrors := model.ValidateStruct(payload)
+	if validationErrors != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
+	}
+
+	current, _ := middleware.CurrentUser(c)
+	if payload.Role == user_model.RoleOwner && current.Role != user_model.RoleOwner {
+		return c.Status(403).JSON(fiber.Map{"status": "fail", "message": "Only an owner can grant the owner role"})
+	}
+
+	invitation, err := s.command(c).InviteMember(payload.Email, payload.Role, current.ID)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to invite member",
+		})
+	}
+
+	if err := s.sendInvitation(current, invitation); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "The invitation was created, but its email could not be sent"})
+	}
+
+	return c.Status(202).JSON(fiber.Map{"status": "success", "message": "An invitation has been sent to the email address"})
+}
+
+// sendInvitation emails the invited address a link to the invitations of the
+// app on APP_URL.
+func (s *OrgController) sendInvitation(from user_model.UserDTO, invitation *model.Invitation) error {
+	if s.Mailer == nil {
+		s.Mailer = mailer.New()
+	}
+
+	org, err := s.query().GetOrganization(invitation.OrgID)
+	if err != nil {
+		return err
+	}
+
+	baseURL := os.Getenv("APP_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:3001"
+	}
+	link := strings.TrimSuffix(baseURL, "/") + "/invitations"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return s.Mailer.Send(ctx, mailer.Message{
+		To:      []string{invitation.Email},
+		Subject: "You have been invited to " + org.Name,
+		Text: fmt.Sprintf("%s %s invited you to join %s as %s. Sign in with this email address to accept or decline the invitation:\n\n%s\n\nThe invitation expires in 7 days.\n",
+			from.FirstName, from.LastName, org.Name, invitation.Role, link),
+	})
+}
+
+// GetInvitations lists the pending invitations of the authenticated user.
+func (s *OrgController) GetInvitations(c *fiber.Ctx) error {
+	user, _ := middleware.CurrentUser(c)
+
+	invitations, err := s.query().GetInvitations(user.Email)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch invitations",
+		})
+	}
+
+	return c.JSON(invitations)
+}
+
+// AcceptInvitation makes the authenticated user a member of the organization
+// that invited them. They can then switch to it.
+func (s *OrgController) AcceptInvitation(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": "Invalid invitation ID"})
+	}
+
+	user, _ := middleware.CurrentUser(c)
+	membership, err := s.command(c).AcceptInvitation(id, user.ID, user.Email)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Invitation not found",
+			})
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "You are already a member of this organization"})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to accept invitation",
+		})
+	}
+
+	return c.Status(201).JSON(membership)
+}
+
+// DeclineInvitation discards an invitation of the authenticated user.
+func (s *OrgController) DeclineInvitation(c *fiber.Ctx) error {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "fail", "message": "Invalid invitation ID"})
+	}
+
+	user, _ := middleware.CurrentUser(c)
+	if _, err := s.command(c).DeclineInvitation(id, user.Email); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Invitation not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to decline invitation",
+		})
+	}
+
+	return c.JSON(fiber.Map{"status": "success"})
+}
+
+// SetTwoFactorPolicy requires, or stops requiring, two-factor authentication
+// for the members of the current organization. Members who signed in without
+// it can then only enroll until they sign in again with a code. Admins
+// enforcing it must have signed in with two-factor authentication
+// themselves, so that they are not locked out.
+func (s *OrgController) SetTwoFactorPolicy(c *fiber.Ctx) error {
+	payload := new(model.TwoFactorPolicy)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(err.Error())
+	}
+
+	if token, _ := middleware.CurrentToken(c); payload.Required && !token.TwoFactor {
+		return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "Sign in with two-factor authentication before requiring it"})
+	}
+
+	if _, err := s.command(c).SetTwoFactorRequired(payload.Required); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Organization not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to update organization",
+		})
+	}
+
+	return c.JSON(fiber.Map{"status": "success", "requireTwoFactor": payload.Required})
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"invoice-api/internal/features/org/model"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/internal/mailer"
+)
+
+// mockCommand keeps invitations in memory and accepts them like the
+// database command: only for the invited address, and once.
+type mockCommand struct {
+	invitations map[primitive.ObjectID]model.Invitation
+	members     map[primitive.ObjectID]string
+}
+
+func newMockCommand() *mockCommand {
+	return &mockCommand{
+		invitations: map[primitive.ObjectID]model.Invitation{},
+		members:     map[primitive.ObjectID]string{},
+	}
+}
+
+func (m *mockCommand) CreateOrganization(name string, ownerID primitive.ObjectID) (*model.Organization, error) {
+	return &model.Organization{ID: primitive.NewObjectID(), Name: name}, nil
+}
+
+func (m *mockCommand) AddMember(userID primitive.ObjectID, role string) (*mongo.InsertOneResult, error) {
+	m.members[userID] = role
+	return &mongo.InsertOneResult{InsertedID: primitive.NewObjectID()}, nil
+}
+
+func (m *mockCommand) UpdateMemberRole(userID primitive.ObjectID, role string) (*mongo.UpdateResult, error) {
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (m *mockCommand) RemoveMember(userID primitive.ObjectID) (*mongo.DeleteResult, error) {
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+
+func (m *mockCommand) SetTwoFactorRequired(required bool) (*mongo.UpdateResult, error) {
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (m *mockCommand) InviteMember(email string, role string, invitedBy primitive.ObjectID) (*model.Invitation, error) {
+	if role == "" {
+		role = user_model.RoleViewer
+	}
+	invitation := model.Invitation{ID: primitive.NewObjectID(), OrgID: primitive.NewObjectID(), Email: user_model.NormalizeEmail(email), Role: role, InvitedBy: invitedBy}
+	m.invitations[invitation.ID] = invitation
+	return &invitation, nil
+}
+
+func (m *mockCommand) AcceptInvitation(id primitive.ObjectID, userID primitive.ObjectID, email string) (*model.Membership, error) {
+	invitation, ok := m.invitations[id]
+	if !ok || invitation.Email != user_model.NormalizeEmail(email) {
+		return nil, mongo.ErrNoDocuments
+	}
+	delete(m.invitations, id)
+	if _, ok := m.members[userID]; ok {
+		return nil, mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}
+	}
+	m.members[userID] = invitation.Role
+	return &model.Membership{OrgID: invitation.OrgID, UserID: userID, Role: invitation.Role}, nil
+}
+
+func (m *mockCommand) DeclineInvitation(id primitive.ObjectID, email string) (*mongo.DeleteResult, error) {
+	invitation, ok := m.invitations[id]
+	if !ok || invitation.Email != user_model.NormalizeEmail(email) {
+		return nil, mongo.ErrNoDocuments
+	}
+	delete(m.invitations, id)
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+
+type mockQuery struct{}
+
+func (m *mockQuery) GetOrganizations(userID primitive.ObjectID) ([]model.OrganizationDTO, error) {
+	return []model.OrganizationDTO{}, nil
+}
+
+func (m *mockQuery) GetMembership(userID primitive.ObjectID, orgID primitive.ObjectID) (*model.Membership, error) {
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *mockQuery) GetOrganization(orgID primitive.ObjectID) (*model.Organization, error) {
+	return &model.Organization{ID: orgID, Name: "Acme"}, nil
+}
+
+func (m *mockQuery) GetInvitations(email string) ([]model.InvitationDTO, error) {
+	return []model.InvitationDTO{}, nil
+}
+
+// newApp serves the controller to the signed-in user.
+func newApp(ctrl *OrgController, user user_model.UserDTO) *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", user)
+		return c.Next()
+	})
+	app.Post("/orgs/members", ctrl.InviteMember)
+	app.Post("/orgs/invitations/:id/accept", ctrl.AcceptInvitation)
+	app.Post("/orgs/invitations/:id/decline", ctrl.DeclineInvitation)
+	return app
+}
+
+func post(t *testing.T, app *fiber.App, path string, body map[string]string) int {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	return resp.StatusCode
+}
+
+// Adding a member only invites them: nobody becomes a member of an
+// organization, and has their account managed by its admins, without
+// accepting.
+func TestInviteMember_RequiresAcceptance(t *testing.T) {
+	command := newMockCommand()
+	mail := &mailer.MemoryMailer{}
+	admin := user_model.UserDTO{ID: primitive.NewObjectID(), FirstName: "Mallory", Role: user_model.RoleAdmin}
+	ctrl := &OrgController{Command: command, Query: &mockQuery{}, Mailer: mail}
+	app := newApp(ctrl, admin)
+
+	require.Equal(t, 400, post(t, app, "/orgs/members", map[string]string{"email": "not an email"}))
+	require.Equal(t, 403, post(t, app, "/orgs/members", map[string]string{"email": "ada@example.com", "role": user_model.RoleOwner}))
+	require.Equal(t, 202, post(t, app, "/orgs/members", map[string]string{"email": "Ada@Example.com", "role": user_model.RoleAccountant}))
+	require.Empty(t, command.members)
+
+	messages := mail.Messages()
+	require.Len(t, messages, 1)
+	require.Equal(t, []string{"ada@example.com"}, messages[0].To)
+	require.Contains(t, messages[0].Subject, "Acme")
+
+	var id primitive.ObjectID
+	for key := range command.invitations {
+		id = key
+	}
+
+	// someone signed in with another address cannot answer it
+	require.Equal(t, 404, post(t, app, "/orgs/invitations/"+id.Hex()+"/accept", nil))
+	require.Equal(t, 404, post(t, app, "/orgs/invitations/"+id.Hex()+"/decline", nil))
+	require.Empty(t, command.members)
+
+	ada := user_model.UserDTO{ID: primitive.NewObjectID(), Email: "ada@example.com", Role: user_model.RoleOwner}
+	app = newApp(ctrl, ada)
+	require.Equal(t, 400, post(t, app, "/orgs/invitations/bad-id/accept", nil))
+	require.Equal(t, 201, post(t, app, "/orgs/invitations/"+id.Hex()+"/accept", nil))
+	require.Equal(t, user_model.RoleAccountant, command.members[ada.ID])
+
+	// invitations are used up, and members cannot join twice
+	require.Equal(t, 404, post(t, app, "/orgs/invitations/"+id.Hex()+"/accept", nil))
+	invitation, _ := command.InviteMember(ada.Email, "", admin.ID)
+	require.Equal(t, 409, post(t, app, "/orgs/invitations/"+invitation.ID.Hex()+"/accept", nil))
+}
+
+func TestDeclineInvitation(t *testing.T) {
+	command := newMockCommand()
+	invitation, _ := command.InviteMember("ada@example.com", "", primitive.NewObjectID())
+	app := newApp(&OrgController{Command: command, Query: &mockQuery{}}, user_model.UserDTO{ID: primitive.NewObjectID(), Email: "ada@example.com"})
+
+	require.Equal(t, 200, post(t, app, "/orgs/invitations/"+invitation.ID.Hex()+"/decline", nil))
+	require.Equal(t, 404, post(t, app, "/orgs/invitations/"+invitation.ID.Hex()+"/accept", nil))
+	require.Empty(t, command.members)
+}
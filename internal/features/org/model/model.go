@@ -0,0 +1,122 @@
+package model
+
+import (
+	"time"
+
+	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var validate = validator.New()
+
+// DefaultName is the organization that data created before organizations
+// existed is moved into.
+const DefaultName = "Default"
+
+// InvitationTTL is how long an invitation to an organization can be accepted.
+const InvitationTTL = 7 * 24 * time.Hour
+
+// TenantCollections are the collections whose documents belong to an
+// organization through their orgId field.
+var TenantCollections = []string{
+	"customers",
+	"invoices",
+	"revenues",
+	"custom_fields",
+	"report_subscriptions",
+	"customer_merges",
+	"data_requests",
+}
+
+type Organization struct {
+	ID   primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name string             `bson:"name" json:"name"`
+	// RequireTwoFactor limits members who signed in without two-factor
+	// authentication to enrolling in it.
+	RequireTwoFactor bool      `bson:"requireTwoFactor" json:"requireTwoFactor"`
+	CreatedAt        time.Time `bson:"createdAt,omitempty" json:"createdAt"`
+	UpdatedAt        time.Time `bson:"updatedAt,omitempty" json:"updatedAt"`
+}
+
+// OrganizationDTO is an organization as seen by one of its members.
+type OrganizationDTO struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	Role      string             `bson:"role" json:"role"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// Membership gives a user a role in an organization. A user can belong to
+// several organizations with a different role in each.
+type Membership struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID     primitive.ObjectID `bson:"orgId" json:"orgId"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	Role      string             `bson:"role" json:"role"`
+	CreatedAt time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
+}
+
+// Invitation offers a role in an organization to whoever signs in with
+// Email. It only becomes a membership when that user accepts it, so that
+// nobody joins an organization, and has their account managed by its
+// admins, without agreeing to. Inviting the same address again replaces the
+// invitation.
+type Invitation struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID     primitive.ObjectID `bson:"orgId" json:"orgId"`
+	Email     string             `bson:"email" json:"email"`
+	Role      string             `bson:"role" json:"role"`
+	InvitedBy primitive.ObjectID `bson:"invitedBy" json:"invitedBy"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
+}
+
+// InvitationDTO is an invitation as seen by the invited user.
+type InvitationDTO struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	OrgID     primitive.ObjectID `bson:"orgId" json:"orgId"`
+	OrgName   string             `bson:"orgName" json:"orgName"`
+	Role      string             `bson:"role" json:"role"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type CreateOrganization struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type TwoFactorPolicy struct {
+	Required bool `json:"required"`
+}
+
+// AddMember invites the user with the email address to the organization.
+type AddMember struct {
+	Email string `json:"email" validate:"required,email"`
+	// Role defaults to viewer.
+	Role string `json:"role" validate:"omitempty,oneof=owner admin accountant sales viewer"`
+}
+
+type SwitchOrganization struct {
+	OrgID string `json:"orgId" validate:"required"`
+}
+
+type ErrorResponse struct {
+	Field string `json:"field"`
+	Tag   string `json:"tag"`
+	Value string `json:"value,omitempty"`
+}
+
+func ValidateStruct[T any](payload T) []ErrorResponse {
+	var errors []ErrorResponse
+	err := validate.Struct(payload)
+	if err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			var element ErrorResponse
+			element.Field = err.StructNamespace()
+			element.Tag = err.Tag()
+			element.Value = err.Param()
+			errors = append(errors, element)
+		}
+	}
+	return errors
+}
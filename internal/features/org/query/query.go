@@ -0,0 +1,152 @@
+package query
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	"invoice-api/internal/features/org/model"
+	user_model "invoice-api/internal/features/user/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultQuery answers questions about a user's memberships, which span
+// organizations, so it is not scoped to one.
+type DefaultQuery struct{}
+
+func (c *DefaultQuery) CollectionName() string {
+	return "memberships"
+}
+
+type Query interface {
+	GetOrganizations(userID primitive.ObjectID) ([]model.OrganizationDTO, error)
+	GetMembership(userID primitive.ObjectID, orgID primitive.ObjectID) (*model.Membership, error)
+	GetOrganization(orgID primitive.ObjectID) (*model.Organization, error)
+	GetInvitations(email string) ([]model.InvitationDTO, error)
+}
+
+// GetOrganizations lists the organizations the user belongs to, oldest
+// membership first.
+func (c *DefaultQuery) GetOrganizations(userID primitive.ObjectID) ([]model.OrganizationDTO, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": bson.M{"userId": userID}},
+		{"$sort": bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}},
+		{"$lookup": bson.M{
+			"from":         "organizations",
+			"localField":   "orgId",
+			"foreignField": "_id",
+			"as":           "org",
+		}},
+		{"$unwind": "$org"},
+		{"$project": bson.M{
+			"_id":       "$org._id",
+			"name":      "$org.name",
+			"createdAt": "$org.createdAt",
+			"role":      1,
+		}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]model.OrganizationDTO, 0)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// GetMembership returns the user's membership in the organization. With a
+// zero orgID it returns the user's oldest membership, which is where users
+// land when they sign in without choosing an organization.
+func (c *DefaultQuery) GetMembership(userID primitive.ObjectID, orgID primitive.ObjectID) (*model.Membership, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"userId": userID}
+	if !orgID.IsZero() {
+		filter["orgId"] = orgID
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+
+	var item model.Membership
+	if err := collection.FindOne(ctx, filter, opts).Decode(&item); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// GetOrganization returns the organization with its settings.
+func (c *DefaultQuery) GetOrganization(orgID primitive.ObjectID) (*model.Organization, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("organizations")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var item model.Organization
+	if err := collection.FindOne(ctx, bson.M{"_id": orgID}).Decode(&item); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// GetInvitations lists the pending invitations sent to the email address,
+// newest first.
+func (c *DefaultQuery) GetInvitations(email string) ([]model.InvitationDTO, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("invitations")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"email":     user_model.NormalizeEmail(email),
+			"expiresAt": bson.M{"$gt": time.Now()},
+		}},
+		{"$sort": bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{"$lookup": bson.M{
+			"from":         "organizations",
+			"localField":   "orgId",
+			"foreignField": "_id",
+			"as":           "org",
+		}},
+		{"$unwind": "$org"},
+		{"$project": bson.M{
+			"orgId":     1,
+			"orgName":   "$org.name",
+			"role":      1,
+			"expiresAt": 1,
+			"createdAt": 1,
+		}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]model.InvitationDTO, 0)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
@@ -0,0 +1,24 @@
+package route
+
+import (
+	"invoice-api/internal/features/org/controller"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type OrgRoute struct{}
+
+func (s *OrgRoute) Init(router *fiber.App) {
+	controller := new(controller.OrgController)
+	orgs := router.Group("/orgs")
+
+	orgs.Get("/", middleware.Authorize, controller.GetOrganizations)
+	orgs.Post("/", middleware.AuthorizeSession, controller.CreateOrganization)
+	orgs.Post("/members", middleware.RequirePermission(user_model.PermUserManage), controller.InviteMember)
+	orgs.Get("/invitations", middleware.AuthorizeSession, controller.GetInvitations)
+	orgs.Post("/invitations/:id/accept", middleware.AuthorizeSession, controller.AcceptInvitation)
+	orgs.Post("/invitations/:id/decline", middleware.AuthorizeSession, controller.DeclineInvitation)
+	orgs.Put("/two-factor", middleware.RequirePermission(user_model.PermOrgManage), controller.SetTwoFactorPolicy)
+}
@@ -0,0 +1,198 @@
+package command
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
+	customer_model "invoice-api/internal/features/customer/model"
+	"invoice-api/internal/features/portal/model"
+	"invoice-api/middleware"
+	"net/url"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultLinkTTL = 72 * time.Hour
+
+// DefaultPortalCommand issues links for customers of OrgID. Portal requests
+// themselves are scoped by the customer in their token instead.
+type DefaultPortalCommand struct {
+	OrgID primitive.ObjectID
+}
+
+func (c *DefaultPortalCommand) CollectionName() string {
+	return "customers"
+}
+
+type PortalCommand interface {
+	CreateLink(customerID string, _val *model.CreatePortalLink) (*model.PortalLink, error)
+	RevokeLink(customerID string, linkID string) error
+	RevokeLinks(customerID string) (int64, error)
+	UpdateContact(customerID string, _val *model.UpdateContact) (*mongo.UpdateResult, error)
+}
+
+// EnsureIndexes removes portal links once they have expired.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("portal_links").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "orgId", Value: 1}, {Key: "customerId", Value: 1}},
+			Options: options.Index().SetName("orgId_customerId"),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// CreateLink issues a magic link that gives the holder read access to the
+// customer's invoices until it expires or is revoked. The token is put in the
+// URL fragment, which browsers do not send to servers, for the portal to pass
+// on as a Bearer token.
+func (c *DefaultPortalCommand) CreateLink(customerID string, _val *model.CreatePortalLink) (*model.PortalLink, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Err(); err != nil {
+		return nil, err
+	}
+
+	ttl := defaultLinkTTL
+	if _val.ExpiresInHours > 0 {
+		ttl = time.Duration(_val.ExpiresInHours) * time.Hour
+	}
+
+	linkID := primitive.NewObjectID()
+	token, expiresAt, err := middleware.SignPortalToken(objID, linkID, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Collection("portal_links").InsertOne(ctx, model.Link{
+		ID:         linkID,
+		CustomerID: objID,
+		ExpiresAt:  expiresAt,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := os.Getenv("PORTAL_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:3001/portal"
+	}
+
+	return &model.PortalLink{
+		ID:         linkID,
+		CustomerID: objID,
+		Token:      token,
+		URL:        baseURL + "#token=" + url.QueryEscape(token),
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// RevokeLink ends one of the customer's portal links before it expires.
+func (c *DefaultPortalCommand) RevokeLink(customerID string, linkID string) error {
+	customerObjID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return err
+	}
+	linkObjID, err := primitive.ObjectIDFromHex(linkID)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.revoke(bson.M{"_id": linkObjID, "customerId": customerObjID})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// RevokeLinks ends every portal link of the customer and returns how many
+// were still active.
+func (c *DefaultPortalCommand) RevokeLinks(customerID string) (int64, error) {
+	objID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := c.revoke(bson.M{"customerId": objID})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.ModifiedCount, nil
+}
+
+// revoke sets revokedAt on the links matching filter, keeping the time of an
+// earlier revocation.
+func (c *DefaultPortalCommand) revoke(filter bson.M) (*mongo.UpdateResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection("portal_links")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"revokedAt": bson.M{"$ifNull": bson.A{"$revokedAt", time.Now()}}}}},
+	}
+	return collection.UpdateMany(ctx, filter, update)
+}
+
+// UpdateContact replaces the customer's billing contact. The customer's own
+// name and email are managed by staff and are not touched.
+func (c *DefaultPortalCommand) UpdateContact(customerID string, _val *model.UpdateContact) (*mongo.UpdateResult, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"billingContact": customer_model.BillingContact{
+				Name:    _val.Name,
+				Email:   _val.Email,
+				Phone:   _val.Phone,
+				Address: _val.Address,
+			},
+			"updatedAt": time.Now(),
+		},
+	}
+
+	res, err := collection.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return res, nil
+}
@@ -0,0 +1,284 @@
+package controller
+
+import (
+	"bytes"
+	"html/template"
+	"invoice-api/internal/features/portal/command"
+	"invoice-api/internal/features/portal/model"
+	"invoice-api/internal/features/portal/query"
+	"invoice-api/middleware"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var invoiceDocument = template.Must(template.New("invoice").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Invoice {{.ID.Hex}}</title></head>
+<body>
+<h1>Invoice</h1>
+<p>Invoice number: {{.ID.Hex}}<br>Date: {{.Date}}<br>Status: {{.Status}}</p>
+<p>Billed to:<br>{{.Customer.Name}}<br>{{.Customer.Email}}</p>
+<p>Amount due: {{printf "%.2f" .Amount}}</p>
+</body>
+</html>
+`))
+
+type PortalController struct {
+	Command command.PortalCommand
+	Query   query.PortalQuery
+}
+
+// command returns the injected command, or one scoped to the organization
+// the request was authorized for.
+func (s *PortalController) command(c *fiber.Ctx) command.PortalCommand {
+	if s.Command != nil {
+		return s.Command
+	}
+	return &command.DefaultPortalCommand{OrgID: middleware.OrgID(c)}
+}
+
+// CreatePortalLink is called by staff to issue a magic link for a customer.
+func (s *PortalController) CreatePortalLink(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	payload := new(model.CreatePortalLink)
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(payload); err != nil {
+			return c.Status(400).JSON(err.Error())
+		}
+	}
+
+	validationErrors := model.ValidateStruct(payload)
+	if validationErrors != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
+	}
+
+	link, err := s.command(c).CreateLink(id, payload)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Customer not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to create portal link",
+		})
+	}
+
+	return c.Status(201).JSON(link)
+}
+
+// RevokePortalLink is called by staff to end one of the customer's portal
+// links before it expires.
+func (s *PortalController) RevokePortalLink(c *fiber.Ctx) error {
+	if err := s.command(c).RevokeLink(c.Params("id"), c.Params("linkId")); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Portal link not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to revoke portal link",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RevokePortalLinks is called by staff to end every portal link of the
+// customer.
+func (s *PortalController) RevokePortalLinks(c *fiber.Ctx) error {
+	revoked, err := s.command(c).RevokeLinks(c.Params("id"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to revoke portal links",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"revoked": revoked,
+	})
+}
+
+// RequireActiveLink runs after middleware.AuthorizePortal and rejects tokens
+// of links that have been revoked.
+func (s *PortalController) RequireActiveLink(c *fiber.Ctx) error {
+	if s.Query == nil {
+		s.Query = &query.DefaultPortalQuery{}
+	}
+
+	linkID, _ := c.Locals("portalLinkId").(string)
+	active, err := s.Query.IsLinkActive(portalCustomerID(c), linkID)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "Invalid portal token"})
+	}
+	if !active {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "This portal link has been revoked"})
+	}
+
+	return c.Next()
+}
+
+func (s *PortalController) GetProfile(c *fiber.Ctx) error {
+	if s.Query == nil {
+		s.Query = &query.DefaultPortalQuery{}
+	}
+
+	customer, err := s.Query.GetCustomer(portalCustomerID(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Customer not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch customer",
+		})
+	}
+
+	return c.JSON(customer)
+}
+
+func (s *PortalController) GetInvoices(c *fiber.Ctx) error {
+	if s.Query == nil {
+		s.Query = &query.DefaultPortalQuery{}
+	}
+	size, err := strconv.ParseInt(c.Query("size"), 10, 64)
+	if err != nil {
+		size = 25
+	}
+	page, err := strconv.ParseInt(c.Query("page"), 10, 64)
+	if err != nil {
+		page = 1
+	}
+
+	items, err := s.Query.GetInvoices(portalCustomerID(c), c.Query("status"), size, page)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(items)
+}
+
+func (s *PortalController) GetInvoice(c *fiber.Ctx) error {
+	if s.Query == nil {
+		s.Query = &query.DefaultPortalQuery{}
+	}
+
+	item, err := s.Query.GetInvoice(portalCustomerID(c), c.Params("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Invoice not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch invoice",
+		})
+	}
+
+	return c.JSON(item)
+}
+
+// DownloadInvoice returns the invoice as a standalone HTML document.
+func (s *PortalController) DownloadInvoice(c *fiber.Ctx) error {
+	if s.Query == nil {
+		s.Query = &query.DefaultPortalQuery{}
+	}
+
+	item, err := s.Query.GetInvoice(portalCustomerID(c), c.Params("id"))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Invoice not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch invoice",
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := invoiceDocument.Execute(&buf, item); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to render invoice",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Attachment("invoice-" + item.ID.Hex() + ".html")
+	return c.Send(buf.Bytes())
+}
+
+func (s *PortalController) GetStatement(c *fiber.Ctx) error {
+	if s.Query == nil {
+		s.Query = &query.DefaultPortalQuery{}
+	}
+
+	statement, err := s.Query.GetStatement(portalCustomerID(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Customer not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch statement",
+		})
+	}
+
+	return c.JSON(statement)
+}
+
+func (s *PortalController) GetBalance(c *fiber.Ctx) error {
+	if s.Query == nil {
+		s.Query = &query.DefaultPortalQuery{}
+	}
+
+	balance, err := s.Query.GetBalance(portalCustomerID(c))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch balance",
+		})
+	}
+
+	return c.JSON(balance)
+}
+
+func (s *PortalController) UpdateContact(c *fiber.Ctx) error {
+	payload := new(model.UpdateContact)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(err.Error())
+	}
+
+	validationErrors := model.ValidateStruct(payload)
+	if validationErrors != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
+	}
+
+	_, err := s.command(c).UpdateContact(portalCustomerID(c), payload)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Customer not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to update billing contact",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Billing contact updated successfully",
+	})
+}
+
+// portalCustomerID returns the customer set by middleware.AuthorizePortal.
+func portalCustomerID(c *fiber.Ctx) string {
+	id, _ := c.Locals("customerId").(string)
+	return id
+}
@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	invoice_model "invoice-api/internal/features/invoice/model"
+	"invoice-api/internal/features/portal/model"
+	"invoice-api/middleware"
+)
+
+type mockQuery struct {
+	invoices []*invoice_model.InvoiceDTO
+	revoked  map[string]bool
+}
+
+func (m *mockQuery) GetInvoices(customerID string, status string, size int64, page int64) (*invoice_model.InvoicePage, error) {
+	items := make([]*invoice_model.InvoiceDTO, 0)
+	for _, item := range m.invoices {
+		if item.CustomerID.Hex() == customerID {
+			items = append(items, item)
+		}
+	}
+	return &invoice_model.InvoicePage{Data: items, TotalRows: int64(len(items))}, nil
+}
+
+func (m *mockQuery) GetInvoice(customerID string, id string) (*invoice_model.InvoiceDTO, error) {
+	for _, item := range m.invoices {
+		if item.ID.Hex() == id && item.CustomerID.Hex() == customerID {
+			return item, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *mockQuery) GetCustomer(customerID string) (*model.PortalCustomer, error) {
+	return &model.PortalCustomer{}, nil
+}
+
+func (m *mockQuery) GetStatement(customerID string) (*model.Statement, error) {
+	return &model.Statement{}, nil
+}
+
+func (m *mockQuery) GetBalance(customerID string) (*model.Balance, error) {
+	return &model.Balance{}, nil
+}
+
+func (m *mockQuery) IsLinkActive(customerID string, linkID string) (bool, error) {
+	return !m.revoked[linkID], nil
+}
+
+type mockCommand struct {
+	link      *model.PortalLink
+	linkErr   error
+	updateErr error
+	revokeErr error
+}
+
+func (m *mockCommand) CreateLink(customerID string, _val *model.CreatePortalLink) (*model.PortalLink, error) {
+	return m.link, m.linkErr
+}
+
+func (m *mockCommand) UpdateContact(customerID string, _val *model.UpdateContact) (*mongo.UpdateResult, error) {
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, m.updateErr
+}
+
+func (m *mockCommand) RevokeLink(customerID string, linkID string) error {
+	return m.revokeErr
+}
+
+func (m *mockCommand) RevokeLinks(customerID string) (int64, error) {
+	return 2, m.revokeErr
+}
+
+func newPortalApp(ctrl *PortalController) *fiber.App {
+	app := fiber.New()
+	portal := app.Group("/portal", middleware.AuthorizePortal, ctrl.RequireActiveLink)
+	portal.Get("/invoices", ctrl.GetInvoices)
+	portal.Get("/invoices/:id", ctrl.GetInvoice)
+	portal.Get("/invoices/:id/download", ctrl.DownloadInvoice)
+	portal.Patch("/me/contact", ctrl.UpdateContact)
+	return app
+}
+
+func TestPortal_RequiresPortalToken(t *testing.T) {
+	os.Setenv("JWT_SECRET", "testsecret")
+	app := newPortalApp(&PortalController{Query: &mockQuery{}})
+
+	// no token
+	resp, err := app.Test(httptest.NewRequest("GET", "/portal/invoices", nil))
+	require.NoError(t, err)
+	require.Equal(t, 401, resp.StatusCode)
+
+	// a staff session token is not accepted
+	staff := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": primitive.NewObjectID().Hex(), "exp": time.Now().Add(time.Hour).Unix()})
+	staffToken, _ := staff.SignedString([]byte("testsecret"))
+	req := httptest.NewRequest("GET", "/portal/invoices", nil)
+	req.Header.Set("Authorization", "Bearer "+staffToken)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 401, resp.StatusCode)
+
+	// expired portal token
+	expired, _, _ := middleware.SignPortalToken(primitive.NewObjectID(), primitive.NewObjectID(), -time.Minute)
+	resp, err = app.Test(httptest.NewRequest("GET", "/portal/invoices?token="+expired, nil))
+	require.NoError(t, err)
+	require.Equal(t, 401, resp.StatusCode)
+}
+
+func TestPortal_OnlySeesOwnInvoices(t *testing.T) {
+	customerA, customerB := primitive.NewObjectID(), primitive.NewObjectID()
+	invoiceA := &invoice_model.InvoiceDTO{ID: primitive.NewObjectID(), CustomerID: customerA, Amount: 100, Date: "2024-06-01", Status: "pending"}
+	invoiceB := &invoice_model.InvoiceDTO{ID: primitive.NewObjectID(), CustomerID: customerB, Amount: 200}
+	app := newPortalApp(&PortalController{Query: &mockQuery{invoices: []*invoice_model.InvoiceDTO{invoiceA, invoiceB}}})
+
+	token, _, err := middleware.SignPortalToken(customerA, primitive.NewObjectID(), time.Hour)
+	require.NoError(t, err)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/portal/invoices?token="+token, nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	var page invoice_model.InvoicePage
+	json.NewDecoder(resp.Body).Decode(&page)
+	require.Len(t, page.Data, 1)
+	require.Equal(t, invoiceA.ID, page.Data[0].ID)
+
+	// another customer's invoice is not found
+	req := httptest.NewRequest("GET", "/portal/invoices/"+invoiceB.ID.Hex(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode)
+
+	// download
+	resp, err = app.Test(httptest.NewRequest("GET", "/portal/invoices/"+invoiceA.ID.Hex()+"/download?token="+token, nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+	body, _ := io.ReadAll(resp.Body)
+	require.True(t, strings.Contains(string(body), "100.00"))
+}
+
+func TestPortal_RejectsRevokedLink(t *testing.T) {
+	customerID, linkID := primitive.NewObjectID(), primitive.NewObjectID()
+	query := &mockQuery{revoked: map[string]bool{}}
+	app := newPortalApp(&PortalController{Query: query})
+
+	token, _, err := middleware.SignPortalToken(customerID, linkID, time.Hour)
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "/portal/invoices", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	query.revoked[linkID.Hex()] = true
+	req = httptest.NewRequest("GET", "/portal/invoices", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 401, resp.StatusCode)
+
+	// a token carrying no link id cannot be revoked, so it is not accepted
+	t.Setenv("PORTAL_SECRET", "portalsecret")
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": customerID.Hex(), "aud": "customer-portal", "exp": time.Now().Add(time.Hour).Unix()})
+	legacyToken, _ := legacy.SignedString([]byte("portalsecret"))
+	req = httptest.NewRequest("GET", "/portal/invoices", nil)
+	req.Header.Set("Authorization", "Bearer "+legacyToken)
+	resp, err = app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 401, resp.StatusCode)
+}
+
+func TestPortal_UpdateContactValidation(t *testing.T) {
+	app := newPortalApp(&PortalController{Query: &mockQuery{}, Command: &mockCommand{}})
+	token, _, _ := middleware.SignPortalToken(primitive.NewObjectID(), primitive.NewObjectID(), time.Hour)
+
+	req := httptest.NewRequest("PATCH", "/portal/me/contact?token="+token, bytes.NewReader([]byte(`{"name":"Jane","email":"not-an-email"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode)
+
+	req2 := httptest.NewRequest("PATCH", "/portal/me/contact?token="+token, bytes.NewReader([]byte(`{"name":"Jane","email":"jane@example.com"}`)))
+	req2.Header.Set("Content-Type", "application/json")
+	resp2, err := app.Test(req2)
+	require.NoError(t, err)
+	require.Equal(t, 200, resp2.StatusCode)
+}
+
+func TestCreatePortalLink_NotFound(t *testing.T) {
+	app := fiber.New()
+	ctrl := &PortalController{Command: &mockCommand{linkErr: mongo.ErrNoDocuments}}
+	app.Post("/customers/:id/portal-link", ctrl.CreatePortalLink)
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/customers/someid/portal-link", nil))
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode)
+}
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"time"
+
+	customer_model "invoice-api/internal/features/customer/model"
+
+	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var validate = validator.New()
+
+type CreatePortalLink struct {
+	// ExpiresInHours defaults to 72 hours and is capped at 30 days.
+	ExpiresInHours int `json:"expiresInHours" validate:"gte=0,lte=720"`
+}
+
+type PortalLink struct {
+	ID         primitive.ObjectID `json:"id"`
+	CustomerID primitive.ObjectID `json:"customerId"`
+	Token      string             `json:"token"`
+	URL        string             `json:"url"`
+	ExpiresAt  time.Time          `json:"expiresAt"`
+}
+
+// Link is an issued portal link, kept so that staff can revoke it before it
+// expires. Its ID is the jti of the link's token.
+type Link struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	CustomerID primitive.ObjectID `bson:"customerId" json:"customerId"`
+	ExpiresAt  time.Time          `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+type UpdateContact struct {
+	Name    string `json:"name" validate:"required"`
+	Email   string `json:"email" validate:"required,email"`
+	Phone   string `json:"phone"`
+	Address string `json:"address"`
+}
+
+type PortalCustomer struct {
+	ID             primitive.ObjectID             `bson:"_id" json:"id"`
+	Name           string                         `bson:"name" json:"name"`
+	Email          string                         `bson:"email" json:"email"`
+	ImageURL       string                         `bson:"imageUrl" json:"imageUrl"`
+	BillingContact *customer_model.BillingContact `bson:"billingContact" json:"billingContact,omitempty"`
+}
+
+type StatementEntry struct {
+	InvoiceID primitive.ObjectID `bson:"_id" json:"invoiceId"`
+	Date      string             `bson:"date" json:"date"`
+	Status    string             `bson:"status" json:"status"`
+	Amount    float64            `bson:"amount" json:"amount"`
+	Balance   float64            `bson:"-" json:"balance"`
+}
+
+// Statement lists a customer's invoices in date order. Balance on each entry is
+// the running total of invoices that are still open.
+type Statement struct {
+	Customer    PortalCustomer   `json:"customer"`
+	Entries     []StatementEntry `json:"entries"`
+	TotalBilled float64          `json:"totalBilled"`
+	TotalPaid   float64          `json:"totalPaid"`
+	Balance     float64          `json:"balance"`
+}
+
+type Balance struct {
+	CustomerID primitive.ObjectID `json:"customerId"`
+	Balance    float64            `json:"balance"`
+	OpenCount  int64              `json:"openCount"`
+}
+
+type ErrorResponse struct {
+	Field string `json:"field"`
+	Tag   string `json:"tag"`
+	Value string `json:"value,omitempty"`
+}
+
+func ValidateStruct[T any](payload T) []ErrorResponse {
+	var errors []ErrorResponse
+	err := validate.Struct(payload)
+	if err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			var element ErrorResponse
+			element.Field = err.StructNamespace()
+			element.Tag = err.Tag()
+			element.Value = err.Param()
+			errors = append(errors, element)
+		}
+	}
+	return errors
+}
@@ -0,0 +1,253 @@
+package query
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	invoice_model "invoice-api/internal/features/invoice/model"
+	"invoice-api/internal/features/portal/model"
+	"math"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DefaultPortalQuery struct{}
+
+func (c *DefaultPortalQuery) CollectionName() string {
+	return "invoices"
+}
+
+// PortalQuery only ever reads data belonging to the customer passed in, which
+// comes from the verified portal token.
+type PortalQuery interface {
+	GetInvoices(customerID string, status string, size int64, page int64) (*invoice_model.InvoicePage, error)
+	GetInvoice(customerID string, id string) (*invoice_model.InvoiceDTO, error)
+	GetCustomer(customerID string) (*model.PortalCustomer, error)
+	GetStatement(customerID string) (*model.Statement, error)
+	GetBalance(customerID string) (*model.Balance, error)
+	IsLinkActive(customerID string, linkID string) (bool, error)
+}
+
+func (c *DefaultPortalQuery) GetInvoices(customerID string, status string, size int64, page int64) (*invoice_model.InvoicePage, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	objID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"customerId": objID}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	totalItems, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if size <= 0 {
+		size = 25
+	}
+	if page <= 0 {
+		page = 1
+	}
+	opts := options.Find().
+		SetSkip((page - 1) * size).
+		SetLimit(size).
+		SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}})
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]*invoice_model.InvoiceDTO, 0, size)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return &invoice_model.InvoicePage{
+		PageSize:   size,
+		PageNumber: page,
+		TotalRows:  totalItems,
+		TotalPages: int64(math.Ceil(float64(totalItems) / float64(size))),
+		Data:       items,
+	}, nil
+}
+
+func (c *DefaultPortalQuery) GetInvoice(customerID string, id string) (*invoice_model.InvoiceDTO, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	customerObjID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return nil, err
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var item invoice_model.InvoiceDTO
+	err = collection.FindOne(ctx, bson.M{"_id": objID, "customerId": customerObjID}).Decode(&item)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func (c *DefaultPortalQuery) GetCustomer(customerID string) (*model.PortalCustomer, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("customers")
+
+	objID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var customer model.PortalCustomer
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&customer); err != nil {
+		return nil, err
+	}
+
+	return &customer, nil
+}
+
+// GetStatement lists every invoice that was not cancelled or voided, oldest
+// first, with a running balance of the ones still open.
+func (c *DefaultPortalQuery) GetStatement(customerID string) (*model.Statement, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	customer, err := c.GetCustomer(customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"customerId": customer.ID,
+		"status":     bson.M{"$nin": bson.A{"cancelled", "void"}},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := make([]model.StatementEntry, 0, 100)
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	statement := &model.Statement{Customer: *customer, Entries: entries}
+	for i, entry := range entries {
+		statement.TotalBilled += entry.Amount
+		if entry.Status == "paid" {
+			statement.TotalPaid += entry.Amount
+		} else if !slices.Contains(invoice_model.ClosedStatuses, entry.Status) {
+			statement.Balance += entry.Amount
+		}
+		entries[i].Balance = statement.Balance
+	}
+
+	return statement, nil
+}
+
+func (c *DefaultPortalQuery) GetBalance(customerID string) (*model.Balance, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	objID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": bson.M{
+			"customerId": objID,
+			"status":     bson.M{"$nin": invoice_model.ClosedStatuses},
+		}},
+		{"$group": bson.M{
+			"_id":     nil,
+			"balance": bson.M{"$sum": "$amount"},
+			"count":   bson.M{"$sum": 1},
+		}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Balance float64 `bson:"balance"`
+		Count   int64   `bson:"count"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	balance := &model.Balance{CustomerID: objID}
+	if len(result) > 0 {
+		balance.Balance = result[0].Balance
+		balance.OpenCount = result[0].Count
+	}
+
+	return balance, nil
+}
+
+// IsLinkActive reports whether the customer's portal link has been issued and
+// has neither been revoked nor expired.
+func (c *DefaultPortalQuery) IsLinkActive(customerID string, linkID string) (bool, error) {
+	db := database.GetDatabase()
+	collection := db.Collection("portal_links")
+
+	customerObjID, err := primitive.ObjectIDFromHex(customerID)
+	if err != nil {
+		return false, err
+	}
+	linkObjID, err := primitive.ObjectIDFromHex(linkID)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":        linkObjID,
+		"customerId": customerObjID,
+		"revokedAt":  bson.M{"$exists": false},
+		"expiresAt":  bson.M{"$gt": time.Now()},
+	}
+	count, err := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
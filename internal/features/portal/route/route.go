@@ -0,0 +1,28 @@
+package route
+
+import (
+	"invoice-api/internal/features/portal/controller"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type PortalRoute struct{}
+
+func (s *PortalRoute) Init(router *fiber.App) {
+	controller := new(controller.PortalController)
+
+	router.Post("/customers/:id/portal-link", middleware.RequirePermission(user_model.PermCustomerWrite), controller.CreatePortalLink)
+	router.Delete("/customers/:id/portal-links", middleware.RequirePermission(user_model.PermCustomerWrite), controller.RevokePortalLinks)
+	router.Delete("/customers/:id/portal-links/:linkId", middleware.RequirePermission(user_model.PermCustomerWrite), controller.RevokePortalLink)
+
+	portal := router.Group("/portal", middleware.AuthorizePortal, controller.RequireActiveLink)
+	portal.Get("/me", controller.GetProfile)
+	portal.Patch("/me/contact", controller.UpdateContact)
+	portal.Get("/invoices", controller.GetInvoices)
+	portal.Get("/invoices/:id", controller.GetInvoice)
+	portal.Get("/invoices/:id/download", controller.DownloadInvoice)
+	portal.Get("/statement", controller.GetStatement)
+	portal.Get("/balance", controller.GetBalance)
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"invoice-api/internal/features/report/model"
+	"invoice-api/internal/features/report/query"
+	"invoice-api/middleware"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ReportController struct {
+	Query query.ReportQuery
+}
+
+// query returns the injected query, or one scoped to the organization the
+// request was authorized for.
+func (s *ReportController) query(c *fiber.Ctx) query.ReportQuery {
+	if s.Query != nil {
+		return s.Query
+	}
+	return &query.DefaultReportQuery{OrgID: middleware.OrgID(c)}
+}
+
+// GetARAging returns the accounts receivable aging as of `asOf` (YYYY-MM-DD,
+// default today). `customerId` drills down to one customer's invoices and
+// `format=csv` downloads the report.
+func (s *ReportController) GetARAging(c *fiber.Ctx) error {
+	asOf, err := parseAsOf(c.Query("asOf"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "asOf must be a YYYY-MM-DD date",
+		})
+	}
+
+	format := c.Query("format", model.FormatJSON)
+	if format != model.FormatJSON && format != model.FormatCSV {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "format must be json or csv",
+		})
+	}
+
+	report, err := s.query(c).GetARAging(asOf, c.Query("customerId"), c.QueryBool("details"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if format == model.FormatCSV {
+		body, err := agingCSV(report)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"error": "Failed to build report",
+			})
+		}
+		c.Attachment("ar-aging-" + report.AsOf + ".csv")
+		return c.Send(body)
+	}
+
+	return c.JSON(report)
+}
+
+// GetCollections returns DSO and payment timing for the `months` (default 12)
+// ending with the month of `asOf`, with a monthly trend and per-customer
+// figures that flag slow payers.
+func (s *ReportController) GetCollections(c *fiber.Ctx) error {
+	asOf, err := parseAsOf(c.Query("asOf"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "asOf must be a YYYY-MM-DD date",
+		})
+	}
+
+	months := c.QueryInt("months", model.DefaultCollectionMonths)
+	if months < 1 || months > model.MaxCollectionMonths {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "months must be between 1 and " + strconv.Itoa(model.MaxCollectionMonths),
+		})
+	}
+
+	report, err := s.query(c).GetCollections(asOf, months)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(report)
+}
+
+// parseAsOf parses a YYYY-MM-DD date, defaulting to today (UTC).
+func parseAsOf(value string) (time.Time, error) {
+	if value == "" {
+		now := time.Now().UTC()
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// agingCSV writes one row per customer followed by a total row. When the
+// report has invoice details, each customer's invoices follow its row.
+func agingCSV(report *model.AgingReport) ([]byte, error) {
+	money := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) }
+	bucketCells := func(b model.AgingBuckets) []string {
+		return []string{money(b.Current), money(b.Days1To30), money(b.Days31To60), money(b.Days61To90), money(b.Over90), money(b.Total)}
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"customer_id", "customer", "email", "invoice_id", "invoice_date", "due_date", "days_past_due",
+		model.BucketCurrent, model.Bucket1To30, model.Bucket31To60, model.Bucket61To90, model.BucketOver90, "total"})
+	for _, customer := range report.Customers {
+		w.Write(append([]string{customer.CustomerID.Hex(), customer.Name, customer.Email, "", "", "", ""}, bucketCells(customer.AgingBuckets)...))
+		for _, invoice := range customer.Invoices {
+			cells := make([]string, 6)
+			for i, bucket := range []string{model.BucketCurrent, model.Bucket1To30, model.Bucket31To60, model.Bucket61To90, model.BucketOver90} {
+				if invoice.Bucket == bucket {
+					cells[i] = money(invoice.Amount)
+				}
+			}
+			cells[5] = money(invoice.Amount)
+			w.Write(append([]string{customer.CustomerID.Hex(), customer.Name, customer.Email, invoice.ID.Hex(), invoice.Date, invoice.DueDate, strconv.Itoa(invoice.DaysPastDue)}, cells...))
+		}
+	}
+	w.Write(append([]string{"", "TOTAL", "", "", "", "", ""}, bucketCells(report.Totals)...))
+	w.Flush()
+
+	return buf.Bytes(), w.Error()
+}
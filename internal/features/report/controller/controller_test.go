@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"invoice-api/internal/features/report/model"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type mockQuery struct {
+	asOf       time.Time
+	customerID string
+	details    bool
+	report     *model.AgingReport
+	months     int
+}
+
+func (m *mockQuery) GetARAging(asOf time.Time, customerID string, details bool) (*model.AgingReport, error) {
+	m.asOf, m.customerID, m.details = asOf, customerID, details
+	return m.report, nil
+}
+
+func (m *mockQuery) GetCollections(asOf time.Time, months int) (*model.CollectionReport, error) {
+	m.asOf, m.months = asOf, months
+	return &model.CollectionReport{AsOf: asOf.Format(time.DateOnly)}, nil
+}
+
+func sampleReport() *model.AgingReport {
+	buckets := model.AgingBuckets{Current: 100, Days31To60: 250, Total: 350}
+	return &model.AgingReport{
+		AsOf:   "2025-06-30",
+		Totals: buckets,
+		Customers: []model.AgingCustomer{{
+			CustomerID:   primitive.NewObjectID(),
+			Name:         "Acme",
+			AgingBuckets: buckets,
+			Invoices: []model.AgingInvoice{
+				{ID: primitive.NewObjectID(), Date: "2025-04-01", DueDate: "2025-05-01", Amount: 250, DaysPastDue: 60, Bucket: model.Bucket31To60},
+			},
+		}},
+	}
+}
+
+func TestGetARAging_JSON(t *testing.T) {
+	app := fiber.New()
+	q := &mockQuery{report: sampleReport()}
+	ctrl := &ReportController{Query: q}
+	app.Get("/reports/ar-aging", ctrl.GetARAging)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/reports/ar-aging?asOf=2025-06-30&details=true", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, "2025-06-30", q.asOf.Format(time.DateOnly))
+	require.True(t, q.details)
+
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	customer := body["customers"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, 250.0, customer["31-60"])
+	require.Len(t, customer["invoices"], 1)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/reports/ar-aging?asOf=30/06/2025", nil))
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode)
+}
+
+func TestGetARAging_CSV(t *testing.T) {
+	app := fiber.New()
+	ctrl := &ReportController{Query: &mockQuery{report: sampleReport()}}
+	app.Get("/reports/ar-aging", ctrl.GetARAging)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/reports/ar-aging?format=csv", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Contains(t, resp.Header.Get("Content-Type"), "text/csv")
+
+	body, _ := io.ReadAll(resp.Body)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	require.Len(t, lines, 4)
+	require.True(t, strings.HasSuffix(lines[2], ",60,,,250.00,,,250.00"))
+	require.True(t, strings.HasPrefix(lines[3], ",TOTAL,"))
+}
+
+func TestGetCollections(t *testing.T) {
+	app := fiber.New()
+	q := &mockQuery{}
+	ctrl := &ReportController{Query: q}
+	app.Get("/reports/collections", ctrl.GetCollections)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/reports/collections?asOf=2025-06-30&months=6", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, 6, q.months)
+	require.Equal(t, "2025-06-30", q.asOf.Format(time.DateOnly))
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/reports/collections", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, model.DefaultCollectionMonths, q.months)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/reports/collections?months=0", nil))
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode)
+}
@@ -0,0 +1,116 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Aging buckets by days past due.
+const (
+	BucketCurrent  = "current"
+	Bucket1To30    = "1-30"
+	Bucket31To60   = "31-60"
+	Bucket61To90   = "61-90"
+	BucketOver90   = "90+"
+	agingDaysLimit = 90
+)
+
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// AgingBucketBounds are the upper bounds, in days past due, of every bucket
+// but the last.
+var AgingBucketBounds = []struct {
+	MaxDays int
+	Bucket  string
+}{
+	{0, BucketCurrent},
+	{30, Bucket1To30},
+	{60, Bucket31To60},
+	{agingDaysLimit, Bucket61To90},
+}
+
+// AgingBuckets are outstanding balances by how far past due they are.
+type AgingBuckets struct {
+	Current    float64 `bson:"current" json:"current"`
+	Days1To30  float64 `bson:"days1To30" json:"1-30"`
+	Days31To60 float64 `bson:"days31To60" json:"31-60"`
+	Days61To90 float64 `bson:"days61To90" json:"61-90"`
+	Over90     float64 `bson:"over90" json:"90+"`
+	Total      float64 `bson:"total" json:"total"`
+}
+
+type AgingInvoice struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Date        string             `bson:"date" json:"date"`
+	DueDate     string             `bson:"dueDate" json:"dueDate"`
+	Amount      float64            `bson:"amount" json:"amount"`
+	DaysPastDue int                `bson:"daysPastDue" json:"daysPastDue"`
+	Bucket      string             `bson:"bucket" json:"bucket"`
+}
+
+type AgingCustomer struct {
+	CustomerID   primitive.ObjectID `bson:"_id" json:"customerId"`
+	Name         string             `bson:"name" json:"name"`
+	Email        string             `bson:"email" json:"email"`
+	AgingBuckets `bson:",inline"`
+	Invoices     []AgingInvoice `bson:"invoices,omitempty" json:"invoices,omitempty"`
+}
+
+// AgingReport is the accounts receivable aging as of a date (YYYY-MM-DD).
+type AgingReport struct {
+	AsOf      string          `json:"asOf"`
+	Totals    AgingBuckets    `json:"totals"`
+	Customers []AgingCustomer `json:"customers"`
+}
+
+// DefaultCollectionMonths and MaxCollectionMonths bound the `months` window
+// of the collections report.
+const (
+	DefaultCollectionMonths = 12
+	MaxCollectionMonths     = 60
+)
+
+// PaymentStats describe how quickly a set of invoices was paid. Days to pay
+// run from the invoice date to the payment; an invoice is on time when it was
+// paid by the end of its due date.
+type PaymentStats struct {
+	Paid            int      `json:"paid"`
+	PaidAmount      float64  `json:"paidAmount"`
+	AvgDaysToPay    *float64 `json:"avgDaysToPay"`
+	MedianDaysToPay *float64 `json:"medianDaysToPay"`
+	AvgDaysLate     *float64 `json:"avgDaysLate"`
+	OnTimePct       *float64 `json:"onTimePct"`
+}
+
+// CollectionMonth is one month of the collections trend. Receivable is what
+// was owed at the end of the month and DSO relates it to the month's billing.
+type CollectionMonth struct {
+	Period     string   `json:"period"`
+	Billed     float64  `json:"billed"`
+	Receivable float64  `json:"receivable"`
+	DSO        *float64 `json:"dso"`
+	PaymentStats
+}
+
+type CustomerCollection struct {
+	CustomerID  primitive.ObjectID `json:"customerId"`
+	Name        string             `json:"name"`
+	Email       string             `json:"email"`
+	Outstanding float64            `json:"outstanding"`
+	SlowPayer   bool               `json:"slowPayer"`
+	PaymentStats
+}
+
+// CollectionReport covers invoices paid and billed from From (YYYY-MM-DD) to
+// AsOf. DSO is the receivable at AsOf divided by what was billed in the
+// window, times the days in the window.
+type CollectionReport struct {
+	From       string   `json:"from"`
+	AsOf       string   `json:"asOf"`
+	Billed     float64  `json:"billed"`
+	Receivable float64  `json:"receivable"`
+	DSO        *float64 `json:"dso"`
+	PaymentStats
+	Trend     []CollectionMonth    `json:"trend"`
+	Customers []CustomerCollection `json:"customers"`
+}
@@ -0,0 +1,184 @@
+package query
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	customer_model "invoice-api/internal/features/customer/model"
+	"invoice-api/internal/features/report/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// collectionInvoice is an invoice reduced to what the collections report
+// needs. PaidOn is only set for paid invoices.
+type collectionInvoice struct {
+	CustomerID primitive.ObjectID `bson:"customerId"`
+	Name       string             `bson:"name"`
+	Email      string             `bson:"email"`
+	Amount     float64            `bson:"amount"`
+	Issued     time.Time          `bson:"issued"`
+	Due        time.Time          `bson:"due"`
+	PaidOn     *time.Time         `bson:"paidOn"`
+}
+
+// outstandingAt reports whether the invoice had been issued and was still
+// unpaid just before t.
+func (inv collectionInvoice) outstandingAt(t time.Time) bool {
+	return inv.Issued.Before(t) && (inv.PaidOn == nil || !inv.PaidOn.Before(t))
+}
+
+func (inv collectionInvoice) paidBetween(start time.Time, end time.Time) bool {
+	return inv.PaidOn != nil && !inv.PaidOn.Before(start) && inv.PaidOn.Before(end)
+}
+
+// paymentStats accumulates the payments of a set of invoices.
+type paymentStats struct {
+	days   []float64
+	late   float64
+	onTime int
+	amount float64
+}
+
+func (s *paymentStats) add(inv collectionInvoice) {
+	dueBy := inv.Due.AddDate(0, 0, 1)
+	s.days = append(s.days, math.Max(0, inv.PaidOn.Sub(inv.Issued).Hours()/24))
+	s.late += math.Max(0, inv.PaidOn.Sub(dueBy).Hours()/24)
+	if inv.PaidOn.Before(dueBy) {
+		s.onTime++
+	}
+	s.amount += inv.Amount
+}
+
+func (s *paymentStats) result() model.PaymentStats {
+	stats := model.PaymentStats{Paid: len(s.days), PaidAmount: round(s.amount, 2)}
+	if len(s.days) == 0 {
+		return stats
+	}
+
+	sorted := append([]float64(nil), s.days...)
+	sort.Float64s(sorted)
+	total := 0.0
+	for _, d := range sorted {
+		total += d
+	}
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + median) / 2
+	}
+	n := float64(len(sorted))
+
+	avg, med, late := round(total/n, 1), round(median, 1), round(s.late/n, 1)
+	onTime := round(float64(s.onTime)/n*100, 2)
+	stats.AvgDaysToPay, stats.MedianDaysToPay, stats.AvgDaysLate, stats.OnTimePct = &avg, &med, &late, &onTime
+	return stats
+}
+
+func round(v float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(v*scale) / scale
+}
+
+// dso is receivable divided by billed, times days, or nil when nothing was
+// billed.
+func dso(receivable float64, billed float64, days float64) *float64 {
+	if billed <= 0 {
+		return nil
+	}
+	v := round(receivable/billed*days, 1)
+	return &v
+}
+
+// buildCollections reports on the months window ending with the month of
+// asOf. Billing is counted by invoice date and payments by the date they were
+// paid; receivables are taken at the end of each month, or of asOf for the
+// current month.
+func buildCollections(invoices []collectionInvoice, asOf time.Time, months int) *model.CollectionReport {
+	end := asOf.AddDate(0, 0, 1)
+	from := time.Date(asOf.Year(), asOf.Month()-time.Month(months-1), 1, 0, 0, 0, 0, time.UTC)
+
+	report := &model.CollectionReport{
+		From:      from.Format(time.DateOnly),
+		AsOf:      asOf.Format(time.DateOnly),
+		Trend:     make([]model.CollectionMonth, 0, months),
+		Customers: make([]model.CustomerCollection, 0),
+	}
+
+	var overall paymentStats
+	byCustomer := make(map[primitive.ObjectID]*paymentStats)
+	customers := make(map[primitive.ObjectID]*model.CustomerCollection)
+	customer := func(inv collectionInvoice) *model.CustomerCollection {
+		c, ok := customers[inv.CustomerID]
+		if !ok {
+			c = &model.CustomerCollection{CustomerID: inv.CustomerID, Name: inv.Name, Email: inv.Email}
+			customers[inv.CustomerID] = c
+			byCustomer[inv.CustomerID] = &paymentStats{}
+		}
+		return c
+	}
+
+	for _, inv := range invoices {
+		if !inv.Issued.Before(from) && inv.Issued.Before(end) {
+			report.Billed += inv.Amount
+		}
+		if inv.outstandingAt(end) {
+			report.Receivable += inv.Amount
+			customer(inv).Outstanding += inv.Amount
+		}
+		if inv.paidBetween(from, end) {
+			overall.add(inv)
+			customer(inv)
+			byCustomer[inv.CustomerID].add(inv)
+		}
+	}
+	report.Billed, report.Receivable = round(report.Billed, 2), round(report.Receivable, 2)
+	report.DSO = dso(report.Receivable, report.Billed, end.Sub(from).Hours()/24)
+	report.PaymentStats = overall.result()
+
+	for start := from; start.Before(end); start = start.AddDate(0, 1, 0) {
+		monthEnd := start.AddDate(0, 1, 0)
+		if monthEnd.After(end) {
+			monthEnd = end
+		}
+		month := model.CollectionMonth{Period: start.Format("2006-01")}
+		var stats paymentStats
+		for _, inv := range invoices {
+			if !inv.Issued.Before(start) && inv.Issued.Before(monthEnd) {
+				month.Billed += inv.Amount
+			}
+			if inv.outstandingAt(monthEnd) {
+				month.Receivable += inv.Amount
+			}
+			if inv.paidBetween(start, monthEnd) {
+				stats.add(inv)
+			}
+		}
+		month.Billed, month.Receivable = round(month.Billed, 2), round(month.Receivable, 2)
+		month.DSO = dso(month.Receivable, month.Billed, monthEnd.Sub(start).Hours()/24)
+		month.PaymentStats = stats.result()
+		report.Trend = append(report.Trend, month)
+	}
+
+	for id, c := range customers {
+		c.Outstanding = round(c.Outstanding, 2)
+		c.PaymentStats = byCustomer[id].result()
+		c.SlowPayer = c.AvgDaysLate != nil && *c.AvgDaysLate > customer_model.SlowPayerDaysLate
+		report.Customers = append(report.Customers, *c)
+	}
+	sort.Slice(report.Customers, func(i, j int) bool {
+		a, b := report.Customers[i], report.Customers[j]
+		if (a.AvgDaysLate == nil) != (b.AvgDaysLate == nil) {
+			return a.AvgDaysLate != nil
+		}
+		if a.AvgDaysLate != nil && *a.AvgDaysLate != *b.AvgDaysLate {
+			return *a.AvgDaysLate > *b.AvgDaysLate
+		}
+		if a.Outstanding != b.Outstanding {
+			return a.Outstanding > b.Outstanding
+		}
+		return a.Name < b.Name
+	})
+
+	return report
+}
@@ -0,0 +1,71 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func date(value string) time.Time {
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func paid(t time.Time) *time.Time {
+	return &t
+}
+
+func TestBuildCollections(t *testing.T) {
+	a, b := primitive.NewObjectID(), primitive.NewObjectID()
+	invoices := []collectionInvoice{
+		{CustomerID: a, Name: "Acme", Amount: 100, Issued: date("2025-01-01"), Due: date("2025-01-31"), PaidOn: paid(date("2025-01-21"))},
+		{CustomerID: a, Name: "Acme", Amount: 200, Issued: date("2025-02-01"), Due: date("2025-03-03"), PaidOn: paid(date("2025-03-03").Add(12 * time.Hour))},
+		{CustomerID: b, Name: "Beta", Amount: 300, Issued: date("2025-01-15"), Due: date("2025-02-14"), PaidOn: paid(date("2025-03-16"))},
+		{CustomerID: b, Name: "Beta", Amount: 400, Issued: date("2025-03-10"), Due: date("2025-04-09")},
+	}
+
+	report := buildCollections(invoices, date("2025-03-31"), 3)
+
+	require.Equal(t, "2025-01-01", report.From)
+	require.Equal(t, 1000.0, report.Billed)
+	require.Equal(t, 400.0, report.Receivable)
+	require.Equal(t, 36.0, *report.DSO)
+	require.Equal(t, 3, report.Paid)
+	require.Equal(t, 36.8, *report.AvgDaysToPay)
+	require.Equal(t, 30.5, *report.MedianDaysToPay)
+	require.Equal(t, 9.7, *report.AvgDaysLate)
+	require.Equal(t, 66.67, *report.OnTimePct)
+
+	require.Len(t, report.Trend, 3)
+	require.Equal(t, "2025-01", report.Trend[0].Period)
+	require.Equal(t, 300.0, report.Trend[0].Receivable)
+	require.Equal(t, 23.3, *report.Trend[0].DSO)
+	require.Equal(t, 70.0, *report.Trend[1].DSO)
+	require.Equal(t, 0, report.Trend[1].Paid)
+	require.Nil(t, report.Trend[1].AvgDaysToPay)
+	require.Equal(t, 31.0, *report.Trend[2].DSO)
+	require.Equal(t, 2, report.Trend[2].Paid)
+
+	require.Len(t, report.Customers, 2)
+	require.Equal(t, b, report.Customers[0].CustomerID)
+	require.True(t, report.Customers[0].SlowPayer)
+	require.Equal(t, 400.0, report.Customers[0].Outstanding)
+	require.Equal(t, 29.0, *report.Customers[0].AvgDaysLate)
+	require.Equal(t, a, report.Customers[1].CustomerID)
+	require.False(t, report.Customers[1].SlowPayer)
+	require.Equal(t, 100.0, *report.Customers[1].OnTimePct)
+}
+
+func TestBuildCollections_NothingBilled(t *testing.T) {
+	report := buildCollections(nil, date("2025-03-31"), 2)
+
+	require.Nil(t, report.DSO)
+	require.Nil(t, report.AvgDaysToPay)
+	require.Len(t, report.Trend, 2)
+	require.Empty(t, report.Customers)
+}
@@ -0,0 +1,210 @@
+package query
+
+import (
+	"context"
+	"invoice-api/internal/database/tenant"
+	invoice_model "invoice-api/internal/features/invoice/model"
+	"invoice-api/internal/features/report/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type DefaultReportQuery struct {
+	OrgID primitive.ObjectID
+}
+
+func (c *DefaultReportQuery) CollectionName() string {
+	return "invoices"
+}
+
+type ReportQuery interface {
+	GetARAging(asOf time.Time, customerID string, details bool) (*model.AgingReport, error)
+	GetCollections(asOf time.Time, months int) (*model.CollectionReport, error)
+}
+
+// GetARAging buckets what each customer owed at the end of asOf by how many
+// days past due it was. An invoice is outstanding when it was dated on or
+// before asOf and was not paid by then; cancelled and void invoices are never
+// outstanding. With details, or for a single customer, the outstanding
+// invoices are included.
+func (c *DefaultReportQuery) GetARAging(asOf time.Time, customerID string, details bool) (*model.AgingReport, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	match := bson.M{"status": bson.M{"$nin": bson.A{"cancelled", "void"}}}
+	if customerID != "" {
+		objID, err := primitive.ObjectIDFromHex(customerID)
+		if err != nil {
+			return nil, err
+		}
+		match["customerId"] = objID
+		details = true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Aggregate(ctx, agingPipeline(match, asOf, details))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var result []struct {
+		Customers []model.AgingCustomer `bson:"customers"`
+		Totals    []model.AgingBuckets  `bson:"totals"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	report := &model.AgingReport{
+		AsOf:      asOf.Format(time.DateOnly),
+		Customers: make([]model.AgingCustomer, 0),
+	}
+	if len(result) > 0 {
+		if result[0].Customers != nil {
+			report.Customers = result[0].Customers
+		}
+		if len(result[0].Totals) > 0 {
+			report.Totals = result[0].Totals[0]
+		}
+	}
+
+	return report, nil
+}
+
+// GetCollections returns DSO, days-to-pay and on-time payment figures for the
+// months window ending with the month of asOf, overall, per month and per
+// customer. Cancelled and void invoices are left out.
+func (c *DefaultReportQuery) GetCollections(asOf time.Time, months int) (*model.CollectionReport, error) {
+	const dayMillis = 24 * 60 * 60 * 1000
+
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": bson.M{"$nin": bson.A{"cancelled", "void"}}}}},
+		{{Key: "$addFields", Value: bson.M{
+			"issued": bson.M{"$dateFromString": bson.M{"dateString": "$date", "onError": nil, "onNull": nil}},
+		}}},
+		{{Key: "$match", Value: bson.M{"issued": bson.M{"$ne": nil}}}},
+		{{Key: "$project", Value: bson.M{
+			"customerId": 1,
+			"name":       "$customer.name",
+			"email":      "$customer.email",
+			"amount":     1,
+			"issued":     1,
+			"due": bson.M{"$ifNull": bson.A{
+				bson.M{"$dateFromString": bson.M{"dateString": "$dueDate", "onError": nil, "onNull": nil}},
+				bson.M{"$add": bson.A{"$issued", invoice_model.DefaultPaymentTermsDays * dayMillis}},
+			}},
+			"paidOn": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$status", invoice_model.StatusPaid}},
+				bson.M{"$ifNull": bson.A{"$paidAt", "$updatedAt"}},
+				nil,
+			}},
+		}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var invoices []collectionInvoice
+	if err := cursor.All(ctx, &invoices); err != nil {
+		return nil, err
+	}
+
+	return buildCollections(invoices, asOf, months), nil
+}
+
+// agingPipeline works out each outstanding invoice's bucket, then groups by
+// customer and totals the buckets in a single aggregation. Invoices without a
+// due date fall due DefaultPaymentTermsDays after their date.
+func agingPipeline(match bson.M, asOf time.Time, details bool) mongo.Pipeline {
+	const dayMillis = 24 * 60 * 60 * 1000
+	endOfDay := asOf.AddDate(0, 0, 1)
+
+	branches := bson.A{}
+	for _, bound := range model.AgingBucketBounds {
+		branches = append(branches, bson.M{
+			"case": bson.M{"$lte": bson.A{"$daysPastDue", bound.MaxDays}},
+			"then": bound.Bucket,
+		})
+	}
+	sumBucket := func(bucket string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$bucket", bucket}}, "$amount", 0}}}
+	}
+
+	group := bson.M{
+		"_id":        "$customerId",
+		"name":       bson.M{"$first": "$customer.name"},
+		"email":      bson.M{"$first": "$customer.email"},
+		"current":    sumBucket(model.BucketCurrent),
+		"days1To30":  sumBucket(model.Bucket1To30),
+		"days31To60": sumBucket(model.Bucket31To60),
+		"days61To90": sumBucket(model.Bucket61To90),
+		"over90":     sumBucket(model.BucketOver90),
+		"total":      bson.M{"$sum": "$amount"},
+	}
+	if details {
+		group["invoices"] = bson.M{"$push": bson.M{
+			"_id":         "$_id",
+			"date":        "$date",
+			"dueDate":     bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$due"}},
+			"amount":      "$amount",
+			"daysPastDue": "$daysPastDue",
+			"bucket":      "$bucket",
+		}}
+	}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$addFields", Value: bson.M{
+			"issued": bson.M{"$dateFromString": bson.M{"dateString": "$date", "onError": nil, "onNull": nil}},
+			"paidOn": bson.M{"$ifNull": bson.A{"$paidAt", "$updatedAt"}},
+		}}},
+		{{Key: "$match", Value: bson.M{
+			"issued": bson.M{"$lt": endOfDay},
+			"$or": bson.A{
+				bson.M{"status": bson.M{"$ne": "paid"}},
+				bson.M{"paidOn": bson.M{"$gte": endOfDay}},
+			},
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"due": bson.M{"$ifNull": bson.A{
+				bson.M{"$dateFromString": bson.M{"dateString": "$dueDate", "onError": nil, "onNull": nil}},
+				bson.M{"$add": bson.A{"$issued", invoice_model.DefaultPaymentTermsDays * dayMillis}},
+			}},
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"daysPastDue": bson.M{"$floor": bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{asOf, "$due"}}, dayMillis}}},
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"bucket": bson.M{"$switch": bson.M{"branches": branches, "default": model.BucketOver90}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "daysPastDue", Value: -1}}}},
+		{{Key: "$group", Value: group}},
+		{{Key: "$facet", Value: bson.M{
+			"customers": bson.A{bson.M{"$sort": bson.D{{Key: "total", Value: -1}, {Key: "name", Value: 1}}}},
+			"totals": bson.A{bson.M{"$group": bson.M{
+				"_id":        nil,
+				"current":    bson.M{"$sum": "$current"},
+				"days1To30":  bson.M{"$sum": "$days1To30"},
+				"days31To60": bson.M{"$sum": "$days31To60"},
+				"days61To90": bson.M{"$sum": "$days61To90"},
+				"over90":     bson.M{"$sum": "$over90"},
+				"total":      bson.M{"$sum": "$total"},
+			}}},
+		}}},
+	}
+}
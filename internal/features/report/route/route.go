@@ -0,0 +1,19 @@
+package route
+
+import (
+	"invoice-api/internal/features/report/controller"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ReportRoute struct{}
+
+func (s *ReportRoute) Init(router *fiber.App) {
+	controller := new(controller.ReportController)
+	reports := router.Group("/reports")
+
+	reports.Get("/ar-aging", middleware.RequirePermission(user_model.PermReportRead), controller.GetARAging)
+	reports.Get("/collections", middleware.RequirePermission(user_model.PermReportRead), controller.GetCollections)
+}
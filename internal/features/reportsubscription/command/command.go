@@ -0,0 +1,218 @@
+package command
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
+	"invoice-api/internal/features/reportsubscription/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DefaultCommand struct {
+	OrgID primitive.ObjectID
+}
+
+func (c *DefaultCommand) CollectionName() string {
+	return "report_subscriptions"
+}
+
+type Command interface {
+	CreateItem(_val *model.CreateReportSubscription) (*mongo.InsertOneResult, error)
+	UpdateItem(id string, _val *model.UpdateReportSubscription) (*mongo.UpdateResult, error)
+	DeleteItem(id string) (*mongo.DeleteResult, error)
+	ClaimRun(id primitive.ObjectID, due time.Time, next time.Time) (bool, error)
+	RecordRun(id primitive.ObjectID, ranAt time.Time, runErr error) error
+}
+
+// EnsureIndexes indexes enabled subscriptions by when they are next due.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("report_subscriptions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "enabled", Value: 1}, {Key: "nextRunAt", Value: 1}},
+		Options: options.Index().SetName("enabled_nextRunAt"),
+	})
+	return err
+}
+
+func (c *DefaultCommand) CreateItem(_val *model.CreateReportSubscription) (*mongo.InsertOneResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	if err := model.ValidateParams(_val.ReportType, _val.Params); err != nil {
+		return nil, err
+	}
+	tz, _, err := model.ResolveTimezone(_val.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	next, err := model.NextRun(_val.Schedule, tz, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &model.ReportSubscription{
+		Name:       _val.Name,
+		ReportType: _val.ReportType,
+		Params:     _val.Params,
+		Schedule:   _val.Schedule,
+		Timezone:   tz,
+		Recipients: _val.Recipients,
+		Format:     _val.Format,
+		Enabled:    _val.Enabled == nil || *_val.Enabled,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	if doc.Enabled {
+		doc.NextRunAt = &next
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := collection.InsertOne(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// UpdateItem applies the changes and works out when the subscription is next
+// due from its resulting schedule and timezone.
+func (c *DefaultCommand) UpdateItem(id string, _val *model.UpdateReportSubscription) (*mongo.UpdateResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var current model.ReportSubscription
+	if err := collection.FindOne(ctx, bson.M{"_id": objId}).Decode(&current); err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"updatedAt": time.Now()}
+	if _val.Name != nil {
+		set["name"] = *_val.Name
+	}
+	if _val.Params != nil {
+		if err := model.ValidateParams(current.ReportType, _val.Params); err != nil {
+			return nil, err
+		}
+		set["params"] = _val.Params
+	}
+	if _val.Schedule != nil {
+		current.Schedule = *_val.Schedule
+		set["schedule"] = current.Schedule
+	}
+	if _val.Timezone != nil {
+		tz, _, err := model.ResolveTimezone(*_val.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		current.Timezone = tz
+		set["timezone"] = tz
+	}
+	if _val.Recipients != nil {
+		set["recipients"] = _val.Recipients
+	}
+	if _val.Format != nil {
+		set["format"] = *_val.Format
+	}
+	if _val.Enabled != nil {
+		current.Enabled = *_val.Enabled
+		set["enabled"] = current.Enabled
+	}
+
+	update := bson.M{"$set": set}
+	next, err := model.NextRun(current.Schedule, current.Timezone, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if current.Enabled {
+		set["nextRunAt"] = next
+	} else {
+		update["$unset"] = bson.M{"nextRunAt": ""}
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objId}, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return result, nil
+}
+
+func (c *DefaultCommand) DeleteItem(id string) (*mongo.DeleteResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := collection.DeleteOne(ctx, bson.M{"_id": objId})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.DeletedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return res, nil
+}
+
+// ClaimRun moves a due subscription on to its next run. It only succeeds for
+// the caller that sees nextRunAt still at due, so when several instances
+// run the scheduler a report is sent once. Like GetDue it works across
+// organizations, and only takes IDs read back from the database.
+func (c *DefaultCommand) ClaimRun(id primitive.ObjectID, due time.Time, next time.Time) (bool, error) {
+	collection := database.GetDatabase().Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": id, "enabled": true, "nextRunAt": due},
+		bson.M{"$set": bson.M{"nextRunAt": next}})
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount == 1, nil
+}
+
+// RecordRun stores when the subscription last ran and why it failed, if it
+// did. It is not scoped, for the same reason as ClaimRun.
+func (c *DefaultCommand) RecordRun(id primitive.ObjectID, ranAt time.Time, runErr error) error {
+	collection := database.GetDatabase().Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"lastRunAt": ranAt}, "$unset": bson.M{"lastError": ""}}
+	if runErr != nil {
+		update = bson.M{"$set": bson.M{"lastRunAt": ranAt, "lastError": runErr.Error()}}
+	}
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"context"
+	"invoice-api/internal/features/reportsubscription/command"
+	"invoice-api/internal/features/reportsubscription/model"
+	"invoice-api/internal/features/reportsubscription/query"
+	"invoice-api/internal/features/reportsubscription/scheduler"
+	"invoice-api/middleware"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Runner sends a subscription's report straight away.
+type Runner interface {
+	Run(ctx context.Context, sub *model.ReportSubscriptionDTO, now time.Time) error
+}
+
+type ReportSubscriptionController struct {
+	Command command.Command
+	Query   query.Query
+	Runner  Runner
+}
+
+// query returns the injected query, or one scoped to the organization the
+// request was authorized for.
+func (s *ReportSubscriptionController) query(c *fiber.Ctx) query.Query {
+	if s.Query != nil {
+		return s.Query
+	}
+	return &query.DefaultQuery{OrgID: middleware.OrgID(c)}
+}
+
+// command returns the injected command, or one scoped to the organization
+// the request was authorized for.
+func (s *ReportSubscriptionController) command(c *fiber.Ctx) command.Command {
+	if s.Command != nil {
+		return s.Command
+	}
+	return &command.DefaultCommand{OrgID: middleware.OrgID(c)}
+}
+
+func (s *ReportSubscriptionController) CreateReportSubscription(c *fiber.Ctx) error {
+	payload := new(model.CreateReportSubscription)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(err.Error())
+	}
+
+	validationErrors := model.ValidateStruct(payload)
+	if validationErrors != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
+	}
+
+	resp, err := s.command(c).CreateItem(payload)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(201).JSON(resp)
+}
+
+func (s *ReportSubscriptionController) GetAllReportSubscriptions(c *fiber.Ctx) error {
+	items, err := s.query(c).GetItemsByQuery()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(items)
+}
+
+func (s *ReportSubscriptionController) GetReportSubscriptionByID(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	item, err := s.query(c).GetItemByID(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Report subscription not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch report subscription",
+		})
+	}
+
+	return c.JSON(item)
+}
+
+func (s *ReportSubscriptionController) UpdateReportSubscription(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	payload := new(model.UpdateReportSubscription)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(err.Error())
+	}
+
+	validationErrors := model.ValidateStruct(payload)
+	if validationErrors != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
+	}
+
+	_, err := s.command(c).UpdateItem(id, payload)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Report subscription not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Report subscription updated successfully",
+	})
+}
+
+func (s *ReportSubscriptionController) DeleteReportSubscription(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	_, err := s.command(c).DeleteItem(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Report subscription not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to delete report subscription",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Report subscription deleted successfully",
+	})
+}
+
+// RunReportSubscription sends the report now, without changing when it is
+// next due.
+func (s *ReportSubscriptionController) RunReportSubscription(c *fiber.Ctx) error {
+	if s.Runner == nil {
+		s.Runner = scheduler.New()
+	}
+	id := c.Params("id")
+
+	item, err := s.query(c).GetItemByID(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "Report subscription not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch report subscription",
+		})
+	}
+
+	now := time.Now()
+	runErr := s.Runner.Run(c.Context(), item, now)
+	if err := s.command(c).RecordRun(item.ID, now, runErr); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to record report run",
+		})
+	}
+	if runErr != nil {
+		return c.Status(502).JSON(fiber.Map{
+			"error": runErr.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Report sent successfully",
+	})
+}
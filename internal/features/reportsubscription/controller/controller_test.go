@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"invoice-api/internal/features/reportsubscription/model"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mockCommand struct {
+	created  *model.CreateReportSubscription
+	recorded error
+}
+
+func (m *mockCommand) CreateItem(_val *model.CreateReportSubscription) (*mongo.InsertOneResult, error) {
+	if err := model.ValidateParams(_val.ReportType, _val.Params); err != nil {
+		return nil, err
+	}
+	m.created = _val
+	return &mongo.InsertOneResult{InsertedID: primitive.NewObjectID()}, nil
+}
+func (m *mockCommand) UpdateItem(id string, _val *model.UpdateReportSubscription) (*mongo.UpdateResult, error) {
+	return nil, mongo.ErrNoDocuments
+}
+func (m *mockCommand) DeleteItem(id string) (*mongo.DeleteResult, error) {
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+func (m *mockCommand) ClaimRun(id primitive.ObjectID, due time.Time, next time.Time) (bool, error) {
+	return true, nil
+}
+func (m *mockCommand) RecordRun(id primitive.ObjectID, ranAt time.Time, runErr error) error {
+	m.recorded = runErr
+	return nil
+}
+
+type mockQuery struct {
+	item *model.ReportSubscriptionDTO
+}
+
+func (m *mockQuery) GetItemsByQuery() ([]model.ReportSubscriptionDTO, error) {
+	return []model.ReportSubscriptionDTO{*m.item}, nil
+}
+func (m *mockQuery) GetItemByID(id string) (*model.ReportSubscriptionDTO, error) {
+	if m.item == nil || m.item.ID.Hex() != id {
+		return nil, mongo.ErrNoDocuments
+	}
+	return m.item, nil
+}
+func (m *mockQuery) GetDue(now time.Time) ([]model.ReportSubscriptionDTO, error) { return nil, nil }
+
+type mockRunner struct {
+	err error
+	ran *model.ReportSubscriptionDTO
+}
+
+func (m *mockRunner) Run(ctx context.Context, sub *model.ReportSubscriptionDTO, now time.Time) error {
+	m.ran = sub
+	return m.err
+}
+
+func TestCreateReportSubscription(t *testing.T) {
+	app := fiber.New()
+	cmd := &mockCommand{}
+	ctrl := &ReportSubscriptionController{Command: cmd}
+	app.Post("/report-subscriptions", ctrl.CreateReportSubscription)
+
+	post := func(body string) int {
+		req := httptest.NewRequest("POST", "/report-subscriptions", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	require.Equal(t, 201, post(`{"reportType":"revenue","params":{"basis":"accrual"},"schedule":"0 8 * * MON","recipients":["cfo@example.com"],"format":"csv"}`))
+	require.Equal(t, "0 8 * * MON", cmd.created.Schedule)
+
+	require.Equal(t, 400, post(`{"reportType":"revenue","schedule":"0 8 * * MON","recipients":["not-an-email"],"format":"csv"}`))
+	require.Equal(t, 400, post(`{"reportType":"revenue","schedule":"0 8 * * MON","recipients":["cfo@example.com"],"format":"pdf"}`))
+	require.Equal(t, 400, post(`{"reportType":"revenue","params":{"keyword":"x"},"schedule":"0 8 * * MON","recipients":["cfo@example.com"],"format":"csv"}`))
+}
+
+func TestRunReportSubscription(t *testing.T) {
+	item := &model.ReportSubscriptionDTO{ID: primitive.NewObjectID(), ReportType: model.ReportInvoiceStatus}
+	cmd := &mockCommand{}
+	runner := &mockRunner{}
+	ctrl := &ReportSubscriptionController{Command: cmd, Query: &mockQuery{item: item}, Runner: runner}
+	app := fiber.New()
+	app.Post("/report-subscriptions/:id/run", ctrl.RunReportSubscription)
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/report-subscriptions/"+item.ID.Hex()+"/run", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, item, runner.ran)
+
+	runner.err = errors.New("connection refused")
+	resp, err = app.Test(httptest.NewRequest("POST", "/report-subscriptions/"+item.ID.Hex()+"/run", nil))
+	require.NoError(t, err)
+	require.Equal(t, 502, resp.StatusCode)
+	require.EqualError(t, cmd.recorded, "connection refused")
+
+	resp, err = app.Test(httptest.NewRequest("POST", "/report-subscriptions/"+primitive.NewObjectID().Hex()+"/run", nil))
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode)
+}
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var validate = validator.New()
+
+// Report types that can be subscribed to.
+const (
+	ReportInvoiceStatus = "invoice-status"
+	ReportRevenue       = "revenue"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatHTML = "html"
+)
+
+// DefaultRevenueMonths is how many months a revenue report covers when its
+// `months` parameter is not set.
+const DefaultRevenueMonths = 12
+
+// ReportSubscription emails a report to its recipients whenever Schedule, a
+// cron expression evaluated in Timezone, comes due. NextRunAt is the next time
+// it is due while it is enabled.
+type ReportSubscription struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	ReportType string             `bson:"reportType" json:"reportType"`
+	Params     map[string]string  `bson:"params,omitempty" json:"params,omitempty"`
+	Schedule   string             `bson:"schedule" json:"schedule"`
+	Timezone   string             `bson:"timezone" json:"timezone"`
+	Recipients []string           `bson:"recipients" json:"recipients"`
+	Format     string             `bson:"format" json:"format"`
+	Enabled    bool               `bson:"enabled" json:"enabled"`
+	NextRunAt  *time.Time         `bson:"nextRunAt,omitempty" json:"nextRunAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
+	UpdatedAt  time.Time          `bson:"updatedAt,omitempty" json:"updatedAt"`
+}
+
+type ReportSubscriptionDTO struct {
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	OrgID      primitive.ObjectID `bson:"orgId" json:"orgId"`
+	Name       string             `bson:"name" json:"name"`
+	ReportType string             `bson:"reportType" json:"reportType"`
+	Params     map[string]string  `bson:"params" json:"params,omitempty"`
+	Schedule   string             `bson:"schedule" json:"schedule"`
+	Timezone   string             `bson:"timezone" json:"timezone"`
+	Recipients []string           `bson:"recipients" json:"recipients"`
+	Format     string             `bson:"format" json:"format"`
+	Enabled    bool               `bson:"enabled" json:"enabled"`
+	NextRunAt  *time.Time         `bson:"nextRunAt" json:"nextRunAt,omitempty"`
+	LastRunAt  *time.Time         `bson:"lastRunAt" json:"lastRunAt,omitempty"`
+	LastError  string             `bson:"lastError" json:"lastError,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt,omitzero"`
+}
+
+type CreateReportSubscription struct {
+	Name       string            `json:"name"`
+	ReportType string            `json:"reportType" validate:"required,oneof=invoice-status revenue"`
+	Params     map[string]string `json:"params"`
+	Schedule   string            `json:"schedule" validate:"required"`
+	Timezone   string            `json:"timezone"`
+	Recipients []string          `json:"recipients" validate:"required,min=1,dive,email"`
+	Format     string            `json:"format" validate:"required,oneof=csv html"`
+	// Enabled defaults to true.
+	Enabled *bool `json:"enabled"`
+}
+
+type UpdateReportSubscription struct {
+	Name       *string           `json:"name"`
+	Params     map[string]string `json:"params"`
+	Schedule   *string           `json:"schedule"`
+	Timezone   *string           `json:"timezone"`
+	Recipients []string          `json:"recipients" validate:"omitempty,min=1,dive,email"`
+	Format     *string           `json:"format" validate:"omitempty,oneof=csv html"`
+	Enabled    *bool             `json:"enabled"`
+}
+
+type ErrorResponse struct {
+	Field string `json:"field"`
+	Tag   string `json:"tag"`
+	Value string `json:"value,omitempty"`
+}
+
+// ResolveTimezone returns tz, or ORG_TIMEZONE, or UTC when neither is set.
+func ResolveTimezone(tz string) (string, *time.Location, error) {
+	if tz == "" {
+		tz = os.Getenv("ORG_TIMEZONE")
+	}
+	if tz == "" {
+		tz = "UTC"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid timezone %q", tz)
+	}
+	return tz, loc, nil
+}
+
+// NextRun returns when a subscription with the given schedule and timezone is
+// next due after after.
+func NextRun(schedule string, tz string, after time.Time) (time.Time, error) {
+	s, err := ParseSchedule(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, loc, err := ResolveTimezone(tz)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := s.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("schedule %q never runs", schedule)
+	}
+	return next.UTC(), nil
+}
+
+// ValidateParams checks the parameters a report type accepts: `keyword` for
+// invoice-status; `basis` (cash or accrual) and `months` for revenue.
+func ValidateParams(reportType string, params map[string]string) error {
+	for key, value := range params {
+		switch {
+		case reportType == ReportInvoiceStatus && key == "keyword":
+		case reportType == ReportRevenue && key == "basis":
+			if value != "cash" && value != "accrual" {
+				return errors.New("basis must be cash or accrual")
+			}
+		case reportType == ReportRevenue && key == "months":
+			if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 120 {
+				return errors.New("months must be between 1 and 120")
+			}
+		default:
+			return fmt.Errorf("unknown parameter %q for %s report", key, reportType)
+		}
+	}
+	return nil
+}
+
+func ValidateStruct[T any](payload T) []ErrorResponse {
+	var errors []ErrorResponse
+	err := validate.Struct(payload)
+	if err != nil {
+		for _, err := range err.(validator.ValidationErrors) {
+			var element ErrorResponse
+			element.Field = err.StructNamespace()
+			element.Tag = err.Tag()
+			element.Value = err.Param()
+			errors = append(errors, element)
+		}
+	}
+	return errors
+}
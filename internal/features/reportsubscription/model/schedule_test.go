@@ -0,0 +1,55 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", value)
+		require.NoError(t, err)
+		return parsed
+	}
+
+	cases := []struct {
+		expr  string
+		after string
+		want  string
+	}{
+		{"0 8 * * MON", "2025-06-04 10:00", "2025-06-09 08:00"},
+		{"0 8 * * 1", "2025-06-09 07:59", "2025-06-09 08:00"},
+		{"0 8 * * 1", "2025-06-09 08:00", "2025-06-16 08:00"},
+		{"*/15 * * * *", "2025-06-09 08:07", "2025-06-09 08:15"},
+		{"30 9 1 * *", "2025-06-09 08:00", "2025-07-01 09:30"},
+		{"0 0 1,15 * 5", "2025-06-01 00:00", "2025-06-06 00:00"},
+		{"0 12 * * 1-5", "2025-06-07 00:00", "2025-06-09 12:00"},
+		{"0 0 29 2 *", "2025-03-01 00:00", "2028-02-29 00:00"},
+		{"@weekly", "2025-06-09 00:00", "2025-06-15 00:00"},
+		{"0 6 * * 7", "2025-06-09 00:00", "2025-06-15 06:00"},
+	}
+	for _, tc := range cases {
+		s, err := ParseSchedule(tc.expr)
+		require.NoError(t, err, tc.expr)
+		require.Equal(t, at(tc.want), s.Next(at(tc.after)), tc.expr)
+	}
+}
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "0 24 * * *", "0 0 0 * *", "0 0 * 13 *", "0 0 * * FUNDAY", "*/0 * * * *", "5-1 * * * *"} {
+		_, err := ParseSchedule(expr)
+		require.Error(t, err, expr)
+	}
+}
+
+func TestNextRun_Timezone(t *testing.T) {
+	after := time.Date(2025, 6, 9, 0, 0, 0, 0, time.UTC)
+	next, err := NextRun("0 8 * * MON", "Asia/Singapore", after)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2025, 6, 16, 0, 0, 0, 0, time.UTC), next)
+
+	_, err = NextRun("0 0 31 2 *", "UTC", after)
+	require.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package query
+
+import (
+	"context"
+	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
+	"invoice-api/internal/features/reportsubscription/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DefaultQuery struct {
+	OrgID primitive.ObjectID
+}
+
+func (c *DefaultQuery) CollectionName() string {
+	return "report_subscriptions"
+}
+
+type Query interface {
+	GetItemsByQuery() ([]model.ReportSubscriptionDTO, error)
+	GetItemByID(id string) (*model.ReportSubscriptionDTO, error)
+	GetDue(now time.Time) ([]model.ReportSubscriptionDTO, error)
+}
+
+func (c *DefaultQuery) GetItemsByQuery() ([]model.ReportSubscriptionDTO, error) {
+	return c.find(bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}))
+}
+
+func (c *DefaultQuery) GetItemByID(id string) (*model.ReportSubscriptionDTO, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var item model.ReportSubscriptionDTO
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&item); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// GetDue returns the enabled subscriptions whose next run is at or before
+// now, most overdue first. The scheduler serves every organization, so unlike
+// the other queries GetDue is not scoped; each subscription carries its OrgID.
+func (c *DefaultQuery) GetDue(now time.Time) ([]model.ReportSubscriptionDTO, error) {
+	collection := database.GetDatabase().Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"enabled": true, "nextRunAt": bson.M{"$lte": now}}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "nextRunAt", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]model.ReportSubscriptionDTO, 0)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (c *DefaultQuery) find(filter bson.M, opts *options.FindOptions) ([]model.ReportSubscriptionDTO, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]model.ReportSubscriptionDTO, 0)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
@@ -0,0 +1,24 @@
+package route
+
+import (
+	"invoice-api/internal/features/reportsubscription/controller"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ReportSubscriptionRoute struct{}
+
+func (s *ReportSubscriptionRoute) Init(router *fiber.App) {
+	controller := new(controller.ReportSubscriptionController)
+	subscriptions := router.Group("/report-subscriptions")
+	subscribe := middleware.RequirePermission(user_model.PermReportSubscribe)
+
+	subscriptions.Post("/", subscribe, controller.CreateReportSubscription)
+	subscriptions.Get("/", subscribe, controller.GetAllReportSubscriptions)
+	subscriptions.Get("/:id", subscribe, controller.GetReportSubscriptionByID)
+	subscriptions.Patch("/:id", subscribe, controller.UpdateReportSubscription)
+	subscriptions.Delete("/:id", subscribe, controller.DeleteReportSubscription)
+	subscriptions.Post("/:id/run", subscribe, controller.RunReportSubscription)
+}
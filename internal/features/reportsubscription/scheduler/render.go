@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"strconv"
+	"time"
+
+	invoice_model "invoice-api/internal/features/invoice/model"
+	"invoice-api/internal/features/reportsubscription/model"
+	revenue_model "invoice-api/internal/features/revenue/model"
+)
+
+// Report is a table with a header row, body rows and a footer of totals that
+// can be rendered as CSV or HTML.
+type Report struct {
+	Title   string
+	Columns []string
+	Rows    [][]string
+	Totals  []string
+}
+
+func money(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// invoiceStatusReport lists each customer's invoice counts by status.
+func invoiceStatusReport(customers []invoice_model.InvoiceCustomers) *Report {
+	report := &Report{
+		Title:   "Invoice status by customer",
+		Columns: []string{"Customer", "Email", "Invoices", "Pending", "Paid"},
+		Rows:    make([][]string, 0, len(customers)),
+	}
+	var invoices, pending, paid int64
+	for _, c := range customers {
+		report.Rows = append(report.Rows, []string{
+			c.Name, c.Email,
+			strconv.FormatInt(c.TotalInvoices, 10),
+			strconv.FormatInt(c.TotalPending, 10),
+			strconv.FormatInt(c.TotalPaid, 10),
+		})
+		invoices, pending, paid = invoices+c.TotalInvoices, pending+c.TotalPending, paid+c.TotalPaid
+	}
+	report.Totals = []string{"Total", "",
+		strconv.FormatInt(invoices, 10), strconv.FormatInt(pending, 10), strconv.FormatInt(paid, 10)}
+	return report
+}
+
+// revenueReport summarises the months months up to and including the month
+// of now.
+func revenueReport(items []revenue_model.MonthlyRevenue, basis string, months int, now time.Time) *Report {
+	last := revenue_model.Period{Year: now.Year(), Month: now.Month()}
+	first := last.AddMonths(1 - months).String()
+
+	report := &Report{
+		Title:   fmt.Sprintf("Revenue (%s basis), %s to %s", basis, first, last),
+		Columns: []string{"Period", "Invoices", "Computed", "Adjustment", "Revenue", "Source"},
+		Rows:    make([][]string, 0, months),
+	}
+	var invoices int64
+	var computed, adjustment, revenue float64
+	for _, item := range items {
+		if item.Period < first || item.Period > last.String() {
+			continue
+		}
+		report.Rows = append(report.Rows, []string{
+			item.Period, strconv.FormatInt(item.Invoices, 10),
+			money(item.Computed), money(item.Adjustment), money(item.Revenue), item.Source,
+		})
+		invoices += item.Invoices
+		computed, adjustment, revenue = computed+item.Computed, adjustment+item.Adjustment, revenue+item.Revenue
+	}
+	report.Totals = []string{"Total", strconv.FormatInt(invoices, 10), money(computed), money(adjustment), money(revenue), ""}
+	return report
+}
+
+func (r *Report) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(r.Columns)
+	w.WriteAll(r.Rows)
+	w.Write(r.Totals)
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<table border="1" cellpadding="4" cellspacing="0">
+<thead><tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</tbody>
+<tfoot><tr>{{range .Totals}}<th>{{.}}</th>{{end}}</tr></tfoot>
+</table>
+</body>
+</html>
+`))
+
+func (r *Report) HTML() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := htmlReport.Execute(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Encode renders the report in format and returns the content type to send it
+// with.
+func (r *Report) Encode(format string) ([]byte, string, error) {
+	if format == model.FormatHTML {
+		data, err := r.HTML()
+		return data, "text/html; charset=utf-8", err
+	}
+	data, err := r.CSV()
+	return data, "text/csv; charset=utf-8", err
+}
@@ -0,0 +1,190 @@
+// Package scheduler runs report subscriptions when they come due and emails
+// the rendered report to their recipients.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"invoice-api/internal/features/invoice/model"
+	invoice_query "invoice-api/internal/features/invoice/query"
+	"invoice-api/internal/features/reportsubscription/command"
+	subscription_model "invoice-api/internal/features/reportsubscription/model"
+	"invoice-api/internal/features/reportsubscription/query"
+	revenue_model "invoice-api/internal/features/revenue/model"
+	revenue_query "invoice-api/internal/features/revenue/query"
+	"invoice-api/internal/mailer"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultInterval is how often the scheduler looks for due subscriptions.
+// Schedules have minute resolution.
+const DefaultInterval = time.Minute
+
+// InvoiceSource and RevenueSource are the parts of the invoice and revenue
+// queries that reports are built from. The scheduler asks for them per
+// subscription, scoped to the subscription's organization.
+type InvoiceSource interface {
+	GetCustomersInvoices(keyword string) ([]model.InvoiceCustomers, error)
+}
+
+type RevenueSource interface {
+	GetItemsByQuery(basis string) ([]revenue_model.MonthlyRevenue, error)
+}
+
+type Scheduler struct {
+	Query    query.Query
+	Command  command.Command
+	Mailer   mailer.Mailer
+	Invoices func(orgID primitive.ObjectID) InvoiceSource
+	Revenue  func(orgID primitive.ObjectID) RevenueSource
+	Interval time.Duration
+}
+
+// New returns a scheduler backed by the database and the mailer configured
+// by the environment.
+func New() *Scheduler {
+	return &Scheduler{
+		Query:   &query.DefaultQuery{},
+		Command: &command.DefaultCommand{},
+		Mailer:  mailer.New(),
+		Invoices: func(orgID primitive.ObjectID) InvoiceSource {
+			return &invoice_query.DefaultInvoiceQuery{OrgID: orgID}
+		},
+		Revenue: func(orgID primitive.ObjectID) RevenueSource {
+			return &revenue_query.DefaultRevenueQuery{OrgID: orgID}
+		},
+		Interval: DefaultInterval,
+	}
+}
+
+// Start runs due subscriptions every Interval until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RunDue(ctx, time.Now()); err != nil {
+			log.Printf("report scheduler: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue sends every subscription that is due at now. Each one is claimed
+// first by moving it to its next run, so a report that fails is not retried
+// until then; the failure is recorded on the subscription.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) error {
+	due, err := s.Query.GetDue(now)
+	if err != nil {
+		return err
+	}
+
+	for i := range due {
+		sub := &due[i]
+		next, err := subscription_model.NextRun(sub.Schedule, sub.Timezone, now)
+		if err != nil {
+			log.Printf("report scheduler: subscription %s: %v", sub.ID.Hex(), err)
+			continue
+		}
+		claimed, err := s.Command.ClaimRun(sub.ID, *sub.NextRunAt, next)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		runErr := s.Run(ctx, sub, now)
+		if runErr != nil {
+			log.Printf("report scheduler: subscription %s: %v", sub.ID.Hex(), runErr)
+		}
+		if err := s.Command.RecordRun(sub.ID, now, runErr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run renders the subscription's report and emails it as an attachment.
+func (s *Scheduler) Run(ctx context.Context, sub *subscription_model.ReportSubscriptionDTO, now time.Time) error {
+	report, err := s.Render(sub, now)
+	if err != nil {
+		return err
+	}
+	data, contentType, err := report.Encode(sub.Format)
+	if err != nil {
+		return err
+	}
+
+	_, loc, err := subscription_model.ResolveTimezone(sub.Timezone)
+	if err != nil {
+		return err
+	}
+	date := now.In(loc).Format(time.DateOnly)
+	subject := report.Title
+	if sub.Name != "" {
+		subject = sub.Name
+	}
+
+	return s.Mailer.Send(ctx, mailer.Message{
+		To:      sub.Recipients,
+		Subject: fmt.Sprintf("%s - %s", subject, date),
+		Text:    fmt.Sprintf("%s as of %s is attached.\n", report.Title, date),
+		Attachments: []mailer.Attachment{{
+			Filename:    fmt.Sprintf("%s-%s.%s", sub.ReportType, date, sub.Format),
+			ContentType: contentType,
+			Data:        data,
+		}},
+	})
+}
+
+// Render builds the subscription's report from its parameters.
+func (s *Scheduler) Render(sub *subscription_model.ReportSubscriptionDTO, now time.Time) (*Report, error) {
+	switch sub.ReportType {
+	case subscription_model.ReportInvoiceStatus:
+		customers, err := s.Invoices(sub.OrgID).GetCustomersInvoices(sub.Params["keyword"])
+		if err != nil {
+			return nil, err
+		}
+		return invoiceStatusReport(customers), nil
+
+	case subscription_model.ReportRevenue:
+		basis := sub.Params["basis"]
+		if basis == "" {
+			basis = revenue_model.BasisCash
+		}
+		months := subscription_model.DefaultRevenueMonths
+		if value := sub.Params["months"]; value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, err
+			}
+			months = n
+		}
+		items, err := s.Revenue(sub.OrgID).GetItemsByQuery(basis)
+		if err != nil {
+			return nil, err
+		}
+		_, loc, err := subscription_model.ResolveTimezone(sub.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		return revenueReport(items, basis, months, now.In(loc)), nil
+	}
+
+	return nil, fmt.Errorf("unknown report type %q", sub.ReportType)
+}
@@ -0,0 +1,219 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	invoice_model "invoice-api/internal/features/invoice/model"
+	"invoice-api/internal/features/reportsubscription/model"
+	revenue_model "invoice-api/internal/features/revenue/model"
+	"invoice-api/internal/mailer"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mockQuery struct {
+	due []model.ReportSubscriptionDTO
+}
+
+func (m *mockQuery) GetItemsByQuery() ([]model.ReportSubscriptionDTO, error) { return m.due, nil }
+func (m *mockQuery) GetItemByID(id string) (*model.ReportSubscriptionDTO, error) {
+	return nil, mongo.ErrNoDocuments
+}
+func (m *mockQuery) GetDue(now time.Time) ([]model.ReportSubscriptionDTO, error) { return m.due, nil }
+
+type mockCommand struct {
+	claimed  map[primitive.ObjectID]time.Time
+	refuse   bool
+	recorded map[primitive.ObjectID]error
+}
+
+func (m *mockCommand) CreateItem(_val *model.CreateReportSubscription) (*mongo.InsertOneResult, error) {
+	return nil, nil
+}
+func (m *mockCommand) UpdateItem(id string, _val *model.UpdateReportSubscription) (*mongo.UpdateResult, error) {
+	return nil, nil
+}
+func (m *mockCommand) DeleteItem(id string) (*mongo.DeleteResult, error) { return nil, nil }
+func (m *mockCommand) ClaimRun(id primitive.ObjectID, due time.Time, next time.Time) (bool, error) {
+	if m.refuse {
+		return false, nil
+	}
+	m.claimed[id] = next
+	return true, nil
+}
+func (m *mockCommand) RecordRun(id primitive.ObjectID, ranAt time.Time, runErr error) error {
+	m.recorded[id] = runErr
+	return nil
+}
+
+type mockInvoices struct{}
+
+func (m *mockInvoices) GetCustomersInvoices(keyword string) ([]invoice_model.InvoiceCustomers, error) {
+	return []invoice_model.InvoiceCustomers{
+		{Name: "Acme", Email: "billing@acme.test", TotalInvoices: 3, TotalPending: 1, TotalPaid: 2},
+		{Name: "Beta", Email: "ap@beta.test", TotalInvoices: 1, TotalPending: 1},
+	}, nil
+}
+
+type mockRevenue struct{}
+
+func (m *mockRevenue) GetItemsByQuery(basis string) ([]revenue_model.MonthlyRevenue, error) {
+	return []revenue_model.MonthlyRevenue{
+		{Period: "2024-12", Revenue: 50},
+		{Period: "2025-05", Invoices: 2, Computed: 300, Revenue: 300, Source: "invoices"},
+		{Period: "2025-06", Invoices: 1, Computed: 100, Adjustment: 20, Revenue: 120, Source: "invoices"},
+	}, nil
+}
+
+func newScheduler(t *testing.T, due ...model.ReportSubscriptionDTO) (*Scheduler, *mockCommand, string) {
+	dir := t.TempDir()
+	cmd := &mockCommand{claimed: map[primitive.ObjectID]time.Time{}, recorded: map[primitive.ObjectID]error{}}
+	return &Scheduler{
+		Query:    &mockQuery{due: due},
+		Command:  cmd,
+		Mailer:   &mailer.FileMailer{Dir: dir},
+		Invoices: func(primitive.ObjectID) InvoiceSource { return &mockInvoices{} },
+		Revenue:  func(primitive.ObjectID) RevenueSource { return &mockRevenue{} },
+	}, cmd, dir
+}
+
+// readAttachment returns the recipients and the decoded attachment of the
+// only message written to dir.
+func readAttachment(t *testing.T, dir string) (string, string, string) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	msg, err := mail.ReadMessage(f)
+	require.NoError(t, err)
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	_, err = reader.NextPart()
+	require.NoError(t, err)
+	part, err := reader.NextPart()
+	require.NoError(t, err)
+	data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	require.NoError(t, err)
+
+	return msg.Header.Get("To"), part.FileName(), string(data)
+}
+
+func TestRunDue_SendsInvoiceStatusCSV(t *testing.T) {
+	now := time.Date(2025, 6, 9, 8, 0, 30, 0, time.UTC)
+	due := now.Truncate(time.Minute)
+	sub := model.ReportSubscriptionDTO{
+		ID:         primitive.NewObjectID(),
+		Name:       "Weekly invoice status",
+		ReportType: model.ReportInvoiceStatus,
+		Schedule:   "0 8 * * MON",
+		Timezone:   "UTC",
+		Recipients: []string{"cfo@example.com"},
+		Format:     model.FormatCSV,
+		Enabled:    true,
+		NextRunAt:  &due,
+	}
+	s, cmd, dir := newScheduler(t, sub)
+
+	require.NoError(t, s.RunDue(context.Background(), now))
+
+	require.Equal(t, time.Date(2025, 6, 16, 8, 0, 0, 0, time.UTC), cmd.claimed[sub.ID])
+	require.Contains(t, cmd.recorded, sub.ID)
+	require.NoError(t, cmd.recorded[sub.ID])
+
+	to, filename, body := readAttachment(t, dir)
+	require.Equal(t, "cfo@example.com", to)
+	require.Equal(t, "invoice-status-2025-06-09.csv", filename)
+	require.Equal(t, "Customer,Email,Invoices,Pending,Paid\n"+
+		"Acme,billing@acme.test,3,1,2\n"+
+		"Beta,ap@beta.test,1,1,0\n"+
+		"Total,,4,2,2\n", body)
+}
+
+func TestRunDue_SkipsUnclaimed(t *testing.T) {
+	now := time.Date(2025, 6, 9, 8, 0, 0, 0, time.UTC)
+	sub := model.ReportSubscriptionDTO{
+		ID: primitive.NewObjectID(), ReportType: model.ReportInvoiceStatus, Schedule: "@daily",
+		Recipients: []string{"cfo@example.com"}, Format: model.FormatCSV, Enabled: true, NextRunAt: &now,
+	}
+	s, cmd, dir := newScheduler(t, sub)
+	cmd.refuse = true
+
+	require.NoError(t, s.RunDue(context.Background(), now))
+
+	require.Empty(t, cmd.recorded)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.Empty(t, files)
+}
+
+type failingMailer struct{}
+
+func (m *failingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	return errors.New("connection refused")
+}
+
+func TestRunDue_RecordsFailure(t *testing.T) {
+	now := time.Date(2025, 6, 9, 8, 0, 0, 0, time.UTC)
+	sub := model.ReportSubscriptionDTO{
+		ID: primitive.NewObjectID(), ReportType: model.ReportRevenue, Schedule: "@daily",
+		Recipients: []string{"cfo@example.com"}, Format: model.FormatHTML, Enabled: true, NextRunAt: &now,
+	}
+	s, cmd, _ := newScheduler(t, sub)
+	s.Mailer = &failingMailer{}
+
+	require.NoError(t, s.RunDue(context.Background(), now))
+
+	require.EqualError(t, cmd.recorded[sub.ID], "connection refused")
+}
+
+func TestRun_RevenueHTML(t *testing.T) {
+	sub := &model.ReportSubscriptionDTO{
+		ReportType: model.ReportRevenue,
+		Params:     map[string]string{"months": "3", "basis": "accrual"},
+		Timezone:   "UTC",
+		Recipients: []string{"cfo@example.com", "ceo@example.com"},
+		Format:     model.FormatHTML,
+	}
+	s, _, dir := newScheduler(t)
+
+	require.NoError(t, s.Run(context.Background(), sub, time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)))
+
+	to, filename, body := readAttachment(t, dir)
+	require.Equal(t, "cfo@example.com, ceo@example.com", to)
+	require.Equal(t, "revenue-2025-06-30.html", filename)
+	require.Contains(t, body, "Revenue (accrual basis), 2025-04 to 2025-06")
+	require.Contains(t, body, "<td>2025-05</td>")
+	require.NotContains(t, body, "2024-12")
+	require.Equal(t, 1, strings.Count(body, "<th>420.00</th>"))
+}
+
+func TestRender_ReadsFromSubscriptionOrganization(t *testing.T) {
+	s, _, _ := newScheduler(t)
+	var asked []primitive.ObjectID
+	s.Invoices = func(orgID primitive.ObjectID) InvoiceSource {
+		asked = append(asked, orgID)
+		return &mockInvoices{}
+	}
+	sub := &model.ReportSubscriptionDTO{OrgID: primitive.NewObjectID(), ReportType: model.ReportInvoiceStatus}
+
+	_, err := s.Render(sub, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []primitive.ObjectID{sub.OrgID}, asked)
+}
@@ -2,16 +2,23 @@ package command
 
 import (
 	"context"
+	"errors"
 	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
 	"invoice-api/internal/features/revenue/model"
+	"log"
+	"strconv"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type DefaultRevenueCommand struct{}
+type DefaultRevenueCommand struct {
+	OrgID primitive.ObjectID
+}
 
 func (c *DefaultRevenueCommand) CollectionName() string {
 	return "revenues"
@@ -20,17 +27,138 @@ func (c *DefaultRevenueCommand) CollectionName() string {
 type RevenueCommand interface {
 	CreateItem(_val *model.CreateRevenue) (*mongo.InsertOneResult, error)
 	UpdateItem(id string, _val *model.UpdateRevenue) (*mongo.UpdateResult, error)
+	UpsertItem(period model.Period, _val *model.UpsertRevenue) (*mongo.UpdateResult, error)
 	DeleteItem(id string) (*mongo.DeleteResult, error)
 }
 
+// EnsureIndexes migrates revenue records written with month names or string
+// years to integer periods, then enforces one override per organization and
+// period. A period can have any number of adjustments, which are summed.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection("revenues")
+
+	if err := migratePeriods(ctx, db); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"period_kind_unique", "orgId_period_kind_unique"} {
+		if err := database.DropIndex(ctx, collection, name); err != nil {
+			return err
+		}
+	}
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "orgId", Value: 1}, {Key: "period", Value: 1}},
+		Options: options.Index().SetName("orgId_period_override_unique").SetUnique(true).
+			SetPartialFilterExpression(bson.M{"kind": model.KindOverride}),
+	})
+	return err
+}
+
+// migratePeriods rewrites records without a period. Records whose month
+// cannot be read, or overrides for a period already overridden by a newer
+// record of the same organization, are moved to revenues_migration_conflicts
+// for manual review.
+func migratePeriods(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection("revenues")
+	conflicts := db.Collection("revenues_migration_conflicts")
+
+	opts := options.Find().SetSort(bson.D{{Key: "updatedAt", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := collection.Find(ctx, bson.M{"period": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return err
+	}
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		kind, _ := doc["kind"].(string)
+		if kind == "" {
+			kind = model.KindOverride
+		}
+
+		period, ok := legacyPeriod(doc["year"], doc["month"])
+		if ok && kind == model.KindOverride {
+			taken, err := collection.CountDocuments(ctx, bson.M{"orgId": doc["orgId"], "period": period.String(), "kind": kind})
+			if err != nil {
+				return err
+			}
+			ok = taken == 0
+		}
+
+		if !ok {
+			log.Printf("revenue %v: moved to revenues_migration_conflicts (year %v, month %v)", doc["_id"], doc["year"], doc["month"])
+			if _, err := conflicts.InsertOne(ctx, doc); err != nil && !mongo.IsDuplicateKeyError(err) {
+				return err
+			}
+			if _, err := collection.DeleteOne(ctx, bson.M{"_id": doc["_id"]}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": doc["_id"]}, bson.M{"$set": bson.M{
+			"period": period.String(),
+			"year":   period.Year,
+			"month":  int(period.Month),
+			"kind":   kind,
+		}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// legacyPeriod reads the year and month of a record written before periods
+// were validated, where both may be strings and the month may be a name.
+func legacyPeriod(year interface{}, month interface{}) (model.Period, bool) {
+	toString := func(v interface{}) string {
+		switch v := v.(type) {
+		case string:
+			return v
+		case int32:
+			return strconv.Itoa(int(v))
+		case int64:
+			return strconv.Itoa(int(v))
+		case float64:
+			return strconv.Itoa(int(v))
+		}
+		return ""
+	}
+
+	m, ok := model.ParseMonth(toString(month))
+	if !ok {
+		return model.Period{}, false
+	}
+	y, err := strconv.Atoi(toString(year))
+	if err != nil {
+		return model.Period{}, false
+	}
+	p, err := model.ResolvePeriod("", y, int(m))
+	return p, err == nil
+}
+
 func (c *DefaultRevenueCommand) CreateItem(_val *model.CreateRevenue) (*mongo.InsertOneResult, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
+	period, err := model.ResolvePeriod(_val.Period, _val.Year, _val.Month)
+	if err != nil {
+		return nil, err
+	}
+	if _val.Kind == "" {
+		_val.Kind = model.KindOverride
+	}
+
 	doc := &model.Revenue{
-		Month:     _val.Month,
-		Year:      _val.Year,
+		Period:    period.String(),
+		Month:     int(period.Month),
+		Year:      period.Year,
 		Revenue:   _val.Revenue,
+		Kind:      _val.Kind,
+		Note:      _val.Note,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -47,20 +175,29 @@ func (c *DefaultRevenueCommand) CreateItem(_val *model.CreateRevenue) (*mongo.In
 }
 
 func (c *DefaultRevenueCommand) UpdateItem(id string, _val *model.UpdateRevenue) (*mongo.UpdateResult, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	update := bson.M{
-		"$set": bson.M{
-			"month":     _val.Month,
-			"year":      _val.Year,
-			"revenue":   _val.Revenue,
-			"updatedAt": time.Now(),
-		},
+	set := bson.M{
+		"revenue":   _val.Revenue,
+		"note":      _val.Note,
+		"updatedAt": time.Now(),
+	}
+	period, err := model.ResolvePeriod(_val.Period, _val.Year, _val.Month)
+	if err == nil {
+		set["period"] = period.String()
+		set["year"] = period.Year
+		set["month"] = int(period.Month)
+	} else if !errors.Is(err, model.ErrNoPeriod) {
+		return nil, err
+	}
+	if _val.Kind != "" {
+		set["kind"] = _val.Kind
 	}
+	update := bson.M{"$set": set}
 
 	objId, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -79,8 +216,33 @@ func (c *DefaultRevenueCommand) UpdateItem(id string, _val *model.UpdateRevenue)
 	return result, nil
 }
 
+// UpsertItem sets the override for the period, creating it when it does not
+// exist yet. Repeating the call has no further effect.
+func (c *DefaultRevenueCommand) UpsertItem(period model.Period, _val *model.UpsertRevenue) (*mongo.UpdateResult, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"period": period.String(), "kind": model.KindOverride}
+	update := bson.M{
+		"$set": bson.M{
+			"year":      period.Year,
+			"month":     int(period.Month),
+			"revenue":   _val.Revenue,
+			"note":      _val.Note,
+			"updatedAt": now,
+		},
+		"$setOnInsert": bson.M{"createdAt": now},
+	}
+
+	return collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+}
+
 func (c *DefaultRevenueCommand) DeleteItem(id string) (*mongo.DeleteResult, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -1,9 +1,13 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"invoice-api/internal/features/revenue/command"
 	"invoice-api/internal/features/revenue/model"
 	"invoice-api/internal/features/revenue/query"
+	"time"
+	"invoice-api/middleware"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -14,10 +18,25 @@ type RevenueController struct {
 	Query             query.RevenueQuery
 }
 
-func (s *RevenueController) CreateRevenue(c *fiber.Ctx) error {
-	if s.Command == nil {
-		s.Command = &command.DefaultRevenueCommand{}
+// query returns the injected query, or one scoped to the organization the
+// request was authorized for.
+func (s *RevenueController) query(c *fiber.Ctx) query.RevenueQuery {
+	if s.Query != nil {
+		return s.Query
+	}
+	return &query.DefaultRevenueQuery{OrgID: middleware.OrgID(c)}
+}
+
+// command returns the injected command, or one scoped to the organization
+// the request was authorized for.
+func (s *RevenueController) command(c *fiber.Ctx) command.RevenueCommand {
+	if s.Command != nil {
+		return s.Command
 	}
+	return &command.DefaultRevenueCommand{OrgID: middleware.OrgID(c)}
+}
+
+func (s *RevenueController) CreateRevenue(c *fiber.Ctx) error {
 	
 	payload := new(model.CreateRevenue)
 	if err := c.BodyParser(payload); err != nil {
@@ -29,8 +48,15 @@ func (s *RevenueController) CreateRevenue(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
 	}
 
-	resp, err := s.Command.CreateItem(payload)
+	if _, err := model.ResolvePeriod(payload.Period, payload.Year, payload.Month); err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+
+	resp, err := s.command(c).CreateItem(payload)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "The period already has a revenue override"})
+		}
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Failed to create revenue",
 		})
@@ -40,10 +66,14 @@ func (s *RevenueController) CreateRevenue(c *fiber.Ctx) error {
 }
 
 func (s *RevenueController) GetAllRevenues(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultRevenueQuery{}
+	basis := c.Query("basis", model.BasisCash)
+	if basis != model.BasisCash && basis != model.BasisAccrual {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "basis must be cash or accrual",
+		})
 	}
-	items, err := s.Query.GetItemsByQuery()
+
+	items, err := s.query(c).GetItemsByQuery(basis)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": err.Error(),
@@ -53,13 +83,82 @@ func (s *RevenueController) GetAllRevenues(c *fiber.Ctx) error {
 	return c.JSON(items)
 }
 
-func (s *RevenueController) GetRevenueByID(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultRevenueQuery{}
+func (s *RevenueController) GetRevenueSeries(c *fiber.Ctx) error {
+	q, err := model.ParseSeriesQuery(c.Query("from"), c.Query("to"), c.Query("granularity"), c.Query("tz"), c.Query("basis"), time.Now())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	series, err := s.query(c).GetSeries(q)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
 	}
+
+	return c.JSON(series)
+}
+
+func (s *RevenueController) GetRevenueForecast(c *fiber.Ctx) error {
+	months := c.QueryInt("months", 12)
+	if months < 1 || months > model.MaxForecastMonths {
+		return c.Status(400).JSON(fiber.Map{
+			"error": fmt.Sprintf("months must be between 1 and %d", model.MaxForecastMonths),
+		})
+	}
+
+	forecast, err := s.query(c).GetForecast(months)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(forecast)
+}
+
+func (s *RevenueController) GetRecognizedRevenue(c *fiber.Ctx) error {
+	from, to, err := model.ParsePeriodRange(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	items, err := s.query(c).GetRecognized(from, to)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(items)
+}
+
+func (s *RevenueController) GetDeferredRevenue(c *fiber.Ctx) error {
+	from, to, err := model.ParsePeriodRange(c.Query("from"), c.Query("to"), time.Now())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	items, err := s.query(c).GetDeferred(from, to)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(items)
+}
+
+func (s *RevenueController) GetRevenueByID(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	item, err := s.Query.GetItemByID(id)
+	item, err := s.query(c).GetItemByID(id)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
@@ -75,9 +174,6 @@ func (s *RevenueController) GetRevenueByID(c *fiber.Ctx) error {
 }
 
 func (s *RevenueController) UpdateRevenue(c *fiber.Ctx) error {
-	if s.Command == nil {
-		s.Command = &command.DefaultRevenueCommand{}
-	}
 	id := c.Params("id")
 
 	payload := new(model.UpdateRevenue)
@@ -89,8 +185,14 @@ func (s *RevenueController) UpdateRevenue(c *fiber.Ctx) error {
 	if validationErrors != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
 	}
-	res, err := s.Command.UpdateItem(id, payload)
+	if _, err := model.ResolvePeriod(payload.Period, payload.Year, payload.Month); err != nil && !errors.Is(err, model.ErrNoPeriod) {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+	res, err := s.command(c).UpdateItem(id, payload)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "The period already has a revenue override"})
+		}
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
 				"error": "Revenue not found",
@@ -112,13 +214,45 @@ func (s *RevenueController) UpdateRevenue(c *fiber.Ctx) error {
 	})
 }
 
-func (s *RevenueController) DeleteRevenue(c *fiber.Ctx) error {
-	if s.Command == nil {
-		s.Command = &command.DefaultRevenueCommand{}
+// PutRevenue creates or replaces the revenue record for the period in the
+// path (YYYY-MM).
+func (s *RevenueController) PutRevenue(c *fiber.Ctx) error {
+	period, err := model.ParsePeriod(c.Params("period"))
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+
+	payload := new(model.UpsertRevenue)
+	if err := c.BodyParser(payload); err != nil {
+		return c.Status(400).JSON(err.Error())
+	}
+
+	validationErrors := model.ValidateStruct(payload)
+	if validationErrors != nil {
+		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
 	}
+
+	res, err := s.command(c).UpsertItem(period, payload)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to save revenue",
+		})
+	}
+
+	status := 200
+	if res.UpsertedCount > 0 {
+		status = 201
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"message": "Revenue saved successfully",
+		"period":  period.String(),
+	})
+}
+
+func (s *RevenueController) DeleteRevenue(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	res, err := s.Command.DeleteItem(id)
+	res, err := s.command(c).DeleteItem(id)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
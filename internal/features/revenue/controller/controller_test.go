@@ -16,14 +16,35 @@ import (
 )
 
 type mockQuery struct{
-    getAll func() ([]model.RevenueDTO, error)
+    getAll func() ([]model.MonthlyRevenue, error)
+    basis string
+    series model.SeriesQuery
+    months int
+    from, to model.Period
     getByID func(id string) (*model.RevenueDTO, error)
 }
 
-func (m *mockQuery) GetItemsByQuery() ([]model.RevenueDTO, error) {
-    if m.getAll == nil { return []model.RevenueDTO{}, nil }
+func (m *mockQuery) GetItemsByQuery(basis string) ([]model.MonthlyRevenue, error) {
+    m.basis = basis
+    if m.getAll == nil { return []model.MonthlyRevenue{}, nil }
     return m.getAll()
 }
+func (m *mockQuery) GetSeries(q model.SeriesQuery) (*model.RevenueSeries, error) {
+    m.series = q
+    return &model.RevenueSeries{}, nil
+}
+func (m *mockQuery) GetForecast(months int) (*model.RevenueForecast, error) {
+    m.months = months
+    return &model.RevenueForecast{}, nil
+}
+func (m *mockQuery) GetRecognized(from model.Period, to model.Period) ([]model.RecognizedRevenue, error) {
+    m.from, m.to = from, to
+    return []model.RecognizedRevenue{}, nil
+}
+func (m *mockQuery) GetDeferred(from model.Period, to model.Period) ([]model.DeferredRevenue, error) {
+    m.from, m.to = from, to
+    return []model.DeferredRevenue{}, nil
+}
 func (m *mockQuery) GetItemByID(id string) (*model.RevenueDTO, error) {
     if m.getByID == nil { return &model.RevenueDTO{}, nil }
     return m.getByID(id)
@@ -35,6 +56,7 @@ type mockCommand struct{
 	createErr error
     update func(id string, val *model.UpdateRevenue) (*mongo.UpdateResult, error)
     del func(id string) (*mongo.DeleteResult, error)
+    upsert func(period model.Period, val *model.UpsertRevenue) (*mongo.UpdateResult, error)
 }
 
 func (m *mockCommand) CreateItem(val *model.CreateRevenue) (*mongo.InsertOneResult, error) {
@@ -45,6 +67,10 @@ func (m *mockCommand) UpdateItem(id string, val *model.UpdateRevenue) (*mongo.Up
     if m.update == nil { return &mongo.UpdateResult{MatchedCount:1, ModifiedCount:1}, nil }
     return m.update(id, val)
 }
+func (m *mockCommand) UpsertItem(period model.Period, val *model.UpsertRevenue) (*mongo.UpdateResult, error) {
+    if m.upsert == nil { return &mongo.UpdateResult{UpsertedCount:1}, nil }
+    return m.upsert(period, val)
+}
 func (m *mockCommand) DeleteItem(id string) (*mongo.DeleteResult, error) {
     if m.del == nil { return &mongo.DeleteResult{DeletedCount:1}, nil }
     return m.del(id)
@@ -59,8 +85,8 @@ func TestCreateCustomer_Success(t *testing.T) {
 	app.Post("/revenues", ctrl.CreateRevenue)
 
 	payload := model.CreateRevenue{
-		Month: "01",
-		Year:  "2025",
+		Month: 1,
+		Year:  2025,
 		Revenue: 10000,
 	}
 	
@@ -97,8 +123,8 @@ func TestCreateRevenue_ValidationAndBodyParser(t *testing.T) {
 
 func TestGetAllRevenues_Success(t *testing.T) {
     app := fiber.New()
-    ctrl := &RevenueController{Query: &mockQuery{getAll: func() ([]model.RevenueDTO, error) {
-        return []model.RevenueDTO{{Month: "Jan"}}, nil
+    ctrl := &RevenueController{Query: &mockQuery{getAll: func() ([]model.MonthlyRevenue, error) {
+        return []model.MonthlyRevenue{{Month: "Jan"}}, nil
     }}}
     app.Get("/revenues", ctrl.GetAllRevenues)
     r, _ := http.NewRequest("GET", "/revenues", nil)
@@ -120,7 +146,7 @@ func TestGetRevenueByID_NotFoundAndSuccess(t *testing.T) {
     if resp.StatusCode != 404 { t.Fatalf("expected 404 got %d", resp.StatusCode) }
 
     // success
-    dto := &model.RevenueDTO{Month: "Feb"}
+    dto := &model.RevenueDTO{Month: 2}
     ctrl2 := &RevenueController{Query: &mockQuery{getByID: func(id string) (*model.RevenueDTO, error) {
         return dto, nil
     }}}
@@ -160,3 +186,149 @@ func TestDeleteRevenue_Success(t *testing.T) {
     json.NewDecoder(resp.Body).Decode(&body)
     if body["message"] != "Revenue deleted successfully" { t.Fatalf("unexpected message: %v", body) }
 }
+
+func TestGetAllRevenues_Basis(t *testing.T) {
+    app := fiber.New()
+    q := &mockQuery{}
+    ctrl := &RevenueController{Query: q}
+    app.Get("/revenues", ctrl.GetAllRevenues)
+
+    resp, err := app.Test(httptest.NewRequest("GET", "/revenues", nil))
+    require.NoError(t, err)
+    require.Equal(t, 200, resp.StatusCode)
+    require.Equal(t, model.BasisCash, q.basis)
+
+    resp, err = app.Test(httptest.NewRequest("GET", "/revenues?basis=accrual", nil))
+    require.NoError(t, err)
+    require.Equal(t, 200, resp.StatusCode)
+    require.Equal(t, model.BasisAccrual, q.basis)
+
+    resp, err = app.Test(httptest.NewRequest("GET", "/revenues?basis=weekly", nil))
+    require.NoError(t, err)
+    require.Equal(t, 400, resp.StatusCode)
+}
+
+func TestGetRevenueSeries_Params(t *testing.T) {
+    app := fiber.New()
+    q := &mockQuery{}
+    ctrl := &RevenueController{Query: q}
+    app.Get("/revenues/series", ctrl.GetRevenueSeries)
+
+    resp, err := app.Test(httptest.NewRequest("GET", "/revenues/series?from=2025-01-01&to=2025-03-31&granularity=week&tz=Asia/Manila", nil))
+    require.NoError(t, err)
+    require.Equal(t, 200, resp.StatusCode)
+    require.Equal(t, model.GranularityWeek, q.series.Granularity)
+    require.Equal(t, "Asia/Manila", q.series.Location.String())
+    require.Equal(t, "2025-01-01", q.series.From.Format("2006-01-02"))
+
+    for _, bad := range []string{"granularity=hour", "tz=Mars/Base", "from=2025-02-01&to=2025-01-01", "from=01/02/2025"} {
+        resp, err = app.Test(httptest.NewRequest("GET", "/revenues/series?"+bad, nil))
+        require.NoError(t, err)
+        require.Equal(t, 400, resp.StatusCode, bad)
+    }
+}
+
+func TestCreateRevenue_Periods(t *testing.T) {
+    app := fiber.New()
+    ctrl := &RevenueController{Command: &mockCommand{}}
+    app.Post("/revenues", ctrl.CreateRevenue)
+
+    cases := map[string]int{
+        `{"period":"2025-01","revenue":100}`:             201,
+        `{"year":2025,"month":1,"revenue":100}`:          201,
+        `{"period":"2025-13","revenue":100}`:             400,
+        `{"period":"2025-01","month":2,"revenue":100}`:   400,
+        `{"year":2025,"revenue":100}`:                    400,
+        `{"year":2025,"month":13,"revenue":100}`:         400,
+        `{"revenue":100}`:                                400,
+    }
+    for body, status := range cases {
+        req := httptest.NewRequest("POST", "/revenues", bytes.NewReader([]byte(body)))
+        req.Header.Set("Content-Type", "application/json")
+        resp, err := app.Test(req)
+        require.NoError(t, err)
+        require.Equal(t, status, resp.StatusCode, body)
+    }
+
+    dup := &RevenueController{Command: &mockCommand{create: func(val *model.CreateRevenue) (*mongo.InsertOneResult, error) {
+        return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000}}}
+    }}}
+    app2 := fiber.New()
+    app2.Post("/revenues", dup.CreateRevenue)
+    req := httptest.NewRequest("POST", "/revenues", bytes.NewReader([]byte(`{"period":"2025-01"}`)))
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := app2.Test(req)
+    require.NoError(t, err)
+    require.Equal(t, 409, resp.StatusCode)
+}
+
+func TestPutRevenue_Upsert(t *testing.T) {
+    var got model.Period
+    results := []*mongo.UpdateResult{{UpsertedCount: 1}, {MatchedCount: 1, ModifiedCount: 1}}
+    ctrl := &RevenueController{Command: &mockCommand{upsert: func(period model.Period, val *model.UpsertRevenue) (*mongo.UpdateResult, error) {
+        got = period
+        res := results[0]
+        results = results[1:]
+        return res, nil
+    }}}
+    app := fiber.New()
+    app.Put("/revenues/:period", ctrl.PutRevenue)
+
+    for _, status := range []int{201, 200} {
+        req := httptest.NewRequest("PUT", "/revenues/2025-03", bytes.NewReader([]byte(`{"revenue":500}`)))
+        req.Header.Set("Content-Type", "application/json")
+        resp, err := app.Test(req)
+        require.NoError(t, err)
+        require.Equal(t, status, resp.StatusCode)
+    }
+    require.Equal(t, model.Period{Year: 2025, Month: 3}, got)
+
+    req := httptest.NewRequest("PUT", "/revenues/March", bytes.NewReader([]byte(`{"revenue":500}`)))
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := app.Test(req)
+    require.NoError(t, err)
+    require.Equal(t, 400, resp.StatusCode)
+
+    // a period can have several adjustments, so there is none to replace
+    req = httptest.NewRequest("PUT", "/revenues/2025-03", bytes.NewReader([]byte(`{"revenue":500,"kind":"adjustment"}`)))
+    req.Header.Set("Content-Type", "application/json")
+    resp, err = app.Test(req)
+    require.NoError(t, err)
+    require.Equal(t, 400, resp.StatusCode)
+}
+
+func TestGetRevenueForecast_Months(t *testing.T) {
+    app := fiber.New()
+    q := &mockQuery{}
+    ctrl := &RevenueController{Query: q}
+    app.Get("/revenues/forecast", ctrl.GetRevenueForecast)
+
+    resp, err := app.Test(httptest.NewRequest("GET", "/revenues/forecast", nil))
+    require.NoError(t, err)
+    require.Equal(t, 200, resp.StatusCode)
+    require.Equal(t, 12, q.months)
+
+    resp, err = app.Test(httptest.NewRequest("GET", "/revenues/forecast?months=40", nil))
+    require.NoError(t, err)
+    require.Equal(t, 400, resp.StatusCode)
+}
+
+func TestGetDeferredRevenue_Range(t *testing.T) {
+    app := fiber.New()
+    q := &mockQuery{}
+    ctrl := &RevenueController{Query: q}
+    app.Get("/revenues/deferred", ctrl.GetDeferredRevenue)
+    app.Get("/revenues/recognized", ctrl.GetRecognizedRevenue)
+
+    resp, err := app.Test(httptest.NewRequest("GET", "/revenues/deferred?from=2024-07&to=2025-06", nil))
+    require.NoError(t, err)
+    require.Equal(t, 200, resp.StatusCode)
+    require.Equal(t, model.Period{Year: 2024, Month: 7}, q.from)
+    require.Equal(t, model.Period{Year: 2025, Month: 6}, q.to)
+
+    for _, bad := range []string{"from=2025-06&to=2025-01", "from=2000-01&to=2025-01", "to=June"} {
+        resp, err = app.Test(httptest.NewRequest("GET", "/revenues/recognized?"+bad, nil))
+        require.NoError(t, err)
+        require.Equal(t, 400, resp.StatusCode, bad)
+    }
+}
@@ -1,7 +1,13 @@
 package model
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/go-playground/validator"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -9,32 +15,350 @@ import (
 
 var validate = validator.New()
 
+// Revenue is computed from invoices on either basis.
+const (
+	// BasisCash recognizes an invoice in the month it was paid.
+	BasisCash = "cash"
+	// BasisAccrual recognizes an invoice in the month it was issued.
+	BasisAccrual = "accrual"
+)
+
+// Manual revenue records no longer hold the revenue itself; they correct the
+// figure computed from invoices for their month.
+const (
+	// KindOverride replaces the computed revenue. Records created before
+	// revenue was computed from invoices have no kind and are overrides.
+	KindOverride = "override"
+	// KindAdjustment is added to the computed (or overridden) revenue.
+	KindAdjustment = "adjustment"
+)
+
+const (
+	SourceInvoices = "invoices"
+	SourceOverride = "override"
+)
+
+// Revenue is a manual revenue record. A period has at most one record of each
+// kind.
 type Revenue struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Month     string             `bson:"month" json:"month"`
-	Year      string             `bson:"year" json:"year"`
+	Period    string             `bson:"period" json:"period"`
+	Month     int                `bson:"month" json:"month"`
+	Year      int                `bson:"year" json:"year"`
 	Revenue   float64            `bson:"revenue" json:"revenue"`
+	Kind      string             `bson:"kind" json:"kind"`
+	Note      string             `bson:"note,omitempty" json:"note,omitempty"`
 	CreatedAt time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
 	UpdatedAt time.Time          `bson:"updatedAt,omitempty" json:"updatedAt"`
 }
 
 type RevenueDTO struct {
 	ID      primitive.ObjectID `bson:"_id" json:"id,,omitzero"`
-	Month   string             `json:"month"`
-	Year    string             `json:"year"`
+	Period  string             `json:"period"`
+	Month   int                `json:"month"`
+	Year    int                `json:"year"`
 	Revenue float64            `json:"revenue"`
+	Kind    string             `json:"kind"`
+	Note    string             `json:"note,omitempty"`
 }
 
+// CreateRevenue takes the period either as `period` ("YYYY-MM") or as `year`
+// and `month`.
 type CreateRevenue struct {
-	Month   string  `json:"month" validate:"required"`
-	Year    string  `json:"year" validate:"required"`
+	Period  string  `json:"period"`
+	Year    int     `json:"year" validate:"omitempty,gte=1900,lte=9999"`
+	Month   int     `json:"month" validate:"omitempty,gte=1,lte=12"`
 	Revenue float64 `json:"revenue"`
+	Kind    string  `json:"kind" validate:"omitempty,oneof=override adjustment"`
+	Note    string  `json:"note"`
 }
 
+// UpdateRevenue moves the record to another period when one is given.
 type UpdateRevenue struct {
-	Month   string  `json:"month"`
-	Year    string  `json:"year"`
+	Period  string  `json:"period"`
+	Year    int     `json:"year" validate:"omitempty,gte=1900,lte=9999"`
+	Month   int     `json:"month" validate:"omitempty,gte=1,lte=12"`
 	Revenue float64 `json:"revenue"`
+	Kind    string  `json:"kind" validate:"omitempty,oneof=override adjustment"`
+	Note    string  `json:"note"`
+}
+
+// UpsertRevenue is the body of PUT /revenues/:period. A period has at most one
+// override but may have several adjustments, so only overrides can be put.
+type UpsertRevenue struct {
+	Revenue float64 `json:"revenue"`
+	Kind    string  `json:"kind" validate:"omitempty,oneof=override"`
+	Note    string  `json:"note"`
+}
+
+// Period is a calendar month.
+type Period struct {
+	Year  int
+	Month time.Month
+}
+
+// String formats the period as YYYY-MM.
+func (p Period) String() string {
+	return fmt.Sprintf("%04d-%02d", p.Year, int(p.Month))
+}
+
+// AddMonths returns the period n months later.
+func (p Period) AddMonths(n int) Period {
+	t := time.Date(p.Year, p.Month+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+	return Period{Year: t.Year(), Month: t.Month()}
+}
+
+// ParsePeriod reads a period written as YYYY-MM.
+func ParsePeriod(period string) (Period, error) {
+	t, err := time.Parse("2006-01", period)
+	if err != nil || t.Year() < 1900 {
+		return Period{}, fmt.Errorf("invalid period %q, expected YYYY-MM", period)
+	}
+	return Period{Year: t.Year(), Month: t.Month()}, nil
+}
+
+// ResolvePeriod returns the period given either as a YYYY-MM string or as a
+// year and month. It returns ErrNoPeriod when neither is given.
+func ResolvePeriod(period string, year int, month int) (Period, error) {
+	if period != "" {
+		p, err := ParsePeriod(period)
+		if err != nil {
+			return Period{}, err
+		}
+		if (year != 0 && year != p.Year) || (month != 0 && month != int(p.Month)) {
+			return Period{}, fmt.Errorf("period %q does not match year and month", period)
+		}
+		return p, nil
+	}
+	if year == 0 && month == 0 {
+		return Period{}, ErrNoPeriod
+	}
+	if year < 1900 || year > 9999 || month < 1 || month > 12 {
+		return Period{}, fmt.Errorf("invalid year %d and month %d", year, month)
+	}
+	return Period{Year: year, Month: time.Month(month)}, nil
+}
+
+var ErrNoPeriod = errors.New("period is required, as YYYY-MM or year and month")
+
+// MonthlyRevenue is the revenue for one calendar month. Computed is derived
+// from invoices; Revenue is the reported figure after the manual Override and
+// Adjustments are applied, and Source says whether it starts from the invoices
+// or the override.
+type MonthlyRevenue struct {
+	Period      string       `json:"period"`
+	Month       string       `json:"month"`
+	Year        string       `json:"year"`
+	Basis       string       `json:"basis"`
+	Invoices    int64        `json:"invoices"`
+	Computed    float64      `json:"computed"`
+	Override    *RevenueDTO  `json:"override,omitempty"`
+	Adjustments []RevenueDTO `json:"adjustments,omitempty"`
+	Adjustment  float64      `json:"adjustment"`
+	Revenue     float64      `json:"revenue"`
+	Source      string       `json:"source"`
+}
+
+const (
+	GranularityDay     = "day"
+	GranularityWeek    = "week"
+	GranularityMonth   = "month"
+	GranularityQuarter = "quarter"
+	GranularityYear    = "year"
+)
+
+// MaxSeriesPoints bounds the length of a revenue series.
+const MaxSeriesPoints = 1000
+
+// SeriesQuery describes a revenue series. From and To are midnight in
+// Location; both are inclusive.
+type SeriesQuery struct {
+	From        time.Time
+	To          time.Time
+	Granularity string
+	Location    *time.Location
+	Basis       string
+}
+
+// ParseSeriesQuery reads the /revenues/series query parameters. Dates are
+// YYYY-MM-DD; the timezone defaults to ORG_TIMEZONE, then UTC. Without a range
+// the series covers the last 12 months up to today.
+func ParseSeriesQuery(from, to, granularity, tz, basis string, now time.Time) (SeriesQuery, error) {
+	if tz == "" {
+		tz = os.Getenv("ORG_TIMEZONE")
+	}
+	if tz == "" {
+		tz = "UTC"
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return SeriesQuery{}, fmt.Errorf("invalid tz %q", tz)
+	}
+
+	q := SeriesQuery{Granularity: granularity, Location: loc, Basis: basis}
+	if q.Granularity == "" {
+		q.Granularity = GranularityMonth
+	}
+	switch q.Granularity {
+	case GranularityDay, GranularityWeek, GranularityMonth, GranularityQuarter, GranularityYear:
+	default:
+		return SeriesQuery{}, fmt.Errorf("invalid granularity %q, expected day, week, month, quarter or year", granularity)
+	}
+	if q.Basis == "" {
+		q.Basis = BasisCash
+	}
+	if q.Basis != BasisCash && q.Basis != BasisAccrual {
+		return SeriesQuery{}, fmt.Errorf("invalid basis %q, expected cash or accrual", basis)
+	}
+
+	today := now.In(loc)
+	q.To = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, loc)
+	if to != "" {
+		if q.To, err = time.ParseInLocation(time.DateOnly, to, loc); err != nil {
+			return SeriesQuery{}, fmt.Errorf("invalid to %q, expected YYYY-MM-DD", to)
+		}
+	}
+	q.From = time.Date(q.To.Year(), q.To.Month()-11, 1, 0, 0, 0, 0, loc)
+	if from != "" {
+		if q.From, err = time.ParseInLocation(time.DateOnly, from, loc); err != nil {
+			return SeriesQuery{}, fmt.Errorf("invalid from %q, expected YYYY-MM-DD", from)
+		}
+	}
+	if q.From.After(q.To) {
+		return SeriesQuery{}, fmt.Errorf("from must not be after to")
+	}
+
+	return q, nil
+}
+
+// SeriesPoint is the revenue for one bucket of a series. Start is the first
+// day of the bucket. The percentage changes are nil when there is nothing to
+// compare against.
+type SeriesPoint struct {
+	Period          string   `json:"period"`
+	Start           string   `json:"start"`
+	Revenue         float64  `json:"revenue"`
+	Invoices        int64    `json:"invoices"`
+	Previous        float64  `json:"previous"`
+	ChangePct       *float64 `json:"changePct"`
+	PreviousYear    float64  `json:"previousYear"`
+	YearOverYearPct *float64 `json:"yearOverYearPct"`
+}
+
+// RevenueSeries is a gap-filled revenue series computed from invoices. From
+// and To are widened to whole buckets.
+type RevenueSeries struct {
+	From              string        `json:"from"`
+	To                string        `json:"to"`
+	Granularity       string        `json:"granularity"`
+	Timezone          string        `json:"timezone"`
+	Basis             string        `json:"basis"`
+	Total             float64       `json:"total"`
+	Invoices          int64         `json:"invoices"`
+	PreviousYearTotal float64       `json:"previousYearTotal"`
+	YearOverYearPct   *float64      `json:"yearOverYearPct"`
+	Points            []SeriesPoint `json:"points"`
+}
+
+// MaxForecastMonths bounds the horizon of a revenue forecast.
+const MaxForecastMonths = 36
+
+// ForecastPoint is the projected revenue for one month. Trend comes from the
+// model fitted to past revenue and Pending from open invoices dated in the
+// month; Estimate is the larger of the two. The bands are 80% and 95%
+// prediction intervals around Estimate.
+type ForecastPoint struct {
+	Period   string  `json:"period"`
+	Estimate float64 `json:"estimate"`
+	Trend    float64 `json:"trend"`
+	Pending  float64 `json:"pending"`
+	Lower80  float64 `json:"lower80"`
+	Upper80  float64 `json:"upper80"`
+	Lower95  float64 `json:"lower95"`
+	Upper95  float64 `json:"upper95"`
+}
+
+// RevenueForecast describes the fitted model and its monthly projections.
+// Method is holt-winters, holt, mean or none depending on how many months of
+// history were available.
+type RevenueForecast struct {
+	Method        string          `json:"method"`
+	HistoryMonths int             `json:"historyMonths"`
+	Alpha         float64         `json:"alpha"`
+	Beta          float64         `json:"beta"`
+	Gamma         float64         `json:"gamma"`
+	Points        []ForecastPoint `json:"points"`
+}
+
+// MaxReportMonths bounds the range of the recognized and deferred revenue
+// reports.
+const MaxReportMonths = 120
+
+// RecognizedRevenue is the revenue recognized in one month. Scheduled comes
+// from the recognition schedules of invoices with a service period and
+// Immediate from the other invoices, recognized in the month of their date.
+type RecognizedRevenue struct {
+	Period     string  `json:"period"`
+	Scheduled  float64 `json:"scheduled"`
+	Immediate  float64 `json:"immediate"`
+	Recognized float64 `json:"recognized"`
+}
+
+// DeferredRevenue is the movement of deferred revenue in one month: invoices
+// with a service period add their amount in the month of their date and
+// release it as it is recognized. A negative balance is revenue recognized
+// ahead of billing.
+type DeferredRevenue struct {
+	Period     string  `json:"period"`
+	Opening    float64 `json:"opening"`
+	Billed     float64 `json:"billed"`
+	Recognized float64 `json:"recognized"`
+	Closing    float64 `json:"closing"`
+}
+
+// ParsePeriodRange reads the `from` and `to` periods (YYYY-MM) of a monthly
+// report. Without them the report covers the last 12 months.
+func ParsePeriodRange(from string, to string, now time.Time) (Period, Period, error) {
+	end := Period{Year: now.Year(), Month: now.Month()}
+	if to != "" {
+		var err error
+		if end, err = ParsePeriod(to); err != nil {
+			return Period{}, Period{}, err
+		}
+	}
+	start := end.AddMonths(-11)
+	if from != "" {
+		var err error
+		if start, err = ParsePeriod(from); err != nil {
+			return Period{}, Period{}, err
+		}
+	}
+	if start.String() > end.String() {
+		return Period{}, Period{}, fmt.Errorf("from must not be after to")
+	}
+	if start.AddMonths(MaxReportMonths-1).String() < end.String() {
+		return Period{}, Period{}, fmt.Errorf("range must not exceed %d months", MaxReportMonths)
+	}
+	return start, end, nil
+}
+
+// ParseMonth accepts a month number ("1", "01") or an English month name
+// ("Jan", "January").
+func ParseMonth(month string) (time.Month, bool) {
+	month = strings.TrimSpace(month)
+	if n, err := strconv.Atoi(month); err == nil {
+		if n < 1 || n > 12 {
+			return 0, false
+		}
+		return time.Month(n), true
+	}
+	for m := time.January; m <= time.December; m++ {
+		name := m.String()
+		if strings.EqualFold(month, name) || strings.EqualFold(month, name[:3]) {
+			return m, true
+		}
+	}
+	return 0, false
 }
 
 type ErrorResponse struct {
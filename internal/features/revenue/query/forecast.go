@@ -0,0 +1,198 @@
+package query
+
+import (
+	"math"
+	"time"
+
+	"invoice-api/internal/features/revenue/model"
+)
+
+// seasonLength is the number of months in a seasonal cycle.
+const seasonLength = 12
+
+// smoothingGrid is the set of values tried for each smoothing parameter.
+var smoothingGrid = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+
+// fit is an exponential smoothing model fitted to a monthly series. Sigma is
+// the standard deviation of its one-step-ahead errors.
+type fit struct {
+	method   string
+	alpha    float64
+	beta     float64
+	gamma    float64
+	sigma    float64
+	forecast []float64
+}
+
+// fitSeries picks the richest model the history supports: additive
+// Holt-Winters with yearly seasonality from two full years, Holt's linear
+// trend from three months, and the mean below that.
+func fitSeries(history []float64, horizon int) fit {
+	switch {
+	case len(history) >= 2*seasonLength:
+		return bestFit(history, horizon, true)
+	case len(history) >= 3:
+		return bestFit(history, horizon, false)
+	case len(history) > 0:
+		mean, sse := 0.0, 0.0
+		for _, y := range history {
+			mean += y
+		}
+		mean /= float64(len(history))
+		for _, y := range history {
+			sse += (y - mean) * (y - mean)
+		}
+		f := fit{method: "mean", sigma: math.Sqrt(sse / float64(len(history))), forecast: make([]float64, horizon)}
+		for i := range f.forecast {
+			f.forecast[i] = mean
+		}
+		return f
+	}
+	return fit{method: "none", forecast: make([]float64, horizon)}
+}
+
+// bestFit grid searches the smoothing parameters for the lowest in-sample
+// squared error.
+func bestFit(history []float64, horizon int, seasonal bool) fit {
+	gammas := []float64{0}
+	if seasonal {
+		gammas = smoothingGrid
+	}
+
+	best := fit{sigma: math.Inf(1)}
+	for _, alpha := range smoothingGrid {
+		for _, beta := range smoothingGrid {
+			for _, gamma := range gammas {
+				var f fit
+				if seasonal {
+					f = holtWinters(history, horizon, alpha, beta, gamma)
+				} else {
+					f = holt(history, horizon, alpha, beta)
+				}
+				if f.sigma < best.sigma {
+					best = f
+				}
+			}
+		}
+	}
+	return best
+}
+
+// holt fits Holt's linear trend model.
+func holt(y []float64, horizon int, alpha, beta float64) fit {
+	level, trend := y[0], y[1]-y[0]
+	sse := 0.0
+	for t := 1; t < len(y); t++ {
+		err := y[t] - (level + trend)
+		sse += err * err
+		prev := level
+		level = alpha*y[t] + (1-alpha)*(level+trend)
+		trend = beta*(level-prev) + (1-beta)*trend
+	}
+
+	f := fit{method: "holt", alpha: alpha, beta: beta, sigma: math.Sqrt(sse / float64(len(y)-1)), forecast: make([]float64, horizon)}
+	for h := 1; h <= horizon; h++ {
+		f.forecast[h-1] = level + float64(h)*trend
+	}
+	return f
+}
+
+// holtWinters fits the additive Holt-Winters model, initialised from the
+// first two seasons.
+func holtWinters(y []float64, horizon int, alpha, beta, gamma float64) fit {
+	m := seasonLength
+	first, second := 0.0, 0.0
+	for i := 0; i < m; i++ {
+		first += y[i]
+		second += y[m+i]
+	}
+	first /= float64(m)
+	second /= float64(m)
+
+	// The first season's mean is the level at its midpoint; detrend the
+	// seasonal indices around it and start from the level at its end.
+	trend := (second - first) / float64(m)
+	mid := float64(m-1) / 2
+	level := first + mid*trend
+	season := make([]float64, len(y))
+	for i := 0; i < m; i++ {
+		season[i] = y[i] - (first + (float64(i)-mid)*trend)
+	}
+
+	sse := 0.0
+	for t := m; t < len(y); t++ {
+		err := y[t] - (level + trend + season[t-m])
+		sse += err * err
+		prev := level
+		level = alpha*(y[t]-season[t-m]) + (1-alpha)*(level+trend)
+		trend = beta*(level-prev) + (1-beta)*trend
+		season[t] = gamma*(y[t]-level) + (1-gamma)*season[t-m]
+	}
+
+	f := fit{method: "holt-winters", alpha: alpha, beta: beta, gamma: gamma, sigma: math.Sqrt(sse / float64(len(y)-m)), forecast: make([]float64, horizon)}
+	n := len(y)
+	for h := 1; h <= horizon; h++ {
+		f.forecast[h-1] = level + float64(h)*trend + season[n-m+(h-1)%m]
+	}
+	return f
+}
+
+// buildForecast fits the months before start and projects months from start
+// onwards. history must be sorted by period; missing months count as zero.
+func buildForecast(history []model.MonthlyRevenue, pending map[string]float64, start time.Time, months int) *model.RevenueForecast {
+	byPeriod := make(map[string]float64, len(history))
+	for _, item := range history {
+		byPeriod[item.Period] = item.Revenue
+	}
+
+	series := make([]float64, 0)
+	if len(history) > 0 {
+		first, err := time.Parse("2006-01", history[0].Period)
+		if err == nil {
+			for m := first; m.Before(start); m = m.AddDate(0, 1, 0) {
+				series = append(series, byPeriod[m.Format("2006-01")])
+			}
+		}
+	}
+
+	f := fitSeries(series, months)
+	forecast := &model.RevenueForecast{
+		Method:        f.method,
+		HistoryMonths: len(series),
+		Alpha:         f.alpha,
+		Beta:          f.beta,
+		Gamma:         f.gamma,
+		Points:        make([]model.ForecastPoint, 0, months),
+	}
+
+	overdue := 0.0
+	startPeriod := start.Format("2006-01")
+	for period, amount := range pending {
+		if period < startPeriod {
+			overdue += amount
+		}
+	}
+
+	for h := 1; h <= months; h++ {
+		period := start.AddDate(0, h-1, 0).Format("2006-01")
+		point := model.ForecastPoint{
+			Period:  period,
+			Trend:   math.Max(0, f.forecast[h-1]),
+			Pending: pending[period],
+		}
+		if h == 1 {
+			point.Pending += overdue
+		}
+		point.Estimate = math.Max(point.Trend, point.Pending)
+
+		spread := f.sigma * math.Sqrt(float64(h))
+		point.Lower80 = math.Max(0, point.Estimate-1.2816*spread)
+		point.Upper80 = point.Estimate + 1.2816*spread
+		point.Lower95 = math.Max(0, point.Estimate-1.96*spread)
+		point.Upper95 = point.Estimate + 1.96*spread
+
+		forecast.Points = append(forecast.Points, point)
+	}
+
+	return forecast
+}
@@ -2,8 +2,12 @@ package query
 
 import (
 	"context"
-	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
+	invoice_model "invoice-api/internal/features/invoice/model"
 	"invoice-api/internal/features/revenue/model"
+	"sort"
+	"strconv"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -11,7 +15,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type DefaultRevenueQuery struct{}
+type DefaultRevenueQuery struct {
+	OrgID primitive.ObjectID
+}
 
 func (c *DefaultRevenueQuery) CollectionName() string {
 	return "revenues"
@@ -19,12 +25,33 @@ func (c *DefaultRevenueQuery) CollectionName() string {
 
 //go:generate mockgen -destination=../mocks/query/mock_invoice_query.go -package=query invoice-api/internal/features/invoice/query RevenueQuery
 type RevenueQuery interface {
-	GetItemsByQuery() ([]model.RevenueDTO, error)
+	GetItemsByQuery(basis string) ([]model.MonthlyRevenue, error)
+	GetSeries(q model.SeriesQuery) (*model.RevenueSeries, error)
+	GetForecast(months int) (*model.RevenueForecast, error)
+	GetRecognized(from model.Period, to model.Period) ([]model.RecognizedRevenue, error)
+	GetDeferred(from model.Period, to model.Period) ([]model.DeferredRevenue, error)
 	GetItemByID(id string) (*model.RevenueDTO, error)
 }
 
-func (c *DefaultRevenueQuery) GetItemsByQuery() ([]model.RevenueDTO, error) {
-	db := database.GetDatabase()
+// GetItemsByQuery returns monthly revenue computed from invoices on the given
+// basis, with the manual revenue records applied as overrides and adjustments.
+func (c *DefaultRevenueQuery) GetItemsByQuery(basis string) ([]model.MonthlyRevenue, error) {
+	computed, err := c.getInvoiceRevenue(basis)
+	if err != nil {
+		return nil, err
+	}
+
+	manual, err := c.getManualItems()
+	if err != nil {
+		return nil, err
+	}
+
+	return applyManualItems(basis, computed, manual), nil
+}
+
+// getManualItems returns the manually entered revenue records.
+func (c *DefaultRevenueQuery) getManualItems() ([]model.RevenueDTO, error) {
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 
 	items := make([]model.RevenueDTO, 0, 100) 
@@ -45,7 +72,7 @@ func (c *DefaultRevenueQuery) GetItemsByQuery() ([]model.RevenueDTO, error) {
 }
 
 func (c *DefaultRevenueQuery) GetItemByID(id string) (*model.RevenueDTO, error) {
-	db := database.GetDatabase()
+	db := tenant.ForOrg(c.OrgID)
 	collection := db.Collection(c.CollectionName())
 	
 	objID, err := primitive.ObjectIDFromHex(id)
@@ -63,4 +90,324 @@ func (c *DefaultRevenueQuery) GetItemByID(id string) (*model.RevenueDTO, error)
 	}
 
 	return &item, nil
-}
\ No newline at end of file
+}
+
+// nonRevenueStatuses are invoice statuses that never count as revenue.
+var nonRevenueStatuses = []string{"cancelled", "void"}
+
+// revenueRecognition returns the filter for invoices that count as revenue on
+// the given basis and the expression for the date they are recognized on.
+func revenueRecognition(basis string) (bson.M, bson.M) {
+	if basis == model.BasisAccrual {
+		return bson.M{"status": bson.M{"$nin": nonRevenueStatuses}},
+			bson.M{"$dateFromString": bson.M{"dateString": "$date", "onError": nil, "onNull": nil}}
+	}
+	return bson.M{"status": "paid"}, bson.M{"$ifNull": bson.A{"$paidAt", "$updatedAt"}}
+}
+
+// monthTotal is the revenue computed from invoices for one month.
+type monthTotal struct {
+	Year     int     `bson:"year"`
+	Month    int     `bson:"month"`
+	Revenue  float64 `bson:"revenue"`
+	Invoices int64   `bson:"invoices"`
+}
+
+// getInvoiceRevenue sums invoice amounts per month. On a cash basis paid
+// invoices count in the month they were paid (their last update when no
+// payment date was recorded); on an accrual basis every invoice that was not
+// cancelled or voided counts in the month of its date.
+func (c *DefaultRevenueQuery) getInvoiceRevenue(basis string) ([]monthTotal, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection("invoices")
+
+	match, recognizedAt := revenueRecognition(basis)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$project", Value: bson.M{"amount": 1, "at": recognizedAt}}},
+		{{Key: "$match", Value: bson.M{"at": bson.M{"$type": "date"}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      bson.M{"year": bson.M{"$year": "$at"}, "month": bson.M{"$month": "$at"}},
+			"revenue":  bson.M{"$sum": "$amount"},
+			"invoices": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":      0,
+			"year":     "$_id.year",
+			"month":    "$_id.month",
+			"revenue":  1,
+			"invoices": 1,
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "year", Value: 1}, {Key: "month", Value: 1}}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	items := make([]monthTotal, 0)
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// applyManualItems merges the manual records into the computed months. Months
+// that only have manual records are included with zero computed revenue.
+func applyManualItems(basis string, computed []monthTotal, manual []model.RevenueDTO) []model.MonthlyRevenue {
+	months := make(map[string]*model.MonthlyRevenue)
+	row := func(year int, month time.Month) *model.MonthlyRevenue {
+		period := model.Period{Year: year, Month: month}.String()
+		if r, ok := months[period]; ok {
+			return r
+		}
+		r := &model.MonthlyRevenue{
+			Period: period,
+			Month:  month.String()[:3],
+			Year:   strconv.Itoa(year),
+			Basis:  basis,
+		}
+		months[period] = r
+		return r
+	}
+
+	for _, item := range computed {
+		r := row(item.Year, time.Month(item.Month))
+		r.Computed = item.Revenue
+		r.Invoices = item.Invoices
+	}
+
+	for _, item := range manual {
+		r := row(item.Year, time.Month(item.Month))
+		if item.Kind == model.KindAdjustment {
+			r.Adjustments = append(r.Adjustments, item)
+			r.Adjustment += item.Revenue
+			continue
+		}
+		// A unique index keeps one override per period.
+		override := item
+		r.Override = &override
+	}
+
+	items := make([]model.MonthlyRevenue, 0, len(months))
+	for _, r := range months {
+		r.Revenue = r.Computed
+		r.Source = model.SourceInvoices
+		if r.Override != nil {
+			r.Revenue = r.Override.Revenue
+			r.Source = model.SourceOverride
+		}
+		r.Revenue += r.Adjustment
+		items = append(items, *r)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Period < items[j].Period })
+
+	return items
+}
+
+// GetSeries returns revenue computed from invoices for each bucket between
+// q.From and q.To, with the year before included for comparison. Days are
+// taken in q.Location for cash revenue; accrual revenue uses the invoice date
+// as written.
+func (c *DefaultRevenueQuery) GetSeries(q model.SeriesQuery) (*model.RevenueSeries, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection("invoices")
+
+	match, recognizedAt := revenueRecognition(q.Basis)
+	timezone := q.Location.String()
+	if q.Basis == model.BasisAccrual {
+		timezone = "UTC"
+	}
+
+	from := yearAgo(bucketStart(q.From, q.Granularity), q.Granularity).Format(time.DateOnly)
+	to := q.To.AddDate(0, 0, 1).Format(time.DateOnly)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$project", Value: bson.M{"amount": 1, "at": recognizedAt}}},
+		{{Key: "$match", Value: bson.M{"at": bson.M{"$type": "date"}}}},
+		{{Key: "$project", Value: bson.M{
+			"amount": 1,
+			"day":    bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$at", "timezone": timezone}},
+		}}},
+		{{Key: "$match", Value: bson.M{"day": bson.M{"$gte": from, "$lt": to}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$day",
+			"revenue":  bson.M{"$sum": "$amount"},
+			"invoices": bson.M{"$sum": 1},
+		}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	days := make([]dayTotal, 0)
+	if err := cursor.All(ctx, &days); err != nil {
+		return nil, err
+	}
+
+	return buildSeries(q, days)
+}
+
+// GetForecast projects cash revenue for the given number of months starting
+// with the current one. The model is fitted to the reported revenue of every
+// complete month so far; open invoices set a floor for the month they are
+// dated in, and overdue ones count towards the current month.
+func (c *DefaultRevenueQuery) GetForecast(months int) (*model.RevenueForecast, error) {
+	items, err := c.GetItemsByQuery(model.BasisCash)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := c.getPendingByMonth()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	return buildForecast(items, pending, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), months), nil
+}
+
+// getPendingByMonth sums open invoices by the YYYY-MM of their date.
+func (c *DefaultRevenueQuery) getPendingByMonth() (map[string]float64, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection("invoices")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": bson.M{"$nin": invoice_model.ClosedStatuses}}}},
+		{{Key: "$project", Value: bson.M{
+			"amount": 1,
+			"at":     bson.M{"$dateFromString": bson.M{"dateString": "$date", "onError": nil, "onNull": nil}},
+		}}},
+		{{Key: "$match", Value: bson.M{"at": bson.M{"$type": "date"}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$at"}},
+			"amount": bson.M{"$sum": "$amount"},
+		}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Period string  `bson:"_id"`
+		Amount float64 `bson:"amount"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	pending := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		pending[row.Period] = row.Amount
+	}
+	return pending, nil
+}
+
+// GetRecognized returns the revenue recognized in each month from from to to.
+func (c *DefaultRevenueQuery) GetRecognized(from model.Period, to model.Period) ([]model.RecognizedRevenue, error) {
+	totals, err := c.getRecognitionTotals()
+	if err != nil {
+		return nil, err
+	}
+	return buildRecognized(totals, from, to), nil
+}
+
+// GetDeferred returns the deferred revenue balance for each month from from
+// to to, opening with everything billed and recognized before from.
+func (c *DefaultRevenueQuery) GetDeferred(from model.Period, to model.Period) ([]model.DeferredRevenue, error) {
+	totals, err := c.getRecognitionTotals()
+	if err != nil {
+		return nil, err
+	}
+	return buildDeferred(totals, from, to), nil
+}
+
+// getRecognitionTotals sums, in one aggregation, the recognition schedules,
+// the billed amount of scheduled invoices and the amount of the other
+// invoices by month.
+func (c *DefaultRevenueQuery) getRecognitionTotals() (recognitionTotals, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection("invoices")
+
+	hasSchedule := bson.M{"recognitionSchedule.0": bson.M{"$exists": true}}
+	invoiceMonth := bson.A{
+		bson.M{"$project": bson.M{
+			"amount": 1,
+			"period": bson.M{"$dateToString": bson.M{
+				"format": "%Y-%m",
+				"date":   bson.M{"$dateFromString": bson.M{"dateString": "$date", "onError": nil, "onNull": nil}},
+			}},
+		}},
+		bson.M{"$match": bson.M{"period": bson.M{"$type": "string"}}},
+		bson.M{"$group": bson.M{"_id": "$period", "amount": bson.M{"$sum": "$amount"}}},
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": bson.M{"$nin": nonRevenueStatuses}}}},
+		{{Key: "$facet", Value: bson.M{
+			"scheduled": bson.A{
+				bson.M{"$match": hasSchedule},
+				bson.M{"$unwind": "$recognitionSchedule"},
+				bson.M{"$group": bson.M{"_id": "$recognitionSchedule.period", "amount": bson.M{"$sum": "$recognitionSchedule.amount"}}},
+			},
+			"billed":    append(bson.A{bson.M{"$match": hasSchedule}}, invoiceMonth...),
+			"immediate": append(bson.A{bson.M{"$match": bson.M{"recognitionSchedule.0": bson.M{"$exists": false}}}}, invoiceMonth...),
+		}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return recognitionTotals{}, err
+	}
+	defer cursor.Close(ctx)
+
+	type monthAmount struct {
+		Period string  `bson:"_id"`
+		Amount float64 `bson:"amount"`
+	}
+	var result []struct {
+		Scheduled []monthAmount `bson:"scheduled"`
+		Billed    []monthAmount `bson:"billed"`
+		Immediate []monthAmount `bson:"immediate"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return recognitionTotals{}, err
+	}
+
+	toMap := func(rows []monthAmount) map[string]float64 {
+		m := make(map[string]float64, len(rows))
+		for _, row := range rows {
+			m[row.Period] = row.Amount
+		}
+		return m
+	}
+	totals := recognitionTotals{scheduled: map[string]float64{}, billed: map[string]float64{}, immediate: map[string]float64{}}
+	if len(result) > 0 {
+		totals.scheduled = toMap(result[0].Scheduled)
+		totals.billed = toMap(result[0].Billed)
+		totals.immediate = toMap(result[0].Immediate)
+	}
+	return totals, nil
+}
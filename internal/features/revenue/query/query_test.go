@@ -0,0 +1,158 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"invoice-api/internal/features/revenue/model"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyManualItems(t *testing.T) {
+	computed := []monthTotal{
+		{Year: 2025, Month: 1, Revenue: 1000, Invoices: 2},
+		{Year: 2025, Month: 2, Revenue: 500, Invoices: 1},
+	}
+	manual := []model.RevenueDTO{
+		{Month: 2, Year: 2025, Revenue: 800},
+		{Month: 2, Year: 2025, Revenue: -50, Kind: model.KindAdjustment},
+		{Month: 3, Year: 2025, Revenue: 75, Kind: model.KindAdjustment},
+	}
+
+	items := applyManualItems(model.BasisCash, computed, manual)
+	require.Len(t, items, 3)
+
+	require.Equal(t, "2025-01", items[0].Period)
+	require.Equal(t, model.SourceInvoices, items[0].Source)
+	require.Equal(t, 1000.0, items[0].Revenue)
+
+	require.Equal(t, "2025-02", items[1].Period)
+	require.Equal(t, model.SourceOverride, items[1].Source)
+	require.Equal(t, 500.0, items[1].Computed)
+	require.Equal(t, 750.0, items[1].Revenue)
+
+	require.Equal(t, "Mar", items[2].Month)
+	require.Equal(t, 0.0, items[2].Computed)
+	require.Equal(t, 75.0, items[2].Revenue)
+}
+
+func TestBuildSeries_GapFillAndComparisons(t *testing.T) {
+	q := model.SeriesQuery{
+		From:        time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC),
+		Granularity: model.GranularityMonth,
+		Location:    time.UTC,
+		Basis:       model.BasisCash,
+	}
+	days := []dayTotal{
+		{Day: "2024-01-20", Revenue: 50, Invoices: 1},
+		{Day: "2024-12-31", Revenue: 200, Invoices: 1},
+		{Day: "2025-01-02", Revenue: 100, Invoices: 1},
+		{Day: "2025-01-30", Revenue: 100, Invoices: 2},
+		{Day: "2025-03-02", Revenue: 300, Invoices: 1},
+	}
+
+	series, err := buildSeries(q, days)
+	require.NoError(t, err)
+	require.Equal(t, "2025-01-01", series.From)
+	require.Equal(t, "2025-03-31", series.To)
+	require.Len(t, series.Points, 3)
+
+	jan, feb, mar := series.Points[0], series.Points[1], series.Points[2]
+	require.Equal(t, "2025-01", jan.Period)
+	require.Equal(t, 200.0, jan.Revenue)
+	require.Equal(t, 200.0, jan.Previous)
+	require.Equal(t, 0.0, *jan.ChangePct)
+	require.Equal(t, 300.0, *jan.YearOverYearPct)
+
+	require.Equal(t, 0.0, feb.Revenue)
+	require.Equal(t, -100.0, *feb.ChangePct)
+	require.Nil(t, feb.YearOverYearPct)
+
+	require.Nil(t, mar.ChangePct)
+	require.Equal(t, 500.0, series.Total)
+	require.Equal(t, 50.0, series.PreviousYearTotal)
+}
+
+func TestBucketStartAndLabel(t *testing.T) {
+	day := time.Date(2025, 5, 18, 0, 0, 0, 0, time.UTC) // Sunday
+
+	require.Equal(t, "2025-05-12", bucketStart(day, model.GranularityWeek).Format(time.DateOnly))
+	require.Equal(t, "2025-W20", bucketLabel(bucketStart(day, model.GranularityWeek), model.GranularityWeek))
+	require.Equal(t, "2025-04-01", bucketStart(day, model.GranularityQuarter).Format(time.DateOnly))
+	require.Equal(t, "2025-Q2", bucketLabel(bucketStart(day, model.GranularityQuarter), model.GranularityQuarter))
+	require.Equal(t, "2025", bucketLabel(bucketStart(day, model.GranularityYear), model.GranularityYear))
+}
+
+func TestFitSeries_HoltWintersFollowsTrendAndSeason(t *testing.T) {
+	history := make([]float64, 36)
+	for i := range history {
+		history[i] = 1000 + 10*float64(i)
+		if i%12 == 11 {
+			history[i] += 500 // December peak
+		}
+	}
+
+	f := fitSeries(history, 12)
+	require.Equal(t, "holt-winters", f.method)
+	require.Len(t, f.forecast, 12)
+	require.InDelta(t, 1360, f.forecast[0], 25)
+	require.InDelta(t, 1470+500, f.forecast[11], 25)
+	require.Greater(t, f.forecast[11]-f.forecast[10], 400.0)
+}
+
+func TestFitSeries_ShortHistory(t *testing.T) {
+	require.Equal(t, "holt", fitSeries([]float64{100, 200, 300, 400}, 3).method)
+	require.InDelta(t, 500, fitSeries([]float64{100, 200, 300, 400}, 3).forecast[0], 50)
+
+	f := fitSeries([]float64{100, 300}, 2)
+	require.Equal(t, "mean", f.method)
+	require.Equal(t, []float64{200, 200}, f.forecast)
+
+	require.Equal(t, "none", fitSeries(nil, 2).method)
+}
+
+func TestBuildForecast_PendingFloorAndBands(t *testing.T) {
+	history := []model.MonthlyRevenue{
+		{Period: "2025-01", Revenue: 100},
+		{Period: "2025-03", Revenue: 300},
+		{Period: "2025-04", Revenue: 400},
+	}
+	pending := map[string]float64{"2025-02": 50, "2025-06": 5000}
+	start := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	f := buildForecast(history, pending, start, 3)
+	require.Equal(t, "holt", f.Method)
+	require.Equal(t, 4, f.HistoryMonths)
+	require.Len(t, f.Points, 3)
+
+	require.Equal(t, "2025-05", f.Points[0].Period)
+	require.Equal(t, 50.0, f.Points[0].Pending)
+	require.Equal(t, 5000.0, f.Points[1].Estimate)
+	for _, p := range f.Points {
+		require.LessOrEqual(t, p.Lower95, p.Lower80)
+		require.LessOrEqual(t, p.Lower80, p.Estimate)
+		require.LessOrEqual(t, p.Estimate, p.Upper80)
+		require.LessOrEqual(t, p.Upper80, p.Upper95)
+	}
+}
+
+func TestBuildDeferred_Balances(t *testing.T) {
+	totals := recognitionTotals{
+		billed:    map[string]float64{"2024-12": 1200},
+		scheduled: map[string]float64{"2024-12": 100, "2025-01": 100, "2025-02": 100},
+		immediate: map[string]float64{"2025-01": 40},
+	}
+	from, to := model.Period{Year: 2025, Month: 1}, model.Period{Year: 2025, Month: 2}
+
+	deferred := buildDeferred(totals, from, to)
+	require.Equal(t, []model.DeferredRevenue{
+		{Period: "2025-01", Opening: 1100, Recognized: 100, Closing: 1000},
+		{Period: "2025-02", Opening: 1000, Recognized: 100, Closing: 900},
+	}, deferred)
+
+	recognized := buildRecognized(totals, from, to)
+	require.Equal(t, 140.0, recognized[0].Recognized)
+	require.Equal(t, 100.0, recognized[1].Recognized)
+}
@@ -0,0 +1,61 @@
+package query
+
+import (
+	"invoice-api/internal/features/revenue/model"
+)
+
+// recognitionTotals holds monthly sums, keyed by YYYY-MM, of the invoices that
+// count as revenue.
+type recognitionTotals struct {
+	// scheduled is recognized from invoices with a service period.
+	scheduled map[string]float64
+	// billed is the amount of invoices with a service period, by invoice date.
+	billed map[string]float64
+	// immediate is the amount of the other invoices, by invoice date.
+	immediate map[string]float64
+}
+
+func buildRecognized(totals recognitionTotals, from model.Period, to model.Period) []model.RecognizedRevenue {
+	items := make([]model.RecognizedRevenue, 0)
+	for p := from; p.String() <= to.String(); p = p.AddMonths(1) {
+		period := p.String()
+		item := model.RecognizedRevenue{
+			Period:    period,
+			Scheduled: totals.scheduled[period],
+			Immediate: totals.immediate[period],
+		}
+		item.Recognized = item.Scheduled + item.Immediate
+		items = append(items, item)
+	}
+	return items
+}
+
+func buildDeferred(totals recognitionTotals, from model.Period, to model.Period) []model.DeferredRevenue {
+	balance := 0.0
+	start := from.String()
+	for period, amount := range totals.billed {
+		if period < start {
+			balance += amount
+		}
+	}
+	for period, amount := range totals.scheduled {
+		if period < start {
+			balance -= amount
+		}
+	}
+
+	items := make([]model.DeferredRevenue, 0)
+	for p := from; p.String() <= to.String(); p = p.AddMonths(1) {
+		period := p.String()
+		item := model.DeferredRevenue{
+			Period:     period,
+			Opening:    balance,
+			Billed:     totals.billed[period],
+			Recognized: totals.scheduled[period],
+		}
+		item.Closing = item.Opening + item.Billed - item.Recognized
+		balance = item.Closing
+		items = append(items, item)
+	}
+	return items
+}
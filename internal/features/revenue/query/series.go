@@ -0,0 +1,143 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"invoice-api/internal/features/revenue/model"
+)
+
+// dayTotal is the revenue recognized on one day in the series timezone.
+type dayTotal struct {
+	Day      string  `bson:"_id"`
+	Revenue  float64 `bson:"revenue"`
+	Invoices int64   `bson:"invoices"`
+}
+
+// bucketStart returns the first day of the bucket containing day. Weeks start
+// on Monday.
+func bucketStart(day time.Time, granularity string) time.Time {
+	y, m, d := day.Date()
+	loc := day.Location()
+	switch granularity {
+	case model.GranularityWeek:
+		offset := (int(day.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, loc)
+	case model.GranularityMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	case model.GranularityQuarter:
+		return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, loc)
+	case model.GranularityYear:
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, loc)
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// nextBucket returns the start of the bucket after the one starting at start.
+func nextBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case model.GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case model.GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	case model.GranularityQuarter:
+		return start.AddDate(0, 3, 0)
+	case model.GranularityYear:
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// yearAgo returns the start of the bucket a year before the one starting at
+// start. Weeks are compared with the same ISO week 52 weeks earlier.
+func yearAgo(start time.Time, granularity string) time.Time {
+	if granularity == model.GranularityWeek {
+		return start.AddDate(0, 0, -364)
+	}
+	return bucketStart(start.AddDate(-1, 0, 0), granularity)
+}
+
+func bucketLabel(start time.Time, granularity string) string {
+	switch granularity {
+	case model.GranularityWeek:
+		year, week := start.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case model.GranularityMonth:
+		return start.Format("2006-01")
+	case model.GranularityQuarter:
+		return fmt.Sprintf("%04d-Q%d", start.Year(), (int(start.Month())-1)/3+1)
+	case model.GranularityYear:
+		return start.Format("2006")
+	}
+	return start.Format(time.DateOnly)
+}
+
+// changePct returns the change from previous to current in percent, or nil
+// when previous is zero.
+func changePct(current, previous float64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	pct := math.Round((current-previous)/previous*10000) / 100
+	return &pct
+}
+
+// buildSeries buckets the daily totals and fills gaps with zero. days must
+// cover the year before q.From so that the first points have a previous and a
+// previous-year value.
+func buildSeries(q model.SeriesQuery, days []dayTotal) (*model.RevenueSeries, error) {
+	type bucket struct {
+		revenue  float64
+		invoices int64
+	}
+	buckets := make(map[string]bucket)
+	for _, d := range days {
+		day, err := time.ParseInLocation(time.DateOnly, d.Day, q.Location)
+		if err != nil {
+			return nil, err
+		}
+		key := bucketStart(day, q.Granularity).Format(time.DateOnly)
+		b := buckets[key]
+		b.revenue += d.Revenue
+		b.invoices += d.Invoices
+		buckets[key] = b
+	}
+
+	start := bucketStart(q.From, q.Granularity)
+	last := bucketStart(q.To, q.Granularity)
+	series := &model.RevenueSeries{
+		From:        start.Format(time.DateOnly),
+		Granularity: q.Granularity,
+		Timezone:    q.Location.String(),
+		Basis:       q.Basis,
+		Points:      make([]model.SeriesPoint, 0),
+	}
+
+	for b := start; !b.After(last); b = nextBucket(b, q.Granularity) {
+		if len(series.Points) == model.MaxSeriesPoints {
+			return nil, fmt.Errorf("range has more than %d %s buckets", model.MaxSeriesPoints, q.Granularity)
+		}
+		current := buckets[b.Format(time.DateOnly)]
+		previous := buckets[bucketStart(b.AddDate(0, 0, -1), q.Granularity).Format(time.DateOnly)]
+		previousYear := buckets[yearAgo(b, q.Granularity).Format(time.DateOnly)]
+
+		series.Points = append(series.Points, model.SeriesPoint{
+			Period:          bucketLabel(b, q.Granularity),
+			Start:           b.Format(time.DateOnly),
+			Revenue:         current.revenue,
+			Invoices:        current.invoices,
+			Previous:        previous.revenue,
+			ChangePct:       changePct(current.revenue, previous.revenue),
+			PreviousYear:    previousYear.revenue,
+			YearOverYearPct: changePct(current.revenue, previousYear.revenue),
+		})
+		series.Total += current.revenue
+		series.Invoices += current.invoices
+		series.PreviousYearTotal += previousYear.revenue
+		series.To = nextBucket(b, q.Granularity).AddDate(0, 0, -1).Format(time.DateOnly)
+	}
+	series.YearOverYearPct = changePct(series.Total, series.PreviousYearTotal)
+
+	return series, nil
+}
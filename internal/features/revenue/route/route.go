@@ -2,6 +2,8 @@ package route
 
 import (
 	"invoice-api/internal/features/revenue/controller"
+	user_model "invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -12,11 +14,16 @@ func (c *InvoiceRoute) Init(router *fiber.App) {
 	controller := new(controller.RevenueController)
 	revenues := router.Group("/revenues")
 
-	revenues.Post("/", controller.CreateRevenue)
-	revenues.Get("/", controller.GetAllRevenues)
-	revenues.Get("/:id", controller.GetRevenueByID)
-	revenues.Patch("/:id", controller.UpdateRevenue)
-	revenues.Delete("/:id", controller.DeleteRevenue)
+	revenues.Post("/", middleware.RequirePermission(user_model.PermRevenueWrite), controller.CreateRevenue)
+	revenues.Get("/", middleware.RequirePermission(user_model.PermRevenueRead), controller.GetAllRevenues)
+	revenues.Get("/series", middleware.RequirePermission(user_model.PermRevenueRead), controller.GetRevenueSeries)
+	revenues.Get("/forecast", middleware.RequirePermission(user_model.PermRevenueRead), controller.GetRevenueForecast)
+	revenues.Get("/recognized", middleware.RequirePermission(user_model.PermRevenueRead), controller.GetRecognizedRevenue)
+	revenues.Get("/deferred", middleware.RequirePermission(user_model.PermRevenueRead), controller.GetDeferredRevenue)
+	revenues.Get("/:id", middleware.RequirePermission(user_model.PermRevenueRead), controller.GetRevenueByID)
+	revenues.Patch("/:id", middleware.RequirePermission(user_model.PermRevenueWrite), controller.UpdateRevenue)
+	revenues.Put("/:period", middleware.RequirePermission(user_model.PermRevenueWrite), controller.PutRevenue)
+	revenues.Delete("/:id", middleware.RequirePermission(user_model.PermRevenueWrite), controller.DeleteRevenue)
 }
 
 	
\ No newline at end of file
@@ -3,16 +3,23 @@ package command
 import (
 	"context"
 	"invoice-api/internal/database"
+	org_command "invoice-api/internal/features/org/command"
 	"invoice-api/internal/features/user/model"
+	"log"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
-type DefaultCommand struct{}
+// DefaultCommand manages the members of OrgID. Without an OrgID, CreateUser
+// signs a user up with an organization of their own.
+type DefaultCommand struct {
+	OrgID primitive.ObjectID
+}
 
 func (c *DefaultCommand) CollectionName() string {
 	return "users"
@@ -21,36 +28,132 @@ func (c *DefaultCommand) CollectionName() string {
 type Command interface {
 	CreateUser(_val *model.CreateUser) (*mongo.InsertOneResult, error)
 	UpdateUser(id string, _val *model.UpdateUser) (*mongo.UpdateResult, error)
+	UpdateProfile(userID primitive.ObjectID, _val *model.UpdateProfile) (*mongo.UpdateResult, error)
+	SetEmail(userID primitive.ObjectID, email string) (*mongo.UpdateResult, error)
 	DeleteUser(id string) (*mongo.DeleteResult, error)
+	SetPassword(userID primitive.ObjectID, password string) (*mongo.UpdateResult, error)
+	VerifyEmail(userID primitive.ObjectID) (*mongo.UpdateResult, error)
+}
+
+// EnsureEmailVerification marks users created before email verification
+// existed as verified so that they can still sign in. Users who sign up
+// afterwards are stored with emailVerified set and are left alone.
+func EnsureEmailVerification(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("users").UpdateMany(ctx,
+		bson.M{"emailVerified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"emailVerified": true}})
+	return err
+}
+
+// EnsureIndexes stores email addresses normalized and makes them unique, so
+// that an address cannot belong to two users. Users whose addresses only
+// differed in case or spacing keep the address on the oldest of them; the
+// others are logged and keep theirs in conflictingEmail, where it does not
+// sign anyone in, until they are resolved by hand.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection("users")
+
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"email": bson.M{"$type": "string"}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}},
+			"ids":    bson.M{"$push": "$_id"},
+			"emails": bson.M{"$push": "$email"},
+		}}},
+		{{Key: "$match", Value: bson.M{"$expr": bson.M{"$or": bson.A{
+			bson.M{"$gt": bson.A{bson.M{"$size": "$ids"}, 1}},
+			bson.M{"$ne": bson.A{bson.M{"$arrayElemAt": bson.A{"$emails", 0}}, "$_id"}},
+		}}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		Email  string               `bson:"_id"`
+		IDs    []primitive.ObjectID `bson:"ids"`
+		Emails []string             `bson:"emails"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		if len(group.IDs) > 1 {
+			log.Printf("users %v share the email address of user %v and cannot sign in with it until they are resolved",
+				group.IDs[1:], group.IDs[0])
+			conflicting := mongo.Pipeline{
+				{{Key: "$set", Value: bson.M{"conflictingEmail": "$email"}}},
+				{{Key: "$unset", Value: "email"}},
+			}
+			if _, err := collection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": group.IDs[1:]}}, conflicting); err != nil {
+				return err
+			}
+		}
+		if group.Emails[0] != group.Email {
+			if _, err := collection.UpdateOne(ctx, bson.M{"_id": group.IDs[0]}, bson.M{"$set": bson.M{"email": group.Email}}); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetName("email_unique").SetUnique(true).
+			SetPartialFilterExpression(bson.M{"email": bson.M{"$type": "string"}}),
+	})
+	return err
 }
 
 func (c *DefaultCommand) CreateUser(_val *model.CreateUser) (*mongo.InsertOneResult, error) {
 	db := database.GetDatabase()
 	collection := db.Collection(c.CollectionName())
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
 	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(_val.Password), bcrypt.DefaultCost)
 	user := &model.User{
-		FirstName:  _val.FirstName,
-		LastName:   _val.LastName,
-		MiddleName: _val.MiddleName,
-		Email:      _val.Email,
-		Password:   string(hashedPassword),
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		FirstName:     _val.FirstName,
+		LastName:      _val.LastName,
+		MiddleName:    _val.MiddleName,
+		Email:         model.NormalizeEmail(_val.Email),
+		Password:      string(hashedPassword),
+		EmailVerified: _val.EmailVerified,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	res, err := collection.InsertOne(ctx, user)
 	if err != nil {
 		return nil, err
 	}
+	userID := res.InsertedID.(primitive.ObjectID)
+
+	if c.OrgID.IsZero() {
+		_, err = (&org_command.DefaultCommand{}).CreateOrganization(user.FirstName+"'s workspace", userID)
+	} else {
+		_, err = (&org_command.DefaultCommand{OrgID: c.OrgID}).AddMember(userID, _val.Role)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	return res, nil
 }
 
+// UpdateUser changes the member's role in the organization.
 func (c *DefaultCommand) UpdateUser(id string, val *model.UpdateUser) (*mongo.UpdateResult, error) {
+	objId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return (&org_command.DefaultCommand{OrgID: c.OrgID}).UpdateMemberRole(objId, val.Role)
+}
+
+// UpdateProfile changes the user's own names.
+func (c *DefaultCommand) UpdateProfile(userID primitive.ObjectID, val *model.UpdateProfile) (*mongo.UpdateResult, error) {
 	db := database.GetDatabase()
 	collection := db.Collection(c.CollectionName())
 
@@ -62,17 +165,41 @@ func (c *DefaultCommand) UpdateUser(id string, val *model.UpdateUser) (*mongo.Up
 			"firstName":  val.FirstName,
 			"lastName":   val.LastName,
 			"middleName": val.MiddleName,
-			"email":      val.Email,
 			"updatedAt":  time.Now(),
 		},
 	}
 
-	objId, err := primitive.ObjectIDFromHex(id)
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": objId}, update)
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return result, nil
+}
+
+// SetEmail changes the user's email address to one they confirmed through a
+// link sent to it. It fails with a duplicate key error when the address
+// belongs to another user.
+func (c *DefaultCommand) SetEmail(userID primitive.ObjectID, email string) (*mongo.UpdateResult, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"email":         model.NormalizeEmail(email),
+			"emailVerified": true,
+			"updatedAt":     time.Now(),
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
 	if err != nil {
 		return nil, err
 	}
@@ -84,7 +211,8 @@ func (c *DefaultCommand) UpdateUser(id string, val *model.UpdateUser) (*mongo.Up
 	return result, nil
 }
 
-// DeleteUser executes the delete user command
+// DeleteUser removes the user from the organization. The user itself is
+// deleted once they no longer belong to any organization.
 func (c *DefaultCommand) DeleteUser(id string) (*mongo.DeleteResult, error) {
 	db := database.GetDatabase()
 	collection := db.Collection(c.CollectionName())
@@ -97,14 +225,81 @@ func (c *DefaultCommand) DeleteUser(id string) (*mongo.DeleteResult, error) {
 		return nil, err
 	}
 
-	res, err := collection.DeleteOne(ctx, bson.M{"_id": objId})
+	res, err := (&org_command.DefaultCommand{OrgID: c.OrgID}).RemoveMember(objId)
 	if err != nil {
 		return nil, err
 	}
 
-	if res.DeletedCount == 0 {
-		return nil, mongo.ErrNoDocuments
+	remaining, err := db.Collection("memberships").CountDocuments(ctx, bson.M{"userId": objId})
+	if err != nil {
+		return nil, err
+	}
+	if remaining == 0 {
+		if _, err := collection.DeleteOne(ctx, bson.M{"_id": objId}); err != nil {
+			return nil, err
+		}
 	}
 
 	return res, nil
 }
+
+// SetPassword replaces the user's password. Users only get here through a
+// link sent to their email address, so the address is verified as well.
+func (c *DefaultCommand) SetPassword(userID primitive.ObjectID, password string) (*mongo.UpdateResult, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"password":      string(hashedPassword),
+			"emailVerified": true,
+			"updatedAt":     time.Now(),
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return result, nil
+}
+
+// VerifyEmail marks the user's email address as verified.
+func (c *DefaultCommand) VerifyEmail(userID primitive.ObjectID) (*mongo.UpdateResult, error) {
+	db := database.GetDatabase()
+	collection := db.Collection(c.CollectionName())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"emailVerified": true,
+			"updatedAt":     time.Now(),
+		},
+	}
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return result, nil
+}
@@ -1,9 +1,12 @@
 package controller
 
 import (
+	auth_command "invoice-api/internal/features/auth/command"
+	auth_model "invoice-api/internal/features/auth/model"
 	"invoice-api/internal/features/user/command"
 	"invoice-api/internal/features/user/model"
 	"invoice-api/internal/features/user/query"
+	"invoice-api/middleware"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,15 +16,35 @@ import (
 type UserController struct {
 	Command           command.Command
 	Query             query.Query
+	Tokens            auth_command.Command
 }
 
-func (s *UserController) CreateUser(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultQuery{}
+// query returns the injected query, or one scoped to the organization the
+// request was authorized for.
+func (s *UserController) query(c *fiber.Ctx) query.Query {
+	if s.Query != nil {
+		return s.Query
 	}
-	if s.Command == nil {
-		s.Command = &command.DefaultCommand{}
+	return &query.DefaultQuery{OrgID: middleware.OrgID(c)}
+}
+
+// command returns the injected command, or one scoped to the organization
+// the request was authorized for.
+func (s *UserController) command(c *fiber.Ctx) command.Command {
+	if s.Command != nil {
+		return s.Command
+	}
+	return &command.DefaultCommand{OrgID: middleware.OrgID(c)}
+}
+
+func (s *UserController) tokens() auth_command.Command {
+	if s.Tokens == nil {
+		s.Tokens = &auth_command.DefaultCommand{}
 	}
+	return s.Tokens
+}
+
+func (s *UserController) CreateUser(c *fiber.Ctx) error {
 	
 	payload := new(model.CreateUser)
 	if err := c.BodyParser(payload); err != nil {
@@ -33,12 +56,17 @@ func (s *UserController) CreateUser(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
 	}
 
-	item, _ := s.Query.GetItemByEmail(payload.Email)
+	if payload.Role == model.RoleOwner && !isOwner(c) {
+		return c.Status(403).JSON(fiber.Map{"status": "fail", "message": "Only an owner can grant the owner role"})
+	}
+
+	item, _ := s.query(c).GetItemByEmail(payload.Email)
 	if item.ID != primitive.NilObjectID {
 		return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "The email address already taken. Please select another email address"})
 	}
 
-	resp, err := s.Command.CreateUser(payload)
+	payload.EmailVerified = true
+	resp, err := s.command(c).CreateUser(payload)
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Failed to create user",
@@ -48,11 +76,31 @@ func (s *UserController) CreateUser(c *fiber.Ctx) error {
 	return c.Status(201).JSON(resp)
 }
 
-func (s *UserController) GetAllUsers(c *fiber.Ctx) error {
-	if s.Query == nil {
-		s.Query = &query.DefaultQuery{}
+// isOwner reports whether the authenticated user is an owner.
+func isOwner(c *fiber.Ctx) bool {
+	user, ok := middleware.CurrentUser(c)
+	return ok && user.Role == model.RoleOwner
+}
+
+// lastOwner reports whether the organization has a single owner, who can
+// then be neither demoted nor removed.
+func (s *UserController) lastOwner(c *fiber.Ctx) (bool, error) {
+	members, err := s.query(c).GetItemsByQuery()
+	if err != nil {
+		return false, err
 	}
-	users, err := s.Query.GetItemsByQuery()
+
+	owners := 0
+	for _, member := range members {
+		if member.Role == model.RoleOwner {
+			owners++
+		}
+	}
+	return owners <= 1, nil
+}
+
+func (s *UserController) GetAllUsers(c *fiber.Ctx) error {
+	users, err := s.query(c).GetItemsByQuery()
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{
 			"error": "Failed to fetch users",
@@ -65,7 +113,7 @@ func (s *UserController) GetAllUsers(c *fiber.Ctx) error {
 func (s *UserController) GetUserByID(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	user, err := s.Query.GetItemByID(id)
+	user, err := s.query(c).GetItemByID(id)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
@@ -80,6 +128,8 @@ func (s *UserController) GetUserByID(c *fiber.Ctx) error {
 	return c.JSON(user)
 }
 
+// UpdateUser changes the role of a member of the organization. Their names
+// and email address are their own to change.
 func (s *UserController) UpdateUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 
@@ -92,7 +142,40 @@ func (s *UserController) UpdateUser(c *fiber.Ctx) error {
 	if validationErrors != nil {
 		return c.Status(400).JSON(fiber.Map{"status": "error", "errors": validationErrors})
 	}
-	res, err := s.Command.UpdateUser(id, payload)
+
+	if payload.Role == model.RoleOwner && !isOwner(c) {
+		return c.Status(403).JSON(fiber.Map{"status": "fail", "message": "Only an owner can grant the owner role"})
+	}
+
+	target, err := s.query(c).GetItemByID(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch user",
+		})
+	}
+	if target.Role == model.RoleOwner {
+		if !isOwner(c) {
+			return c.Status(403).JSON(fiber.Map{"status": "fail", "message": "Only an owner can change an owner"})
+		}
+		if payload.Role != model.RoleOwner {
+			last, err := s.lastOwner(c)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{
+					"error": "Failed to update user",
+				})
+			}
+			if last {
+				return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "The organization needs at least one owner"})
+			}
+		}
+	}
+
+	res, err := s.command(c).UpdateUser(id, payload)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
@@ -104,7 +187,7 @@ func (s *UserController) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	if res.ModifiedCount == 0 {
+	if res.MatchedCount == 0 {
 		return c.Status(404).JSON(fiber.Map{
 			"error": "Failed to update user",
 		})
@@ -118,7 +201,33 @@ func (s *UserController) UpdateUser(c *fiber.Ctx) error {
 func (s *UserController) DeleteUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	res, err := s.Command.DeleteUser(id)
+	target, err := s.query(c).GetItemByID(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch user",
+		})
+	}
+	if target.Role == model.RoleOwner {
+		if !isOwner(c) {
+			return c.Status(403).JSON(fiber.Map{"status": "fail", "message": "Only an owner can remove an owner"})
+		}
+		last, err := s.lastOwner(c)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{
+				"error": "Failed to delete user",
+			})
+		}
+		if last {
+			return c.Status(409).JSON(fiber.Map{"status": "fail", "message": "The organization needs at least one owner"})
+		}
+	}
+
+	res, err := s.command(c).DeleteUser(id)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(404).JSON(fiber.Map{
@@ -139,4 +248,32 @@ func (s *UserController) DeleteUser(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
 		"message": "User deleted successfully",
 	})
-}
\ No newline at end of file
+}
+
+// UnlockUser lifts the sign-in lockout and backoff of a member of the
+// organization after too many failed attempts.
+func (s *UserController) UnlockUser(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	user, err := s.query(c).GetItemByID(id)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(404).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(400).JSON(fiber.Map{
+			"error": "Failed to fetch user",
+		})
+	}
+
+	if err := s.tokens().ResetLoginAttempts(auth_model.AccountKey(user.Email)); err != nil {
+		return c.Status(500).JSON(fiber.Map{
+			"error": "Failed to unlock user",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "User unlocked successfully",
+	})
+}
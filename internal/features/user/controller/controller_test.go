@@ -12,6 +12,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
+	auth_command "invoice-api/internal/features/auth/command"
 	modelpkg "invoice-api/internal/features/user/model"
 )
 
@@ -32,10 +33,26 @@ func (m *mockCommand) UpdateUser(id string, _val *modelpkg.UpdateUser) (*mongo.U
 	return m.updateRes, m.updateErr
 }
 
+func (m *mockCommand) UpdateProfile(userID primitive.ObjectID, _val *modelpkg.UpdateProfile) (*mongo.UpdateResult, error) {
+	return m.updateRes, m.updateErr
+}
+
+func (m *mockCommand) SetEmail(userID primitive.ObjectID, email string) (*mongo.UpdateResult, error) {
+	return m.updateRes, m.updateErr
+}
+
 func (m *mockCommand) DeleteUser(id string) (*mongo.DeleteResult, error) {
 	return m.deleteRes, m.deleteErr
 }
 
+func (m *mockCommand) SetPassword(userID primitive.ObjectID, password string) (*mongo.UpdateResult, error) {
+	return m.updateRes, m.updateErr
+}
+
+func (m *mockCommand) VerifyEmail(userID primitive.ObjectID) (*mongo.UpdateResult, error) {
+	return m.updateRes, m.updateErr
+}
+
 type mockQuery struct {
 	itemsRes []modelpkg.UserDTO
 	itemsErr error
@@ -57,6 +74,18 @@ func (m *mockQuery) GetItemByEmail(email string) (modelpkg.User, error) {
 	return m.byEmail, m.byEmailErr
 }
 
+// mockTokens records the sign-in counters that are reset. Other methods are
+// not used by the controller.
+type mockTokens struct {
+	auth_command.Command
+	reset []string
+}
+
+func (m *mockTokens) ResetLoginAttempts(key string) error {
+	m.reset = append(m.reset, key)
+	return nil
+}
+
 func TestCreateUser_SuccessAndBadBodyAndValidationAndEmailTaken(t *testing.T) {
 	app := fiber.New()
 
@@ -160,10 +189,10 @@ func TestUpdateUser_NotFoundAndSuccess(t *testing.T) {
 	// not found (err == mongo.ErrNoDocuments)
 	app := fiber.New()
 	notFoundMock := &mockCommand{updateRes: nil, updateErr: mongo.ErrNoDocuments}
-	ctrl := &UserController{Command: notFoundMock}
+	ctrl := &UserController{Command: notFoundMock, Query: &mockQuery{itemErr: mongo.ErrNoDocuments}}
 	app.Put("/:id", ctrl.UpdateUser)
 
-	payload := map[string]string{"firstName": "New Name"}
+	payload := map[string]string{"role": modelpkg.RoleAccountant}
 	b, _ := json.Marshal(payload)
 	req := httptest.NewRequest("PUT", "/someid", bytes.NewReader(b))
 	req.Header.Set("Content-Type", "application/json")
@@ -171,9 +200,9 @@ func TestUpdateUser_NotFoundAndSuccess(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 404, resp.StatusCode)
 
-	// update success with ModifiedCount 1
-	succMock := &mockCommand{updateRes: &mongo.UpdateResult{ModifiedCount: 1}, updateErr: nil}
-	ctrl2 := &UserController{Command: succMock}
+	// update success
+	succMock := &mockCommand{updateRes: &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, updateErr: nil}
+	ctrl2 := &UserController{Command: succMock, Query: &mockQuery{itemRes: &modelpkg.UserDTO{Role: modelpkg.RoleViewer}}}
 	app2 := fiber.New()
 	app2.Put("/:id", ctrl2.UpdateUser)
 
@@ -194,7 +223,7 @@ func TestDeleteUser_NotFoundAndSuccess(t *testing.T) {
 
 	// Not found case
 	notFoundMock := &mockCommand{deleteRes: nil, deleteErr: mongo.ErrNoDocuments}
-	ctrl := &UserController{Command: notFoundMock}
+	ctrl := &UserController{Command: notFoundMock, Query: &mockQuery{itemErr: mongo.ErrNoDocuments}}
 	app.Delete("/:id", ctrl.DeleteUser)
 
 	req := httptest.NewRequest("DELETE", "/someid", nil)
@@ -204,7 +233,7 @@ func TestDeleteUser_NotFoundAndSuccess(t *testing.T) {
 
 	// success case
 	succMock := &mockCommand{deleteRes: &mongo.DeleteResult{DeletedCount: 1}, deleteErr: nil}
-	ctrl2 := &UserController{Command: succMock}
+	ctrl2 := &UserController{Command: succMock, Query: &mockQuery{itemRes: &modelpkg.UserDTO{Role: modelpkg.RoleViewer}}}
 	app2 := fiber.New()
 	app2.Delete("/:id", ctrl2.DeleteUser)
 
@@ -218,3 +247,143 @@ func TestDeleteUser_NotFoundAndSuccess(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, "User deleted successfully", body["message"])
 }
+
+func TestUpdateUser_OnlyOwnersManageOwners(t *testing.T) {
+	send := func(callerRole string, target *modelpkg.UserDTO, role string) int {
+		app := fiber.New()
+		ctrl := &UserController{
+			Command: &mockCommand{updateRes: &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}},
+			Query:   &mockQuery{itemRes: target},
+		}
+		app.Patch("/:id", func(c *fiber.Ctx) error {
+			c.Locals("user", modelpkg.UserDTO{Role: callerRole})
+			return c.Next()
+		}, ctrl.UpdateUser)
+
+		b, _ := json.Marshal(map[string]string{"role": role})
+		req := httptest.NewRequest("PATCH", "/someid", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	viewer := &modelpkg.UserDTO{Role: modelpkg.RoleViewer}
+	owner := &modelpkg.UserDTO{Role: modelpkg.RoleOwner}
+
+	require.Equal(t, 403, send(modelpkg.RoleAdmin, viewer, modelpkg.RoleOwner))
+	require.Equal(t, 403, send(modelpkg.RoleAdmin, owner, modelpkg.RoleViewer))
+	require.Equal(t, 200, send(modelpkg.RoleAdmin, viewer, modelpkg.RoleAccountant))
+	require.Equal(t, 200, send(modelpkg.RoleOwner, viewer, modelpkg.RoleOwner))
+	require.Equal(t, 400, send(modelpkg.RoleOwner, viewer, "superuser"))
+}
+
+// Only owners can change or remove an owner, so that an admin cannot take
+// over an owner's organization.
+func TestOwnersAreOnlyManagedByOwners(t *testing.T) {
+	owners := []modelpkg.UserDTO{{Role: modelpkg.RoleOwner}, {Role: modelpkg.RoleOwner}}
+	send := func(callerRole string, method string, body map[string]string) int {
+		app := fiber.New()
+		ctrl := &UserController{
+			Command: &mockCommand{updateRes: &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, deleteRes: &mongo.DeleteResult{DeletedCount: 1}},
+			Query:   &mockQuery{itemRes: &owners[0], itemsRes: owners},
+		}
+		handler := ctrl.UpdateUser
+		if method == "DELETE" {
+			handler = ctrl.DeleteUser
+		}
+		app.Add(method, "/:id", func(c *fiber.Ctx) error {
+			c.Locals("user", modelpkg.UserDTO{Role: callerRole})
+			return c.Next()
+		}, handler)
+
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, "/someid", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	require.Equal(t, 403, send(modelpkg.RoleAdmin, "PATCH", map[string]string{"role": modelpkg.RoleViewer}))
+	require.Equal(t, 403, send(modelpkg.RoleAdmin, "DELETE", nil))
+	require.Equal(t, 200, send(modelpkg.RoleOwner, "PATCH", map[string]string{"role": modelpkg.RoleAdmin}))
+	require.Equal(t, 200, send(modelpkg.RoleOwner, "DELETE", nil))
+}
+
+// Users are shared between organizations, so admins can only change a
+// member's role. Changing the email address of a member would let them take
+// the account over with a password reset.
+func TestUpdateUser_CannotChangeIdentity(t *testing.T) {
+	app := fiber.New()
+	ctrl := &UserController{
+		Command: &mockCommand{updateRes: &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}},
+		Query:   &mockQuery{itemRes: &modelpkg.UserDTO{Role: modelpkg.RoleViewer}},
+	}
+	app.Patch("/:id", func(c *fiber.Ctx) error {
+		c.Locals("user", modelpkg.UserDTO{Role: modelpkg.RoleOwner})
+		return c.Next()
+	}, ctrl.UpdateUser)
+
+	b, _ := json.Marshal(map[string]string{"email": "mallory@example.com", "firstName": "Mallory"})
+	req := httptest.NewRequest("PATCH", "/someid", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 400, resp.StatusCode)
+}
+
+func TestLastOwnerIsKept(t *testing.T) {
+	owner := modelpkg.UserDTO{Role: modelpkg.RoleOwner}
+	send := func(method string, body map[string]string) int {
+		app := fiber.New()
+		ctrl := &UserController{
+			Command: &mockCommand{updateRes: &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, deleteRes: &mongo.DeleteResult{DeletedCount: 1}},
+			Query:   &mockQuery{itemRes: &owner, itemsRes: []modelpkg.UserDTO{owner, {Role: modelpkg.RoleAdmin}}},
+		}
+		handler := ctrl.UpdateUser
+		if method == "DELETE" {
+			handler = ctrl.DeleteUser
+		}
+		app.Add(method, "/:id", func(c *fiber.Ctx) error {
+			c.Locals("user", modelpkg.UserDTO{Role: modelpkg.RoleOwner})
+			return c.Next()
+		}, handler)
+
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, "/someid", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	require.Equal(t, 409, send("PATCH", map[string]string{"role": modelpkg.RoleAdmin}))
+	require.Equal(t, 409, send("DELETE", nil))
+	require.Equal(t, 200, send("PATCH", map[string]string{"role": modelpkg.RoleOwner}))
+}
+
+func TestUnlockUser_NotFoundAndSuccess(t *testing.T) {
+	tokens := &mockTokens{}
+
+	// a user of another organization is not found
+	ctrl := &UserController{Query: &mockQuery{itemErr: mongo.ErrNoDocuments}, Tokens: tokens}
+	app := fiber.New()
+	app.Post("/:id/unlock", ctrl.UnlockUser)
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/someid/unlock", nil))
+	require.NoError(t, err)
+	require.Equal(t, 404, resp.StatusCode)
+	require.Empty(t, tokens.reset)
+
+	// success
+	user := &modelpkg.UserDTO{ID: primitive.NewObjectID(), Email: "Jane@Example.com"}
+	ctrl2 := &UserController{Query: &mockQuery{itemRes: user}, Tokens: tokens}
+	app2 := fiber.New()
+	app2.Post("/:id/unlock", ctrl2.UnlockUser)
+
+	resp2, err := app2.Test(httptest.NewRequest("POST", "/someid/unlock", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp2.StatusCode)
+	require.Equal(t, []string{"account:jane@example.com"}, tokens.reset)
+}
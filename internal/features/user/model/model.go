@@ -1,6 +1,7 @@
 package model
 
 import (
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator"
@@ -16,16 +17,22 @@ type User struct {
 	MiddleName string             `bson:"middleName" json:"middleName"`
 	Email      string             `bson:"email" json:"email"`
 	Password   string             `bson:"password" json:"password"`
-	CreatedAt  time.Time          `bson:"createdAt,omitempty" json:"createdAt"`
-	UpdatedAt  time.Time          `bson:"updatedAt,omitempty" json:"updatedAt"`
+	// EmailVerified is false for users who signed up and have not yet
+	// confirmed their email address; they cannot sign in.
+	EmailVerified bool      `bson:"emailVerified" json:"emailVerified"`
+	CreatedAt     time.Time `bson:"createdAt,omitempty" json:"createdAt"`
+	UpdatedAt     time.Time `bson:"updatedAt,omitempty" json:"updatedAt"`
 }
 
+// UserDTO is a user as a member of an organization. Role is the user's role
+// in that organization.
 type UserDTO struct {
 	ID         primitive.ObjectID `bson:"_id" json:"id"`
 	FirstName  string             `bson:"firstName" json:"firstName"`
 	LastName   string             `bson:"lastName" json:"lastName"`
 	MiddleName string             `bson:"middleName" json:"middleName"`
 	Email      string             `bson:"email" json:"email"`
+	Role       string             `bson:"role" json:"role"`
 	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
 	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt,omitzero"`
 }
@@ -36,13 +43,26 @@ type CreateUser struct {
 	MiddleName string `json:"middleName"`
 	Email      string `json:"email" validate:"required"`
 	Password   string `json:"password" validate:"required"`
+	// Role is the user's role in the organization creating them and defaults
+	// to viewer. Users who sign up become the owner of a new organization.
+	Role string `json:"role" validate:"omitempty,oneof=owner admin accountant sales viewer"`
+	// EmailVerified is set for users created by a user manager, who vouches
+	// for the address. Users who sign up verify it themselves.
+	EmailVerified bool `json:"-"`
 }
 
+// UpdateUser changes a member's role in the organization. Names and the
+// email address belong to the user, who is shared between organizations, and
+// only the user can change them through UpdateProfile and an email change.
 type UpdateUser struct {
-	FirstName  string `json:"firstName"`
-	LastName   string `json:"lastName"`
+	Role string `json:"role" validate:"required,oneof=owner admin accountant sales viewer"`
+}
+
+// UpdateProfile is how users change their own names.
+type UpdateProfile struct {
+	FirstName  string `json:"firstName" validate:"required"`
+	LastName   string `json:"lastName" validate:"required"`
 	MiddleName string `json:"middleName"`
-	Email      string `json:"email"`
 }
 
 type SignUp struct {
@@ -56,8 +76,19 @@ type SignUp struct {
 type SignIn struct {
 	Email    string `json:"email" validate:"required"`
 	Password string `json:"password" validate:"required"`
+	// OrgID picks the organization to sign in to. It defaults to the user's
+	// first organization.
+	OrgID string `json:"orgId"`
+}
+
+// NormalizeEmail returns the form in which email addresses are stored and
+// looked up, so that an address belongs to one user whatever its case.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
+// FilterUserRecord drops the password. The role is left for the caller to
+// fill in from the user's membership.
 func FilterUserRecord(user *User) UserDTO {
 	return UserDTO{
 		ID:        user.ID,
@@ -0,0 +1,77 @@
+package model
+
+import "slices"
+
+// Roles, from most to least privileged. Owners and admins can do everything;
+// only an owner can make someone else an owner.
+const (
+	RoleOwner      = "owner"
+	RoleAdmin      = "admin"
+	RoleAccountant = "accountant"
+	RoleSales      = "sales"
+	RoleViewer     = "viewer"
+)
+
+// Roles lists every valid role.
+var Roles = []string{RoleOwner, RoleAdmin, RoleAccountant, RoleSales, RoleViewer}
+
+// Permissions checked by the routes and controllers.
+const (
+	PermCustomerRead      = "customer:read"
+	PermCustomerWrite     = "customer:write"
+	PermCustomerDelete    = "customer:delete"
+	PermCustomerPrivacy   = "customer:privacy"
+	PermInvoiceRead       = "invoice:read"
+	PermInvoiceWrite      = "invoice:write"
+	PermInvoiceDelete     = "invoice:delete"
+	PermInvoiceVoid       = "invoice:void"
+	PermInvoiceOverride   = "invoice:override-credit"
+	PermRevenueRead       = "revenue:read"
+	PermRevenueWrite      = "revenue:write"
+	PermReportRead        = "report:read"
+	PermReportSubscribe   = "report:subscribe"
+	PermCustomFieldRead   = "customfield:read"
+	PermCustomFieldManage = "customfield:manage"
+	PermUserRead          = "user:read"
+	PermUserManage        = "user:manage"
+	PermOrgManage         = "org:manage"
+	PermAPIKeyManage      = "apikey:manage"
+)
+
+// AllPermissions lists every permission.
+var AllPermissions = []string{
+	PermCustomerRead, PermCustomerWrite, PermCustomerDelete, PermCustomerPrivacy,
+	PermInvoiceRead, PermInvoiceWrite, PermInvoiceDelete, PermInvoiceVoid, PermInvoiceOverride,
+	PermRevenueRead, PermRevenueWrite,
+	PermReportRead, PermReportSubscribe,
+	PermCustomFieldRead, PermCustomFieldManage,
+	PermUserRead, PermUserManage,
+	PermOrgManage, PermAPIKeyManage,
+}
+
+// RolePermissions is the permission matrix.
+var RolePermissions = map[string][]string{
+	RoleOwner: AllPermissions,
+	RoleAdmin: AllPermissions,
+	RoleAccountant: {
+		PermCustomerRead, PermCustomerWrite,
+		PermInvoiceRead, PermInvoiceWrite, PermInvoiceVoid, PermInvoiceOverride,
+		PermRevenueRead, PermRevenueWrite,
+		PermReportRead, PermReportSubscribe,
+		PermCustomFieldRead, PermUserRead,
+	},
+	RoleSales: {
+		PermCustomerRead, PermCustomerWrite,
+		PermInvoiceRead, PermInvoiceWrite,
+		PermReportRead, PermCustomFieldRead,
+	},
+	RoleViewer: {
+		PermCustomerRead, PermInvoiceRead, PermRevenueRead, PermReportRead, PermCustomFieldRead,
+	},
+}
+
+// HasPermission reports whether role grants permission. Unknown roles grant
+// nothing.
+func HasPermission(role string, permission string) bool {
+	return slices.Contains(RolePermissions[role], permission)
+}
@@ -3,15 +3,21 @@ package query
 import (
 	"context"
 	"invoice-api/internal/database"
+	"invoice-api/internal/database/tenant"
 	"invoice-api/internal/features/user/model"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type DefaultQuery struct{}
+// DefaultQuery lists the members of OrgID. Users themselves are shared
+// between organizations; which ones a query can see, and with what role, comes
+// from the organization's memberships.
+type DefaultQuery struct {
+	OrgID primitive.ObjectID
+}
 
 func (c *DefaultQuery) CollectionName() string {
 	return "users"
@@ -24,54 +30,72 @@ type Query interface {
 	GetItemByEmail(email string) (model.User, error)
 }
 
-func (c *DefaultQuery) GetItemsByQuery() ([]model.UserDTO, error) {
-	db := database.GetDatabase()
-	collection := db.Collection(c.CollectionName())
-
-	opts := options.Find()
-	opts.SetSort(bson.D{{Key: "createdAt", Value: -1}})
-	cursor, err := collection.Find(context.TODO(), bson.M{}, opts)
+// members returns the organization's members matching filter, with the role
+// each has in the organization.
+func (c *DefaultQuery) members(filter bson.M) ([]model.UserDTO, error) {
+	db := tenant.ForOrg(c.OrgID)
+	collection := db.Collection("memberships")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$lookup": bson.M{
+			"from":         c.CollectionName(),
+			"localField":   "userId",
+			"foreignField": "_id",
+			"as":           "user",
+		}},
+		{"$unwind": "$user"},
+		{"$replaceRoot": bson.M{"newRoot": bson.M{"$mergeObjects": bson.A{"$user", bson.M{"role": "$role"}}}}},
+		{"$project": bson.M{"password": 0}},
+		{"$sort": bson.D{{Key: "createdAt", Value: -1}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	defer cursor.Close(context.TODO())
-
-	items := make([]model.UserDTO, 0, 100) 
-	if err := cursor.All(context.TODO(), &items); err != nil {
+	items := make([]model.UserDTO, 0, 100)
+	if err := cursor.All(ctx, &items); err != nil {
 		return nil, err
 	}
 
 	return items, nil
 }
 
+func (c *DefaultQuery) GetItemsByQuery() ([]model.UserDTO, error) {
+	return c.members(bson.M{})
+}
+
 func (c *DefaultQuery) GetItemByID(id string) (*model.UserDTO, error) {
-	db := database.GetDatabase()
-	collection := db.Collection(c.CollectionName())
-	
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
 
-	var item model.UserDTO
-	err = collection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&item)
+	items, err := c.members(bson.M{"userId": objID})
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, err
-		}
 		return nil, err
 	}
+	if len(items) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
 
-	return &item, nil
+	return &items[0], nil
 }
 
+// GetItemByEmail finds a user in any organization. It backs sign-in and
+// checks for taken email addresses, which are unique across organizations
+// once normalized.
 func (c *DefaultQuery) GetItemByEmail(email string) (model.User, error) {
 	db := database.GetDatabase()
 	collection := db.Collection(c.CollectionName())
 
 	var user model.User
-	err := collection.FindOne(context.TODO(), bson.M{"email": email}).Decode(&user)
+	err := collection.FindOne(context.TODO(), bson.M{"email": model.NormalizeEmail(email)}).Decode(&user)
 
 	if err != nil {
 		return user, err
@@ -2,6 +2,8 @@ package route
 
 import (
 	"invoice-api/internal/features/user/controller"
+	"invoice-api/internal/features/user/model"
+	"invoice-api/middleware"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -19,9 +21,10 @@ func (c *UserRoute) Init(router *fiber.App) {
 	controller := new(controller.UserController)
 	users := router.Group("/users")
 
-	users.Post("/", controller.CreateUser)
-	users.Get("/", controller.GetAllUsers)
-	users.Get("/:id", controller.GetUserByID)
-	users.Patch("/:id", controller.UpdateUser)
-	users.Delete("/:id", controller.DeleteUser)
+	users.Post("/", middleware.RequirePermission(model.PermUserManage), controller.CreateUser)
+	users.Get("/", middleware.RequirePermission(model.PermUserRead), controller.GetAllUsers)
+	users.Get("/:id", middleware.RequirePermission(model.PermUserRead), controller.GetUserByID)
+	users.Patch("/:id", middleware.RequirePermission(model.PermUserManage), controller.UpdateUser)
+	users.Delete("/:id", middleware.RequirePermission(model.PermUserManage), controller.DeleteUser)
+	users.Post("/:id/unlock", middleware.RequirePermission(model.PermUserManage), controller.UnlockUser)
 }
\ No newline at end of file
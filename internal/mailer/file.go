@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes each message to Dir as an .eml file instead of sending
+// it. It is meant for development and tests.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := Encode(msg, sender(msg, m.From), now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := now.UTC().Format("20060102T150405.000000000") + "-" + randomID() + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o644)
+}
@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailer_WritesAttachments(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: dir, From: "reports@example.com"}
+
+	err := m.Send(context.Background(), Message{
+		To:          []string{"cfo@example.com"},
+		Subject:     "Weekly revenue",
+		HTML:        "<p>Attached</p>",
+		Attachments: []Attachment{{Filename: "revenue.csv", ContentType: "text/csv", Data: []byte("period,revenue\n2025-01,100\n")}},
+	})
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+
+	msg, err := mail.ReadMessage(f)
+	require.NoError(t, err)
+	require.Equal(t, "reports@example.com", msg.Header.Get("From"))
+	require.Equal(t, "cfo@example.com", msg.Header.Get("To"))
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/mixed", mediaType)
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	body, err := reader.NextPart()
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(body.Header.Get("Content-Type"), "text/html"))
+
+	attachment, err := reader.NextPart()
+	require.NoError(t, err)
+	require.Equal(t, "revenue.csv", attachment.FileName())
+	data, err := io.ReadAll(attachment)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "cGVyaW9k")
+}
@@ -0,0 +1,120 @@
+package oidc_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"invoice-api/internal/oidc"
+	"invoice-api/internal/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://localhost:3000/auth/oidc/callback"
+
+func signIn(t *testing.T, server *oidctest.Server, provider *oidc.Provider, verifier string) *url.URL {
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", verifier)
+	require.NoError(t, err)
+	callback, err := server.SignIn(authURL)
+	require.NoError(t, err)
+	require.Equal(t, "state", callback.Query().Get("state"))
+	return callback
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	server := oidctest.NewServer("invoice-api", "secret")
+	defer server.Close()
+	server.SetClaims(map[string]interface{}{
+		"sub":            "user-1",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"given_name":     "Jane",
+		"groups":         []string{"finance", "staff"},
+		"amr":            []string{"pwd", "mfa"},
+	})
+	provider := oidc.NewProvider(server.Config(redirectURL))
+
+	verifier, err := oidc.NewVerifier()
+	require.NoError(t, err)
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", verifier)
+	require.NoError(t, err)
+	query, err := url.Parse(authURL)
+	require.NoError(t, err)
+	require.Equal(t, oidc.Challenge(verifier), query.Query().Get("code_challenge"))
+	require.Equal(t, "S256", query.Query().Get("code_challenge_method"))
+	require.Equal(t, "openid email profile", query.Query().Get("scope"))
+
+	callback := signIn(t, server, provider, verifier)
+	idToken, err := provider.Exchange(context.Background(), callback.Query().Get("code"), verifier)
+	require.NoError(t, err)
+	require.Equal(t, "user-1", idToken.Subject)
+	require.Equal(t, "jane@example.com", idToken.Email)
+	require.True(t, idToken.EmailVerified)
+	require.Equal(t, "Jane", idToken.GivenName)
+	require.Equal(t, "nonce", idToken.Nonce)
+	require.Equal(t, []string{"finance", "staff"}, idToken.Strings("groups"))
+	require.Equal(t, []string{"pwd", "mfa"}, idToken.AMR)
+
+	// codes are single use
+	_, err = provider.Exchange(context.Background(), callback.Query().Get("code"), verifier)
+	require.Error(t, err)
+}
+
+func TestExchange_RequiresTheVerifier(t *testing.T) {
+	server := oidctest.NewServer("invoice-api", "secret")
+	defer server.Close()
+	server.SetClaims(map[string]interface{}{"sub": "user-1"})
+	provider := oidc.NewProvider(server.Config(redirectURL))
+
+	callback := signIn(t, server, provider, "the-verifier")
+	_, err := provider.Exchange(context.Background(), callback.Query().Get("code"), "another-verifier")
+	require.ErrorContains(t, err, "PKCE")
+}
+
+func TestVerify_RejectsForeignTokens(t *testing.T) {
+	server := oidctest.NewServer("invoice-api", "secret")
+	defer server.Close()
+	provider := oidc.NewProvider(server.Config(redirectURL))
+	now := time.Now()
+
+	valid := jwt.MapClaims{"iss": server.URL, "aud": "invoice-api", "sub": "user-1", "exp": now.Add(time.Minute).Unix()}
+	_, err := provider.Verify(context.Background(), server.Sign(valid))
+	require.NoError(t, err)
+
+	for name, change := range map[string]jwt.MapClaims{
+		"audience": {"aud": "another-app"},
+		"issuer":   {"iss": "https://evil.example.com"},
+		"expired":  {"exp": now.Add(-time.Minute).Unix()},
+		"azp":      {"aud": []string{"invoice-api", "another-app"}, "azp": "another-app"},
+		"subject":  {"sub": ""},
+	} {
+		claims := jwt.MapClaims{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		for k, v := range change {
+			claims[k] = v
+		}
+		_, err := provider.Verify(context.Background(), server.Sign(claims))
+		require.ErrorIs(t, err, oidc.ErrInvalidIDToken, name)
+	}
+
+	// tokens signed by anyone else
+	other := oidctest.NewServer("invoice-api", "secret")
+	defer other.Close()
+	_, err = provider.Verify(context.Background(), other.Sign(valid))
+	require.Error(t, err)
+}
+
+func TestDiscover_ChecksTheIssuer(t *testing.T) {
+	server := oidctest.NewServer("invoice-api", "secret")
+	defer server.Close()
+
+	config := server.Config(redirectURL)
+	config.Issuer = server.URL + "/"
+	_, err := oidc.NewProvider(config).Discover(context.Background())
+	require.ErrorContains(t, err, "reports issuer")
+}
@@ -8,9 +8,17 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/logger"
 
 	"invoice-api/internal/database"
+	analytics_route "invoice-api/internal/features/analytics/route"
+	apikey_route "invoice-api/internal/features/apikey/route"
 	auth_route "invoice-api/internal/features/auth/route"
 	customer_route "invoice-api/internal/features/customer/route"
+	customfield_route "invoice-api/internal/features/customfield/route"
+	dashboard_route "invoice-api/internal/features/dashboard/route"
 	invoice_route "invoice-api/internal/features/invoice/route"
+	org_route "invoice-api/internal/features/org/route"
+	portal_route "invoice-api/internal/features/portal/route"
+	report_route "invoice-api/internal/features/report/route"
+	reportsubscription_route "invoice-api/internal/features/reportsubscription/route"
 	revenue_route "invoice-api/internal/features/revenue/route"
 	user_route "invoice-api/internal/features/user/route"
 )
@@ -44,14 +52,30 @@ func New() *FiberServer {
 		Format:     "${blue}[${time}] | ${green}${status} | ${cyan}${latency} | ${blue}${ip} | ${method} | ${white}${path} | ${red}${error}${white}\n",
 	}))
 
+	orgRoute := new(org_route.OrgRoute)
+	orgRoute.Init(server.App)
 	userRoute := new(user_route.UserRoute)
 	userRoute.Init(server.App)
 	customerRoute := new(customer_route.CustomerRoute)
 	customerRoute.Init(server.App)
+	customFieldRoute := new(customfield_route.CustomFieldRoute)
+	customFieldRoute.Init(server.App)
 	invoiceRoute := new(invoice_route.InvoiceRoute)
 	invoiceRoute.Init(server.App)
 	revenueRoute := new(revenue_route.InvoiceRoute)
 	revenueRoute.Init(server.App)
+	portalRoute := new(portal_route.PortalRoute)
+	portalRoute.Init(server.App)
+	analyticsRoute := new(analytics_route.AnalyticsRoute)
+	analyticsRoute.Init(server.App)
+	reportRoute := new(report_route.ReportRoute)
+	reportRoute.Init(server.App)
+	reportSubscriptionRoute := new(reportsubscription_route.ReportSubscriptionRoute)
+	reportSubscriptionRoute.Init(server.App)
+	dashboardRoute := new(dashboard_route.DashboardRoute)
+	dashboardRoute.Init(server.App)
+	apiKeyRoute := new(apikey_route.APIKeyRoute)
+	apiKeyRoute.Init(server.App)
 	authRoute := new(auth_route.AuthRoute)
 	authRoute.Init(server.App)
 
@@ -2,11 +2,18 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"invoice-api/internal/database"
+	apikey_command "invoice-api/internal/features/apikey/command"
+	apikey_model "invoice-api/internal/features/apikey/model"
+	apikey_query "invoice-api/internal/features/apikey/query"
+	auth_query "invoice-api/internal/features/auth/query"
+	org_query "invoice-api/internal/features/org/query"
 	"invoice-api/internal/features/user/model"
 
 	"github.com/gofiber/fiber/v2"
@@ -18,10 +25,83 @@ import (
 
 var jwtSecret = os.Getenv("JWT_SECRET")
 
+// Authorize authenticates the request from its Bearer token, `token` cookie
+// or `ApiKey` authorization and stores the user in c.Locals("user") and the
+// organization the token was issued for in c.Locals("orgId"). The user's role
+// is their role in that organization. A request made with an API key acts as
+// the member who created the key, limited to the key's scopes.
+//
+// Members of an organization that requires two-factor authentication are
+// rejected unless they signed in with it.
 func Authorize(c *fiber.Ctx) error {
+	if status, message := authenticate(c, true, true); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"status": "fail", "message": message})
+	}
+
+	return c.Next()
+}
+
+// AuthorizeSession is Authorize for the routes that act on the user's own
+// account or session rather than the organization's data, which API keys
+// cannot use.
+func AuthorizeSession(c *fiber.Ctx) error {
+	if status, message := authenticate(c, true, false); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"status": "fail", "message": message})
+	}
+
+	return c.Next()
+}
+
+// AuthorizeTwoFactorSetup is AuthorizeSession for the routes that stay open
+// to members of organizations that require two-factor authentication who
+// signed in without it: enrolling in it, signing out and switching
+// organization.
+func AuthorizeTwoFactorSetup(c *fiber.Ctx) error {
+	if status, message := authenticate(c, false, false); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"status": "fail", "message": message})
+	}
+
+	return c.Next()
+}
+
+// Token identifies the access token a request was authorized with. Tokens
+// issued before revocation existed have no ID or family. TwoFactor is set
+// when the user signed in with two-factor authentication.
+type Token struct {
+	ID        string
+	FamilyID  string
+	ExpiresAt time.Time
+	TwoFactor bool
+}
+
+// CurrentToken returns the token Authorize accepted for the request. There
+// is none when the request was made with an API key.
+func CurrentToken(c *fiber.Ctx) (Token, bool) {
+	token, ok := c.Locals("token").(Token)
+	return token, ok
+}
+
+// CurrentAPIKey returns the API key Authorize accepted for the request.
+func CurrentAPIKey(c *fiber.Ctx) (*apikey_model.APIKey, bool) {
+	key, ok := c.Locals("apiKey").(*apikey_model.APIKey)
+	return key, ok
+}
+
+// authenticate does the work of Authorize, enforcing the organization's
+// two-factor requirement when enforceTwoFactor is set and accepting API keys
+// when allowAPIKey is set. On failure it returns the status and message to
+// respond with.
+func authenticate(c *fiber.Ctx, enforceTwoFactor bool, allowAPIKey bool) (int, string) {
 	var tokenString string
 	authorization := c.Get("Authorization")
 
+	if key, ok := strings.CutPrefix(authorization, "ApiKey "); ok {
+		if !allowAPIKey {
+			return fiber.StatusForbidden, "This route cannot be used with an API key"
+		}
+		return authenticateAPIKey(c, key)
+	}
+
 	if after, ok := strings.CutPrefix(authorization, "Bearer "); ok {
 		tokenString = after
 	} else if c.Cookies("token") != "" {
@@ -29,7 +109,7 @@ func Authorize(c *fiber.Ctx) error {
 	}
 
 	if tokenString == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "You are not logged in"})
+		return fiber.StatusUnauthorized, "You are not logged in"
 	}
 
 	if jwtSecret == "" {
@@ -40,39 +120,128 @@ func Authorize(c *fiber.Ctx) error {
 		jwtSecret = os.Getenv("JWT_SECRET")
 	}
 
-	tokenByte, err := jwt.Parse(tokenString, func(jwtToken *jwt.Token) (interface{}, error) {
-		if _, ok := jwtToken.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %s", jwtToken.Header["alg"])
-		}
-		return []byte(jwtSecret), nil
-	})
+	tokenByte, err := jwt.Parse(tokenString, verificationKey)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": fmt.Sprintf("Invalid token [01]: %v", err)})
+		return fiber.StatusUnauthorized, fmt.Sprintf("Invalid token [01]: %v", err)
 	}
 
 	claims, ok := tokenByte.Claims.(jwt.MapClaims)
 	if !ok || !tokenByte.Valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": "Invalid token claim"})
+		return fiber.StatusUnauthorized, "Invalid token claim"
+	}
+
+	token := Token{}
+	token.ID, _ = claims["jti"].(string)
+	token.FamilyID, _ = claims["fam"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		token.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	if amr, ok := claims["amr"].([]interface{}); ok {
+		for _, method := range amr {
+			token.TwoFactor = token.TwoFactor || method == "otp"
+		}
+	}
+	revoked, err := (&auth_query.DefaultQuery{}).IsRevoked(token.ID, token.FamilyID)
+	if err != nil {
+		return fiber.StatusUnauthorized, fmt.Sprintf("Invalid token [03]: %v", err)
+	}
+	if revoked {
+		return fiber.StatusUnauthorized, "Token has been revoked"
 	}
 
 	var user model.User
 	db := database.GetDatabase()
 	collection := db.Collection("users")
 
-	var claimsSub = fmt.Sprint(claims["sub"])
+	// Tokens issued before the subject was stored in "sub" carry it in "id".
+	subject, ok := claims["sub"]
+	if !ok {
+		subject = claims["id"]
+	}
+	var claimsSub = fmt.Sprint(subject)
 	var error error
 	ID, _ := primitive.ObjectIDFromHex(claimsSub)
 	error = collection.FindOne(context.TODO(), bson.M{"_id": ID}).Decode(&user)
 
 	if error != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "fail", "message": fmt.Sprintf("Invalid token [02]: %v", error)})
+		return fiber.StatusUnauthorized, fmt.Sprintf("Invalid token [02]: %v", error)
 	}
 
 	if user.ID.Hex() != claimsSub {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "fail", "message": "The user belonging to this token no logger exists"})
+		return fiber.StatusForbidden, "The user belonging to this token no logger exists"
 	}
 
-	c.Locals("user", model.FilterUserRecord(&user))
+	// Tokens issued before organizations carry no "org" claim and fall back
+	// to the user's first organization.
+	orgID, _ := primitive.ObjectIDFromHex(fmt.Sprint(claims["org"]))
+	membership, err := (&org_query.DefaultQuery{}).GetMembership(user.ID, orgID)
+	if err != nil {
+		return fiber.StatusForbidden, "You are not a member of this organization"
+	}
 
-	return c.Next()
+	if enforceTwoFactor && !token.TwoFactor {
+		org, err := (&org_query.DefaultQuery{}).GetOrganization(membership.OrgID)
+		if err != nil {
+			return fiber.StatusInternalServerError, "Failed to load organization"
+		}
+		if org.RequireTwoFactor {
+			return fiber.StatusForbidden, "This organization requires two-factor authentication. Enable it and sign in again"
+		}
+	}
+
+	dto := model.FilterUserRecord(&user)
+	dto.Role = membership.Role
+	c.Locals("user", dto)
+	c.Locals("orgId", membership.OrgID)
+	c.Locals("token", token)
+
+	return 0, ""
+}
+
+// authenticateAPIKey authenticates a request made with an API key as the
+// member who created it. Keys are not subject to the organization's
+// two-factor requirement.
+func authenticateAPIKey(c *fiber.Ctx, value string) (int, string) {
+	prefix, secret, ok := apikey_model.ParseKey(strings.TrimSpace(value))
+	if !ok {
+		return fiber.StatusUnauthorized, "Invalid API key"
+	}
+
+	key, err := (&apikey_query.DefaultQuery{}).GetItemByPrefix(prefix)
+	if err != nil {
+		return fiber.StatusUnauthorized, "Invalid API key"
+	}
+	if subtle.ConstantTimeCompare([]byte(apikey_model.HashSecret(secret)), []byte(key.SecretHash)) != 1 {
+		return fiber.StatusUnauthorized, "Invalid API key"
+	}
+	if key.RevokedAt != nil {
+		return fiber.StatusUnauthorized, "API key has been revoked"
+	}
+	if key.Expired(time.Now()) {
+		return fiber.StatusUnauthorized, "API key has expired"
+	}
+
+	var user model.User
+	db := database.GetDatabase()
+	if err := db.Collection("users").FindOne(context.TODO(), bson.M{"_id": key.CreatedBy}).Decode(&user); err != nil {
+		return fiber.StatusUnauthorized, "The user belonging to this API key no longer exists"
+	}
+
+	membership, err := (&org_query.DefaultQuery{}).GetMembership(user.ID, key.OrgID)
+	if err != nil || membership.OrgID != key.OrgID {
+		return fiber.StatusUnauthorized, "The user belonging to this API key is no longer a member of the organization"
+	}
+
+	// A failure to record the use must not fail the request.
+	if err := (&apikey_command.DefaultCommand{}).RecordUse(key.ID); err != nil {
+		fmt.Printf("Failed to record use of API key %s: %v\n", key.Prefix, err)
+	}
+
+	dto := model.FilterUserRecord(&user)
+	dto.Role = membership.Role
+	c.Locals("user", dto)
+	c.Locals("orgId", membership.OrgID)
+	c.Locals("apiKey", key)
+
+	return 0, ""
 }
@@ -0,0 +1,301 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is one asymmetric key of the key set. A key signs new tokens
+// from ActiveFrom until a newer key becomes active, and verifies tokens until
+// RetireAt. It is published in the JWKS until it retires, including before it
+// becomes active, so that verifiers already know a key when it starts
+// signing.
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	ActiveFrom time.Time
+	RetireAt   time.Time
+
+	private crypto.Signer
+}
+
+// Public returns the key used to verify the key's signatures.
+func (k *SigningKey) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+func (k *SigningKey) retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+func (k *SigningKey) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// KeySet holds the keys tokens are signed and verified with.
+type KeySet struct {
+	keys []*SigningKey
+}
+
+// keySetFile is the format of the JWT_KEYS_FILE key set. Each key holds its
+// PEM encoded private key inline or in a file relative to the key set.
+type keySetFile struct {
+	Keys []struct {
+		ID             string    `json:"kid"`
+		Algorithm      string    `json:"alg"`
+		PrivateKey     string    `json:"privateKey"`
+		PrivateKeyFile string    `json:"privateKeyFile"`
+		ActiveFrom     time.Time `json:"activeFrom"`
+		RetireAt       time.Time `json:"retireAt"`
+	} `json:"keys"`
+}
+
+// LoadKeySet reads a key set file. Keys must have a unique kid and an RS256
+// (RSA) or ES256 (P-256) private key; the algorithm is taken from the key
+// when alg is omitted.
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keySetFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing key set: %w", err)
+	}
+
+	set := &KeySet{}
+	seen := map[string]bool{}
+	for _, entry := range file.Keys {
+		if entry.ID == "" {
+			return nil, fmt.Errorf("key set: every key needs a kid")
+		}
+		if seen[entry.ID] {
+			return nil, fmt.Errorf("key set: duplicate kid %q", entry.ID)
+		}
+		seen[entry.ID] = true
+
+		pem := []byte(entry.PrivateKey)
+		if entry.PrivateKeyFile != "" {
+			keyPath := entry.PrivateKeyFile
+			if !filepath.IsAbs(keyPath) {
+				keyPath = filepath.Join(filepath.Dir(path), keyPath)
+			}
+			if pem, err = os.ReadFile(keyPath); err != nil {
+				return nil, fmt.Errorf("key %q: %w", entry.ID, err)
+			}
+		}
+
+		key, err := newSigningKey(entry.ID, entry.Algorithm, pem)
+		if err != nil {
+			return nil, err
+		}
+		key.ActiveFrom = entry.ActiveFrom
+		key.RetireAt = entry.RetireAt
+		set.keys = append(set.keys, key)
+	}
+
+	return set, nil
+}
+
+func newSigningKey(id string, algorithm string, pem []byte) (*SigningKey, error) {
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+		if algorithm == "" {
+			algorithm = jwt.SigningMethodRS256.Alg()
+		}
+		if algorithm != jwt.SigningMethodRS256.Alg() {
+			return nil, fmt.Errorf("key %q: an RSA key cannot sign %s", id, algorithm)
+		}
+		return &SigningKey{ID: id, Algorithm: algorithm, private: rsaKey}, nil
+	}
+
+	if ecKey, err := jwt.ParseECPrivateKeyFromPEM(pem); err == nil {
+		if algorithm == "" {
+			algorithm = jwt.SigningMethodES256.Alg()
+		}
+		if algorithm != jwt.SigningMethodES256.Alg() || ecKey.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %q: ES256 needs a P-256 key", id)
+		}
+		return &SigningKey{ID: id, Algorithm: algorithm, private: ecKey}, nil
+	}
+
+	return nil, fmt.Errorf("key %q: expected a PEM encoded RSA or EC private key", id)
+}
+
+// SigningKey returns the key that signs tokens at now: the most recently
+// activated key that has not retired.
+func (s *KeySet) SigningKey(now time.Time) (*SigningKey, bool) {
+	var current *SigningKey
+	for _, key := range s.keys {
+		if key.ActiveFrom.After(now) || key.retired(now) {
+			continue
+		}
+		if current == nil || key.ActiveFrom.After(current.ActiveFrom) {
+			current = key
+		}
+	}
+	return current, current != nil
+}
+
+// VerificationKey returns the key with the kid unless it has retired.
+func (s *KeySet) VerificationKey(kid string, now time.Time) (*SigningKey, bool) {
+	for _, key := range s.keys {
+		if key.ID == kid && !key.retired(now) {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Sign signs the claims with the current key and sets its kid in the header.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key, ok := s.SigningKey(time.Now())
+	if !ok {
+		return "", fmt.Errorf("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS lists the public keys that have not retired, ordered by when they
+// become active.
+func (s *KeySet) JWKS(now time.Time) []JWK {
+	keys := make([]*SigningKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		if !key.retired(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].ActiveFrom.Before(keys[j].ActiveFrom) })
+
+	encode := base64.RawURLEncoding.EncodeToString
+	jwks := make([]JWK, 0, len(keys))
+	for _, key := range keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encode(public.N.Bytes())
+			jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = public.Curve.Params().Name
+			jwk.X = encode(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = encode(public.Y.FillBytes(make([]byte, size)))
+		}
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks
+}
+
+var signingKeys struct {
+	once sync.Once
+	set  *KeySet
+	err  error
+}
+
+// Keys returns the key set from JWT_KEYS_FILE, loaded on first use. It
+// returns nil when no key set is configured, in which case tokens are signed
+// with JWT_SECRET (HS256).
+func Keys() (*KeySet, error) {
+	signingKeys.once.Do(func() {
+		if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+			signingKeys.set, signingKeys.err = LoadKeySet(path)
+		}
+	})
+	return signingKeys.set, signingKeys.err
+}
+
+// SignToken signs staff session tokens: with the active key of the key set
+// when one is configured, otherwise with JWT_SECRET.
+func SignToken(claims jwt.Claims) (string, error) {
+	keys, err := Keys()
+	if err != nil {
+		return "", err
+	}
+	if keys != nil {
+		return keys.Sign(claims)
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// verificationKey is the jwt.Keyfunc for staff session tokens. Asymmetric
+// tokens are verified with the key named by their kid, which must be of the
+// token's algorithm. HS256 tokens are verified with JWT_SECRET for as long as
+// it is set, so tokens issued before a key set was configured keep working.
+func verificationKey(jwtToken *jwt.Token) (interface{}, error) {
+	if _, ok := jwtToken.Method.(*jwt.SigningMethodHMAC); ok {
+		if jwtSecret == "" {
+			return nil, fmt.Errorf("HS256 tokens are not accepted")
+		}
+		return []byte(jwtSecret), nil
+	}
+
+	keys, err := Keys()
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := jwtToken.Header["kid"].(string)
+	if keys == nil || kid == "" {
+		return nil, fmt.Errorf("unexpected signing method: %s", jwtToken.Header["alg"])
+	}
+
+	key, ok := keys.VerificationKey(kid, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if jwtToken.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %s", jwtToken.Header["alg"])
+	}
+
+	return key.Public(), nil
+}
+
+// JWKSHandler publishes the public keys of the key set so that other
+// services can verify tokens issued here.
+func JWKSHandler(c *fiber.Ctx) error {
+	keys, err := Keys()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "fail", "message": "Failed to load signing keys"})
+	}
+
+	jwks := []JWK{}
+	if keys != nil {
+		jwks = keys.JWKS(time.Now())
+	}
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(fiber.Map{"keys": jwks})
+}
@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func writeKey(t *testing.T, dir string, name string, key interface{}) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+}
+
+// writeKeySet writes rsa.pem and ec.pem next to a key set file with the
+// given keys and returns the key set's path.
+func writeKeySet(t *testing.T, keys []map[string]interface{}) string {
+	dir := t.TempDir()
+	writeKey(t, dir, "rsa.pem", rsaKey)
+	writeKey(t, dir, "ec.pem", ecKey)
+
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	require.NoError(t, err)
+	path := filepath.Join(dir, "keys.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+// useKeySet makes Authorize and SignToken use the key set for the test.
+func useKeySet(t *testing.T, set *KeySet, secret string) {
+	signingKeys.once = sync.Once{}
+	signingKeys.once.Do(func() {})
+	signingKeys.set, signingKeys.err = set, nil
+	previous := jwtSecret
+	jwtSecret = secret
+	t.Cleanup(func() {
+		signingKeys.once = sync.Once{}
+		signingKeys.set, signingKeys.err = nil, nil
+		jwtSecret = previous
+	})
+}
+
+func TestKeySetRotation(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	set, err := LoadKeySet(writeKeySet(t, []map[string]interface{}{
+		{"kid": "old", "privateKeyFile": "rsa.pem", "activeFrom": now.Add(-2 * time.Hour), "retireAt": now.Add(time.Hour)},
+		{"kid": "current", "alg": "ES256", "privateKeyFile": "ec.pem", "activeFrom": now.Add(-time.Hour)},
+		{"kid": "next", "privateKeyFile": "rsa.pem", "activeFrom": now.Add(2 * time.Hour)},
+	}))
+	require.NoError(t, err)
+
+	key, ok := set.SigningKey(now)
+	require.True(t, ok)
+	require.Equal(t, "current", key.ID)
+	require.Equal(t, "ES256", key.Algorithm)
+
+	// the next key is published before it signs, and the old key until it retires
+	kids := func(jwks []JWK) []string {
+		ids := []string{}
+		for _, jwk := range jwks {
+			ids = append(ids, jwk.KeyID)
+		}
+		return ids
+	}
+	require.Equal(t, []string{"old", "current", "next"}, kids(set.JWKS(now)))
+	require.Equal(t, []string{"current", "next"}, kids(set.JWKS(now.Add(90*time.Minute))))
+	_, ok = set.VerificationKey("old", now.Add(90*time.Minute))
+	require.False(t, ok)
+
+	key, ok = set.SigningKey(now.Add(3 * time.Hour))
+	require.True(t, ok)
+	require.Equal(t, "next", key.ID)
+	require.Equal(t, "RS256", key.Algorithm)
+}
+
+func TestLoadKeySet_RejectsInvalidKeys(t *testing.T) {
+	_, err := LoadKeySet(writeKeySet(t, []map[string]interface{}{
+		{"kid": "a", "privateKeyFile": "rsa.pem"},
+		{"kid": "a", "privateKeyFile": "ec.pem"},
+	}))
+	require.ErrorContains(t, err, "duplicate kid")
+
+	_, err = LoadKeySet(writeKeySet(t, []map[string]interface{}{
+		{"kid": "a", "alg": "ES256", "privateKeyFile": "rsa.pem"},
+	}))
+	require.ErrorContains(t, err, "cannot sign ES256")
+
+	_, err = LoadKeySet(writeKeySet(t, []map[string]interface{}{
+		{"privateKeyFile": "rsa.pem"},
+	}))
+	require.ErrorContains(t, err, "kid")
+}
+
+func TestVerificationKey(t *testing.T) {
+	now := time.Now().UTC()
+	set, err := LoadKeySet(writeKeySet(t, []map[string]interface{}{
+		{"kid": "rsa", "privateKeyFile": "rsa.pem", "activeFrom": now.Add(-2 * time.Hour)},
+		{"kid": "ec", "privateKeyFile": "ec.pem", "activeFrom": now.Add(-time.Hour)},
+	}))
+	require.NoError(t, err)
+	useKeySet(t, set, "")
+
+	claims := jwt.MapClaims{"sub": "user", "exp": now.Add(time.Minute).Unix()}
+	signed, err := SignToken(claims)
+	require.NoError(t, err)
+	token, err := jwt.Parse(signed, verificationKey)
+	require.NoError(t, err)
+	require.Equal(t, "ec", token.Header["kid"])
+	require.Equal(t, "ES256", token.Method.Alg())
+
+	// tokens of an earlier key keep verifying until it retires
+	older := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	older.Header["kid"] = "rsa"
+	signed, err = older.SignedString(rsaKey)
+	require.NoError(t, err)
+	_, err = jwt.Parse(signed, verificationKey)
+	require.NoError(t, err)
+
+	// the kid must name a key of the token's algorithm
+	mismatched := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	mismatched.Header["kid"] = "ec"
+	signed, err = mismatched.SignedString(rsaKey)
+	require.NoError(t, err)
+	_, err = jwt.Parse(signed, verificationKey)
+	require.Error(t, err)
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	unknown.Header["kid"] = "missing"
+	signed, err = unknown.SignedString(rsaKey)
+	require.NoError(t, err)
+	_, err = jwt.Parse(signed, verificationKey)
+	require.ErrorContains(t, err, "unknown signing key")
+
+	// HS256 tokens are only accepted while JWT_SECRET is set
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("legacy"))
+	require.NoError(t, err)
+	_, err = jwt.Parse(legacy, verificationKey)
+	require.Error(t, err)
+	jwtSecret = "legacy"
+	_, err = jwt.Parse(legacy, verificationKey)
+	require.NoError(t, err)
+}
+
+func TestSignToken_FallsBackToSecret(t *testing.T) {
+	useKeySet(t, nil, "testsecret")
+	os.Setenv("JWT_SECRET", "testsecret")
+
+	signed, err := SignToken(jwt.MapClaims{"sub": "user"})
+	require.NoError(t, err)
+	token, err := jwt.Parse(signed, verificationKey)
+	require.NoError(t, err)
+	require.Equal(t, "HS256", token.Method.Alg())
+	require.Nil(t, token.Header["kid"])
+}
+
+func TestJWKSHandler(t *testing.T) {
+	set, err := LoadKeySet(writeKeySet(t, []map[string]interface{}{
+		{"kid": "rsa", "privateKeyFile": "rsa.pem", "activeFrom": time.Now().Add(-time.Hour)},
+		{"kid": "ec", "privateKeyFile": "ec.pem"},
+	}))
+	require.NoError(t, err)
+	useKeySet(t, set, "")
+
+	app := fiber.New()
+	app.Get("/.well-known/jwks.json", JWKSHandler)
+	resp, err := app.Test(httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	var body struct{ Keys []JWK }
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Len(t, body.Keys, 2)
+
+	number := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		require.NoError(t, err)
+		return new(big.Int).SetBytes(b)
+	}
+	ecJWK, rsaJWK := body.Keys[0], body.Keys[1]
+
+	require.Equal(t, JWK{KeyType: "EC", KeyID: "ec", Use: "sig", Algorithm: "ES256", Curve: "P-256", X: ecJWK.X, Y: ecJWK.Y}, ecJWK)
+	require.Equal(t, 0, number(ecJWK.X).Cmp(ecKey.X))
+	require.Equal(t, 0, number(ecJWK.Y).Cmp(ecKey.Y))
+
+	require.Equal(t, "RSA", rsaJWK.KeyType)
+	require.Equal(t, "RS256", rsaJWK.Algorithm)
+	require.Equal(t, 0, number(rsaJWK.N).Cmp(rsaKey.N))
+	require.Equal(t, int64(rsaKey.E), number(rsaJWK.E).Int64())
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"invoice-api/internal/features/user/model"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CurrentUser returns the user Authorize stored on the request.
+func CurrentUser(c *fiber.Ctx) (model.UserDTO, bool) {
+	user, ok := c.Locals("user").(model.UserDTO)
+	return user, ok
+}
+
+// OrgID returns the organization Authorize scoped the request to. It is the
+// zero ID, which matches no data, when the request is not authenticated.
+func OrgID(c *fiber.Ctx) primitive.ObjectID {
+	orgID, _ := c.Locals("orgId").(primitive.ObjectID)
+	return orgID
+}
+
+// HasPermission reports whether the authenticated user's role grants
+// permission and, for requests made with an API key, the key's scopes include
+// it.
+func HasPermission(c *fiber.Ctx, permission string) bool {
+	user, ok := CurrentUser(c)
+	if !ok || !model.HasPermission(user.Role, permission) {
+		return false
+	}
+	if key, ok := CurrentAPIKey(c); ok {
+		return key.HasScope(permission)
+	}
+	return true
+}
+
+// Forbidden responds with 403, naming the permission that is missing.
+func Forbidden(c *fiber.Ctx, permission string) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"status":     "fail",
+		"message":    "Missing permission " + permission,
+		"permission": permission,
+	})
+}
+
+// RequirePermission authenticates the request, unless an earlier handler
+// already did, and rejects it with 403 unless the user's role grants
+// permission.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := CurrentUser(c); !ok {
+			if status, message := authenticate(c, true, true); status != 0 {
+				return c.Status(status).JSON(fiber.Map{"status": "fail", "message": message})
+			}
+		}
+		if !HasPermission(c, permission) {
+			return Forbidden(c, permission)
+		}
+		return c.Next()
+	}
+}
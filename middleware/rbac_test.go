@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	apikey_model "invoice-api/internal/features/apikey/model"
+	"invoice-api/internal/features/user/model"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func appWithRole(role string, permission string) *fiber.App {
+	app := fiber.New()
+	if role != "" {
+		app.Use(func(c *fiber.Ctx) error {
+			c.Locals("user", model.UserDTO{Role: role})
+			return c.Next()
+		})
+	}
+	app.Get("/", RequirePermission(permission), func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	return app
+}
+
+func TestRequirePermission(t *testing.T) {
+	resp, err := appWithRole(model.RoleAccountant, model.PermInvoiceVoid).Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	resp, err = appWithRole(model.RoleSales, model.PermInvoiceVoid).Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	require.Equal(t, 403, resp.StatusCode)
+	var body map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, model.PermInvoiceVoid, body["permission"])
+	require.Contains(t, body["message"], model.PermInvoiceVoid)
+
+	resp, err = appWithRole("", model.PermInvoiceRead).Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	require.Equal(t, 401, resp.StatusCode)
+}
+
+func TestRequirePermission_LimitsAPIKeysToTheirScopes(t *testing.T) {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", model.UserDTO{Role: model.RoleSales})
+		c.Locals("apiKey", &apikey_model.APIKey{Scopes: []string{model.PermInvoiceRead, model.PermRevenueWrite}})
+		return c.Next()
+	})
+	ok := func(c *fiber.Ctx) error { return c.SendString("ok") }
+	app.Get("/invoices", RequirePermission(model.PermInvoiceRead), ok)
+	app.Post("/invoices", RequirePermission(model.PermInvoiceWrite), ok)
+	app.Post("/revenues", RequirePermission(model.PermRevenueWrite), ok)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/invoices", nil))
+	require.NoError(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+
+	// the creator's role grants it, the key's scopes do not
+	resp, err = app.Test(httptest.NewRequest("POST", "/invoices", nil))
+	require.NoError(t, err)
+	require.Equal(t, 403, resp.StatusCode)
+
+	// the key's scopes grant it, the creator's role no longer does
+	resp, err = app.Test(httptest.NewRequest("POST", "/revenues", nil))
+	require.NoError(t, err)
+	require.Equal(t, 403, resp.StatusCode)
+}
+
+func TestAuthorizeSession_RejectsAPIKeys(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", AuthorizeSession, func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "ApiKey ik_0123456789ab.secret")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 403, resp.StatusCode)
+}
+
+func TestAuthorize_RejectsMalformedAPIKeys(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", Authorize, func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "ApiKey not-a-key")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, 401, resp.StatusCode)
+}
+
+func TestRolePermissions(t *testing.T) {
+	for _, permission := range model.AllPermissions {
+		require.True(t, model.HasPermission(model.RoleOwner, permission), permission)
+		require.True(t, model.HasPermission(model.RoleAdmin, permission), permission)
+	}
+	require.True(t, model.HasPermission(model.RoleViewer, model.PermInvoiceRead))
+	require.False(t, model.HasPermission(model.RoleViewer, model.PermInvoiceWrite))
+	require.False(t, model.HasPermission(model.RoleSales, model.PermRevenueWrite))
+	require.False(t, model.HasPermission(model.RoleAccountant, model.PermUserManage))
+	require.False(t, model.HasPermission("", model.PermInvoiceRead))
+}